@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// enforceAdditionalProperties applies an additionalProperties policy to every
+// object regardless of what the schema text says: "reject" fails requests
+// with properties the schema doesn't describe, as if additionalProperties
+// were false everywhere, and "log" only logs them. The check walks allOf
+// compositions as a whole, so a property described by any member is known
+// to all of them. It runs after validation.
+func enforceAdditionalProperties(mode string, rt *runtimeSettings, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		replaceBody(r, body)
+
+		doc, err := decodeJSON(body)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		schema := requestSchema(r)
+		var msgs []string
+		walkUnknown(schema.Document, schema.Document, doc, "", func(_ map[string]interface{}, key, field string) {
+			msgs = append(msgs, fmt.Sprintf("%s: Additional property %s is not allowed", displayField(field), key))
+		})
+		sort.Strings(msgs)
+
+		if mode == "reject" {
+			if len(msgs) > 0 && rt.rejectInvalid(w, r, rt.forRequest(r), "has properties the schema doesn't describe", errorEntries(codeUnknownProperty, msgs)) {
+				return
+			}
+		} else {
+			for _, msg := range msgs {
+				log.Printf("unknown property in request to %s validated against %s %s: %s", r.URL.Path, schema.Name, schema.Version, msg)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
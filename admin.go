@@ -0,0 +1,86 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+// newAdminMux builds the handler for the admin listener. Nothing here is
+// reachable from the data path, so it can be firewalled off on its own.
+// Health checks stay open for probes; everything else requires an admin API
+// key when keys is non-nil. Runtime settings can only be changed, and schemas
+// uploaded, with keys, and only by callers with the role for it. When
+// readOnly, every request that isn't a GET or HEAD is refused, so nothing can
+// be changed through the admin API at all.
+// Schema coverage, unknown fields, field statistics, learned schemas and
+// drift alerts are served when they are being collected, API key quota usage
+// when there are quotas.
+func newAdminMux(h *health, keys *adminKeys, readOnly bool, schemas *schemaStore, rt *runtimeSettings, levels *logLevel, cov *coverage, unknown *unknownFields, stats *fieldStats, learned *learner, drift *driftDetector, quotas *quotas) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.healthz)
+	mux.HandleFunc("/readyz", h.readyz)
+
+	protect := func(next http.HandlerFunc) http.HandlerFunc {
+		if readOnly {
+			next = refuseChanges(next)
+		}
+		if keys == nil {
+			return next
+		}
+		return requireAdminKey(keys, next)
+	}
+
+	mux.HandleFunc("/debug/vars", protect(expvar.Handler().ServeHTTP))
+	mux.HandleFunc("/debug/pprof/", protect(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", protect(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", protect(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", protect(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", protect(pprof.Trace))
+	mux.HandleFunc("/admin/loglevel", protect(levels.handle(keys)))
+	mux.HandleFunc("/admin/version", protect(handleVersion(schemas)))
+	mux.HandleFunc("/admin/schema-loader", protect(handleLoaderStats(schemas)))
+	mux.HandleFunc("/admin/schemas", protect(schemas.cfg.Uploads.handle(schemas, keys)))
+	mux.HandleFunc("/admin/schemas/", protect(schemas.cfg.Uploads.handle(schemas, keys)))
+	if cov != nil {
+		mux.HandleFunc("/admin/coverage", protect(cov.handle))
+	}
+	if unknown != nil {
+		mux.HandleFunc("/admin/unknown-fields", protect(unknown.handle))
+	}
+	if stats != nil {
+		mux.HandleFunc("/admin/field-stats", protect(stats.handle))
+	}
+	if learned != nil {
+		mux.HandleFunc("/admin/learned-schemas", protect(learned.handle))
+	}
+	if drift != nil {
+		mux.HandleFunc("/admin/drift", protect(drift.handle))
+	}
+	if quotas != nil {
+		mux.HandleFunc("/admin/quotas", protect(quotas.handle))
+	}
+
+	if keys != nil {
+		mux.HandleFunc("/admin/audit", protect(keys.audit.handle))
+		mux.HandleFunc("/admin/settings", protect(rt.handleSettings(keys)))
+		mux.HandleFunc("/admin/settings/revert", protect(rt.handleRevert(keys)))
+	} else {
+		mux.HandleFunc("/admin/settings", func(w http.ResponseWriter, r *http.Request) {
+			writeErrors(w, http.StatusForbidden, codeForbidden, "changing settings at runtime requires -admin-keys-file")
+		})
+	}
+
+	return mux
+}
+
+// refuseChanges lets only requests that read through to next.
+func refuseChanges(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeErrors(w, http.StatusForbidden, codeForbidden, "the admin API is read-only, changes have to be deployed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
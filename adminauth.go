@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adminKeys holds the SHA-256 hashes of the API keys allowed to call the
+// admin endpoints. The file has one hex hash per line, optionally followed by
+// a label, which is the format `echo -n key | sha256sum` prints, and by
+// role=<role> to give the key less than the operator role. It is re-read
+// whenever it changes so keys can be rotated without a restart. With
+// -admin-jwt-role-claim, bearer JWTs verified against -jwt-jwks-url are let
+// in too, with the role their claim names.
+type adminKeys struct {
+	path string
+
+	jwt       *jwtVerifier
+	roleClaim string
+	audit     *adminAudit
+
+	mu      sync.Mutex
+	modTime time.Time
+	hashes  map[[sha256.Size]byte]string
+	roles   map[[sha256.Size]byte]adminRole
+}
+
+// adminRole is what a caller of the admin API may do: viewers read,
+// uploaders also upload schemas, and operators may do anything, from
+// rolling schemas back to changing runtime settings.
+type adminRole int
+
+const (
+	roleViewer adminRole = iota + 1
+	roleUploader
+	roleOperator
+)
+
+var adminRoles = map[string]adminRole{"viewer": roleViewer, "uploader": roleUploader, "operator": roleOperator}
+
+func (r adminRole) String() string {
+	for name, role := range adminRoles {
+		if role == r {
+			return name
+		}
+	}
+	return "none"
+}
+
+// requiredRole is the role r needs: uploading schemas needs an uploader,
+// other changes an operator.
+func requiredRole(r *http.Request) adminRole {
+	switch {
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return roleViewer
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/admin/schemas/"):
+		return roleUploader
+	}
+	return roleOperator
+}
+
+// adminIdentity is who called the admin API, and with what role.
+type adminIdentity struct {
+	name string
+	role adminRole
+}
+
+type adminIdentityKey struct{}
+
+func newAdminKeys(path string) (*adminKeys, error) {
+	k := &adminKeys{path: path, audit: &adminAudit{}}
+	if err := k.reload(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (k *adminKeys) reload() error {
+	if k.path == "" {
+		return nil
+	}
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(k.modTime) {
+		return nil
+	}
+
+	f, err := os.Open(k.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hashes := make(map[[sha256.Size]byte]string)
+	roles := make(map[[sha256.Size]byte]adminRole)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		b, err := hex.DecodeString(fields[0])
+		if err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("%s:%d: expected a hex SHA-256 hash", k.path, n)
+		}
+
+		var hash [sha256.Size]byte
+		copy(hash[:], b)
+		roles[hash] = roleOperator
+		var label []string
+		for _, field := range fields[1:] {
+			name, ok := strings.CutPrefix(field, "role=")
+			if !ok {
+				label = append(label, field)
+				continue
+			}
+			if roles[hash], ok = adminRoles[name]; !ok {
+				return fmt.Errorf("%s:%d: unknown role %q, expected viewer, uploader or operator", k.path, n, name)
+			}
+		}
+		hashes[hash] = strings.Join(label, " ")
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	k.hashes, k.roles, k.modTime = hashes, roles, info.ModTime()
+	return nil
+}
+
+// lookup returns the label of key if it is one of the allowed keys.
+func (k *adminKeys) lookup(key string) (string, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// Keep serving the last good set of keys if the file is mid-rotation.
+	k.reload()
+
+	label, ok := k.hashes[sha256.Sum256([]byte(key))]
+	return label, ok
+}
+
+// authenticate returns who the bearer token of r belongs to, an API key or
+// a verified JWT. Authorization headers with another scheme, or none, don't
+// authenticate anyone.
+func (k *adminKeys) authenticate(r *http.Request) (adminIdentity, bool) {
+	key, ok := bearerToken(r)
+	if !ok {
+		return adminIdentity{}, false
+	}
+
+	k.mu.Lock()
+	k.reload()
+	hash := sha256.Sum256([]byte(key))
+	label, ok := k.hashes[hash]
+	role := k.roles[hash]
+	k.mu.Unlock()
+	if ok {
+		return adminIdentity{name: label, role: role}, true
+	}
+
+	if k.jwt == nil {
+		return adminIdentity{}, false
+	}
+	claims, err := k.jwt.verify(key)
+	if err != nil {
+		return adminIdentity{}, false
+	}
+	// With several roles in an array claim, the highest one counts.
+	id := adminIdentity{}
+	id.name, _ = claims["sub"].(string)
+	for name, role := range adminRoles {
+		if claimMatches(claims, k.roleClaim, name) && role > id.role {
+			id.role = role
+		}
+	}
+	return id, true
+}
+
+func requireAdminKey(keys *adminKeys, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := keys.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			msg := "a valid admin API key is required"
+			if keys.jwt != nil {
+				msg = "a valid admin API key or bearer JWT is required"
+			}
+			writeErrors(w, http.StatusUnauthorized, codeUnauthorized, msg)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), adminIdentityKey{}, id)
+		r = r.WithContext(ctx)
+		if need := requiredRole(r); id.role < need {
+			keys.audit.add(r, id, http.StatusForbidden, nil)
+			writeErrors(w, http.StatusForbidden, codeForbidden, fmt.Sprintf("%s %s needs the %s role, the caller has %s", r.Method, r.URL.Path, need, id.role))
+			return
+		}
+		if requiredRole(r) == roleViewer {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ow := &outcomeWriter{ResponseWriter: w}
+		next.ServeHTTP(ow, r)
+		keys.audit.add(r, id, ow.status, ow.errors())
+	})
+}
+
+// maxAuditEntries is how many admin changes are kept in memory.
+const maxAuditEntries = 1000
+
+// adminAudit records every admin request that would change something,
+// whether it was allowed and how it went, so who uploaded, rolled back or
+// reconfigured what and when can be traced. Entries are logged as they are
+// made and the latest are served from /admin/audit.
+type adminAudit struct {
+	mu      sync.Mutex
+	entries []auditEntry
+}
+
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Caller string    `json:"caller"`
+	Role   string    `json:"role"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Query  string    `json:"query,omitempty"`
+	Status int       `json:"status"`
+	Errors []string  `json:"errors,omitempty"`
+}
+
+func (a *adminAudit) add(r *http.Request, id adminIdentity, status int, errors []string) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	caller := id.name
+	if caller == "" {
+		caller = r.RemoteAddr
+	}
+	e := auditEntry{
+		Time:   time.Now().UTC(),
+		Caller: caller,
+		Role:   id.role.String(),
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Status: status,
+		Errors: errors,
+	}
+	slog.Info("admin audit", "caller", e.Caller, "role", e.Role, "method", e.Method, "path", e.Path, "query", e.Query, "status", e.Status)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, e)
+	if len(a.entries) > maxAuditEntries {
+		a.entries = a.entries[len(a.entries)-maxAuditEntries:]
+	}
+}
+
+// handle serves GET /admin/audit, the latest changes first, optionally only
+// those whose path starts with ?path=.
+func (a *adminAudit) handle(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("path")
+
+	a.mu.Lock()
+	entries := make([]auditEntry, 0, len(a.entries))
+	for i := len(a.entries) - 1; i >= 0; i-- {
+		if strings.HasPrefix(a.entries[i].Path, prefix) {
+			entries = append(entries, a.entries[i])
+		}
+	}
+	a.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Entries []auditEntry `json:"entries"`
+	}{entries}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestAdminKeysNeedBearerScheme checks admin keys are only accepted as
+// bearer tokens.
+func TestAdminKeysNeedBearerScheme(t *testing.T) {
+	sum := sha256.Sum256([]byte("secret"))
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(sum[:])+" ops\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := newAdminKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := requireAdminKey(keys, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for auth, want := range map[string]int{
+		"Bearer secret": http.StatusNoContent,
+		"bearer secret": http.StatusNoContent,
+		"secret":        http.StatusUnauthorized,
+		"Basic secret":  http.StatusUnauthorized,
+		"Token secret":  http.StatusUnauthorized,
+		"Bearer ":       http.StatusUnauthorized,
+		"":              http.StatusUnauthorized,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/admin/settings", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != want {
+			t.Errorf("Authorization %q = %d, want %d", auth, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// admissionRule names the schema objects of a kind are validated against,
+// optionally only the value at a JSON pointer into the object, such as
+// /data of a ConfigMap.
+type admissionRule struct {
+	group   string
+	kind    string
+	pointer string
+	schema  string
+}
+
+// admissionRules is a flag.Value accepting repeated
+// "[group/]Kind[#pointer]=schema[.version]" rules, e.g.
+// "example.com/Widget=widget" or "ConfigMap#/data=configmap-data.v2". Core
+// kinds have no group.
+type admissionRules []admissionRule
+
+type admissionConfig struct {
+	Path  string
+	Rules admissionRules
+}
+
+func (a *admissionRules) String() string {
+	var out []string
+	for _, rule := range *a {
+		s := rule.kind
+		if rule.group != "" {
+			s = rule.group + "/" + s
+		}
+		if rule.pointer != "" {
+			s += "#" + rule.pointer
+		}
+		out = append(out, s+"="+rule.schema)
+	}
+	return strings.Join(out, ",")
+}
+
+func (a *admissionRules) Set(v string) error {
+	target, schema, ok := strings.Cut(v, "=")
+	if !ok || target == "" || schema == "" {
+		return fmt.Errorf("expected [group/]Kind[#pointer]=schema, got %q", v)
+	}
+
+	var rule admissionRule
+	rule.schema = schema
+	target, rule.pointer, _ = strings.Cut(target, "#")
+	if rule.pointer != "" && !strings.HasPrefix(rule.pointer, "/") {
+		return fmt.Errorf("invalid pointer %q, expected it to start with /", rule.pointer)
+	}
+	if i := strings.LastIndex(target, "/"); i >= 0 {
+		rule.group, rule.kind = target[:i], target[i+1:]
+	} else {
+		rule.kind = target
+	}
+	if rule.kind == "" {
+		return fmt.Errorf("expected [group/]Kind[#pointer]=schema, got %q", v)
+	}
+
+	*a = append(*a, rule)
+	return nil
+}
+
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID  string `json:"uid"`
+	Kind struct {
+		Group   string `json:"group"`
+		Version string `json:"version"`
+		Kind    string `json:"kind"`
+	} `json:"kind"`
+	Name      string          `json:"name"`
+	Namespace string          `json:"namespace"`
+	Operation string          `json:"operation"`
+	Object    json.RawMessage `json:"object"`
+}
+
+type admissionResponse struct {
+	UID      string           `json:"uid"`
+	Allowed  bool             `json:"allowed"`
+	Status   *admissionStatus `json:"status,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+type admissionStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// handleAdmission serves a Kubernetes validating admission webhook: it
+// answers AdmissionReviews for objects of the kinds with a rule by validating
+// them, or the part the rule points at, against the rule's schema. Errors
+// are those the gateway would return, joined into the denial's message, and
+// follow the runtime settings: with enforcement off invalid objects are
+// admitted with the errors as warnings. Kinds without a rule, and deletions,
+// are admitted.
+func handleAdmission(schemas *schemaStore, cfg *admissionConfig, rt *runtimeSettings) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		var review admissionReview
+		if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+			writeErrors(w, http.StatusBadRequest, codeInvalidRequest, "request body is not an AdmissionReview")
+			return
+		}
+
+		req := review.Request
+		resp := &admissionResponse{UID: req.UID, Allowed: true}
+		if msgs, ok := admissionErrors(schemas.current(), cfg.Rules, r, req); len(msgs) > 0 {
+			what := fmt.Sprintf("%s of %s %s/%s does not match the schema", req.Operation, req.Kind.Kind, req.Namespace, req.Name)
+			status, entries := rt.rejection(r, rt.forRequest(r), what, errorEntries(codeSchemaViolation, msgs))
+			if status != 0 {
+				resp.Allowed = false
+				resp.Status = &admissionStatus{Code: status, Message: strings.Join(entryMessages(entries), "; ")}
+			} else {
+				resp.Warnings = entryMessages(entries)
+			}
+		} else if !ok {
+			resp.Allowed = false
+			resp.Status = &admissionStatus{Code: http.StatusInternalServerError, Message: strings.Join(msgs, "; ")}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		apiVersion := review.APIVersion
+		if apiVersion == "" {
+			apiVersion = "admission.k8s.io/v1"
+		}
+		if err := json.NewEncoder(w).Encode(admissionReview{APIVersion: apiVersion, Kind: "AdmissionReview", Response: resp}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+}
+
+// admissionErrors validates the object of req against the schemas of the
+// rules for its kind, returning false when a rule's schema doesn't exist.
+func admissionErrors(registry *schemaRegistry, rules admissionRules, r *http.Request, req *admissionRequest) ([]string, bool) {
+	if len(req.Object) == 0 || string(req.Object) == "null" {
+		return nil, true
+	}
+
+	var object interface{}
+	var msgs []string
+	for _, rule := range rules {
+		if rule.kind != req.Kind.Kind || rule.group != req.Kind.Group {
+			continue
+		}
+
+		name, version := splitSchemaID(rule.schema)
+		schema, ok := registry.find(r, name, version)
+		if !ok {
+			return []string{fmt.Sprintf("schema %s is not loaded", rule.schema)}, false
+		}
+
+		body := []byte(req.Object)
+		if rule.pointer != "" {
+			if object == nil {
+				var err error
+				if object, err = decodeJSON(body); err != nil {
+					return []string{fmt.Sprintf("object is not valid JSON: %v", err)}, false
+				}
+			}
+			value, ok := schemavalidation.ResolvePointer(object, rule.pointer)
+			if !ok {
+				// A field that isn't set has nothing to check.
+				continue
+			}
+			var err error
+			if body, err = encodeJSON(value); err != nil {
+				return []string{err.Error()}, false
+			}
+		}
+
+		result, err := schema.Schema.Validate(gojsonschema.NewBytesLoader(body))
+		if err != nil {
+			return []string{fmt.Sprintf("object is not valid JSON: %v", err)}, false
+		}
+		for _, msg := range errorMessages(schemaErrors(schema, body, result)) {
+			if rule.pointer != "" {
+				msg = rule.pointer + ": " + msg
+			}
+			msgs = append(msgs, msg)
+		}
+	}
+	sort.Strings(msgs)
+	return msgs, true
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// schemaDecrypter decrypts schemas kept encrypted at rest with age
+// (age-encryption.org/v1) to the X25519 identities of -schema-age-identity:
+// <name>.<version>.json.age files next to the plain ones, and, with
+// -schema-store, the documents of uploads, which are saved encrypted to the
+// same identities. Decrypted schemas only ever live in memory. Files
+// encrypted with age -r <recipient> or armored with age -a both work; other
+// recipient types, passphrases and SSH keys, are not supported.
+type schemaDecrypter struct {
+	identities []*ecdh.PrivateKey
+}
+
+const (
+	ageIntro       = "age-encryption.org/v1"
+	ageX25519Label = "age-encryption.org/v1/X25519"
+	ageArmorType   = "AGE ENCRYPTED FILE"
+	ageChunkSize   = 64 * 1024
+)
+
+// loadSchemaDecrypter reads the AGE-SECRET-KEY-1 identities in file, one
+// per line, as age-keygen writes them.
+func loadSchemaDecrypter(file string) (*schemaDecrypter, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &schemaDecrypter{}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hrp, key, err := bech32Decode(line)
+		if err != nil || hrp != "age-secret-key-" {
+			return nil, fmt.Errorf("%s: expected AGE-SECRET-KEY-1... identities", file)
+		}
+		identity, err := ecdh.X25519().NewPrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		d.identities = append(d.identities, identity)
+	}
+	if len(d.identities) == 0 {
+		return nil, fmt.Errorf("%s: no identities", file)
+	}
+	return d, nil
+}
+
+// decrypt decrypts an age file, armored or not.
+func (d *schemaDecrypter) decrypt(b []byte) ([]byte, error) {
+	if block, _ := pem.Decode(b); block != nil && block.Type == ageArmorType {
+		b = block.Bytes
+	}
+
+	r := bufio.NewReader(bytes.NewReader(b))
+	var header bytes.Buffer
+	line, err := ageLine(r, &header)
+	if err != nil || line != ageIntro {
+		return nil, errors.New("not an age encrypted file")
+	}
+
+	var fileKey []byte
+	for {
+		if line, err = ageLine(r, &header); err != nil {
+			return nil, errors.New("malformed age header")
+		}
+		if strings.HasPrefix(line, "---") {
+			break
+		}
+		args := strings.Fields(strings.TrimPrefix(line, "->"))
+		if !strings.HasPrefix(line, "-> ") || len(args) == 0 {
+			return nil, errors.New("malformed age header")
+		}
+
+		// The body is wrapped at 64 columns, ending with a shorter line.
+		var body []byte
+		for {
+			l, err := ageLine(r, &header)
+			if err != nil {
+				return nil, errors.New("malformed age header")
+			}
+			chunk, err := base64.RawStdEncoding.Strict().DecodeString(l)
+			if err != nil || len(l) > 64 {
+				return nil, errors.New("malformed age header")
+			}
+			body = append(body, chunk...)
+			if len(l) < 64 {
+				break
+			}
+		}
+
+		if fileKey == nil && args[0] == "X25519" && len(args) == 2 {
+			fileKey = d.unwrapX25519(args[1], body)
+		}
+	}
+	if fileKey == nil {
+		return nil, errors.New("not encrypted to any of the -schema-age-identity identities")
+	}
+
+	// The MAC covers the header up to and including "---".
+	mac, err := base64.RawStdEncoding.Strict().DecodeString(strings.TrimPrefix(line, "--- "))
+	if err != nil {
+		return nil, errors.New("malformed age header")
+	}
+	h := hmac.New(sha256.New, ageKey(fileKey, nil, "header"))
+	h.Write(header.Bytes()[:header.Len()-len(line)-1+3])
+	if !hmac.Equal(h.Sum(nil), mac) {
+		return nil, errors.New("age header MAC doesn't verify")
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, errors.New("truncated age payload")
+	}
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ageOpenPayload(ageKey(fileKey, nonce, "payload"), payload)
+}
+
+// ageLine reads a header line, adding it to header.
+func ageLine(r *bufio.Reader, header *bytes.Buffer) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	header.WriteString(line)
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+func ageKey(secret, salt []byte, info string) []byte {
+	key, err := hkdf.Key(sha256.New, secret, salt, info, 32)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// unwrapX25519 returns the file key of an X25519 stanza, or nil when none
+// of the identities can unwrap it.
+func (d *schemaDecrypter) unwrapX25519(share string, body []byte) []byte {
+	s, err := base64.RawStdEncoding.Strict().DecodeString(share)
+	if err != nil || len(body) != 32 {
+		return nil
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(s)
+	if err != nil {
+		return nil
+	}
+
+	for _, identity := range d.identities {
+		shared, err := identity.ECDH(ephemeral)
+		if err != nil {
+			continue
+		}
+		salt := append(append([]byte{}, s...), identity.PublicKey().Bytes()...)
+		aead, _ := chacha20poly1305.New(ageKey(shared, salt, ageX25519Label))
+		if fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), body, nil); err == nil && len(fileKey) == 16 {
+			return fileKey
+		}
+	}
+	return nil
+}
+
+// ageOpenPayload decrypts the STREAM of 64 KiB chunks, the last one flagged
+// in its nonce.
+func ageOpenPayload(key, payload []byte) ([]byte, error) {
+	aead, _ := chacha20poly1305.New(key)
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+
+	var out []byte
+	for counter := uint64(0); ; counter++ {
+		n := ageChunkSize + aead.Overhead()
+		last := len(payload) <= n
+		if last {
+			n = len(payload)
+			nonce[11] = 1
+		}
+		for i := 0; i < 8; i++ {
+			nonce[10-i] = byte(counter >> (8 * i))
+		}
+
+		chunk, err := aead.Open(nil, nonce, payload[:n], nil)
+		if err != nil || (len(chunk) == 0 && counter > 0) {
+			return nil, errors.New("age payload doesn't decrypt")
+		}
+		out = append(out, chunk...)
+		payload = payload[n:]
+		if last {
+			return out, nil
+		}
+	}
+}
+
+// encrypt encrypts b to the identities, armored so it can be kept as text.
+func (d *schemaDecrypter) encrypt(b []byte) ([]byte, error) {
+	fileKey := make([]byte, 16)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+
+	var header bytes.Buffer
+	header.WriteString(ageIntro + "\n")
+	for _, identity := range d.identities {
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		recipient := identity.PublicKey()
+		shared, err := ephemeral.ECDH(recipient)
+		if err != nil {
+			return nil, err
+		}
+		share := ephemeral.PublicKey().Bytes()
+		salt := append(append([]byte{}, share...), recipient.Bytes()...)
+		aead, _ := chacha20poly1305.New(ageKey(shared, salt, ageX25519Label))
+		body := base64.RawStdEncoding.EncodeToString(aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil))
+		// A 32 byte body is 43 characters, one line shorter than 64.
+		fmt.Fprintf(&header, "-> X25519 %s\n%s\n", base64.RawStdEncoding.EncodeToString(share), body)
+	}
+	header.WriteString("---")
+	h := hmac.New(sha256.New, ageKey(fileKey, nil, "header"))
+	h.Write(header.Bytes())
+	fmt.Fprintf(&header, " %s\n", base64.RawStdEncoding.EncodeToString(h.Sum(nil)))
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header.Write(nonce)
+
+	aead, _ := chacha20poly1305.New(ageKey(fileKey, nonce, "payload"))
+	chunkNonce := make([]byte, chacha20poly1305.NonceSize)
+	for counter := uint64(0); ; counter++ {
+		n := len(b)
+		last := n <= ageChunkSize
+		if !last {
+			n = ageChunkSize
+		} else {
+			chunkNonce[11] = 1
+		}
+		for i := 0; i < 8; i++ {
+			chunkNonce[10-i] = byte(counter >> (8 * i))
+		}
+		header.Write(aead.Seal(nil, chunkNonce, b[:n], nil))
+		b = b[n:]
+		if last {
+			break
+		}
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: ageArmorType, Bytes: header.Bytes()}), nil
+}
+
+// decryptDocuments decrypts the encrypted documents read from where in
+// place.
+func (d *schemaDecrypter) decryptDocuments(where string, docs []schemaDocument) error {
+	for i, doc := range docs {
+		if !doc.Encrypted {
+			continue
+		}
+		id := doc.Name + "." + doc.Version
+		if doc.Tenant != "" {
+			id = doc.Tenant + "/" + id
+		}
+		if d == nil {
+			return fmt.Errorf("%s schema %s is encrypted, decrypting it needs -schema-age-identity", where, id)
+		}
+		b, err := d.decrypt(doc.Data)
+		if err != nil {
+			return fmt.Errorf("%s schema %s: %v", where, id, err)
+		}
+		docs[i].Data, docs[i].Encrypted = b, false
+	}
+	return nil
+}
+
+// isAgeArmored reports whether b is an armored age file.
+func isAgeArmored(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("-----BEGIN "+ageArmorType+"-----"))
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Decode decodes the Bech32 encoding age keys use, returning the
+// lowercased human readable part and the data.
+func bech32Decode(s string) (string, []byte, error) {
+	s = strings.ToLower(s)
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, errors.New("invalid bech32 string")
+	}
+	hrp := s[:sep]
+
+	var values []byte
+	for _, c := range []byte(hrp) {
+		values = append(values, c>>5)
+	}
+	values = append(values, 0)
+	for _, c := range []byte(hrp) {
+		values = append(values, c&31)
+	}
+	var data []byte
+	for _, c := range s[sep+1:] {
+		i := strings.IndexRune(bech32Charset, c)
+		if i < 0 {
+			return "", nil, errors.New("invalid bech32 character")
+		}
+		data = append(data, byte(i))
+	}
+	if bech32Polymod(append(values, data...)) != 1 {
+		return "", nil, errors.New("invalid bech32 checksum")
+	}
+	data = data[:len(data)-6]
+
+	var out []byte
+	var acc, bits uint
+	for _, v := range data {
+		acc = acc<<5 | uint(v)
+		bits += 5
+		for bits >= 8 {
+			bits -= 8
+			out = append(out, byte(acc>>bits))
+		}
+	}
+	if bits >= 5 || acc&(1<<bits-1) != 0 {
+		return "", nil, errors.New("invalid bech32 padding")
+	}
+	return hrp, out, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		b := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (b>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
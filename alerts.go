@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// maxAlertRoutes bounds the routes failure rates are tracked for.
+const maxAlertRoutes = 1000
+
+type alertConfig struct {
+	WebhookURL       string
+	SlackWebhookURL  string
+	PagerDutyKey     string
+	PagerDutyURL     string
+	FailureSeverity  string
+	DriftSeverity    string
+	Timeout          time.Duration
+	FailureThreshold float64
+	Window           time.Duration
+	MinRequests      int64
+}
+
+func (c *alertConfig) enabled() bool {
+	return c.WebhookURL != "" || c.SlackWebhookURL != "" || c.PagerDutyKey != ""
+}
+
+// alertSeverities are the severities notifications can have, PagerDuty's.
+var alertSeverities = map[string]bool{"critical": true, "error": true, "warning": true, "info": true}
+
+// notification is the JSON body posted to the alert webhook. Key is the
+// same for every notification about the same problem, so receivers can
+// deduplicate them and match resolutions with what they resolve.
+type notification struct {
+	Kind     string    `json:"kind"`
+	Key      string    `json:"key"`
+	Status   string    `json:"status"`
+	Severity string    `json:"severity"`
+	Summary  string    `json:"summary"`
+	Time     time.Time `json:"time"`
+
+	Method    string       `json:"method,omitempty"`
+	Path      string       `json:"path,omitempty"`
+	Requests  int64        `json:"requests,omitempty"`
+	Failures  int64        `json:"failures,omitempty"`
+	Percent   float64      `json:"percent,omitempty"`
+	TopErrors []errorCount `json:"top_errors,omitempty"`
+
+	Drift *driftAlert `json:"drift,omitempty"`
+}
+
+type errorCount struct {
+	Code  string `json:"code"`
+	Count int64  `json:"count"`
+}
+
+// alertSink is somewhere notifications are delivered, body formatting a
+// notification the way it expects.
+type alertSink struct {
+	name string
+	url  string
+	body func(notification) interface{}
+}
+
+// notifier delivers notifications to every configured sink in the
+// background, so a slow receiver never holds up requests. Notifications
+// that can't be delivered are logged and dropped.
+type notifier struct {
+	client *http.Client
+	sinks  []alertSink
+	queue  chan notification
+}
+
+func newNotifier(cfg *alertConfig) (*notifier, error) {
+	for _, severity := range []string{cfg.FailureSeverity, cfg.DriftSeverity} {
+		if !alertSeverities[severity] {
+			return nil, fmt.Errorf("invalid alert severity %q, expected critical, error, warning or info", severity)
+		}
+	}
+
+	n := &notifier{client: &http.Client{Timeout: cfg.Timeout}, queue: make(chan notification, 100)}
+	if cfg.WebhookURL != "" {
+		n.sinks = append(n.sinks, alertSink{"webhook", cfg.WebhookURL, func(msg notification) interface{} { return msg }})
+	}
+	if cfg.SlackWebhookURL != "" {
+		n.sinks = append(n.sinks, alertSink{"slack", cfg.SlackWebhookURL, slackMessage})
+	}
+	if cfg.PagerDutyKey != "" {
+		source, err := os.Hostname()
+		if err != nil {
+			source = "schema-validations"
+		}
+		n.sinks = append(n.sinks, alertSink{"pagerduty", cfg.PagerDutyURL, func(msg notification) interface{} {
+			return pagerDutyEvent(cfg.PagerDutyKey, source, msg)
+		}})
+	}
+
+	go func() {
+		for msg := range n.queue {
+			for _, sink := range n.sinks {
+				if err := n.post(sink, msg); err != nil {
+					metricAlertsFailed.Add(sink.name, 1)
+					log.Printf("failed to send %s alert to %s: %v", msg.Kind, sink.name, err)
+					continue
+				}
+				metricAlertsSent.Add(sink.name, 1)
+			}
+		}
+	}()
+	return n, nil
+}
+
+func (n *notifier) send(msg notification) {
+	select {
+	case n.queue <- msg:
+	default:
+		metricAlertsFailed.Add("dropped", 1)
+		log.Printf("alert queue is full, dropped %s alert: %s", msg.Kind, msg.Summary)
+	}
+}
+
+func (n *notifier) post(sink alertSink, msg notification) error {
+	b, err := json.Marshal(sink.body(msg))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, sink.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", sink.name, res.Status)
+	}
+	return nil
+}
+
+// slackColors are the attachment colors of firing notifications by
+// severity, resolutions being green.
+var slackColors = map[string]string{"critical": "#a30200", "error": "#e01e5a", "warning": "#ecb22e", "info": "#439fe0"}
+
+// slackMessage formats msg for a Slack incoming webhook.
+func slackMessage(msg notification) interface{} {
+	type field struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+		Short bool   `json:"short"`
+	}
+	type attachment struct {
+		Color    string  `json:"color"`
+		Fallback string  `json:"fallback"`
+		Fields   []field `json:"fields,omitempty"`
+		Footer   string  `json:"footer"`
+		Ts       int64   `json:"ts"`
+	}
+
+	color, prefix := slackColors[msg.Severity], strings.ToUpper(msg.Severity)
+	if msg.Status == "resolved" {
+		color, prefix = "#2eb886", "RESOLVED"
+	}
+	a := attachment{Color: color, Fallback: msg.Summary, Footer: msg.Key, Ts: msg.Time.Unix()}
+	if msg.Requests > 0 {
+		a.Fields = append(a.Fields, field{"Requests", strconv.FormatInt(msg.Requests, 10), true})
+	}
+	if msg.Kind == "failure_rate" {
+		a.Fields = append(a.Fields, field{"Failures", strconv.FormatInt(msg.Failures, 10), true})
+	}
+	if len(msg.TopErrors) > 0 {
+		lines := make([]string, len(msg.TopErrors))
+		for i, e := range msg.TopErrors {
+			lines[i] = fmt.Sprintf("`%s` %d", e.Code, e.Count)
+		}
+		a.Fields = append(a.Fields, field{"Top errors", strings.Join(lines, "\n"), false})
+	}
+
+	return struct {
+		Text        string       `json:"text"`
+		Attachments []attachment `json:"attachments"`
+	}{fmt.Sprintf("[%s] %s", prefix, msg.Summary), []attachment{a}}
+}
+
+// pagerDutyEvent formats msg as a PagerDuty Events API v2 event, resolving
+// the incident with the same dedup key when msg is a resolution.
+func pagerDutyEvent(routingKey, source string, msg notification) interface{} {
+	type payload struct {
+		Summary   string       `json:"summary"`
+		Source    string       `json:"source"`
+		Severity  string       `json:"severity"`
+		Timestamp string       `json:"timestamp"`
+		Component string       `json:"component"`
+		Class     string       `json:"class"`
+		Details   notification `json:"custom_details"`
+	}
+	type event struct {
+		RoutingKey  string   `json:"routing_key"`
+		EventAction string   `json:"event_action"`
+		DedupKey    string   `json:"dedup_key"`
+		Payload     *payload `json:"payload,omitempty"`
+	}
+
+	if msg.Status == "resolved" {
+		return event{RoutingKey: routingKey, EventAction: "resolve", DedupKey: msg.Key}
+	}
+	summary := msg.Summary
+	if len(summary) > 1024 {
+		summary = summary[:1024]
+	}
+	return event{
+		RoutingKey:  routingKey,
+		EventAction: "trigger",
+		DedupKey:    msg.Key,
+		Payload: &payload{
+			Summary:   summary,
+			Source:    source,
+			Severity:  msg.Severity,
+			Timestamp: msg.Time.Format(time.RFC3339),
+			Component: "schema-validations",
+			Class:     msg.Kind,
+			Details:   msg,
+		},
+	}
+}
+
+// driftNotification is the notification for a drift alert changing.
+func driftNotification(cfg *alertConfig, a driftAlert, resolved bool) notification {
+	status := "firing"
+	if resolved {
+		status = "resolved"
+	}
+	key := strings.Join([]string{"schema_drift", a.Tenant, a.Schema, a.Version, a.Kind, a.Detail}, " ")
+	return notification{
+		Kind:     "schema_drift",
+		Key:      strings.Join(strings.Fields(key), " "),
+		Status:   status,
+		Severity: cfg.DriftSeverity,
+		Summary:  "schema drift: " + a.String(),
+		Time:     time.Now().UTC(),
+		Drift:    &a,
+	}
+}
+
+// failureWindow counts a route's requests and validation failures during
+// the current window.
+type failureWindow struct {
+	method, path string
+	requests     int64
+	failures     int64
+	codes        map[string]int64
+}
+
+// failureMonitor fires a notification when the share of a route's requests
+// failing validation exceeds the threshold over a window, and another when
+// a later window with enough traffic is back under it.
+type failureMonitor struct {
+	cfg    *alertConfig
+	notify func(notification)
+
+	mu      sync.Mutex
+	windows map[string]*failureWindow
+	firing  map[string]bool
+}
+
+func newFailureMonitor(cfg *alertConfig, notify func(notification)) *failureMonitor {
+	m := &failureMonitor{cfg: cfg, notify: notify, windows: make(map[string]*failureWindow), firing: make(map[string]bool)}
+	go func() {
+		for now := range time.Tick(cfg.Window) {
+			m.evaluate(now)
+		}
+	}()
+	return m
+}
+
+func (m *failureMonitor) observe(method, path string, codes []string) {
+	path = learnedPath(path)
+	key := method + " " + path
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.windows[key]
+	if !ok {
+		if len(m.windows) >= maxAlertRoutes {
+			return
+		}
+		w = &failureWindow{method: method, path: path, codes: make(map[string]int64)}
+		m.windows[key] = w
+	}
+	w.requests++
+	if len(codes) > 0 {
+		w.failures++
+	}
+	for _, code := range codes {
+		w.codes[code]++
+	}
+}
+
+func (m *failureMonitor) evaluate(now time.Time) {
+	m.mu.Lock()
+	windows := m.windows
+	m.windows = make(map[string]*failureWindow)
+	m.mu.Unlock()
+
+	for key, w := range windows {
+		if w.requests < m.cfg.MinRequests {
+			continue
+		}
+		percent := 100 * float64(w.failures) / float64(w.requests)
+		over := percent > m.cfg.FailureThreshold
+
+		m.mu.Lock()
+		changed := over != m.firing[key]
+		if over {
+			m.firing[key] = true
+		} else {
+			delete(m.firing, key)
+		}
+		m.mu.Unlock()
+		if !changed {
+			continue
+		}
+
+		msg := notification{
+			Kind:     "failure_rate",
+			Key:      "failure_rate " + key,
+			Status:   "firing",
+			Severity: m.cfg.FailureSeverity,
+			Time:     now.UTC(),
+			Method:   w.method,
+			Path:     w.path,
+			Requests: w.requests,
+			Failures: w.failures,
+			Percent:  percent,
+		}
+		if !over {
+			msg.Status = "resolved"
+		}
+		for code, n := range w.codes {
+			msg.TopErrors = append(msg.TopErrors, errorCount{Code: code, Count: n})
+		}
+		sort.Slice(msg.TopErrors, func(i, j int) bool {
+			a, b := msg.TopErrors[i], msg.TopErrors[j]
+			if a.Count != b.Count {
+				return a.Count > b.Count
+			}
+			return a.Code < b.Code
+		})
+		if len(msg.TopErrors) > 5 {
+			msg.TopErrors = msg.TopErrors[:5]
+		}
+		msg.Summary = fmt.Sprintf("%.1f%% of %d requests to %s %s failed validation over %s", percent, w.requests, w.method, w.path, m.cfg.Window)
+		if !over {
+			msg.Summary = fmt.Sprintf("validation failures for %s %s are back under %.1f%%", w.method, w.path, m.cfg.FailureThreshold)
+		}
+
+		log.Printf("alert %s: %s", msg.Status, msg.Summary)
+		m.notify(msg)
+	}
+}
+
+// errorCode identifies an error by its keyword and the JSON pointer of the
+// value it's about, which for a missing property is where it should be.
+func errorCode(e gojsonschema.ResultError) string {
+	pointer := schemavalidation.Pointer(e.Context())
+	if property, ok := e.Details()["property"].(string); ok && e.Type() == "required" {
+		pointer += "/" + schemavalidation.EscapePointer(property)
+	}
+	return e.Type() + " " + pointer
+}
+
+// monitorFailures counts the requests reaching next that fail validation,
+// by the keyword and JSON pointer of their errors.
+func monitorFailures(m *failureMonitor, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		schema := requestSchema(r)
+		if schema == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		replaceBody(r, body)
+		rw := &outcomeWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		var codes []string
+		if msgs := rw.errors(); len(msgs) > 0 && rw.status < 500 {
+			// Responses carry messages; the codes come from validating
+			// again, which only failing requests pay for.
+			if errs, err := bodyErrors(schema, body); err == nil && len(errs) > 0 {
+				for _, e := range errs {
+					codes = append(codes, errorCode(e))
+				}
+			} else {
+				codes = msgs
+			}
+		}
+		m.observe(r.Method, r.URL.Path, codes)
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+// composeBase adds the base schema in file, holding the fields every request
+// shares, to the root allOf of each document. The base's definitions move to
+// the document's root so its local $refs still resolve; a document's own
+// definition of the same name wins. Tenant documents that override a base
+// set document already get the base through it.
+func composeBase(file string, docs []schemaDocument) ([]schemaDocument, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	base, ok := decodedObject(b)
+	if !ok {
+		return nil, fmt.Errorf("base schema %s: expected a JSON object", file)
+	}
+
+	shared := make(map[string]bool)
+	for _, doc := range docs {
+		if doc.Tenant == "" {
+			shared[doc.Name+"."+doc.Version] = true
+		}
+	}
+
+	out := make([]schemaDocument, len(docs))
+	for i, doc := range docs {
+		out[i] = doc
+		if doc.Tenant != "" && shared[doc.Name+"."+doc.Version] {
+			continue
+		}
+
+		if out[i].Data, err = layerSchema(doc.Data, base); err != nil {
+			return nil, fmt.Errorf("schema %s %s %s: %v", doc.Tenant, doc.Name, doc.Version, err)
+		}
+	}
+	return out, nil
+}
+
+// layerSchema adds part to the root allOf of the schema in data, moving the
+// definitions of part to the root so its local $refs resolve there too.
+func layerSchema(data []byte, part map[string]interface{}) ([]byte, error) {
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	doc, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot layer a schema onto a %T schema", v)
+	}
+
+	layer := make(map[string]interface{}, len(part))
+	for k, e := range part {
+		switch k {
+		case "$schema", "$id", "id":
+		case "definitions", "$defs":
+			baseDefs, ok := e.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not an object", k)
+			}
+			defs, _ := doc[k].(map[string]interface{})
+			if defs == nil {
+				defs = make(map[string]interface{})
+			}
+			for name, def := range baseDefs {
+				if _, ok := defs[name]; !ok {
+					defs[name] = copyJSON(def)
+				}
+			}
+			doc[k] = defs
+		default:
+			layer[k] = copyJSON(e)
+		}
+	}
+
+	allOf, _ := doc["allOf"].([]interface{})
+	doc["allOf"] = append(allOf, layer)
+	return encodeJSON(doc)
+}
+
+func decodedObject(data []byte) (map[string]interface{}, bool) {
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil, false
+	}
+	m, ok := v.(map[string]interface{})
+	return m, ok
+}
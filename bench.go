@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+type benchConfig struct {
+	URL          string
+	RPS          int
+	Duration     time.Duration
+	Workers      int
+	InvalidRatio float64
+	Schema       schemaArgs
+	JSONLimits   jsonLimits
+}
+
+// runBench implements the bench subcommand: it generates valid and invalid
+// payloads from the schema and sends them at a fixed rate, either to a
+// running server or straight through the in-process validator, then reports
+// latency percentiles.
+func runBench(args []string) error {
+	var cfg benchConfig
+	fs := flag.NewFlagSet("schema-validations bench", flag.ExitOnError)
+	fs.StringVar(&cfg.URL, "url", "", "server to send payloads to, the in-process validator if empty")
+	fs.IntVar(&cfg.RPS, "rps", 100, "target requests per second")
+	fs.DurationVar(&cfg.Duration, "duration", 10*time.Second, "how long to send payloads for")
+	fs.IntVar(&cfg.Workers, "workers", 16, "number of requests in flight at once")
+	fs.Float64Var(&cfg.InvalidRatio, "invalid-ratio", 0.1, "fraction of payloads generated to fail validation")
+	cfg.Schema.register(fs)
+	fs.Parse(args)
+
+	store, schema, err := cfg.Schema.load()
+	if err != nil {
+		return err
+	}
+
+	valid, invalid, err := benchPayloads(schema)
+	if err != nil {
+		return err
+	}
+
+	send := benchRemote(cfg.URL)
+	if cfg.URL == "" {
+		send = benchLocal(selectSchema(store, validate(&cfg.JSONLimits, nil, nil, nil, process)))
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	results := benchRun(&cfg, valid, invalid, send)
+	runtime.ReadMemStats(&after)
+
+	benchReport(results, cfg.Duration, cfg.URL == "", after.Mallocs-before.Mallocs, after.TotalAlloc-before.TotalAlloc)
+	return nil
+}
+
+// benchPayloads generates a valid payload, checking the validator agrees,
+// and invalid ones each missing a required property or carrying a property
+// of the wrong type.
+func benchPayloads(schema *schemaVersion) ([]byte, [][]byte, error) {
+	doc := sampleValue(schema.Document, schema.Document, 0)
+	valid, err := encodeJSON(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if result, err := schema.Schema.Validate(gojsonschema.NewBytesLoader(valid)); err != nil || !result.Valid() {
+		return nil, nil, fmt.Errorf("couldn't generate a valid %s %s payload, add examples or defaults to the schema: %s", schema.Name, schema.Version, valid)
+	}
+
+	var invalid [][]byte
+	if obj, ok := doc.(map[string]interface{}); ok {
+		var names []string
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			v := obj[name]
+
+			delete(obj, name)
+			invalid = append(invalid, benchCandidate(schema, obj))
+
+			obj[name] = map[string]interface{}{"unexpected": []interface{}{}}
+			invalid = append(invalid, benchCandidate(schema, obj))
+
+			obj[name] = v
+		}
+	}
+	invalid = append(invalid, benchCandidate(schema, []interface{}{"not", "an", "object"}))
+
+	var kept [][]byte
+	for _, p := range invalid {
+		if p != nil {
+			kept = append(kept, p)
+		}
+	}
+	return valid, kept, nil
+}
+
+// benchCandidate encodes v if the schema rejects it.
+func benchCandidate(schema *schemaVersion, v interface{}) []byte {
+	b, err := encodeJSON(v)
+	if err != nil {
+		return nil
+	}
+	if result, err := schema.Schema.Validate(gojsonschema.NewBytesLoader(b)); err != nil || result.Valid() {
+		return nil
+	}
+	return b
+}
+
+type benchResult struct {
+	latency time.Duration
+	status  int
+	err     error
+}
+
+type benchSender func(body []byte) (int, error)
+
+func benchLocal(handler http.Handler) benchSender {
+	return func(body []byte) (int, error) {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code, nil
+	}
+}
+
+func benchRemote(url string) benchSender {
+	client := &http.Client{Timeout: 30 * time.Second}
+	return func(body []byte) (int, error) {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+}
+
+// benchRun sends payloads at cfg.RPS for cfg.Duration. Ticks that find every
+// worker busy are recorded as dropped rather than queued, so a slow server
+// shows up as missed throughput instead of ever growing latency.
+func benchRun(cfg *benchConfig, valid []byte, invalid [][]byte, send benchSender) []benchResult {
+	var (
+		mu      sync.Mutex
+		results []benchResult
+		wg      sync.WaitGroup
+	)
+
+	jobs := make(chan []byte)
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for body := range jobs {
+				start := time.Now()
+				status, err := send(body)
+				res := benchResult{latency: time.Since(start), status: status, err: err}
+
+				mu.Lock()
+				results = append(results, res)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// Timers don't fire reliably more than about a thousand times a second,
+	// so each tick sends however many payloads are owed by then.
+	interval := time.Second / time.Duration(cfg.RPS)
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	start, sent := time.Now(), 0
+	deadline := time.After(cfg.Duration)
+
+loop:
+	for {
+		select {
+		case <-deadline:
+			break loop
+		case now := <-ticker.C:
+			owed := int(now.Sub(start).Seconds()*float64(cfg.RPS)) - sent
+			for ; owed > 0; owed-- {
+				sent++
+				body := valid
+				if len(invalid) > 0 && rand.Float64() < cfg.InvalidRatio {
+					body = invalid[rand.Intn(len(invalid))]
+				}
+
+				select {
+				case jobs <- body:
+				default:
+					mu.Lock()
+					results = append(results, benchResult{err: errBenchDropped})
+					mu.Unlock()
+				}
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+var errBenchDropped = errors.New("dropped, every worker was busy")
+
+func benchReport(results []benchResult, d time.Duration, local bool, mallocs, allocated uint64) {
+	var latencies []time.Duration
+	statuses := make(map[int]int)
+	errs := make(map[string]int)
+	for _, r := range results {
+		if r.err != nil {
+			errs[r.err.Error()]++
+			continue
+		}
+		statuses[r.status]++
+		latencies = append(latencies, r.latency)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	fmt.Printf("requests: %d in %v (%.1f/s)\n", len(latencies), d, float64(len(latencies))/d.Seconds())
+	for status, n := range statuses {
+		fmt.Printf("  %d: %d\n", status, n)
+	}
+	for msg, n := range errs {
+		fmt.Printf("  %s: %d\n", msg, n)
+	}
+
+	if len(latencies) > 0 {
+		fmt.Printf("latency: p50 %v, p90 %v, p99 %v, max %v\n",
+			percentile(latencies, 0.50), percentile(latencies, 0.90), percentile(latencies, 0.99), latencies[len(latencies)-1])
+	}
+
+	if local && len(latencies) > 0 {
+		fmt.Printf("allocations: %d per request, %d bytes per request\n", mallocs/uint64(len(latencies)), allocated/uint64(len(latencies)))
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
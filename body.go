@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// hasBody reports whether r comes with a body. Those without one, such as
+// most GETs and DELETEs, have nothing to check and are passed on as they are.
+func hasBody(r *http.Request) bool {
+	if r.Method == http.MethodHead || r.Body == nil || r.Body == http.NoBody {
+		return false
+	}
+	return r.ContentLength != 0 || len(r.TransferEncoding) > 0
+}
+
+// readBody reads the whole request body. When that fails it writes the
+// error response itself and returns false.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := bodyBuffers.readAll(r.Body, r.ContentLength)
+	r.Body.Close()
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeBodyTooLarge(w, tooLarge.Limit)
+		return nil, false
+	}
+	var encErr *encodingError
+	if errors.As(err, &encErr) {
+		writeEncodingError(w, encErr)
+		return nil, false
+	}
+	if err != nil {
+		// With compressed bodies a read error is usually a corrupt stream.
+		writeErrors(w, http.StatusBadRequest, codeUnreadableBody, fmt.Sprintf("failed to read request body: %v", err))
+		return nil, false
+	}
+
+	return body, true
+}
+
+// replaceBody makes body the request body seen by the rest of the chain,
+// and by the upstream when the request is forwarded.
+func replaceBody(r *http.Request, body []byte) {
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Del("Content-Length")
+}
+
+// decodeJSON decodes a document keeping numbers as json.Number, so large
+// integers and decimals survive a decode and encode round trip unchanged.
+func decodeJSON(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func encodeJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type breakerConfig struct {
+	Failures int
+	OpenFor  time.Duration
+}
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// breaker stops calls to a dependency after Failures consecutive failures.
+// It stays open for OpenFor, failing calls immediately, then lets a single
+// probe through: success closes it again, failure reopens it.
+type breaker struct {
+	name string
+	cfg  *breakerConfig
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.cfg.Failures {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.cfg.OpenFor {
+		return false
+	}
+
+	b.probing = true
+	return true
+}
+
+func (b *breaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if ok {
+		if b.failures >= b.cfg.Failures {
+			metricBreakerState.Set(b.name, expvarString("closed"))
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.cfg.Failures {
+		if b.failures == b.cfg.Failures {
+			metricBreakerOpened.Add(1)
+		}
+		b.openedAt = time.Now()
+		metricBreakerState.Set(b.name, expvarString("open"))
+	}
+}
+
+// breakerTransport puts a breaker per host in front of next. Transport
+// errors and 5xx responses count as failures.
+type breakerTransport struct {
+	cfg  *breakerConfig
+	next http.RoundTripper
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+func newBreakerTransport(cfg *breakerConfig, next http.RoundTripper) *breakerTransport {
+	return &breakerTransport{cfg: cfg, next: next, breakers: make(map[string]*breaker)}
+}
+
+func (t *breakerTransport) breaker(host string) *breaker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, ok := t.breakers[host]
+	if !ok {
+		b = &breaker{name: host, cfg: t.cfg}
+		t.breakers[host] = b
+	}
+	return b
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := t.breaker(req.URL.Host)
+	if !b.allow() {
+		return nil, errCircuitOpen
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	b.record(err == nil && resp.StatusCode < 500)
+	return resp, err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// bufferPool recycles the buffers request bodies are read into. Buffers that
+// grew past max are left for the garbage collector so one huge body doesn't
+// pin its memory in the pool.
+type bufferPool struct {
+	max  int
+	pool sync.Pool
+}
+
+// bodyBuffers is sized to the default body limit until main configures it.
+var bodyBuffers = &bufferPool{max: 1 << 20}
+
+// responseBuffers hold error responses while they are written.
+var responseBuffers = &bufferPool{max: 64 << 10}
+
+func (p *bufferPool) get() *bytes.Buffer {
+	if b, ok := p.pool.Get().(*bytes.Buffer); ok {
+		return b
+	}
+	return new(bytes.Buffer)
+}
+
+func (p *bufferPool) put(b *bytes.Buffer) {
+	if b.Cap() > p.max {
+		return
+	}
+	b.Reset()
+	p.pool.Put(b)
+}
+
+// readAll reads r into a pooled buffer and returns an exactly sized copy, so
+// a body costs one allocation rather than one per doubling of the buffer.
+func (p *bufferPool) readAll(r io.Reader, sizeHint int64) ([]byte, error) {
+	buf := p.get()
+	defer p.put(buf)
+
+	if sizeHint > 0 && sizeHint <= int64(p.max) {
+		buf.Grow(int(sizeHint) + bytes.MinRead)
+	}
+
+	_, err := buf.ReadFrom(r)
+	return bytes.Clone(buf.Bytes()), err
+}
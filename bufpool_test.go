@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func BenchmarkReadBody(b *testing.B) {
+	body := bytes.Repeat([]byte(`{"title":"A title","body":"some text"},`), 1<<10)
+
+	b.Run("ReadAll", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(body)))
+		for b.Loop() {
+			if _, err := io.ReadAll(bytes.NewReader(body)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("pool", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(body)))
+		for b.Loop() {
+			if _, err := bodyBuffers.readAll(bytes.NewReader(body), 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("pool with Content-Length", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(body)))
+		for b.Loop() {
+			if _, err := bodyBuffers.readAll(bytes.NewReader(body), int64(len(body))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestBufferPoolReadAll(t *testing.T) {
+	p := &bufferPool{max: 64}
+	for _, size := range []int{0, 10, 64, 1000} {
+		body := bytes.Repeat([]byte("x"), size)
+		got, err := p.readAll(bytes.NewReader(body), int64(size))
+		if err != nil || !bytes.Equal(got, body) {
+			t.Errorf("readAll of %d bytes = %d bytes, %v", size, len(got), err)
+		}
+	}
+
+	// The copy returned must not share the pooled buffer.
+	first, _ := p.readAll(bytes.NewReader([]byte("first")), 0)
+	p.readAll(bytes.NewReader([]byte("other")), 0)
+	if string(first) != "first" {
+		t.Errorf("first body became %q after the buffer was reused", first)
+	}
+}
@@ -0,0 +1,156 @@
+// Package caddyschemavalidations is a Caddy HTTP handler validating request
+// bodies with package schemavalidation, so schema validation can be turned
+// on per route in an existing Caddy. Build it in with
+//
+//	xcaddy build --with github.com/mitchfriedman/schema-validations/caddy
+//
+// and use it from a Caddyfile:
+//
+//	route /posts* {
+//		schema_validations {
+//			schema_file   schemas/post.v1.json
+//			max_body_size 1048576
+//			report_only
+//		}
+//		reverse_proxy localhost:8080
+//	}
+package caddyschemavalidations
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+func init() {
+	caddy.RegisterModule(Handler{})
+	httpcaddyfile.RegisterHandlerDirective("schema_validations", parseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("schema_validations", httpcaddyfile.Before, "reverse_proxy")
+}
+
+// Handler rejects requests whose body doesn't match the schema with the
+// gateway's 400 and error body, and passes the rest on.
+type Handler struct {
+	// SchemaFile is the JSON Schema to validate against.
+	SchemaFile string `json:"schema_file,omitempty"`
+	// Schema is the JSON Schema inline, instead of SchemaFile.
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// MaxBodyBytes rejects larger bodies with 413, unlimited if 0.
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
+	// ReportOnly logs invalid bodies instead of rejecting them.
+	ReportOnly bool `json:"report_only,omitempty"`
+	// StringLength is how minLength and maxLength count characters: runes,
+	// the default, bytes or graphemes, as -string-length does.
+	StringLength string `json:"string_length,omitempty"`
+
+	validator *schemavalidation.Validator
+}
+
+// CaddyModule returns the Caddy module information.
+func (Handler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.schema_validations",
+		New: func() caddy.Module { return new(Handler) },
+	}
+}
+
+// Provision compiles the schema.
+func (h *Handler) Provision(ctx caddy.Context) error {
+	schema := []byte(h.Schema)
+	if h.SchemaFile != "" {
+		var err error
+		if schema, err = os.ReadFile(h.SchemaFile); err != nil {
+			return err
+		}
+	}
+
+	logger := ctx.Logger().Sugar()
+	v, err := schemavalidation.New(schema, schemavalidation.Options{
+		MaxBodyBytes: h.MaxBodyBytes,
+		ReportOnly:   h.ReportOnly,
+		StringLength: h.StringLength,
+		Logf: func(format string, args ...interface{}) {
+			logger.Warnw(fmt.Sprintf(format, args...), zap.String("module", "schema_validations"))
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("invalid schema: %v", err)
+	}
+	h.validator = v
+	return nil
+}
+
+// Validate checks exactly one schema is configured.
+func (h *Handler) Validate() error {
+	if (h.SchemaFile == "") == (len(h.Schema) == 0) {
+		return errors.New("exactly one of schema_file and schema is required")
+	}
+	return nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if !h.validator.Check(w, r) {
+		return nil
+	}
+	return next.ServeHTTP(w, r)
+}
+
+// UnmarshalCaddyfile reads the schema_validations directive.
+func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next()
+	if d.NextArg() {
+		h.SchemaFile = d.Val()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "schema_file":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			h.SchemaFile = d.Val()
+		case "max_body_size":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.ParseInt(d.Val(), 10, 64)
+			if err != nil {
+				return d.Errf("invalid max_body_size: %v", err)
+			}
+			h.MaxBodyBytes = n
+		case "report_only":
+			h.ReportOnly = true
+		case "string_length":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			h.StringLength = d.Val()
+		default:
+			return d.Errf("unknown subdirective %q", d.Val())
+		}
+	}
+	return nil
+}
+
+func parseCaddyfile(helper httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var h Handler
+	err := h.UnmarshalCaddyfile(helper.Dispenser)
+	return &h, err
+}
+
+var (
+	_ caddy.Provisioner           = (*Handler)(nil)
+	_ caddy.Validator             = (*Handler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*Handler)(nil)
+	_ caddyfile.Unmarshaler       = (*Handler)(nil)
+)
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+type chaosConfig struct {
+	Enabled         bool
+	Header          string
+	DelayPercent    float64
+	Delay           time.Duration
+	ThrottlePercent float64
+	RejectPercent   float64
+}
+
+func (c *chaosConfig) enabled() bool {
+	return c.Enabled || c.Header != ""
+}
+
+// injectFaults makes requests fail the way a busy or strict gateway would,
+// at the configured rates, so clients can exercise their retries and error
+// handling. With a header configured, only requests carrying it are
+// affected. Injected responses carry X-Chaos-Injected.
+func injectFaults(c *chaosConfig, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.Enabled && r.Header.Get(c.Header) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if c.Delay > 0 && rand.Float64()*100 < c.DelayPercent {
+			metricChaos.Add("delay", 1)
+			w.Header().Add("X-Chaos-Injected", "delay")
+			select {
+			case <-time.After(time.Duration(rand.Int63n(int64(c.Delay)) + 1)):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if rand.Float64()*100 < c.ThrottlePercent {
+			metricChaos.Add("throttle", 1)
+			w.Header().Add("X-Chaos-Injected", "throttle")
+			w.Header().Set("Retry-After", "1")
+			writeErrors(w, http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded")
+			return
+		}
+
+		if rand.Float64()*100 < c.RejectPercent {
+			metricChaos.Add("reject", 1)
+			w.Header().Add("X-Chaos-Injected", "reject")
+			writeErrors(w, http.StatusBadRequest, codeSchemaViolation, "request body does not match the schema")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
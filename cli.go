@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// errFailed is returned by commands that have already reported why they
+// failed, so only the exit status is left to set.
+var errFailed = errors.New("failed")
+
+// exitStatus is returned by commands exiting with a status of their own
+// rather than 1, printing err first when it is set.
+type exitStatus struct {
+	code int
+	err  error
+}
+
+func (e *exitStatus) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// newCLI builds the command tree. Every command parses its own flags with
+// the flag package, so they keep the -flag syntax, the config file and the
+// SV_ environment variables the server has always accepted.
+func newCLI() *cobra.Command {
+	root := &cobra.Command{
+		Use:           "schema-validations",
+		Short:         "Validate JSON requests against JSON Schemas",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+
+	root.AddCommand(
+		flagCommand("serve", "Serve the validating gateway, the default command", func(args []string) error {
+			serve(args)
+			return nil
+		}),
+		flagCommand("validate", "Validate JSON documents against a schema", runValidate),
+		flagCommand("repl", "Validate JSON documents as they are pasted in against a schema file, reloading it on change", runREPL),
+		flagCommand("lint", "Check schemas compile and flag common mistakes", runLint),
+		flagCommand("diff", "Report changes, and breaking changes, between two schema versions", runDiff),
+		flagCommand("test", "Check valid/ and invalid/ example payloads per schema are classified as expected", runTest),
+		flagCommand("pact", "Check the requests in Pact contracts are accepted by the schemas", runPact),
+		flagCommand("replay", "Validate recorded traffic against the schemas and list changed outcomes", runReplay),
+		flagCommand("infer", "Draft a schema per route from recorded traffic", runInfer),
+		flagCommand("bench", "Drive a server or the in-process validator with generated payloads", runBench),
+		flagCommand("version", "Print the version, commit and build date", runVersion),
+	)
+
+	generate := flagCommand("generate", "Generate sample payloads from a schema", runGenerate)
+	generate.AddCommand(flagCommand("go", "Generate a Go client package per schema that validates payloads before sending", runGenerateGo))
+	generate.AddCommand(flagCommand("ts", "Generate TypeScript types, and optionally zod schemas, from the schemas", runGenerateTS))
+	root.AddCommand(generate)
+
+	config := &cobra.Command{Use: "config", Short: "Work with the server configuration"}
+	config.AddCommand(flagCommand("check", "Load and validate the configuration and its schemas without serving", runConfigCheck))
+	root.AddCommand(config)
+
+	kube := &cobra.Command{Use: "kubernetes", Short: "Work with schemas kept in Kubernetes"}
+	kube.AddCommand(flagCommand("crd", "Print the ValidationSchema CustomResourceDefinition to kubectl apply", runKubeCRD))
+	root.AddCommand(kube)
+
+	return root
+}
+
+func flagCommand(name, short string, run func(args []string) error) *cobra.Command {
+	return &cobra.Command{
+		Use:                name,
+		Short:              short,
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(args)
+		},
+	}
+}
+
+// cliArgs defaults to serve when no command is named, so existing
+// invocations such as `schema-validations -addr :8000` keep working.
+// -version is the version command.
+func cliArgs(args []string) []string {
+	if len(args) > 0 && (args[0] == "-version" || args[0] == "--version") {
+		return append([]string{"version"}, args[1:]...)
+	}
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "--help" && args[0] != "-help") {
+		return append([]string{"serve"}, args...)
+	}
+	return args
+}
+
+func runCLI() {
+	root := newCLI()
+	root.SetArgs(cliArgs(os.Args[1:]))
+
+	if err := root.Execute(); err != nil {
+		var status *exitStatus
+		if errors.As(err, &status) {
+			if status.err != nil {
+				fmt.Fprintln(os.Stderr, status.err)
+			}
+			os.Exit(status.code)
+		}
+		if err != errFailed {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+}
+
+// schemaArgs are the flags commands working on a single schema share.
+type schemaArgs struct {
+	cfg    schemaConfig
+	schema string
+	length string
+}
+
+func (a *schemaArgs) register(fs *flag.FlagSet) {
+	fs.StringVar(&a.cfg.Dir, "schema-dir", "", "directory of <name>.<version>.json schemas, the built-in post schema if empty")
+	fs.StringVar(&a.cfg.BaseSchema, "base-schema", "", "JSON schema of fields every request shares, added to the allOf of every schema")
+	fs.BoolVar(&a.cfg.Embedded, "embedded-schemas", embeddedSchemas != nil, "load the schemas bundled into the binary with -tags embedschemas")
+	fs.StringVar(&a.schema, "schema", "", "schema to use as <name> or <name>.<version>, the default schema if empty")
+	fs.StringVar(&a.length, "string-length", "runes", "how minLength and maxLength count characters: runes, bytes or graphemes")
+}
+
+// load loads the schemas and picks the one -schema names.
+func (a *schemaArgs) load() (*schemaStore, *schemaVersion, error) {
+	name, version := splitSchemaID(a.schema)
+	a.cfg.Default, a.cfg.DefaultVersion = name, version
+	if err := setStringLength(a.length); err != nil {
+		return nil, nil, err
+	}
+
+	store, err := newSchemaStore(&a.cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load schemas: %v", err)
+	}
+
+	registry := store.current()
+	schema, ok := registry.base.lookup(registry.defaultName, version)
+	if !ok {
+		return nil, nil, fmt.Errorf("no schema %q at version %q", registry.defaultName, version)
+	}
+	return store, schema, nil
+}
+
+// splitSchemaID splits <name>.<version>, or just <name> for the latest
+// version.
+func splitSchemaID(id string) (name, version string) {
+	if i := strings.LastIndex(id, "."); i > 0 && isVersion(id[i+1:]) {
+		return id[:i], id[i+1:]
+	}
+	return id, ""
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientKey struct{}
+
+// resolveClient works out the address of the client r is from for the rest
+// of the chain. When the peer is in one of the trusted proxy networks the
+// client is read from X-Forwarded-For instead: the rightmost address that
+// isn't a trusted proxy, since anything left of it could have been made up
+// by the client. A header that can't be parsed leaves the client unknown.
+func resolveClient(proxies []netip.Prefix, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, _ := netip.ParseAddr(peerIP(r))
+		addr = addr.Unmap()
+		if addr.IsValid() && containsAddr(proxies, addr) {
+			var hops []string
+			for _, header := range r.Header.Values("X-Forwarded-For") {
+				hops = append(hops, strings.Split(header, ",")...)
+			}
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+				if err != nil {
+					addr = netip.Addr{}
+					break
+				}
+				addr = hop.Unmap()
+				if !containsAddr(proxies, addr) {
+					break
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, addr)))
+	})
+}
+
+// clientAddr returns the address of the client r is from, false when it
+// isn't known.
+func clientAddr(r *http.Request) (netip.Addr, bool) {
+	if addr, ok := r.Context().Value(clientKey{}).(netip.Addr); ok {
+		return addr, addr.IsValid()
+	}
+	addr, err := netip.ParseAddr(peerIP(r))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// clientIP returns the address of the client r is from, or of the peer that
+// sent it when that isn't known.
+func clientIP(r *http.Request) string {
+	if addr, ok := clientAddr(r); ok {
+		return addr.String()
+	}
+	return peerIP(r)
+}
+
+// peerIP returns the address of the peer that sent r, without the port.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// clientIdentity returns the identity presented in a verified client
+// certificate: the subject common name, falling back to the first DNS, URI or
+// email SAN. It is empty when the client did not present a certificate.
+func clientIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	}
+
+	return ""
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+var (
+	jsonNumberRe  = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+	jsonIntegerRe = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+)
+
+// coerceTypes converts strings to the number or boolean the selected schema
+// expects where the conversion is unambiguous, e.g. "5" to 5 and "true" to
+// true, for form based and legacy clients that stringify everything. It runs
+// before validation, and the coerced body is what gets forwarded.
+func coerceTypes(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+
+		doc, err := decodeJSON(body)
+		if err != nil {
+			// Leave reporting malformed JSON to validation.
+			replaceBody(r, body)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		schema := requestSchema(r)
+		doc = coerce(schema.Document, schema.Document, doc)
+
+		if b, err := encodeJSON(doc); err == nil {
+			body = b
+		}
+		replaceBody(r, body)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func coerce(root, schema, value interface{}) interface{} {
+	parts := schemavalidation.SchemaParts(root, schema)
+
+	switch v := value.(type) {
+	case string:
+		return coerceString(schemaTypes(parts), v)
+
+	case map[string]interface{}:
+		for key, child := range v {
+			for _, s := range schemavalidation.PropertySchemas(parts, key) {
+				child = coerce(root, s, child)
+			}
+			v[key] = child
+		}
+
+	case []interface{}:
+		for i, child := range v {
+			for _, s := range schemavalidation.ItemSchemas(parts, i) {
+				child = coerce(root, s, child)
+			}
+			v[i] = child
+		}
+	}
+
+	return value
+}
+
+// coerceString converts s when the schema doesn't allow strings at that
+// location but does allow a type s is a clear representation of.
+func coerceString(types map[string]bool, s string) interface{} {
+	if len(types) == 0 || types["string"] {
+		return s
+	}
+
+	t := strings.TrimSpace(s)
+	switch {
+	case types["integer"] && jsonIntegerRe.MatchString(t):
+		return json.Number(t)
+	case types["number"] && jsonNumberRe.MatchString(t):
+		return json.Number(t)
+	case types["boolean"] && (t == "true" || t == "false"):
+		return t == "true"
+	case types["null"] && t == "null":
+		return nil
+	}
+
+	return s
+}
+
+// schemaTypes returns the types allowed by every part declaring a type.
+func schemaTypes(parts []schemavalidation.SchemaObject) map[string]bool {
+	var types map[string]bool
+
+	for _, part := range parts {
+		var declared []string
+		switch t := part["type"].(type) {
+		case string:
+			declared = []string{t}
+		case []interface{}:
+			for _, e := range t {
+				if s, ok := e.(string); ok {
+					declared = append(declared, s)
+				}
+			}
+		default:
+			continue
+		}
+
+		allowed := make(map[string]bool)
+		for _, t := range declared {
+			if types == nil || types[t] || (t == "number" && types["integer"]) || (t == "integer" && types["number"]) {
+				allowed[t] = true
+			}
+		}
+		types = allowed
+	}
+
+	return types
+}
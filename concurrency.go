@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+type concurrencyConfig struct {
+	MaxConcurrent int
+	MaxQueue      int
+	QueueTimeout  time.Duration
+}
+
+// concurrencyLimiter caps how many requests are validated at once. Requests
+// over the cap wait in a bounded queue; once the queue is full, or a request
+// has waited longer than the queue timeout, it is shed with a 503 so latency
+// stays bounded for everyone else.
+type concurrencyLimiter struct {
+	slots   chan struct{}
+	queue   chan struct{}
+	timeout time.Duration
+}
+
+func newConcurrencyLimiter(cfg *concurrencyConfig) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		slots:   make(chan struct{}, cfg.MaxConcurrent),
+		queue:   make(chan struct{}, cfg.MaxQueue),
+		timeout: cfg.QueueTimeout,
+	}
+}
+
+func (l *concurrencyLimiter) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return false
+	}
+	metricQueueDepth.Add(1)
+	defer func() {
+		<-l.queue
+		metricQueueDepth.Add(-1)
+	}()
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (l *concurrencyLimiter) release() {
+	<-l.slots
+}
+
+func limitConcurrency(l *concurrencyLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire(r) {
+			metricShed.Add(1)
+			writeErrors(w, http.StatusServiceUnavailable, codeOverloaded, "server is overloaded, try again later")
+			return
+		}
+		defer l.release()
+
+		metricInFlight.Add(1)
+		defer metricInFlight.Add(-1)
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+type config struct {
+	Addr          string
+	AdminAddr     string
+	AdminKeysFile string
+	AdminReadOnly bool
+	AdminJWTRole  string
+	LogLevel      string
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+
+	Upstream             string
+	ReadyUpstreamTimeout time.Duration
+	ResponseSchemas      responseSchemas
+	ResponseViolation    string
+	Mirror               mirrorConfig
+	Record               recordConfig
+	Breaker              breakerConfig
+
+	Schemas              schemaConfig
+	SchemaStore          string
+	SchemaKV             string
+	SchemaPublicKey      string
+	SchemaAgeIdentity    string
+	Kubernetes           kubeConfig
+	SchemaRetry          backoff
+	BodyLimits           bodyLimits
+	RoutePolicies        routePolicies
+	MaxDecodedBytes      int64
+	ContentTypes         string
+	RequestIDHeader      string
+	InjectDefaults       bool
+	CoerceTypes          bool
+	StringLength         string
+	NormalizeUnicode     bool
+	ByteOrderMark        string
+	StripUnknown         bool
+	AdditionalProperties string
+	Email                emailConfig
+	JSONPatch            bool
+	MergePatch           mergePatchConfig
+	GraphQL              graphqlConfig
+	Admission            admissionConfig
+	Report               reportConfig
+	Egress               egressConfig
+	JSONLimits           jsonLimits
+	StreamCheck          bool
+	ResultCacheSize      int
+	Idempotency          idempotencyConfig
+	CoveragePercent      float64
+	TrackUnknownFields   bool
+	FieldStatsPercent    float64
+	Learn                learnConfig
+	Drift                driftConfig
+	Alerts               alertConfig
+	Settings             settings
+	FeatureFlags         featureFlagConfig
+	ValidationWorkers    int
+	ValidationQueue      int
+	RateLimit            rateLimitConfig
+	Quotas               quotaConfig
+	Concurrency          concurrencyConfig
+	TLS                  tlsConfig
+	JWT                  jwtConfig
+	Introspection        introspectionConfig
+	CORS                 corsConfig
+	IPFilter             ipFilterConfig
+	TrustedProxies       string
+	Chaos                chaosConfig
+
+	ACMEHTTPAddr string
+	H2C          bool
+	HTTP3        bool
+
+	UnixSocket     string
+	UnixSocketMode string
+
+	// Args are what is left after the flags, for commands that take files
+	// as well as the server's flags.
+	Args []string
+}
+
+func parseConfig(args []string) *config {
+	cfg := config{BodyLimits: bodyLimits{Routes: routeLimits{}}, ResponseSchemas: responseSchemas{}, RoutePolicies: routePolicies{}}
+
+	fs := flag.NewFlagSet("schema-validations", flag.ExitOnError)
+	fs.StringVar(&cfg.Addr, "addr", ":8000", "address to serve validated traffic on")
+	fs.StringVar(&cfg.AdminAddr, "admin-addr", ":9000", "address to serve health, pprof and admin endpoints on")
+	fs.StringVar(&cfg.AdminKeysFile, "admin-keys-file", "", "file of SHA-256 hashes of API keys allowed to call admin endpoints, one per line")
+	fs.StringVar(&cfg.AdminJWTRole, "admin-jwt-role-claim", "", "claim of bearer JWTs, verified against -jwt-jwks-url, naming the admin role of the caller, viewer, uploader or operator, to let them call admin endpoints")
+	fs.BoolVar(&cfg.AdminReadOnly, "admin-read-only", false, "refuse every admin request that would change something, uploads, rollbacks, settings and log levels, leaving only reads")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "minimum level to log at: debug, info, warn or error, changeable at runtime through /admin/loglevel with -admin-keys-file")
+	fs.StringVar(&cfg.Upstream, "upstream", "", "URL to forward valid requests to, valid requests are answered directly if empty")
+	fs.DurationVar(&cfg.ReadyUpstreamTimeout, "ready-upstream-timeout", time.Second, "how long /readyz waits to connect to -upstream, reporting not ready when it can't, 0 to leave the upstream out of readiness")
+	fs.Var(cfg.ResponseSchemas, "response-schema", "schema upstream responses on a route must satisfy as `prefix=name.version`, may be repeated")
+	fs.StringVar(&cfg.ResponseViolation, "response-violation", "log", "what to do with upstream responses violating their schema: log, header to also flag them with X-Response-Schema-Violation, or reject to replace them with a 502")
+	fs.StringVar(&cfg.Mirror.Upstream, "mirror-upstream", "", "shadow upstream to send copies of forwarded requests to, requires -upstream")
+	fs.Float64Var(&cfg.Mirror.Percent, "mirror-percent", 100, "percentage of forwarded requests to mirror")
+	fs.IntVar(&cfg.Mirror.MaxInFlight, "mirror-max-in-flight", 100, "maximum number of mirrored requests in flight, further copies are dropped")
+	fs.DurationVar(&cfg.Mirror.Timeout, "mirror-timeout", 5*time.Second, "maximum time to wait for the shadow upstream")
+	fs.StringVar(&cfg.Record.File, "record-file", "", "file to append requests and their outcomes to, as JSON lines for the replay command")
+	fs.Float64Var(&cfg.Record.Percent, "record-percent", 100, "percentage of requests to record")
+	fs.StringVar(&cfg.Record.Redact, "record-redact", "password,secret,token", "comma-separated body fields and headers whose values are redacted in recordings, at any depth")
+	fs.IntVar(&cfg.Breaker.Failures, "breaker-failures", 0, "consecutive failures of the upstream or a remote schema host that open its circuit breaker, 0 to disable")
+	fs.DurationVar(&cfg.Breaker.OpenFor, "breaker-open-for", 30*time.Second, "how long an open circuit breaker fails calls before letting a probe through")
+	fs.StringVar(&cfg.SchemaKV, "schema-kv", "", "etcd://host:port/prefix or consul://host:port/prefix, with +https for TLS, to read schemas from, overriding -schema-dir, and watch for changes; Consul's token is read from CONSUL_HTTP_TOKEN")
+	fs.StringVar(&cfg.Kubernetes.Resource, "schema-kubernetes", "", "read schemas from, and watch, labeled configmaps or validationschemas custom resources in the cluster, overriding -schema-dir")
+	fs.StringVar(&cfg.Kubernetes.Namespace, "kubernetes-namespace", "", "namespace to read -schema-kubernetes objects from, the pod's own if empty")
+	fs.StringVar(&cfg.Kubernetes.Selector, "kubernetes-label-selector", "schema-validations.io/schema=true", "label selector of the -schema-kubernetes objects to read")
+	fs.StringVar(&cfg.Kubernetes.API, "kubernetes-api", "", "URL of the Kubernetes API server, e.g. from kubectl proxy, instead of the in-cluster one with the pod's service account")
+	fs.StringVar(&cfg.SchemaPublicKey, "schema-public-key", "", "file of the PEM (cosign, Ed25519) or minisign public keys schema files, keys and uploads must carry a detached signature of, in <file>.sig or the upload's X-Schema-Signature header")
+	fs.StringVar(&cfg.SchemaAgeIdentity, "schema-age-identity", "", "file of the age X25519 identities to decrypt <name>.<version>.json.age schema files with, and to keep uploads encrypted to in -schema-store")
+	fs.StringVar(&cfg.SchemaStore, "schema-store", "", "Postgres connection URL to keep schemas uploaded through /admin/schemas in, so they survive restarts, in memory only if empty")
+	fs.StringVar(&cfg.Schemas.Dir, "schema-dir", "", "directory of <name>.<version>.json schemas to load instead of the built-in post schema, with per-tenant overrides in subdirectories, overriding embedded schemas of the same name and version")
+	fs.StringVar(&cfg.Schemas.BaseSchema, "base-schema", "", "JSON schema of fields every request shares, added to the allOf of every loaded schema")
+	fs.BoolVar(&cfg.Schemas.Embedded, "embedded-schemas", embeddedSchemas != nil, "load the schemas bundled into the binary with -tags embedschemas")
+	fs.StringVar(&cfg.Schemas.Default, "default-schema", "", "schema to validate against when a request doesn't select one, required with more than one schema")
+	fs.StringVar(&cfg.Schemas.DefaultVersion, "default-schema-version", "", "version of the default schema to validate against when a request doesn't name one, latest if empty")
+	fs.StringVar(&cfg.Schemas.VersionHeader, "schema-version-header", "X-Schema-Version", "request header selecting the version of the default schema")
+	fs.StringVar(&cfg.Schemas.Deprecated, "deprecated-schemas", "", "comma separated <name>.<version>[=YYYY-MM-DD] schema versions to flag as deprecated, with an optional sunset date")
+	fs.StringVar(&cfg.Schemas.TenantFrom, "tenant-from", "", "where to read the tenant id selecting schema overrides from: header:<name>, subdomain, claim:<name> or client-cert")
+	fs.IntVar(&cfg.SchemaRetry.Retries, "schema-retries", 3, "how many times to retry loading schemas, and fetching remote $refs, after a failure")
+	fs.DurationVar(&cfg.SchemaRetry.Base, "schema-retry-backoff", 200*time.Millisecond, "initial delay between schema loading retries, doubled on every retry")
+	fs.DurationVar(&cfg.SchemaRetry.Max, "schema-retry-max-backoff", 5*time.Second, "maximum delay between schema loading retries")
+	fs.StringVar(&cfg.Schemas.ProtoDescriptors, "proto-descriptors", "", "FileDescriptorSet of grpc-gateway services to derive a schema per google.api.http binding from, selected by method and path")
+	fs.StringVar(&cfg.Schemas.ExampleFixtures, "example-fixtures", "", "directory of fixtures, laid out as for the test command, checked along with the root examples of every schema whenever schemas are loaded")
+	fs.StringVar(&cfg.Schemas.ExampleFailures, "example-failures", "warn", "what to do when a schema example or fixture fails as schemas are loaded: off, warn or fail, refusing to start or reload")
+	fs.StringVar(&cfg.Schemas.RulesFile, "schema-rules-file", "", "JSON file of rules adjusting the selected schema for requests matching a method, path prefix, headers or claims")
+	fs.StringVar(&cfg.Schemas.Vendor, "media-type-vendor", "", "vendor name enabling schema selection by application/vnd.<vendor>.<name>.<version>+json media types")
+	fs.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", 5*time.Second, "maximum time to read request headers")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", 30*time.Second, "maximum time to read an entire request, including the body")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", 30*time.Second, "maximum time to write a response")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", 120*time.Second, "maximum time to keep an idle keep-alive connection open")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 15*time.Second, "how long to wait for in-flight requests to finish on shutdown")
+	fs.Int64Var(&cfg.BodyLimits.Max, "max-body-bytes", 1<<20, "maximum request body size in bytes")
+	fs.Var(cfg.BodyLimits.Routes, "route-max-body-bytes", "per-route body size limit as `prefix=bytes`, may be repeated")
+	fs.Var(cfg.RoutePolicies, "route-policy", "per-route overrides as `prefix=key:value;...` with the keys max-body-bytes, status, error-detail, enforcement, timeout and validation-timeout, may be repeated")
+	fs.Int64Var(&cfg.MaxDecodedBytes, "max-decompressed-bytes", 10<<20, "maximum size in bytes of a compressed request body once decompressed")
+	fs.StringVar(&cfg.RequestIDHeader, "request-id-header", "X-Request-Id", "header carrying the request ID, generated when missing, forwarded upstream, echoed back and included in error bodies as request_id")
+	fs.StringVar(&cfg.ContentTypes, "content-types", "application/json", "comma separated request content types to accept, wildcards such as application/*+json are allowed")
+	fs.BoolVar(&cfg.InjectDefaults, "inject-defaults", false, "fill in missing optional properties from schema defaults before forwarding")
+	fs.StringVar(&cfg.StringLength, "string-length", "runes", "how minLength and maxLength count characters: runes, bytes or graphemes")
+	fs.StringVar(&cfg.ByteOrderMark, "byte-order-mark", "reject", "what to do with request bodies starting with a UTF-8 byte order mark: reject or strip")
+	fs.BoolVar(&cfg.NormalizeUnicode, "normalize-unicode", false, "put the strings of request bodies in Unicode normalization form C before validating and forwarding them")
+	fs.BoolVar(&cfg.CoerceTypes, "coerce-types", false, "convert strings such as \"5\" or \"true\" to the number or boolean the schema expects before validating")
+	fs.BoolVar(&cfg.StripUnknown, "strip-unknown", false, "remove properties the schema doesn't describe before forwarding")
+	fs.BoolVar(&cfg.Email.Verify, "verify-email-domains", false, "reject format: email fields whose domain has no mail server or is a disposable email service")
+	fs.StringVar(&cfg.Email.Disposable, "email-disposable-domains", "", "file of disposable email domains to block with -verify-email-domains, one per line, in addition to the built-in ones")
+	fs.DurationVar(&cfg.Email.Timeout, "email-lookup-timeout", 2*time.Second, "maximum time to look up the mail servers of an email domain, addresses are let through when it runs out")
+	fs.DurationVar(&cfg.Email.CacheTTL, "email-lookup-cache-ttl", time.Hour, "how long the mail server lookup of an email domain is cached")
+	fs.StringVar(&cfg.AdditionalProperties, "additional-properties", "", "override additionalProperties for every object: reject to treat it as false, log to log unknown properties")
+	fs.BoolVar(&cfg.JSONPatch, "json-patch", false, "validate PATCH requests with an application/json-patch+json body as JSON Patches against the schema")
+	fs.StringVar(&cfg.MergePatch.ResourceURL, "merge-patch-resource-url", "", "base URL to GET the current resource from, joined with the request path, to validate application/merge-patch+json PATCHes by their merged result")
+	fs.StringVar(&cfg.MergePatch.ResourceHeader, "merge-patch-resource-header", "", "request header carrying the base64 encoded current resource, to validate application/merge-patch+json PATCHes by their merged result")
+	fs.DurationVar(&cfg.MergePatch.Timeout, "merge-patch-timeout", 5*time.Second, "maximum time to fetch the current resource for a merge patch")
+	fs.StringVar(&cfg.GraphQL.Path, "graphql-path", "", "path of a GraphQL endpoint whose operation variables are validated against per-operation schemas")
+	fs.StringVar(&cfg.GraphQL.SchemaPrefix, "graphql-schema-prefix", "", "prefix of the schema names for GraphQL operations, the schema of operation CreatePost being <prefix>CreatePost")
+	fs.StringVar(&cfg.GraphQL.Unknown, "graphql-unknown-operations", "allow", "what to do with GraphQL operations without a schema: allow or reject")
+	fs.StringVar(&cfg.Admission.Path, "admission-path", "", "path to serve a Kubernetes validating admission webhook on, validating objects against the schemas of -admission-rule")
+	fs.Var(&cfg.Admission.Rules, "admission-rule", "schema objects of a kind are validated against by the admission webhook, as `[group/]Kind[#pointer]=name.version` with no group for core kinds, may be repeated")
+	fs.StringVar(&cfg.Report.Path, "validate-path", "", "path to serve a validation report endpoint on, answering documents posted to it with whether they are valid and why not, without forwarding them")
+	fs.DurationVar(&cfg.Report.CacheTTL, "validate-cache-ttl", 0, "how long reports of -validate-path are cached by schema version and body hash, and may be cached by clients, 0 to disable")
+	fs.IntVar(&cfg.Report.CacheSize, "validate-cache-size", 10000, "maximum number of reports of -validate-path cached")
+	fs.Float64Var(&cfg.CoveragePercent, "schema-coverage-percent", 0, "percentage of valid requests whose oneOf and anyOf alternatives, enum values and optional properties are counted, served from /admin/coverage on the admin listener, 0 to disable")
+	fs.BoolVar(&cfg.TrackUnknownFields, "track-unknown-fields", false, "count the properties valid requests send that their schema doesn't describe, served from /admin/unknown-fields on the admin listener")
+	fs.Float64Var(&cfg.FieldStatsPercent, "field-stats-percent", 0, "percentage of valid requests to collect field presence, length and numeric range statistics from, served from /admin/field-stats on the admin listener, 0 to disable")
+	fs.DurationVar(&cfg.Learn.Duration, "learn-duration", 0, "observe request bodies for this long after starting and draft a schema per route from them, served from /admin/learned-schemas on the admin listener, 0 to disable")
+	fs.Float64Var(&cfg.Learn.RequiredThreshold, "learn-required-threshold", 1, "fraction of the objects a property has to be present in to be drafted as required")
+	fs.IntVar(&cfg.Learn.EnumMax, "learn-enum-max", 10, "most distinct values a string field can take to be drafted as an enum")
+	fs.Float64Var(&cfg.Drift.Threshold, "drift-threshold", 0, "percentage of a schema version's requests over a -drift-window an unknown field or validation error has to appear in to raise a drift alert, served from /admin/drift on the admin listener, 0 to disable")
+	fs.DurationVar(&cfg.Drift.Window, "drift-window", 10*time.Minute, "window drift is measured over")
+	fs.Int64Var(&cfg.Drift.MinRequests, "drift-min-requests", 100, "fewest requests to a schema version in a window for drift to be measured")
+	fs.StringVar(&cfg.Alerts.WebhookURL, "alert-webhook-url", "", "URL to POST a JSON notification to when a route's validation failure rate crosses -alert-failure-threshold, and when drift alerts are raised or resolved")
+	fs.StringVar(&cfg.Alerts.SlackWebhookURL, "alert-slack-webhook-url", "", "Slack incoming webhook URL to post the notifications to")
+	fs.StringVar(&cfg.Alerts.PagerDutyKey, "alert-pagerduty-routing-key", "", "PagerDuty Events API v2 routing key to trigger and resolve incidents for the notifications with")
+	fs.StringVar(&cfg.Alerts.PagerDutyURL, "alert-pagerduty-url", "https://events.pagerduty.com/v2/enqueue", "PagerDuty Events API v2 endpoint")
+	fs.StringVar(&cfg.Alerts.FailureSeverity, "alert-failure-severity", "error", "severity of failure rate notifications: critical, error, warning or info")
+	fs.StringVar(&cfg.Alerts.DriftSeverity, "alert-drift-severity", "warning", "severity of drift notifications: critical, error, warning or info")
+	fs.DurationVar(&cfg.Alerts.Timeout, "alert-webhook-timeout", 5*time.Second, "maximum time to deliver a notification to -alert-webhook-url")
+	fs.Float64Var(&cfg.Alerts.FailureThreshold, "alert-failure-threshold", 0, "percentage of a route's requests over an -alert-window failing validation that fires a notification, 0 to disable")
+	fs.DurationVar(&cfg.Alerts.Window, "alert-window", 5*time.Minute, "window failure rates are measured over")
+	fs.Int64Var(&cfg.Alerts.MinRequests, "alert-min-requests", 20, "fewest requests to a route in a window for its failure rate to be measured")
+	fs.StringVar(&cfg.Egress.Destinations, "egress-destinations", "", "JSON file of webhook destinations; outgoing webhooks posted to -egress-path/<destination> are validated against the destination's published schema, signed and forwarded")
+	fs.StringVar(&cfg.Egress.Path, "egress-path", "/egress", "path egress webhooks are posted under, with -egress-destinations")
+	fs.IntVar(&cfg.JSONLimits.MaxDepth, "max-json-depth", 32, "maximum nesting depth of a request body, 0 for no limit")
+	fs.IntVar(&cfg.JSONLimits.MaxTokens, "max-json-tokens", 100000, "maximum number of JSON tokens in a request body, 0 for no limit")
+	fs.IntVar(&cfg.JSONLimits.MaxArrayLen, "max-json-array-len", 10000, "maximum length of any array in a request body, 0 for no limit")
+	fs.IntVar(&cfg.JSONLimits.MaxObjectKeys, "max-json-object-keys", 1000, "maximum number of keys in any object in a request body, 0 for no limit")
+	fs.BoolVar(&cfg.JSONLimits.ExactFloats, "reject-imprecise-numbers", false, "reject request bodies with numbers a 64-bit float can't represent exactly, such as integers beyond 2^53, which clients parsing numbers as doubles would silently change")
+	fs.BoolVar(&cfg.JSONLimits.DuplicateKeys, "reject-duplicate-keys", false, "reject request bodies with an object holding the same key twice, which parsers resolve differently and can be used to smuggle values past validation")
+	fs.BoolVar(&cfg.JSONLimits.Strict, "strict-json", false, "reject request bodies with anything after the top-level value or control characters other than tab, newline and carriage return in strings")
+	fs.BoolVar(&cfg.StreamCheck, "stream-check", false, "check JSON limits, the top level type and required properties while the body is read, rejecting bad bodies before they are fully buffered")
+	fs.StringVar(&cfg.Settings.Enforcement, "enforcement", "enforce", "enforce to reject invalid requests or report to only log them, changeable at runtime through /admin/settings")
+	fs.Float64Var(&cfg.Settings.EnforcePercent, "enforce-percent", 100, "percentage of invalid requests to reject while rolling out enforcement, the rest are only logged")
+	fs.StringVar(&cfg.Settings.ErrorDetail, "error-detail", "full", "full to return every validation error or summary to return a single generic one")
+	fs.DurationVar(&cfg.Settings.ValidationTimeout, "validation-timeout", 0, "how long validating a request body may take before it is abandoned with a 503, 0 for no limit, overridable per route with the validation-timeout route policy key")
+	fs.StringVar(&cfg.FeatureFlags.URL, "feature-flags-url", "", "OpenFeature remote evaluation (OFREP) base URL to evaluate the enforcement flags against for every invalid request")
+	fs.StringVar(&cfg.FeatureFlags.Token, "feature-flags-token", "", "bearer token to authenticate to the feature flag service with")
+	fs.DurationVar(&cfg.FeatureFlags.Timeout, "feature-flags-timeout", 200*time.Millisecond, "maximum time to wait for feature flags before falling back to the runtime settings")
+	fs.IntVar(&cfg.ResultCacheSize, "result-cache-size", 0, "number of validation results to cache by schema version and body hash, 0 to disable")
+	fs.DurationVar(&cfg.Idempotency.TTL, "idempotency-ttl", 0, "how long to remember the outcome of requests sent with an Idempotency-Key so retries aren't validated again, 0 to disable")
+	fs.BoolVar(&cfg.Idempotency.ReplayResponses, "idempotency-replay-responses", false, "also remember upstream responses to requests with an Idempotency-Key and answer retries with them, requires -idempotency-ttl")
+	fs.IntVar(&cfg.Idempotency.MaxResponseBytes, "idempotency-max-response-bytes", 1<<20, "largest response body to remember for an Idempotency-Key")
+	fs.IntVar(&cfg.Idempotency.MaxBytes, "idempotency-max-bytes", 64<<20, "memory to remember Idempotency-Key outcomes in, the least recently used are forgotten early beyond it")
+	fs.IntVar(&cfg.ValidationWorkers, "validation-workers", 0, "number of goroutines schema validation runs on, 0 to validate on the request goroutine")
+	fs.IntVar(&cfg.ValidationQueue, "validation-queue", 1000, "maximum number of validations waiting for a worker before requests are shed with a 503")
+	fs.Float64Var(&cfg.RateLimit.Rate, "rate-limit", 0, "requests per second allowed per client, 0 to disable rate limiting")
+	fs.IntVar(&cfg.RateLimit.Burst, "rate-burst", 20, "number of requests a client may burst above the rate limit")
+	fs.StringVar(&cfg.RateLimit.By, "rate-limit-by", "ip", "comma separated keys to rate limit by: ip, api-key, client-cert. API keys not in -quota-file share a single limit")
+	fs.StringVar(&cfg.RateLimit.APIKeyHeader, "api-key-header", "X-API-Key", "header carrying the client API key")
+	fs.Int64Var(&cfg.Quotas.Daily, "quota-daily", 0, "number of requests each API key in -quota-file may have validated per UTC day, or all requests together without a -quota-file, 0 for no limit")
+	fs.Int64Var(&cfg.Quotas.Monthly, "quota-monthly", 0, "number of requests each API key in -quota-file may have validated per UTC month, or all requests together without a -quota-file, 0 for no limit")
+	fs.StringVar(&cfg.Quotas.File, "quota-file", "", "file of SHA-256 hashes of API keys, one per line with a label and daily=N or monthly=N quotas overriding -quota-daily and -quota-monthly, requests without one of them are rejected")
+	fs.IntVar(&cfg.Concurrency.MaxConcurrent, "max-concurrent", 0, "maximum number of requests validated at once, 0 for no limit")
+	fs.IntVar(&cfg.Concurrency.MaxQueue, "max-queue", 100, "maximum number of requests waiting for a validation slot")
+	fs.DurationVar(&cfg.Concurrency.QueueTimeout, "queue-timeout", time.Second, "maximum time a request waits for a validation slot")
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert", "", "PEM certificate to serve TLS with on the data listener")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key", "", "PEM private key for -tls-cert")
+	fs.StringVar(&cfg.TLS.MinVersion, "tls-min-version", "1.2", "minimum TLS version to accept: 1.2 or 1.3")
+	fs.StringVar(&cfg.TLS.ClientCAFile, "tls-client-ca", "", "PEM bundle of CAs trusted to sign client certificates")
+	fs.StringVar(&cfg.TLS.ClientAuth, "tls-client-auth", "none", "client certificate policy: none, optional or require")
+	fs.StringVar(&cfg.TLS.ACMEHosts, "acme-hosts", "", "comma separated hostnames to obtain Let's Encrypt certificates for")
+	fs.StringVar(&cfg.TLS.ACMECacheDir, "acme-cache-dir", "acme-cache", "directory ACME certificates and account keys are cached in")
+	fs.StringVar(&cfg.TLS.ACMEEmail, "acme-email", "", "contact email registered with the ACME account")
+	fs.StringVar(&cfg.ACMEHTTPAddr, "acme-http-addr", "", "address to answer ACME http-01 challenges on, e.g. :80")
+	fs.BoolVar(&cfg.H2C, "h2c", false, "accept cleartext HTTP/2 (h2c) on the data listener")
+	fs.BoolVar(&cfg.HTTP3, "http3", false, "also serve HTTP/3 over QUIC on the data address, requires TLS")
+	fs.StringVar(&cfg.UnixSocket, "unix-socket", "", "serve validated traffic on this unix socket path instead of -addr")
+	fs.StringVar(&cfg.UnixSocketMode, "unix-socket-mode", "0660", "octal file mode applied to -unix-socket")
+	fs.StringVar(&cfg.JWT.JWKSURL, "jwt-jwks-url", "", "JWKS URL to verify bearer JWTs against, enables JWT verification")
+	fs.StringVar(&cfg.JWT.Issuer, "jwt-issuer", "", "issuer bearer JWTs must carry in their iss claim")
+	fs.StringVar(&cfg.JWT.Audience, "jwt-audience", "", "audience bearer JWTs must carry in their aud claim")
+	fs.BoolVar(&cfg.JWT.AllowNoExpiry, "jwt-allow-no-exp", false, "accept bearer JWTs without an exp claim, which never expire, rather than rejecting them")
+	fs.StringVar(&cfg.Introspection.URL, "introspection-url", "", "RFC 7662 endpoint to introspect opaque bearer tokens against")
+	fs.StringVar(&cfg.Introspection.ClientID, "introspection-client-id", "", "client id to authenticate to the introspection endpoint with")
+	fs.StringVar(&cfg.Introspection.ClientSecret, "introspection-client-secret", "", "client secret to authenticate to the introspection endpoint with")
+	fs.DurationVar(&cfg.Introspection.CacheTTL, "introspection-cache-ttl", time.Minute, "how long introspection results are cached for")
+	fs.StringVar(&cfg.CORS.Origins, "cors-origins", "", "comma separated origins allowed to make cross-origin requests, * for any")
+	fs.StringVar(&cfg.CORS.Methods, "cors-methods", "POST, PUT, PATCH", "methods allowed in cross-origin requests")
+	fs.StringVar(&cfg.CORS.Headers, "cors-headers", "Content-Type, Authorization", "request headers allowed in cross-origin requests")
+	fs.DurationVar(&cfg.CORS.MaxAge, "cors-max-age", 10*time.Minute, "how long browsers may cache preflight responses")
+	fs.StringVar(&cfg.IPFilter.Allow, "allow-cidrs", "", "comma separated networks clients are allowed from, refusing any other with a 403")
+	fs.StringVar(&cfg.IPFilter.Deny, "deny-cidrs", "", "comma separated networks clients are refused from with a 403, even when in -allow-cidrs")
+	fs.StringVar(&cfg.TrustedProxies, "trusted-proxies", "", "comma separated networks of proxies whose X-Forwarded-For is trusted to name the client, for -allow-cidrs, -deny-cidrs, rate limits and feature flags")
+	fs.BoolVar(&cfg.Chaos.Enabled, "chaos", false, "inject delays, 429s and rejections into every request at the -chaos-*-percent rates, for testing clients only")
+	fs.StringVar(&cfg.Chaos.Header, "chaos-header", "", "request header opting a request into fault injection, without -chaos")
+	fs.Float64Var(&cfg.Chaos.DelayPercent, "chaos-delay-percent", 0, "percentage of chaos requests delayed by up to -chaos-delay")
+	fs.DurationVar(&cfg.Chaos.Delay, "chaos-delay", time.Second, "longest delay injected into chaos requests")
+	fs.Float64Var(&cfg.Chaos.ThrottlePercent, "chaos-throttle-percent", 0, "percentage of chaos requests answered with 429")
+	fs.Float64Var(&cfg.Chaos.RejectPercent, "chaos-reject-percent", 0, "percentage of chaos requests rejected as not matching the schema")
+	fs.String("config", "", "YAML file of flag settings, overridden by flags given on the command line")
+
+	// Flags override SV_ environment variables, which override the config
+	// file, which overrides the defaults.
+	file, _ := os.LookupEnv(envName("config"))
+	if arg := configFileArg(fs, args); arg != "" {
+		file = arg
+	}
+	if file != "" {
+		if err := loadConfigFile(fs, file); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid config file: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if err := loadEnv(fs, os.LookupEnv); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid environment: %v\n", err)
+		os.Exit(2)
+	}
+	fs.Parse(args)
+	cfg.Args = fs.Args()
+
+	return &cfg
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+)
+
+// runConfigCheck builds everything serve would from the same flags, config
+// file and environment, without binding any listeners, so a bad deploy is
+// caught before it starts.
+func runConfigCheck(args []string) error {
+	cfg := parseConfig(args)
+
+	g, err := newGateway(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errFailed
+	}
+	// serve only loads the certificate once it starts listening.
+	if cfg.TLS.CertFile != "" && cfg.TLS.KeyFile != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid TLS certificate: %v\n", err)
+			return errFailed
+		}
+	}
+
+	registry := g.schemas.current()
+	n := countVersions(registry.base)
+	for _, set := range registry.tenants {
+		n += countVersions(set)
+	}
+	fmt.Printf("configuration is valid, %d schema versions loaded\n", n)
+	return nil
+}
+
+func countVersions(set schemaSet) int {
+	n := 0
+	for _, versions := range set {
+		n += len(versions)
+	}
+	return n
+}
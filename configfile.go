@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/yaml.v3"
+)
+
+// A config file is a YAML mapping from flag names to values, with a list of
+// values for flags that may be repeated. Settings given on the command line
+// override the file.
+
+// configFileArg finds the value of -config in args, parsing them against
+// the flags of fs without setting any, so the file can be applied before the
+// command line. A -config after anything fs can't parse is left for the
+// real parse to complain about.
+func configFileArg(fs *flag.FlagSet, args []string) string {
+	var file string
+	probe := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	probe.SetOutput(io.Discard)
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			probe.StringVar(&file, "config", "", "")
+			return
+		}
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		probe.Var(ignoredValue{ok && b.IsBoolFlag()}, f.Name, "")
+	})
+	probe.Parse(args)
+
+	return file
+}
+
+// ignoredValue accepts any value of a flag, reading it as a bool flag when
+// the flag it stands in for is one.
+type ignoredValue struct {
+	isBool bool
+}
+
+func (v ignoredValue) String() string   { return "" }
+func (v ignoredValue) Set(string) error { return nil }
+func (v ignoredValue) IsBoolFlag() bool { return v.isBool }
+
+// configFileSchema describes the config file from the flags in fs, which is
+// what the file is validated against: every key must be a flag and every
+// value must be of the flag's type.
+func configFileSchema(fs *flag.FlagSet) map[string]interface{} {
+	props := make(map[string]interface{})
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+
+		var prop map[string]interface{}
+		getter, ok := f.Value.(flag.Getter)
+		if !ok {
+			prop = map[string]interface{}{"anyOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			}}
+		} else {
+			switch getter.Get().(type) {
+			case bool:
+				prop = map[string]interface{}{"type": "boolean"}
+			case int, int64, uint, uint64:
+				prop = map[string]interface{}{"type": "integer"}
+			case float64:
+				prop = map[string]interface{}{"type": "number"}
+			case time.Duration:
+				prop = map[string]interface{}{"type": "string", "pattern": `^(0|([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+)$`}
+			default:
+				prop = map[string]interface{}{"type": "string"}
+			}
+		}
+		prop["description"] = f.Usage
+		props[f.Name] = prop
+	})
+
+	return map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+// loadConfigFile validates the YAML config file against configFileSchema
+// and sets the flags it names.
+func loadConfigFile(fs *flag.FlagSet, file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	if doc == nil {
+		return nil
+	}
+
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(configFileSchema(fs)), gojsonschema.NewGoLoader(doc))
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	if !result.Valid() {
+		return fmt.Errorf("%s: %s", file, strings.Join(errorMessages(result.Errors()), "; "))
+	}
+
+	for name, value := range doc {
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+
+		for _, v := range values {
+			if err := fs.Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("%s: %s: %v", file, name, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestConfigFileArg(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("admin-addr", ":9000", "")
+	fs.Bool("http3", false, "")
+	fs.String("config", "", "")
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-config", "c.yaml"}, "c.yaml"},
+		{[]string{"--config=c.yaml"}, "c.yaml"},
+		{[]string{"-admin-addr", ":9999", "-config", "c.yaml"}, "c.yaml"},
+		{[]string{"-admin-addr", ":9999", "--config=c.yaml"}, "c.yaml"},
+		{[]string{"-http3", "-config", "c.yaml"}, "c.yaml"},
+		{[]string{"-admin-addr=:9999", "-http3=false", "--config", "c.yaml"}, "c.yaml"},
+		{[]string{"-admin-addr", ":9999"}, ""},
+		{[]string{"file.json", "-config", "c.yaml"}, ""},
+	} {
+		if got := configFileArg(fs, tt.args); got != tt.want {
+			t.Errorf("configFileArg(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+	if got := fs.Lookup("admin-addr").Value.String(); got != ":9000" {
+		t.Errorf("configFileArg set -admin-addr to %q", got)
+	}
+}
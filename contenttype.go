@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// mediaTypes is the set of request content types the validator accepts.
+// Entries may use path.Match wildcards, e.g. application/*+json.
+type mediaTypes []string
+
+func parseMediaTypes(v string) mediaTypes {
+	var types mediaTypes
+	for _, t := range strings.Split(v, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			types = append(types, t)
+		}
+	}
+
+	return types
+}
+
+func (m mediaTypes) allows(mediaType string) bool {
+	for _, pattern := range m {
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requireContentType rejects requests that don't declare one of the accepted
+// media types, or that declare a charset other than UTF-8, which is the only
+// encoding JSON may be exchanged in. Requests without a body needn't declare
+// one.
+func requireContentType(types mediaTypes, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasBody(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch {
+		case err != nil:
+			writeUnsupportedMediaType(w, r, types, "a valid Content-Type header is required")
+			return
+		case !types.allows(mediaType):
+			writeUnsupportedMediaType(w, r, types, fmt.Sprintf("unsupported content type %q", mediaType))
+			return
+		}
+
+		if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+			writeUnsupportedMediaType(w, r, types, fmt.Sprintf("unsupported charset %q, request bodies must be UTF-8", charset))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeUnsupportedMediaType rejects r with a 415, listing the media types
+// that would have been accepted in Accept-Post or Accept-Patch, the headers
+// defined for it (RFC 5789 and the Linked Data Platform). Other methods have
+// none.
+func writeUnsupportedMediaType(w http.ResponseWriter, r *http.Request, types mediaTypes, msg string) {
+	switch r.Method {
+	case http.MethodPost:
+		w.Header().Set("Accept-Post", strings.Join(types, ", "))
+	case http.MethodPatch:
+		w.Header().Set("Accept-Patch", strings.Join(types, ", "))
+	}
+	writeErrors(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, msg)
+}
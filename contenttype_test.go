@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestUnsupportedMediaTypeAccepts checks a 415 lists what would have been
+// accepted in the header defined for the method, and not in Accept.
+func TestUnsupportedMediaTypeAccepts(t *testing.T) {
+	handler := requireContentType(mediaTypes{"application/json"}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for method, header := range map[string]string{
+		http.MethodPost:  "Accept-Post",
+		http.MethodPatch: "Accept-Patch",
+		http.MethodPut:   "",
+	} {
+		r := httptest.NewRequest(method, "/", strings.NewReader("hello"))
+		r.Header.Set("Content-Type", "text/plain")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != http.StatusUnsupportedMediaType {
+			t.Fatalf("%s: got status %d, want 415", method, rec.Code)
+		}
+		if got := rec.Header().Get("Accept"); got != "" {
+			t.Errorf("%s: got Accept %q, a response header it isn't", method, got)
+		}
+		for _, name := range []string{"Accept-Post", "Accept-Patch"} {
+			want := ""
+			if name == header {
+				want = "application/json"
+			}
+			if got := rec.Header().Get(name); got != want {
+				t.Errorf("%s: got %s %q, want %q", method, name, got, want)
+			}
+		}
+	}
+}
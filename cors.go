@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type corsConfig struct {
+	Origins string
+	Methods string
+	Headers string
+	MaxAge  time.Duration
+}
+
+type cors struct {
+	origins map[string]bool
+	any     bool
+	methods string
+	headers string
+	maxAge  string
+}
+
+func newCORS(cfg *corsConfig) *cors {
+	c := &cors{
+		origins: make(map[string]bool),
+		methods: cfg.Methods,
+		headers: cfg.Headers,
+		maxAge:  strconv.Itoa(int(cfg.MaxAge.Seconds())),
+	}
+	for _, origin := range strings.Split(cfg.Origins, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			c.any = true
+		}
+		c.origins[origin] = true
+	}
+
+	return c
+}
+
+// handleCORS answers preflight requests itself and adds CORS headers to
+// actual requests from allowed origins. It has to run before authentication
+// since browsers don't send credentials on preflights.
+func handleCORS(c *cors, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !c.any && !c.origins[origin] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", c.methods)
+			w.Header().Set("Access-Control-Allow-Headers", c.headers)
+			w.Header().Set("Access-Control-Max-Age", c.maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// coverage counts which branches of each schema valid requests exercise:
+// each anyOf and oneOf alternative, each enum value and each optional
+// property, identified by their JSON pointer into the schema. Branches no
+// request has taken point at dead or untested parts of the contract.
+type coverage struct {
+	percent float64
+
+	mu      sync.Mutex
+	schemas map[string]*schemaCoverage
+}
+
+type schemaCoverage struct {
+	mu       sync.Mutex
+	schema   *schemaVersion
+	requests int64
+	branches map[string]*coveredBranch
+
+	// compiled caches the alternatives and ifs compiled on their own, to
+	// tell which ones a value matches.
+	compiled map[string]*gojsonschema.Schema
+}
+
+type coveredBranch struct {
+	Pointer string `json:"pointer"`
+	Kind    string `json:"kind"`
+	Hits    int64  `json:"hits"`
+}
+
+func newCoverage(percent float64) *coverage {
+	return &coverage{percent: percent, schemas: make(map[string]*schemaCoverage)}
+}
+
+// forSchema returns the counts for schema, starting them over when the
+// schema was reloaded with different contents.
+func (c *coverage) forSchema(schema *schemaVersion) *schemaCoverage {
+	key := schema.Tenant + "/" + schema.Name + "/" + schema.Version
+	sc, ok := c.schemas[key]
+	if !ok || string(sc.schema.Data) != string(schema.Data) {
+		sc = &schemaCoverage{
+			schema:   schema,
+			branches: schemaBranches(schema.Document),
+			compiled: make(map[string]*gojsonschema.Schema),
+		}
+		c.schemas[key] = sc
+	}
+	return sc
+}
+
+// schemaBranches lists every branch in a schema document, definitions
+// included.
+func schemaBranches(doc interface{}) map[string]*coveredBranch {
+	branches := make(map[string]*coveredBranch)
+	add := func(pointer, kind string) {
+		branches[pointer] = &coveredBranch{Pointer: pointer, Kind: kind}
+	}
+
+	var walk func(pointer string, node interface{}, depth int)
+	walk = func(pointer string, node interface{}, depth int) {
+		m, ok := node.(schemavalidation.SchemaObject)
+		if !ok || depth > schemavalidation.MaxSchemaDepth {
+			return
+		}
+
+		for _, keyword := range []string{"anyOf", "oneOf"} {
+			list, _ := m[keyword].([]interface{})
+			for i, s := range list {
+				p := pointer + "/" + keyword + "/" + strconv.Itoa(i)
+				add(p, keyword+" alternative")
+				walk(p, s, depth+1)
+			}
+		}
+		if values, ok := m["enum"].([]interface{}); ok {
+			for i := range values {
+				add(pointer+"/enum/"+strconv.Itoa(i), "enum value")
+			}
+		}
+		required := schemavalidation.RequiredProperties([]schemavalidation.SchemaObject{m})
+		if props, ok := m["properties"].(schemavalidation.SchemaObject); ok {
+			for name, s := range props {
+				p := pointer + "/properties/" + schemavalidation.EscapePointer(name)
+				if !required[name] {
+					add(p, "optional property")
+				}
+				walk(p, s, depth+1)
+			}
+		}
+
+		for _, keyword := range []string{"patternProperties", "definitions", "$defs", "dependencies"} {
+			if children, ok := m[keyword].(schemavalidation.SchemaObject); ok {
+				for name, s := range children {
+					walk(pointer+"/"+keyword+"/"+schemavalidation.EscapePointer(name), s, depth+1)
+				}
+			}
+		}
+		for _, keyword := range []string{"allOf", "items"} {
+			if list, ok := m[keyword].([]interface{}); ok {
+				for i, s := range list {
+					walk(pointer+"/"+keyword+"/"+strconv.Itoa(i), s, depth+1)
+				}
+			}
+		}
+		for _, keyword := range []string{"additionalProperties", "items", "additionalItems", "not", "if", "then", "else"} {
+			walk(pointer+"/"+keyword, m[keyword], depth+1)
+		}
+	}
+	walk("", doc, 0)
+
+	return branches
+}
+
+// record counts the branches value takes through the schema.
+func (sc *schemaCoverage) record(value interface{}) {
+	sc.requests++
+	root := sc.schema.Document
+	hit := func(pointer string) {
+		if b, ok := sc.branches[pointer]; ok {
+			b.Hits++
+		}
+	}
+
+	var visit func(pointer string, node, value interface{}, depth int)
+	visit = func(pointer string, node, value interface{}, depth int) {
+		m, ok := node.(schemavalidation.SchemaObject)
+		if !ok || depth > schemavalidation.MaxSchemaDepth {
+			return
+		}
+
+		if ref, ok := m["$ref"].(string); ok {
+			if strings.HasPrefix(ref, "#") {
+				if p, err := url.PathUnescape(ref[1:]); err == nil {
+					if target, ok := schemavalidation.ResolvePointer(root, p); ok {
+						visit(p, target, value, depth+1)
+					}
+				}
+			}
+			return
+		}
+
+		if values, ok := m["enum"].([]interface{}); ok {
+			for i, v := range values {
+				if jsonEqual(v, value) {
+					hit(pointer + "/enum/" + strconv.Itoa(i))
+				}
+			}
+		}
+		for _, keyword := range []string{"anyOf", "oneOf"} {
+			list, _ := m[keyword].([]interface{})
+			for i, s := range list {
+				p := pointer + "/" + keyword + "/" + strconv.Itoa(i)
+				if sc.matches(p, s, value) {
+					hit(p)
+					visit(p, s, value, depth+1)
+				}
+			}
+		}
+		if all, ok := m["allOf"].([]interface{}); ok {
+			for i, s := range all {
+				visit(pointer+"/allOf/"+strconv.Itoa(i), s, value, depth+1)
+			}
+		}
+		if cond, ok := m["if"]; ok {
+			if sc.matches(pointer+"/if", cond, value) {
+				visit(pointer+"/then", m["then"], value, depth+1)
+			} else {
+				visit(pointer+"/else", m["else"], value, depth+1)
+			}
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			props, _ := m["properties"].(schemavalidation.SchemaObject)
+			patterns, _ := m["patternProperties"].(schemavalidation.SchemaObject)
+			for key, child := range v {
+				matched := false
+				if s, ok := props[key]; ok {
+					p := pointer + "/properties/" + schemavalidation.EscapePointer(key)
+					hit(p)
+					visit(p, s, child, depth+1)
+					matched = true
+				}
+				for pattern, s := range patterns {
+					if re, err := regexp.Compile(pattern); err == nil && re.MatchString(key) {
+						visit(pointer+"/patternProperties/"+schemavalidation.EscapePointer(pattern), s, child, depth+1)
+						matched = true
+					}
+				}
+				if !matched {
+					visit(pointer+"/additionalProperties", m["additionalProperties"], child, depth+1)
+				}
+			}
+		case []interface{}:
+			switch items := m["items"].(type) {
+			case schemavalidation.SchemaObject:
+				for _, child := range v {
+					visit(pointer+"/items", items, child, depth+1)
+				}
+			case []interface{}:
+				for i, child := range v {
+					if i < len(items) {
+						visit(pointer+"/items/"+strconv.Itoa(i), items[i], child, depth+1)
+					} else {
+						visit(pointer+"/additionalItems", m["additionalItems"], child, depth+1)
+					}
+				}
+			}
+		}
+	}
+	visit("", root, value, 0)
+}
+
+// matches reports whether value matches the subschema at pointer.
+func (sc *schemaCoverage) matches(pointer string, schema, value interface{}) bool {
+	compiled, ok := sc.compiled[pointer]
+	if !ok {
+		compiled, _, _ = schemavalidation.CompileSubschema(sc.schema.Document, schema)
+		sc.compiled[pointer] = compiled
+	}
+	if compiled == nil {
+		return false
+	}
+
+	result, err := compiled.Validate(gojsonschema.NewGoLoader(value))
+	return err == nil && result.Valid()
+}
+
+// trackCoverage records the branches taken by the bodies that reach next,
+// for the configured percentage of requests.
+func trackCoverage(c *coverage, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		schema := requestSchema(r)
+		if schema == nil || (c.percent < 100 && rand.Float64()*100 >= c.percent) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		if value, err := decodeJSON(body); err == nil {
+			c.mu.Lock()
+			sc := c.forSchema(schema)
+			c.mu.Unlock()
+
+			sc.mu.Lock()
+			sc.record(value)
+			sc.mu.Unlock()
+		}
+
+		replaceBody(r, body)
+		next.ServeHTTP(w, r)
+	})
+}
+
+type coverageReport struct {
+	Tenant   string           `json:"tenant,omitempty"`
+	Name     string           `json:"name"`
+	Version  string           `json:"version"`
+	Requests int64            `json:"requests"`
+	Covered  int              `json:"covered"`
+	Total    int              `json:"total"`
+	Branches []*coveredBranch `json:"branches"`
+}
+
+// handle serves the coverage of every schema requests have been recorded
+// for, with ?uncovered listing only the branches no request has taken.
+func (c *coverage) handle(w http.ResponseWriter, r *http.Request) {
+	_, uncovered := r.URL.Query()["uncovered"]
+
+	c.mu.Lock()
+	reports := make([]coverageReport, 0, len(c.schemas))
+	for _, sc := range c.schemas {
+		sc.mu.Lock()
+		report := coverageReport{
+			Tenant:   sc.schema.Tenant,
+			Name:     sc.schema.Name,
+			Version:  sc.schema.Version,
+			Requests: sc.requests,
+			Total:    len(sc.branches),
+			Branches: []*coveredBranch{},
+		}
+		for _, b := range sc.branches {
+			if b.Hits > 0 {
+				report.Covered++
+			}
+			if b.Hits == 0 || !uncovered {
+				copied := *b
+				report.Branches = append(report.Branches, &copied)
+			}
+		}
+		sc.mu.Unlock()
+		sort.Slice(report.Branches, func(i, j int) bool {
+			return report.Branches[i].Pointer < report.Branches[j].Pointer
+		})
+		reports = append(reports, report)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(reports, func(i, j int) bool {
+		a, b := reports[i], reports[j]
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return versionLess(a.Version, b.Version)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Schemas []coverageReport `json:"schemas"`
+	}{reports}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ab, err := encodeJSON(a)
+	if err != nil {
+		return false
+	}
+	bb, err := encodeJSON(b)
+	return err == nil && string(ab) == string(bb)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+)
+
+// checkCrossFieldRules applies the cross-field keywords of the selected
+// schema to bodies that passed validation. Schemas without any are passed
+// through without decoding the body.
+func checkCrossFieldRules(rt *runtimeSettings, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		schema := requestSchema(r)
+		if !schema.Validation.CrossFields() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		replaceBody(r, body)
+
+		if errs := schema.Validation.CrossFieldErrors(body); len(errs) > 0 {
+			if rt.rejectInvalid(w, r, rt.forRequest(r), "breaks cross-field constraints", errorEntries(codeCrossField, validationMessages(errs))) {
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// TestCrossFieldKeywordsOnlyInSchemas checks values and property names that
+// look like cross-field keywords don't stop a schema from compiling.
+func TestCrossFieldKeywordsOnlyInSchemas(t *testing.T) {
+	schema, err := schemavalidation.Compile([]byte(`{
+		"type": "object",
+		"properties": {
+			"x-before": {"type": "string"},
+			"options": {"enum": [{"x-requires": 1}], "default": {"x-less-than": 3}},
+			"start": {"type": "integer", "x-less-than": "end"},
+			"end": {"type": "integer"}
+		}
+	}`), "")
+	if err != nil {
+		t.Fatalf("schema doesn't compile: %v", err)
+	}
+
+	errs := schema.CrossFieldErrors([]byte(`{"start":3,"end":1}`))
+	if len(errs) != 1 || errs[0].Pointer != "/start" {
+		t.Errorf("CrossFieldErrors = %v, want x-less-than to fail at /start", errs)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// decompressBody transparently decodes gzip, deflate (zlib) and brotli
+// request bodies so later handlers, and anything the request is forwarded
+// to, only ever see the identity encoding. The decoded size is capped at max
+// bytes to protect against compression bombs.
+func decompressBody(max int64, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Content-Encoding")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		encodings := strings.Split(header, ",")
+		body := r.Body
+		var reader io.Reader = r.Body
+
+		// Encodings are listed in the order they were applied.
+		for i := len(encodings) - 1; i >= 0; i-- {
+			var err error
+			reader, err = decoder(strings.ToLower(strings.TrimSpace(encodings[i])), reader)
+			if err == errUnsupportedEncoding {
+				writeUnsupportedEncoding(w, encodings[i])
+				return
+			}
+			if err != nil {
+				msg := fmt.Sprintf("failed to decode %s request body: %v", strings.TrimSpace(encodings[i]), err)
+				writeErrors(w, http.StatusBadRequest, codeUnreadableBody, msg)
+				return
+			}
+		}
+
+		r.Body = http.MaxBytesReader(w, readCloser{reader, body}, max)
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+var errUnsupportedEncoding = fmt.Errorf("unsupported content encoding")
+
+func decoder(encoding string, r io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "identity":
+		return r, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		return zlib.NewReader(r)
+	case "br":
+		return brotli.NewReader(r), nil
+	}
+
+	return nil, errUnsupportedEncoding
+}
+
+func writeUnsupportedEncoding(w http.ResponseWriter, encoding string) {
+	w.Header().Set("Accept-Encoding", "gzip, deflate, br")
+	msg := fmt.Sprintf("unsupported content encoding %q", strings.TrimSpace(encoding))
+	writeErrors(w, http.StatusUnsupportedMediaType, codeUnsupportedEncoding, msg)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// injectDefaults fills in missing optional properties that have a default in
+// the selected schema, so the upstream receives the enriched body and
+// doesn't have to repeat the defaulting rules. It runs after validation.
+func injectDefaults(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+
+		doc, err := decodeJSON(body)
+		if err != nil {
+			// Already validated, so this can't happen; forward it untouched.
+			replaceBody(r, body)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		schema := requestSchema(r)
+		applyDefaults(schema.Document, schema.Document, doc)
+
+		if b, err := encodeJSON(doc); err == nil {
+			body = b
+		}
+		replaceBody(r, body)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func applyDefaults(root, schema, value interface{}) {
+	parts := schemavalidation.SchemaParts(root, schema)
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		required := schemavalidation.RequiredProperties(parts)
+		for _, part := range parts {
+			props, _ := part["properties"].(schemavalidation.SchemaObject)
+			for key, prop := range props {
+				if _, present := v[key]; present || required[key] {
+					continue
+				}
+
+				if d, ok := schemaDefault(root, prop); ok {
+					v[key] = copyJSON(d)
+				}
+			}
+		}
+
+		for key, child := range v {
+			for _, s := range schemavalidation.PropertySchemas(parts, key) {
+				applyDefaults(root, s, child)
+			}
+		}
+
+	case []interface{}:
+		for i, child := range v {
+			for _, s := range schemavalidation.ItemSchemas(parts, i) {
+				applyDefaults(root, s, child)
+			}
+		}
+	}
+}
+
+// schemaDefault returns the default of schema. A default next to a $ref is
+// honoured even though other $ref siblings are not, since that is a common
+// way of giving a shared definition a default in one place.
+func schemaDefault(root, schema interface{}) (interface{}, bool) {
+	if m, ok := schema.(schemavalidation.SchemaObject); ok {
+		if d, ok := m["default"]; ok {
+			return d, true
+		}
+	}
+
+	for _, p := range schemavalidation.SchemaParts(root, schema) {
+		if d, ok := p["default"]; ok {
+			return d, true
+		}
+	}
+
+	return nil, false
+}
+
+// copyJSON deep copies a decoded JSON value, so defaults taken from the
+// schema document are never shared with, and mutated through, a request.
+func copyJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = copyJSON(e)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, e := range v {
+			s[i] = copyJSON(e)
+		}
+		return s
+	}
+
+	return v
+}
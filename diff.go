@@ -0,0 +1,258 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// runDiff implements the diff command. Each side is a schema file or a
+// <name>.<version> loaded from -schema-dir. Changes that reject documents
+// the old schema accepted are breaking and fail the run.
+func runDiff(args []string) error {
+	var cfg schemaConfig
+	fs := flag.NewFlagSet("schema-validations diff", flag.ExitOnError)
+	fs.StringVar(&cfg.Dir, "schema-dir", "", "directory to find <name>.<version> schemas in")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: schema-validations diff [-schema-dir dir] <old> <new>")
+	}
+
+	var registry *schemaRegistry
+	load := func(id string) (interface{}, error) {
+		if b, err := ioutil.ReadFile(id); err == nil {
+			return decodeJSON(b)
+		}
+
+		if registry == nil {
+			docs, err := readSchemaDir(cfg.Dir)
+			if err != nil {
+				return nil, err
+			}
+			for _, doc := range docs {
+				if doc.Tenant == "" {
+					cfg.Default = doc.Name
+				}
+			}
+			if registry, err = newSchemaRegistry(&cfg, docs, nil); err != nil {
+				return nil, err
+			}
+		}
+
+		v, ok := registry.base.lookup(splitSchemaID(id))
+		if !ok {
+			return nil, fmt.Errorf("no schema file or loaded schema version %q", id)
+		}
+		return v.Document, nil
+	}
+
+	old, err := load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	updated, err := load(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	changes := diffSchemas(old, updated)
+	breaking := false
+	for _, c := range changes {
+		kind := "compatible"
+		if c.breaking {
+			kind, breaking = "breaking", true
+		}
+		fmt.Printf("%s: %s: %s\n", kind, displayField(c.field), c.msg)
+	}
+
+	if breaking {
+		return errFailed
+	}
+	return nil
+}
+
+type schemaChange struct {
+	field    string
+	msg      string
+	breaking bool
+}
+
+// diffSchemas compares what two schemas accept, property by property.
+func diffSchemas(oldRoot, newRoot interface{}) []schemaChange {
+	var changes []schemaChange
+	add := func(field string, breaking bool, format string, args ...interface{}) {
+		changes = append(changes, schemaChange{field: field, msg: fmt.Sprintf(format, args...), breaking: breaking})
+	}
+
+	var compare func(oldNode, newNode interface{}, field string, depth int)
+	compare = func(oldNode, newNode interface{}, field string, depth int) {
+		if depth > schemavalidation.MaxSchemaDepth {
+			return
+		}
+		oldParts, newParts := schemavalidation.SchemaParts(oldRoot, oldNode), schemavalidation.SchemaParts(newRoot, newNode)
+
+		oldTypes, newTypes := schemaTypes(oldParts), schemaTypes(newParts)
+		if removed := missingKeys(oldTypes, newTypes); len(removed) > 0 || (oldTypes == nil && newTypes != nil) {
+			add(field, true, "type narrowed from %s to %s", typeList(oldTypes), typeList(newTypes))
+		} else if added := missingKeys(newTypes, oldTypes); len(added) > 0 || (oldTypes != nil && newTypes == nil) {
+			add(field, false, "type widened from %s to %s", typeList(oldTypes), typeList(newTypes))
+		}
+
+		oldRequired, newRequired := schemavalidation.RequiredProperties(oldParts), schemavalidation.RequiredProperties(newParts)
+		for _, name := range missingKeys(newRequired, oldRequired) {
+			add(joinField(field, name), true, "is now required")
+		}
+		for _, name := range missingKeys(oldRequired, newRequired) {
+			add(joinField(field, name), false, "is no longer required")
+		}
+
+		oldProps, newProps := schemaPropertyNames(oldParts), schemaPropertyNames(newParts)
+		closed := closedObject(newParts)
+		for _, name := range missingKeys(oldProps, newProps) {
+			add(joinField(field, name), closed, "was removed")
+		}
+		for _, name := range missingKeys(newProps, oldProps) {
+			add(joinField(field, name), false, "was added")
+		}
+		if closed && !closedObject(oldParts) {
+			add(field, true, "additional properties are no longer allowed")
+		}
+
+		oldEnum, newEnum := enumValues(oldParts), enumValues(newParts)
+		if newEnum != nil {
+			if removed := missingKeys(oldEnum, newEnum); len(removed) > 0 || oldEnum == nil {
+				add(field, true, "enum no longer allows %s", strings.Join(removed, ", "))
+			}
+		}
+		if oldEnum != nil {
+			if added := missingKeys(newEnum, oldEnum); len(added) > 0 && newEnum != nil {
+				add(field, false, "enum now also allows %s", strings.Join(added, ", "))
+			}
+		}
+
+		for _, keyword := range []string{"minLength", "minimum", "exclusiveMinimum", "minItems", "minProperties"} {
+			compareBound(oldParts, newParts, keyword, true, func(breaking bool, from, to string) {
+				add(field, breaking, "%s changed from %s to %s", keyword, from, to)
+			})
+		}
+		for _, keyword := range []string{"maxLength", "maximum", "exclusiveMaximum", "maxItems", "maxProperties"} {
+			compareBound(oldParts, newParts, keyword, false, func(breaking bool, from, to string) {
+				add(field, breaking, "%s changed from %s to %s", keyword, from, to)
+			})
+		}
+		if o, n := keywordString(oldParts, "pattern"), keywordString(newParts, "pattern"); o != n {
+			add(field, n != "", "pattern changed from %q to %q", o, n)
+		}
+
+		for _, name := range sortedKeys(oldProps) {
+			if newProps[name] {
+				o, n := schemavalidation.PropertySchemas(oldParts, name), schemavalidation.PropertySchemas(newParts, name)
+				if len(o) > 0 && len(n) > 0 {
+					compare(o[0], n[0], joinField(field, name), depth+1)
+				}
+			}
+		}
+		if o, n := schemavalidation.ItemSchemas(oldParts, 0), schemavalidation.ItemSchemas(newParts, 0); len(o) > 0 && len(n) > 0 {
+			compare(o[0], n[0], joinField(field, "0"), depth+1)
+		}
+	}
+	compare(oldRoot, newRoot, "", 0)
+
+	return changes
+}
+
+// compareBound reports a change to a numeric bound. Raising a lower bound,
+// or lowering an upper one, is breaking, as is adding either.
+func compareBound(oldParts, newParts []schemavalidation.SchemaObject, keyword string, lower bool, report func(breaking bool, from, to string)) {
+	o, oldOK := keywordNumber(oldParts, keyword)
+	n, newOK := keywordNumber(newParts, keyword)
+	switch {
+	case !oldOK && !newOK:
+	case !newOK:
+		report(false, fmt.Sprint(o), "none")
+	case !oldOK:
+		report(true, "none", fmt.Sprint(n))
+	case o != n:
+		report((lower && n > o) || (!lower && n < o), fmt.Sprint(o), fmt.Sprint(n))
+	}
+}
+
+func keywordNumber(parts []schemavalidation.SchemaObject, keyword string) (float64, bool) {
+	for _, part := range parts {
+		if _, ok := part[keyword]; ok {
+			return schemaNumber([]schemavalidation.SchemaObject{part}, keyword, 0), true
+		}
+	}
+	return 0, false
+}
+
+func keywordString(parts []schemavalidation.SchemaObject, keyword string) string {
+	for _, part := range parts {
+		if s, ok := part[keyword].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+func schemaPropertyNames(parts []schemavalidation.SchemaObject) map[string]bool {
+	names := make(map[string]bool)
+	for _, part := range parts {
+		props, _ := part["properties"].(schemavalidation.SchemaObject)
+		for name := range props {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+func closedObject(parts []schemavalidation.SchemaObject) bool {
+	for _, part := range parts {
+		if part["additionalProperties"] == false {
+			return true
+		}
+	}
+	return false
+}
+
+func enumValues(parts []schemavalidation.SchemaObject) map[string]bool {
+	for _, part := range parts {
+		if enum, ok := part["enum"].([]interface{}); ok {
+			values := make(map[string]bool)
+			for _, v := range enum {
+				b, _ := encodeJSON(v)
+				values[string(b)] = true
+			}
+			return values
+		}
+	}
+	return nil
+}
+
+// missingKeys returns the keys of a that aren't in b, sorted.
+func missingKeys(a, b map[string]bool) []string {
+	var missing []string
+	for k := range a {
+		if !b[k] {
+			missing = append(missing, k)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func sortedKeys(m map[string]bool) []string {
+	return missingKeys(m, nil)
+}
+
+func typeList(types map[string]bool) string {
+	if types == nil {
+		return "any"
+	}
+	return strings.Join(sortedKeys(types), "/")
+}
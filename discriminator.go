@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// discriminator picks the schema a body is validated against from the value
+// of one of its top level properties, declared with an x-discriminator
+// annotation on the selected schema:
+//
+//	"x-discriminator": {
+//	  "property": "post_type",
+//	  "mapping": {"original": "post-original", "cross-post": "post-cross-post.v2"}
+//	}
+//
+// Mapped schemas are given as <name>.<version>, or just <name> for the
+// latest version, and replace the selected schema entirely, so properties
+// they share are better kept in a definition they $ref.
+type discriminator struct {
+	Property string
+	Mapping  map[string]string
+}
+
+// checkDiscriminator reads the x-discriminator annotation of a schema
+// document, returning nil when there is none.
+func checkDiscriminator(doc interface{}) (*discriminator, error) {
+	obj, _ := doc.(map[string]interface{})
+	raw, ok := obj["x-discriminator"]
+	if !ok {
+		return nil, nil
+	}
+
+	spec, _ := raw.(map[string]interface{})
+	property, _ := spec["property"].(string)
+	mapping, _ := spec["mapping"].(map[string]interface{})
+	if property == "" || len(mapping) == 0 {
+		return nil, errors.New("x-discriminator needs a property and a mapping")
+	}
+
+	d := &discriminator{Property: property, Mapping: make(map[string]string)}
+	for value, target := range mapping {
+		id, ok := target.(string)
+		if !ok || id == "" {
+			return nil, fmt.Errorf("x-discriminator maps %q to %v, expected a schema name", value, target)
+		}
+		d.Mapping[value] = id
+	}
+	return d, nil
+}
+
+// checkDiscriminators makes sure every schema a discriminator maps to is
+// loaded, and doesn't discriminate further itself.
+func (s *schemaRegistry) checkDiscriminators() error {
+	sets := []schemaSet{s.base}
+	for _, set := range s.tenants {
+		sets = append(sets, set)
+	}
+
+	for _, set := range sets {
+		for _, versions := range set {
+			for _, v := range versions {
+				if v.Discriminator == nil {
+					continue
+				}
+				for value, id := range v.Discriminator.Mapping {
+					target, ok := s.base.lookup(splitSchemaID(id))
+					if !ok {
+						return fmt.Errorf("schema %s %s %s: x-discriminator maps %q to %s, which is not loaded", v.Tenant, v.Name, v.Version, value, id)
+					}
+					if target.Discriminator != nil {
+						return fmt.Errorf("schema %s %s %s: x-discriminator maps %q to %s, which has its own x-discriminator", v.Tenant, v.Name, v.Version, value, id)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// values lists the discriminator values d maps, for error messages.
+func (d *discriminator) values() string {
+	values := make([]string, 0, len(d.Mapping))
+	for value := range d.Mapping {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return strings.Join(values, ", ")
+}
+
+// selectDiscriminated switches the selected schema for the one its
+// discriminator maps the body to. Bodies without the discriminator, or with a
+// value it doesn't map, are rejected whatever the enforcement, as there is
+// no schema to validate them against.
+func selectDiscriminated(schemas *schemaStore, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		schema := requestSchema(r)
+		if schema.Discriminator == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		doc, err := decodeJSON(body)
+		if err != nil {
+			writeErrors(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("request body is not valid JSON: %v", err))
+			return
+		}
+
+		d := schema.Discriminator
+		obj, _ := doc.(map[string]interface{})
+		value, ok := obj[d.Property]
+		if !ok {
+			writeErrors(w, http.StatusBadRequest, codeMissingDiscriminator, fmt.Sprintf("request body is missing the discriminator %s, expected one of %s", d.Property, d.values()))
+			return
+		}
+		s, _ := value.(string)
+		id, ok := d.Mapping[s]
+		if !ok {
+			b, _ := encodeJSON(value)
+			writeErrors(w, http.StatusBadRequest, codeUnknownDiscriminator, fmt.Sprintf("unknown %s %s, expected one of %s", d.Property, b, d.values()))
+			return
+		}
+
+		registry := schemas.current()
+		name, version := splitSchemaID(id)
+		target, ok := registry.find(r, name, version)
+		if !ok {
+			writeErrors(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("no schema %q at version %q", name, version))
+			return
+		}
+		target, err = registry.applyRules(r, target)
+		var selErr *selectionError
+		if errors.As(err, &selErr) {
+			writeErrors(w, selErr.status, selErr.code, selErr.msg)
+			return
+		}
+		target.setDeprecationHeaders(w.Header())
+
+		replaceBody(r, body)
+		ctx := context.WithValue(r.Context(), schemaKey{}, target)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
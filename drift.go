@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type driftConfig struct {
+	Threshold   float64
+	Window      time.Duration
+	MinRequests int64
+}
+
+// driftAlert is one way traffic to a schema version has drifted from it: an
+// unknown field or a validation error showing up in more than the
+// threshold percentage of its requests over a window.
+type driftAlert struct {
+	Tenant   string    `json:"tenant,omitempty"`
+	Schema   string    `json:"schema"`
+	Version  string    `json:"version"`
+	Kind     string    `json:"kind"`
+	Detail   string    `json:"detail"`
+	Percent  float64   `json:"percent"`
+	Requests int64     `json:"requests"`
+	Since    time.Time `json:"since"`
+}
+
+func (a driftAlert) key() string {
+	return a.Tenant + "\x00" + a.Schema + "\x00" + a.Version + "\x00" + a.Kind + "\x00" + a.Detail
+}
+
+func (a driftAlert) String() string {
+	return fmt.Sprintf("%s: %s %s in %.1f%% of %d requests", strings.TrimSpace(a.Tenant+" "+a.Schema+" "+a.Version), a.Kind, a.Detail, a.Percent, a.Requests)
+}
+
+// driftWindow counts what a schema version's requests carried during the
+// current window.
+type driftWindow struct {
+	schema   *schemaVersion
+	requests int64
+	unknown  map[string]int64
+	errors   map[string]int64
+}
+
+// driftDetector compares the traffic to each schema version with it window
+// by window. Alerts are raised when a window crosses the threshold and
+// resolved when a later one doesn't, and every change is passed to notify.
+type driftDetector struct {
+	cfg    *driftConfig
+	notify func(alert driftAlert, resolved bool)
+
+	mu      sync.Mutex
+	windows map[string]*driftWindow
+	active  map[string]driftAlert
+}
+
+func newDriftDetector(cfg *driftConfig, notify func(driftAlert, bool)) *driftDetector {
+	d := &driftDetector{
+		cfg:     cfg,
+		notify:  notify,
+		windows: make(map[string]*driftWindow),
+		active:  make(map[string]driftAlert),
+	}
+
+	go func() {
+		for now := range time.Tick(cfg.Window) {
+			d.evaluate(now)
+		}
+	}()
+	return d
+}
+
+func (d *driftDetector) observe(schema *schemaVersion, unknown map[string]bool, errors []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := schema.Tenant + "/" + schema.Name + "/" + schema.Version
+	w, ok := d.windows[key]
+	if !ok {
+		w = &driftWindow{schema: schema, unknown: make(map[string]int64), errors: make(map[string]int64)}
+		d.windows[key] = w
+	}
+
+	w.requests++
+	for field := range unknown {
+		if _, ok := w.unknown[field]; ok || len(w.unknown) < maxUnknownFields {
+			w.unknown[field]++
+		}
+	}
+	for _, msg := range errors {
+		if _, ok := w.errors[msg]; ok || len(w.errors) < maxUnknownFields {
+			w.errors[msg]++
+		}
+	}
+}
+
+// evaluate closes the current window, raising and resolving alerts.
+func (d *driftDetector) evaluate(now time.Time) {
+	d.mu.Lock()
+	windows := d.windows
+	d.windows = make(map[string]*driftWindow)
+	d.mu.Unlock()
+
+	current := make(map[string]driftAlert)
+	for _, w := range windows {
+		if w.requests < d.cfg.MinRequests {
+			continue
+		}
+
+		check := func(kind string, counts map[string]int64) {
+			for detail, n := range counts {
+				percent := 100 * float64(n) / float64(w.requests)
+				if percent <= d.cfg.Threshold {
+					continue
+				}
+				a := driftAlert{
+					Tenant:   w.schema.Tenant,
+					Schema:   w.schema.Name,
+					Version:  w.schema.Version,
+					Kind:     kind,
+					Detail:   detail,
+					Percent:  percent,
+					Requests: w.requests,
+					Since:    now,
+				}
+				current[a.key()] = a
+			}
+		}
+		check("unknown field", w.unknown)
+		check("validation error", w.errors)
+	}
+
+	d.mu.Lock()
+	var raised, resolved []driftAlert
+	for key, a := range current {
+		if previous, ok := d.active[key]; ok {
+			a.Since = previous.Since
+			current[key] = a
+			continue
+		}
+		raised = append(raised, a)
+	}
+	for key, a := range d.active {
+		// Schema versions without enough traffic this window keep their
+		// alerts until there is enough to tell.
+		if _, ok := current[key]; !ok {
+			if w, ok := windows[a.Tenant+"/"+a.Schema+"/"+a.Version]; ok && w.requests >= d.cfg.MinRequests {
+				resolved = append(resolved, a)
+				continue
+			}
+			current[key] = a
+		}
+	}
+	d.active = current
+	d.mu.Unlock()
+
+	for _, a := range raised {
+		metricDriftAlerts.Add(1)
+		d.notify(a, false)
+	}
+	for _, a := range resolved {
+		d.notify(a, true)
+	}
+}
+
+// logDrift is the notify function logging every change.
+func logDrift(a driftAlert, resolved bool) {
+	if resolved {
+		log.Printf("schema drift resolved: %s", a)
+		return
+	}
+	log.Printf("schema drift: %s", a)
+}
+
+// detectDrift counts the unknown fields and validation errors of every
+// request that reaches next.
+func detectDrift(d *driftDetector, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		schema := requestSchema(r)
+		if schema == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		unknown := make(map[string]bool)
+		if doc, err := decodeJSON(body); err == nil {
+			walkUnknown(schema.Document, schema.Document, doc, "", func(_ map[string]interface{}, key, field string) {
+				unknown[unknownFieldPath(field, key)] = true
+			})
+		}
+
+		replaceBody(r, body)
+		rw := &outcomeWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		d.observe(schema, unknown, rw.errors())
+	})
+}
+
+// handle serves the active alerts.
+func (d *driftDetector) handle(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	alerts := make([]driftAlert, 0, len(d.active))
+	for _, a := range d.active {
+		alerts = append(alerts, a)
+	}
+	d.mu.Unlock()
+
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Percent != alerts[j].Percent {
+			return alerts[i].Percent > alerts[j].Percent
+		}
+		return alerts[i].key() < alerts[j].key()
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Alerts []driftAlert `json:"alerts"`
+	}{alerts}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type egressConfig struct {
+	Path         string
+	Destinations string
+}
+
+// egressDestination is a partner outgoing webhooks are sent to, as read
+// from the destinations file:
+//
+//	{
+//	  "partner-a": {
+//	    "url": "https://hooks.partner-a.example/events",
+//	    "schema_url": "https://partner-a.example/schemas/event.json",
+//	    "secret_env": "PARTNER_A_WEBHOOK_SECRET"
+//	  }
+//	}
+//
+// The schema is the one the partner publishes, fetched once at startup, or
+// a local copy with schema_file. The secret comes from the environment so
+// it stays out of the file.
+type egressDestination struct {
+	URL             string `json:"url"`
+	SchemaURL       string `json:"schema_url"`
+	SchemaFile      string `json:"schema_file"`
+	SecretEnv       string `json:"secret_env"`
+	SignatureHeader string `json:"signature_header"`
+
+	schema *schemaVersion
+	secret []byte
+	proxy  *httputil.ReverseProxy
+}
+
+// loadEgressDestinations reads the destinations file and fetches every
+// destination's schema, failing on the first that can't be used. Requests
+// reach a destination under path, through transport.
+func loadEgressDestinations(file, path string, transport http.RoundTripper) (map[string]*egressDestination, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var dests map[string]*egressDestination
+	if err := json.Unmarshal(data, &dests); err != nil {
+		return nil, err
+	}
+
+	for name, d := range dests {
+		target, err := url.Parse(d.URL)
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return nil, fmt.Errorf("%s: url %q must be an absolute URL", name, d.URL)
+		}
+		d.proxy = newEgressProxy(target, strings.TrimRight(path, "/")+"/"+name, transport)
+		if d.SignatureHeader == "" {
+			d.SignatureHeader = "X-Webhook-Signature"
+		}
+		if d.SecretEnv != "" {
+			secret, ok := os.LookupEnv(d.SecretEnv)
+			if !ok || secret == "" {
+				return nil, fmt.Errorf("%s: %s is not set", name, d.SecretEnv)
+			}
+			d.secret = []byte(secret)
+		}
+
+		var schema []byte
+		switch {
+		case d.SchemaURL != "" && d.SchemaFile != "":
+			return nil, fmt.Errorf("%s: schema_url and schema_file are mutually exclusive", name)
+		case d.SchemaURL != "":
+			schema, err = fetchEgressSchema(d.SchemaURL)
+		case d.SchemaFile != "":
+			schema, err = ioutil.ReadFile(d.SchemaFile)
+		default:
+			return nil, fmt.Errorf("%s: schema_url or schema_file is required", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to load schema: %v", name, err)
+		}
+
+		cs, err := compileSchema(schema)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid schema: %v", name, err)
+		}
+		d.schema = cs.version(name, "", schema)
+	}
+
+	return dests, nil
+}
+
+// newEgressProxy forwards requests under prefix to target, anything after
+// the prefix being appended to its path, without the caller's credentials.
+func newEgressProxy(target *url.URL, prefix string, transport http.RoundTripper) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			u := *target
+			if rest := strings.TrimPrefix(pr.In.URL.Path, prefix); rest != "" && rest != "/" {
+				u.Path = strings.TrimRight(u.Path, "/") + rest
+			}
+			u.RawQuery = pr.In.URL.RawQuery
+			pr.Out.URL, pr.Out.Host = &u, ""
+
+			pr.Out.Header.Del("Authorization")
+			pr.Out.Header.Del("Cookie")
+		},
+		Transport:    transport,
+		ErrorHandler: proxyError,
+	}
+}
+
+// fetchEgressSchema downloads a published schema with the default client,
+// which retries like remote $refs do.
+func fetchEgressSchema(u string) ([]byte, error) {
+	res, err := http.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", u, res.Status)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// sign returns the signature header value for body sent at ts: the hex
+// HMAC-SHA256 of the timestamp, a dot and the body, keyed with the
+// destination's secret.
+func (d *egressDestination) sign(ts string, body []byte) string {
+	mac := hmac.New(sha256.New, d.secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// egressProxy sends outgoing webhooks posted to <path>/<destination> on to
+// the destination. Payloads that don't match the destination's schema are
+// refused rather than sent; the rest are signed, when the destination has a
+// secret, and forwarded.
+func egressProxy(path string, dests map[string]*egressDestination, limits *jsonLimits, rt *runtimeSettings) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, strings.TrimRight(path, "/")+"/"), "/")
+		d, ok := dests[name]
+		if !ok {
+			writeErrors(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("unknown egress destination %q", name))
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		if err := limits.check(body); err != nil {
+			writeErrors(w, http.StatusBadRequest, codeOf(err, codeInvalidJSON), err.Error())
+			return
+		}
+
+		msgs, err := validateBody(d.schema, body)
+		if err != nil {
+			metricEgressRefused.Add(name, 1)
+			writeErrors(w, http.StatusBadRequest, codeInvalidJSON, "request body is "+err.Error())
+			return
+		}
+		if len(msgs) > 0 && rt.rejectInvalid(w, r, rt.forRequest(r), "does not match the schema of egress destination "+name, errorEntries(codeSchemaViolation, msgs)) {
+			metricEgressRefused.Add(name, 1)
+			return
+		}
+
+		if d.secret != nil {
+			ts := strconv.FormatInt(time.Now().Unix(), 10)
+			r.Header.Set("X-Webhook-Timestamp", ts)
+			r.Header.Set(d.SignatureHeader, d.sign(ts, body))
+		}
+
+		metricEgressSent.Add(name, 1)
+		replaceBody(r, body)
+		d.proxy.ServeHTTP(w, r)
+	})
+}
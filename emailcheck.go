@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// maxEmailDomains bounds the domains whose lookups are cached.
+const maxEmailDomains = 10000
+
+type emailConfig struct {
+	Verify     bool
+	Disposable string
+	Timeout    time.Duration
+	CacheTTL   time.Duration
+}
+
+// disposableDomains are blocked without a -email-disposable-domains file,
+// which adds to them.
+var disposableDomains = []string{
+	"10minutemail.com",
+	"guerrillamail.com",
+	"mailinator.com",
+	"sharklasers.com",
+	"temp-mail.org",
+	"throwawaymail.com",
+	"trashmail.com",
+	"yopmail.com",
+}
+
+// emailVerifier checks the domain of email addresses beyond their syntax:
+// that it isn't a disposable email service and that it accepts mail, having
+// MX records or, without any, an address to deliver to as RFC 5321 falls
+// back to. Lookups that fail for reasons other than the domain not existing
+// let the address through, so a DNS outage doesn't reject every request.
+type emailVerifier struct {
+	cfg        *emailConfig
+	disposable map[string]bool
+	lookupMX   func(ctx context.Context, name string) ([]*net.MX, error)
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	mu    sync.Mutex
+	cache map[string]emailDomain
+}
+
+type emailDomain struct {
+	accepts bool
+	expires time.Time
+}
+
+func newEmailVerifier(cfg *emailConfig) (*emailVerifier, error) {
+	v := &emailVerifier{
+		cfg:        cfg,
+		disposable: make(map[string]bool),
+		lookupMX:   net.DefaultResolver.LookupMX,
+		lookupHost: net.DefaultResolver.LookupHost,
+		cache:      make(map[string]emailDomain),
+	}
+	for _, domain := range disposableDomains {
+		v.disposable[domain] = true
+	}
+
+	if cfg.Disposable != "" {
+		f, err := os.Open(cfg.Disposable)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+			if line != "" && !strings.HasPrefix(line, "#") {
+				v.disposable[line] = true
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// isDisposable reports whether domain or any domain it's under is blocked.
+func (v *emailVerifier) isDisposable(domain string) bool {
+	for {
+		if v.disposable[domain] {
+			return true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			return false
+		}
+		domain = domain[i+1:]
+	}
+}
+
+// acceptsMail reports whether domain can receive mail, from the cache when
+// it was looked up within the TTL.
+func (v *emailVerifier) acceptsMail(ctx context.Context, domain string) bool {
+	now := time.Now()
+	v.mu.Lock()
+	cached, ok := v.cache[domain]
+	v.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		metricEmailLookups.Add("cached", 1)
+		return cached.accepts
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, v.cfg.Timeout)
+	defer cancel()
+
+	accepts, err := v.lookup(ctx, domain)
+	if err != nil {
+		metricEmailLookups.Add("failed", 1)
+		log.Printf("failed to look up the mail servers of %s, letting it through: %v", domain, err)
+		return true
+	}
+	metricEmailLookups.Add("resolved", 1)
+
+	v.mu.Lock()
+	if len(v.cache) >= maxEmailDomains {
+		for d, c := range v.cache {
+			if now.After(c.expires) {
+				delete(v.cache, d)
+			}
+		}
+		if len(v.cache) >= maxEmailDomains {
+			v.cache = make(map[string]emailDomain)
+		}
+	}
+	v.cache[domain] = emailDomain{accepts: accepts, expires: now.Add(v.cfg.CacheTTL)}
+	v.mu.Unlock()
+	return accepts
+}
+
+func (v *emailVerifier) lookup(ctx context.Context, domain string) (bool, error) {
+	mxs, err := v.lookupMX(ctx, domain)
+	if err != nil && !isNotFound(err) {
+		return false, err
+	}
+	if len(mxs) > 0 {
+		// A single "." is a null MX, the domain declaring it accepts no
+		// mail (RFC 7505).
+		return !(len(mxs) == 1 && (mxs[0].Host == "." || mxs[0].Host == "")), nil
+	}
+
+	hosts, err := v.lookupHost(ctx, domain)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return len(hosts) > 0, nil
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// check returns the problem with the domain of address and its code,
+// disposable_email or undeliverable_email, or "" if there is none.
+// Addresses without a domain are left to the format check.
+func (v *emailVerifier) check(ctx context.Context, address string) (code, problem string) {
+	i := strings.LastIndexByte(address, '@')
+	if i < 0 {
+		return "", ""
+	}
+	domain := strings.ToLower(strings.TrimSuffix(address[i+1:], "."))
+	if domain == "" {
+		return "", ""
+	}
+
+	if v.isDisposable(domain) {
+		return codeDisposableEmail, "Disposable email addresses are not allowed"
+	}
+	if !v.acceptsMail(ctx, domain) {
+		return codeUndeliverableEmail, fmt.Sprintf("Email domain %s does not accept mail", domain)
+	}
+	return "", ""
+}
+
+// walkFormat calls fn with every string in value that a schema in root,
+// starting at schema, gives the format.
+func walkFormat(root, schema, value interface{}, field, format string, fn func(s, field string)) {
+	parts := schemavalidation.SchemaAlternatives(root, schema)
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			for _, s := range schemavalidation.PropertySchemas(parts, key) {
+				walkFormat(root, s, child, joinField(field, key), format, fn)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			for _, s := range schemavalidation.ItemSchemas(parts, i) {
+				walkFormat(root, s, child, joinField(field, strconv.Itoa(i)), format, fn)
+			}
+		}
+	case string:
+		for _, part := range parts {
+			if part["format"] == format {
+				fn(v, field)
+				return
+			}
+		}
+	}
+}
+
+// verifyEmails checks the domains of the format: email fields of every
+// body that reaches next, rejecting the request when any is disposable or
+// doesn't accept mail. Whether it is rejected follows the runtime settings,
+// like validation.
+func verifyEmails(v *emailVerifier, rt *runtimeSettings, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		replaceBody(r, body)
+
+		doc, err := decodeJSON(body)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		schema := requestSchema(r)
+		checked := make(map[string]errorEntry)
+		var entries []errorEntry
+		walkFormat(schema.Document, schema.Document, doc, "", "email", func(address, field string) {
+			problem, ok := checked[address]
+			if !ok {
+				problem.Code, problem.Message = v.check(r.Context(), address)
+				checked[address] = problem
+			}
+			if problem.Message != "" {
+				entries = append(entries, errorEntry{Code: problem.Code, Message: displayField(field) + ": " + problem.Message})
+			}
+		})
+		if len(entries) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Message < entries[j].Message })
+		metricEmailRejected.Add(1)
+
+		if !rt.rejectInvalid(w, r, rt.forRequest(r), "has undeliverable email addresses", entries) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
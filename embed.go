@@ -0,0 +1,23 @@
+//go:build embedschemas
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed schemas
+var bundledSchemas embed.FS
+
+// embeddedSchemas are the schemas in schemas/, bundled in at build time so
+// a single binary carries everything it validates against.
+var embeddedSchemas = mustSub(bundledSchemas, "schemas")
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,8 @@
+//go:build !embedschemas
+
+package main
+
+import "io/fs"
+
+// embeddedSchemas is nil unless the binary is built with -tags embedschemas.
+var embeddedSchemas fs.FS
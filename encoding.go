@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+)
+
+var byteOrderMark = []byte("\xef\xbb\xbf")
+
+// encodingError is a request body that isn't well-formed UTF-8, reported
+// as unsupported media rather than as JSON that doesn't parse, with the code
+// invalid_utf8 or byte_order_mark.
+type encodingError struct {
+	code string
+	msg  string
+}
+
+func (e *encodingError) Error() string { return e.msg }
+
+func writeEncodingError(w http.ResponseWriter, e *encodingError) {
+	writeErrors(w, http.StatusUnsupportedMediaType, e.code, e.msg)
+}
+
+// checkEncoding makes sure request bodies are UTF-8, the only encoding JSON
+// may be exchanged in. A leading byte order mark, which JSON parsers may
+// but most don't ignore, is rejected, or with bom "strip" removed before
+// the body is validated and forwarded. Bytes that aren't UTF-8 fail the
+// read of the body, wherever in it they are.
+func checkEncoding(bom string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		br := bufio.NewReader(r.Body)
+		if head, _ := br.Peek(len(byteOrderMark)); bytes.Equal(head, byteOrderMark) {
+			if bom != "strip" {
+				writeEncodingError(w, &encodingError{code: codeByteOrderMark, msg: "request body starts with a byte order mark, which isn't allowed in JSON"})
+				return
+			}
+			br.Discard(len(byteOrderMark))
+			if r.ContentLength > 0 {
+				r.ContentLength -= int64(len(byteOrderMark))
+				r.Header.Set("Content-Length", strconv.FormatInt(r.ContentLength, 10))
+			}
+		}
+
+		r.Body = readCloser{&utf8Reader{r: br}, r.Body}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// utf8Reader fails with an *encodingError at the first byte of r that isn't
+// part of a well-formed UTF-8 sequence, and keeps failing with it.
+type utf8Reader struct {
+	r      io.Reader
+	tail   []byte // the start of a sequence split across reads
+	offset int64
+	err    *encodingError
+}
+
+func (u *utf8Reader) Read(p []byte) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	n, err := u.r.Read(p)
+
+	buf := p[:n]
+	if len(u.tail) > 0 {
+		buf = append(u.tail, buf...)
+		u.tail = nil
+	}
+	if u.err = u.check(buf, err != nil); u.err != nil {
+		return n, u.err
+	}
+	return n, err
+}
+
+func (u *utf8Reader) check(buf []byte, final bool) *encodingError {
+	for i := 0; i < len(buf); {
+		if buf[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		if !final && !utf8.FullRune(buf[i:]) {
+			u.tail = append([]byte(nil), buf[i:]...)
+			u.offset += int64(i)
+			return nil
+		}
+		r, size := utf8.DecodeRune(buf[i:])
+		if r == utf8.RuneError && size == 1 {
+			return &encodingError{code: codeInvalidUTF8, msg: fmt.Sprintf("request body is not valid UTF-8: invalid byte 0x%02x at offset %d", buf[i], u.offset+int64(i))}
+		}
+		i += size
+	}
+	u.offset += int64(len(buf))
+	return nil
+}
+
+func validBOMMode(mode string) error {
+	switch mode {
+	case "reject", "strip":
+		return nil
+	}
+	return fmt.Errorf("invalid -byte-order-mark %q, expected reject or strip", mode)
+}
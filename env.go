@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+const envPrefix = "SV_"
+
+// envName is the environment variable setting the flag name, e.g.
+// SV_MAX_BODY_BYTES for -max-body-bytes.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// loadEnv sets every flag in fs that has an SV_ variable in env. Flags that
+// may be repeated take a comma separated list.
+func loadEnv(fs *flag.FlagSet, env func(string) (string, bool)) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := env(envName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+
+		values := []string{v}
+		if _, ok := f.Value.(flag.Getter); !ok {
+			values = strings.Split(v, ",")
+		}
+
+		for _, v := range values {
+			if setErr := fs.Set(f.Name, strings.TrimSpace(v)); setErr != nil {
+				err = fmt.Errorf("%s: %v", envName(f.Name), setErr)
+				return
+			}
+		}
+	})
+
+	return err
+}
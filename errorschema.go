@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// The codes of the entries of error bodies, what clients branch on. Unlike
+// the messages they don't change once released.
+const (
+	codeSchemaViolation      = "schema_violation"
+	codeCrossField           = "cross_field_violation"
+	codeUnknownProperty      = "unknown_property"
+	codeDisposableEmail      = "disposable_email"
+	codeUndeliverableEmail   = "undeliverable_email"
+	codeInvalidJSON          = "invalid_json"
+	codeEmptyBody            = "empty_body"
+	codeDuplicateKey         = "duplicate_key"
+	codeTrailingData         = "trailing_data"
+	codeControlCharacter     = "control_character"
+	codeInexactNumber        = "inexact_number"
+	codeJSONLimit            = "json_limit_exceeded"
+	codeInvalidUTF8          = "invalid_utf8"
+	codeByteOrderMark        = "byte_order_mark"
+	codeUnreadableBody       = "unreadable_body"
+	codeBodyTooLarge         = "body_too_large"
+	codeUnsupportedMedia     = "unsupported_media_type"
+	codeUnsupportedEncoding  = "unsupported_content_encoding"
+	codeInvalidPatch         = "invalid_patch"
+	codeInvalidGraphQL       = "invalid_graphql"
+	codeMissingDiscriminator = "missing_discriminator"
+	codeUnknownDiscriminator = "unknown_discriminator"
+	codeUnknownSchema        = "unknown_schema"
+	codeInvalidSchema        = "invalid_schema"
+	codeInvalidRequest       = "invalid_request"
+	codeUnauthorized         = "unauthorized"
+	codeForbidden            = "forbidden"
+	codeNotFound             = "not_found"
+	codeConflict             = "conflict"
+	codeIdempotencyKeyReused = "idempotency_key_reused"
+	codeIdempotencyKeyInUse  = "idempotency_key_in_use"
+	codeRateLimited          = "rate_limited"
+	codeQuotaExceeded        = "quota_exceeded"
+	codeOverloaded           = "overloaded"
+	codeValidationTimeout    = "validation_timeout"
+	codeInternal             = "internal_error"
+	codeInvalidResponse      = "invalid_upstream_response"
+	codeUpstreamError        = "upstream_error"
+	codeUpstreamUnavailable  = "upstream_unavailable"
+	codeUpstreamTimeout      = "upstream_timeout"
+)
+
+// errorEntry is one problem in an error body.
+type errorEntry struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorEntries returns an entry with code for each of msgs.
+func errorEntries(code string, msgs []string) []errorEntry {
+	entries := make([]errorEntry, len(msgs))
+	for i, msg := range msgs {
+		entries[i] = errorEntry{Code: code, Message: msg}
+	}
+	return entries
+}
+
+// entryMessages returns the messages of entries.
+func entryMessages(entries []errorEntry) []string {
+	msgs := make([]string, len(entries))
+	for i, e := range entries {
+		msgs[i] = e.Message
+	}
+	return msgs
+}
+
+// errorSchemaPath is where the data listener serves errorSchemaJSON.
+const errorSchemaPath = "/.well-known/schema-validations/error.schema.json"
+
+// errorSchemaJSON describes every error body the gateway writes, see
+// writeErrors, so clients can generate types for it.
+const errorSchemaJSON = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "` + errorSchemaPath + `",
+  "title": "Error",
+  "description": "The body of every error response written by schema-validations.",
+  "type": "object",
+  "required": ["errors"],
+  "properties": {
+    "errors": {
+      "description": "Why the request was rejected, one entry per problem, sorted by the location they refer to.",
+      "type": ["array", "null"],
+      "items": {
+        "type": "object",
+        "required": ["code", "message"],
+        "properties": {
+          "code": {
+            "description": "What kind of problem it is, such as schema_violation or duplicate_key, for clients to act on. Codes don't change between releases.",
+            "type": "string",
+            "pattern": "^[a-z0-9]+(_[a-z0-9]+)*$"
+          },
+          "message": {
+            "description": "The problem described for people. Messages may be reworded between releases.",
+            "type": "string"
+          }
+        },
+        "additionalProperties": false
+      }
+    },
+    "request_id": {
+      "description": "ID of the request, also sent in the request ID response header, to quote when reporting a problem.",
+      "type": "string"
+    }
+  },
+  "additionalProperties": false
+}
+`
+
+// serveErrorSchema answers GETs for errorSchemaPath ahead of the rest of
+// the chain, so the schema is available without credentials.
+func serveErrorSchema(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != errorSchemaPath || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(errorSchemaJSON)))
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write([]byte(errorSchemaJSON))
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// TestErrorBodiesMatchErrorSchema checks the error bodies the gateway sends
+// against the schema it publishes for them, and the codes of their entries.
+func TestErrorBodiesMatchErrorSchema(t *testing.T) {
+	g, err := newGateway(parseConfig([]string{"-reject-duplicate-keys", "-strict-json"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	g.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, errorSchemaPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d", errorSchemaPath, rec.Code)
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("published error schema doesn't compile: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		code        string
+	}{
+		{"invalid body", http.MethodPost, "application/json", `{"title":"x"}`, codeSchemaViolation},
+		{"empty body", http.MethodPost, "application/json", "  \n", codeEmptyBody},
+		{"not JSON", http.MethodPost, "application/json", `{"title":`, codeInvalidJSON},
+		{"duplicate key", http.MethodPost, "application/json", `{"title":"x","title":"y"}`, codeDuplicateKey},
+		{"data after the value", http.MethodPost, "application/json", `{"title":"x"} {}`, codeTrailingData},
+		{"escaped control character", http.MethodPost, "application/json", `{"title":"\u0000"}`, codeControlCharacter},
+		{"unsupported content type", http.MethodPost, "text/plain", `hello`, codeUnsupportedMedia},
+		{"not UTF-8", http.MethodPost, "application/json", "{\"title\":\"\xff\"}", codeInvalidUTF8},
+		{"byte order mark", http.MethodPost, "application/json", "\ufeff{\"title\":\"x\"}", codeByteOrderMark},
+		{"body too large", http.MethodPost, "application/json", `{"title":"` + strings.Repeat("x", 2<<20) + `"}`, codeBodyTooLarge},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			g.handler.ServeHTTP(rec, r)
+			if rec.Code < 400 {
+				t.Fatalf("got status %d, want an error", rec.Code)
+			}
+			checkErrorBody(t, schema, rec.Body.Bytes())
+			checkErrorCodes(t, rec.Body.Bytes(), tt.code)
+		})
+	}
+
+	// Written directly, without a request ID and with no messages at all.
+	rec = httptest.NewRecorder()
+	writeErrors(rec, http.StatusBadRequest, codeSchemaViolation)
+	checkErrorBody(t, schema, rec.Body.Bytes())
+}
+
+// TestEmailErrorCodes checks addresses at disposable services and at domains
+// without mail servers are rejected with codes of their own.
+func TestEmailErrorCodes(t *testing.T) {
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(errorSchemaJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := newEmailVerifier(&emailConfig{Timeout: time.Second, CacheTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.lookupMX = func(ctx context.Context, name string) ([]*net.MX, error) {
+		return []*net.MX{{Host: "."}}, nil
+	}
+	doc, err := decodeJSON([]byte(`{"properties":{"author_email":{"type":"string","format":"email"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := verifyEmails(v, nil, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, tt := range []struct {
+		address string
+		code    string
+	}{
+		{"someone@mailinator.com", codeDisposableEmail},
+		{"someone@example.com", codeUndeliverableEmail},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"author_email":"`+tt.address+`"}`))
+		r = r.WithContext(context.WithValue(r.Context(), schemaKey{}, &schemaVersion{Document: doc}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: got status %d, want 400", tt.address, rec.Code)
+		}
+		checkErrorBody(t, schema, rec.Body.Bytes())
+		checkErrorCodes(t, rec.Body.Bytes(), tt.code)
+	}
+}
+
+func checkErrorBody(t *testing.T, schema *gojsonschema.Schema, body []byte) {
+	t.Helper()
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(bytes.TrimSpace(body)))
+	if err != nil {
+		t.Fatalf("error body %s is not JSON: %v", body, err)
+	}
+	for _, e := range result.Errors() {
+		t.Errorf("error body %s: %s", body, e)
+	}
+}
+
+// checkErrorCodes fails the test unless body has entries, all with code.
+func checkErrorCodes(t *testing.T, body []byte, code string) {
+	t.Helper()
+	var errBody struct {
+		Errors []errorEntry `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &errBody); err != nil {
+		t.Fatalf("error body %s: %v", body, err)
+	}
+	if len(errBody.Errors) == 0 {
+		t.Errorf("error body %s has no entries, want code %s", body, code)
+	}
+	for _, e := range errBody.Errors {
+		if e.Code != code {
+			t.Errorf("error body %s has an entry with code %q, want %q", body, e.Code, code)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// checkExamples validates the examples every schema carries in a root
+// examples array, which are expected to be valid, and, with
+// cfg.ExampleFixtures set, the fixtures in that directory, laid out as for the test command. It
+// returns a line for every one that doesn't behave as expected.
+func checkExamples(cfg *schemaConfig, s *schemaRegistry) ([]string, error) {
+	sets := map[string]schemaSet{"": s.base}
+	for tenant, set := range s.tenants {
+		sets[tenant] = set
+	}
+
+	var problems []string
+	for _, set := range sets {
+		for _, versions := range set {
+			for _, schema := range versions {
+				doc, _ := schema.Document.(map[string]interface{})
+				examples, _ := doc["examples"].([]interface{})
+				for i, example := range examples {
+					body, err := json.Marshal(example)
+					if err != nil {
+						return nil, err
+					}
+					msgs, err := validateBody(schema, body)
+					if err != nil {
+						return nil, err
+					}
+					if len(msgs) > 0 {
+						problems = append(problems, fmt.Sprintf("%s example %d: %s", strings.TrimSpace(schema.Tenant+" "+schema.Name+" "+schema.Version), i, strings.Join(msgs, "; ")))
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(problems)
+
+	if cfg.ExampleFixtures != "" {
+		err := checkFixtures(s, cfg.ExampleFixtures, func(file, problem string) {
+			if problem != "" {
+				lines := strings.Split(strings.TrimSpace(problem), "\n")
+				for i := range lines {
+					lines[i] = strings.TrimSpace(lines[i])
+				}
+				problems = append(problems, strings.TrimSpace(fmt.Sprintf("%s: %s %s", file, lines[0], strings.Join(lines[1:], "; "))))
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return problems, nil
+}
+
+// smokeTest runs checkExamples when schemas are loaded, logging the examples
+// that fail and, when cfg.ExampleFailures is fail, refusing the schemas.
+func smokeTest(cfg *schemaConfig, s *schemaRegistry) error {
+	switch cfg.ExampleFailures {
+	case "", "off":
+		return nil
+	case "warn", "fail":
+	default:
+		return fmt.Errorf("invalid -example-failures %q, expected off, warn or fail", cfg.ExampleFailures)
+	}
+
+	problems, err := checkExamples(cfg, s)
+	if err != nil {
+		return fmt.Errorf("failed to check schema examples: %v", err)
+	}
+	for _, problem := range problems {
+		log.Printf("schema example fails: %s", problem)
+	}
+	if len(problems) > 0 && cfg.ExampleFailures == "fail" {
+		return fmt.Errorf("%d schema examples fail", len(problems))
+	}
+	return nil
+}
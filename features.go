@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/open-feature/go-sdk/openfeature"
+)
+
+// Flags evaluated through OpenFeature for every invalid request. Each one
+// falls back to the runtime settings when the flag service doesn't know it
+// or can't be reached.
+const (
+	// flagEnforcement is enforce or report.
+	flagEnforcement = "schema-validations-enforcement"
+	// flagEnforcePercent is the share of requests enforced, 0 to 100.
+	flagEnforcePercent = "schema-validations-enforce-percent"
+	// flagRouteEnforced turns enforcement off for matching requests when
+	// false, for targeting on the path, method or schema.
+	flagRouteEnforced = "schema-validations-route-enforced"
+)
+
+type featureFlagConfig struct {
+	URL     string
+	Token   string
+	Timeout time.Duration
+}
+
+// featureFlags drives enforcement from a feature flag system. The targeting
+// key is the client: its API key, hashed, or its address.
+type featureFlags struct {
+	client    *openfeature.Client
+	keyHeader string
+	timeout   time.Duration
+}
+
+func newFeatureFlags(cfg *featureFlagConfig, apiKeyHeader string) (*featureFlags, error) {
+	provider, err := newOFREPProvider(cfg.URL, cfg.Token, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := openfeature.SetProviderAndWait(provider); err != nil {
+		return nil, fmt.Errorf("failed to set up the feature flag provider: %v", err)
+	}
+
+	return &featureFlags{client: openfeature.NewDefaultClient(), keyHeader: apiKeyHeader, timeout: cfg.Timeout}, nil
+}
+
+func (f *featureFlags) evaluationContext(r *http.Request, schema *schemaVersion) openfeature.EvaluationContext {
+	key := "ip:" + clientIP(r)
+	if apiKey := r.Header.Get(f.keyHeader); apiKey != "" {
+		sum := sha256.Sum256([]byte(apiKey))
+		key = "key:" + hex.EncodeToString(sum[:8])
+	}
+
+	attrs := map[string]interface{}{"method": r.Method, "path": r.URL.Path}
+	if schema != nil {
+		attrs["schema"] = schema.Name + "." + schema.Version
+		if schema.Tenant != "" {
+			attrs["tenant"] = schema.Tenant
+		}
+	}
+	return openfeature.NewEvaluationContext(key, attrs)
+}
+
+// apply returns s with the flags for r evaluated over it.
+func (f *featureFlags) apply(r *http.Request, s *settings) *settings {
+	ctx, cancel := context.WithTimeout(r.Context(), f.timeout)
+	defer cancel()
+
+	evalCtx := f.evaluationContext(r, requestSchema(r))
+	out := *s
+
+	enforcement, err := f.client.StringValue(ctx, flagEnforcement, s.Enforcement, evalCtx)
+	countFlagError(err)
+	if enforcement == "enforce" || enforcement == "report" {
+		out.Enforcement = enforcement
+	}
+
+	percent, err := f.client.FloatValue(ctx, flagEnforcePercent, s.EnforcePercent, evalCtx)
+	countFlagError(err)
+	if percent >= 0 && percent <= 100 {
+		out.EnforcePercent = percent
+	}
+
+	enforced, err := f.client.BooleanValue(ctx, flagRouteEnforced, true, evalCtx)
+	countFlagError(err)
+	if !enforced {
+		out.Enforcement = "report"
+	}
+
+	return &out
+}
+
+func countFlagError(err error) {
+	if err != nil {
+		metricFlagErrors.Add(1)
+	}
+}
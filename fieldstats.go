@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// maxStatsFields bounds the fields tracked per schema version.
+const maxStatsFields = 1000
+
+// lengthBuckets are the upper bounds of the length histogram buckets, the
+// last bucket holding everything longer.
+var lengthBuckets = []int{0, 8, 32, 128, 512}
+
+// fieldStats describes the shape of the values valid requests carry, per
+// schema version and field: how often the field is present, the JSON types
+// it holds, the lengths of its strings and arrays and the range of its
+// numbers. No values are kept, only these aggregates, and each field comes
+// with the constraints the schema declares for it, to show where they are
+// looser than real traffic needs.
+type fieldStats struct {
+	percent float64
+
+	mu      sync.Mutex
+	schemas map[string]*schemaFieldStats
+}
+
+type schemaFieldStats struct {
+	schema   *schemaVersion
+	requests int64
+	fields   map[string]*fieldShape
+}
+
+type fieldShape struct {
+	Pointer  string                        `json:"pointer"`
+	Present  int64                         `json:"present"`
+	Rate     float64                       `json:"presence_rate"`
+	Types    map[string]int64              `json:"types"`
+	Length   *lengthStats                  `json:"length,omitempty"`
+	Number   *numberStats                  `json:"number,omitempty"`
+	Declared schemavalidation.SchemaObject `json:"declared,omitempty"`
+}
+
+type lengthStats struct {
+	Min     int              `json:"min"`
+	Max     int              `json:"max"`
+	Buckets map[string]int64 `json:"buckets"`
+}
+
+type numberStats struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+func newFieldStats(percent float64) *fieldStats {
+	return &fieldStats{percent: percent, schemas: make(map[string]*schemaFieldStats)}
+}
+
+// record adds value, a body validated against schema, to the statistics.
+func (s *fieldStats) record(schema *schemaVersion, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := schema.Tenant + "/" + schema.Name + "/" + schema.Version
+	stats, ok := s.schemas[key]
+	if !ok || string(stats.schema.Data) != string(schema.Data) {
+		stats = &schemaFieldStats{schema: schema, fields: make(map[string]*fieldShape)}
+		s.schemas[key] = stats
+	}
+	stats.requests++
+
+	seen := make(map[string]bool)
+	var visit func(pointer string, v interface{})
+	visit = func(pointer string, v interface{}) {
+		f, ok := stats.fields[pointer]
+		if !ok {
+			if len(stats.fields) >= maxStatsFields {
+				return
+			}
+			f = &fieldShape{Pointer: pointer, Types: make(map[string]int64)}
+			stats.fields[pointer] = f
+		}
+		if !seen[pointer] {
+			seen[pointer] = true
+			f.Present++
+		}
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			f.Types["object"]++
+			for key, child := range v {
+				visit(pointer+"/"+schemavalidation.EscapePointer(key), child)
+			}
+		case []interface{}:
+			f.Types["array"]++
+			f.addLength(len(v))
+			for _, child := range v {
+				visit(pointer+"/*", child)
+			}
+		case string:
+			f.Types["string"]++
+			f.addLength(utf8.RuneCountInString(v))
+		case json.Number:
+			f.Types["number"]++
+			if n, err := v.Float64(); err == nil {
+				f.addNumber(n)
+			}
+		case bool:
+			f.Types["boolean"]++
+		case nil:
+			f.Types["null"]++
+		}
+	}
+	visit("", value)
+}
+
+func (f *fieldShape) addLength(n int) {
+	if f.Length == nil {
+		f.Length = &lengthStats{Min: n, Max: n, Buckets: make(map[string]int64)}
+	}
+	if n < f.Length.Min {
+		f.Length.Min = n
+	}
+	if n > f.Length.Max {
+		f.Length.Max = n
+	}
+
+	label := ""
+	for i, upper := range lengthBuckets {
+		if n <= upper {
+			label = bucketLabel(i)
+			break
+		}
+	}
+	if label == "" {
+		label = bucketLabel(len(lengthBuckets))
+	}
+	f.Length.Buckets[label]++
+}
+
+func bucketLabel(i int) string {
+	switch {
+	case i == 0:
+		return "0"
+	case i == len(lengthBuckets):
+		return ">" + strconv.Itoa(lengthBuckets[i-1])
+	default:
+		return strconv.Itoa(lengthBuckets[i-1]+1) + "-" + strconv.Itoa(lengthBuckets[i])
+	}
+}
+
+func (f *fieldShape) addNumber(n float64) {
+	if f.Number == nil {
+		f.Number = &numberStats{Min: n, Max: n}
+	}
+	f.Number.Min = math.Min(f.Number.Min, n)
+	f.Number.Max = math.Max(f.Number.Max, n)
+}
+
+// declaredConstraints collects the keywords bounding the field at pointer,
+// "*" standing for any array element, from the schemas that apply to it.
+func declaredConstraints(root interface{}, pointer string) schemavalidation.SchemaObject {
+	loc, ok := schemavalidation.LocateInSchema(root, strings.Replace(pointer, "/*", "/0", -1))
+	if !ok {
+		return nil
+	}
+
+	declared := make(schemavalidation.SchemaObject)
+	for _, s := range loc.Schemas {
+		for _, part := range schemavalidation.SchemaParts(root, s) {
+			for _, keyword := range []string{"type", "minLength", "maxLength", "minItems", "maxItems", "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "pattern", "format", "enum"} {
+				if v, ok := part[keyword]; ok {
+					declared[keyword] = v
+				}
+			}
+		}
+	}
+	if loc.Required {
+		declared["required"] = true
+	}
+	if len(declared) == 0 {
+		return nil
+	}
+	return declared
+}
+
+// collectFieldStats records a sample of the bodies that reach next.
+func collectFieldStats(s *fieldStats, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		schema := requestSchema(r)
+		if schema == nil || (s.percent < 100 && rand.Float64()*100 >= s.percent) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		if value, err := decodeJSON(body); err == nil {
+			s.record(schema, value)
+		}
+
+		replaceBody(r, body)
+		next.ServeHTTP(w, r)
+	})
+}
+
+type fieldStatsReport struct {
+	Tenant   string        `json:"tenant,omitempty"`
+	Name     string        `json:"name"`
+	Version  string        `json:"version"`
+	Requests int64         `json:"requests"`
+	Fields   []*fieldShape `json:"fields"`
+
+	document interface{}
+}
+
+// handle serves the statistics of every schema version seen so far.
+func (s *fieldStats) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	reports := make([]fieldStatsReport, 0, len(s.schemas))
+	for _, stats := range s.schemas {
+		report := fieldStatsReport{
+			Tenant:   stats.schema.Tenant,
+			Name:     stats.schema.Name,
+			Version:  stats.schema.Version,
+			Requests: stats.requests,
+			document: stats.schema.Document,
+		}
+		for _, f := range stats.fields {
+			copied := *f
+			copied.Types = make(map[string]int64, len(f.Types))
+			for t, n := range f.Types {
+				copied.Types[t] = n
+			}
+			if f.Length != nil {
+				length := *f.Length
+				length.Buckets = make(map[string]int64, len(f.Length.Buckets))
+				for b, n := range f.Length.Buckets {
+					length.Buckets[b] = n
+				}
+				copied.Length = &length
+			}
+			if f.Number != nil {
+				number := *f.Number
+				copied.Number = &number
+			}
+			copied.Rate = float64(f.Present) / float64(stats.requests)
+			report.Fields = append(report.Fields, &copied)
+		}
+		reports = append(reports, report)
+	}
+	s.mu.Unlock()
+
+	for i := range reports {
+		report := &reports[i]
+		for _, f := range report.Fields {
+			f.Declared = declaredConstraints(report.document, f.Pointer)
+		}
+		sort.Slice(report.Fields, func(i, j int) bool {
+			return report.Fields[i].Pointer < report.Fields[j].Pointer
+		})
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		a, b := reports[i], reports[j]
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return versionLess(a.Version, b.Version)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Schemas []fieldStatsReport `json:"schemas"`
+	}{reports}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// runTest implements the test command. The fixtures directory holds a
+// directory per schema, named <name> or <name>.<version>, with valid/ and
+// invalid/ example payloads. Every valid payload has to match its schema and
+// every invalid one has to be rejected, with, when a <file>.errors or
+// <file>.codes next to it lists them, exactly those errors.
+func runTest(args []string) error {
+	var cfg schemaConfig
+	fs := flag.NewFlagSet("schema-validations test", flag.ExitOnError)
+	fs.StringVar(&cfg.Dir, "schema-dir", "", "directory of <name>.<version>.json schemas, the built-in post schema if empty")
+	fs.StringVar(&cfg.BaseSchema, "base-schema", "", "JSON schema of fields every request shares, added to the allOf of every schema")
+	fs.BoolVar(&cfg.Embedded, "embedded-schemas", embeddedSchemas != nil, "load the schemas bundled into the binary with -tags embedschemas")
+	fs.Parse(args)
+
+	dir := "fixtures"
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+
+	store, err := newSchemaStore(&cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load schemas: %v", err)
+	}
+
+	passed, failed := 0, 0
+	err = checkFixtures(store.current(), dir, func(file, problem string) {
+		if problem != "" {
+			fmt.Printf("FAIL %s\n%s", file, problem)
+			failed++
+			return
+		}
+		fmt.Printf("ok   %s\n", file)
+		passed++
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return errFailed
+	}
+	return nil
+}
+
+// checkFixtures checks every fixture in dir against its schema in
+// registry, calling report with each file and how it differs from what is
+// expected of it, "" when it doesn't.
+func checkFixtures(registry *schemaRegistry, dir string, report func(file, problem string)) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		name, version := splitSchemaID(entry.Name())
+		schema, ok := registry.base.lookup(name, version)
+		if !ok {
+			report(filepath.Join(dir, entry.Name()), fmt.Sprintf("    no schema %q at version %q\n", name, version))
+			continue
+		}
+
+		for _, valid := range []bool{true, false} {
+			kind := "invalid"
+			if valid {
+				kind = "valid"
+			}
+
+			files, err := filepath.Glob(filepath.Join(dir, entry.Name(), kind, "*.json"))
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				report(file, checkFixture(schema, file, valid))
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkFixture describes how file's classification differs from what is
+// expected of it, "" when it doesn't. For invalid payloads a <file>.errors
+// can list the exact messages, and a <file>.codes the exact keywords and
+// JSON pointers of the errors, one "<keyword> <pointer>" per line with the
+// pointer left out for the root.
+func checkFixture(schema *schemaVersion, file string, valid bool) string {
+	errs, err := validateFileErrors(schema, file)
+	if err != nil {
+		return fmt.Sprintf("    %v\n", err)
+	}
+	msgs := errorMessages(errs)
+
+	if valid {
+		if len(msgs) == 0 {
+			return ""
+		}
+		return "    expected valid, got errors:\n" + fixtureDiff(nil, msgs)
+	}
+
+	if len(msgs) == 0 {
+		return "    expected errors, got valid\n"
+	}
+
+	codes := make([]string, len(errs))
+	for i, e := range errs {
+		codes[i] = strings.TrimSpace(e.Type() + " " + schemavalidation.Pointer(e.Context()))
+	}
+
+	var problem string
+	for _, check := range []struct {
+		ext, what string
+		actual    []string
+	}{
+		{".errors", "errors", msgs},
+		{".codes", "error codes", codes},
+	} {
+		expected, err := readExpected(strings.TrimSuffix(file, ".json") + check.ext)
+		if err != nil {
+			return fmt.Sprintf("    %v\n", err)
+		}
+		if expected == nil {
+			continue
+		}
+
+		actual := append([]string(nil), check.actual...)
+		sort.Strings(actual)
+		if strings.Join(expected, "\n") != strings.Join(actual, "\n") {
+			problem += "    " + check.what + " differ, - expected, + actual:\n" + fixtureDiff(expected, actual)
+		}
+	}
+	return problem
+}
+
+// readExpected returns the non-blank lines of file sorted, and nil if it
+// doesn't exist.
+func readExpected(file string) ([]string, error) {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	expected := []string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			expected = append(expected, line)
+		}
+	}
+	sort.Strings(expected)
+	return expected, nil
+}
+
+// fixtureDiff lists the lines only in expected with -, only in actual with
+// +, and the ones in both unmarked. Both are sorted.
+func fixtureDiff(expected, actual []string) string {
+	var b strings.Builder
+	i, j := 0, 0
+	for i < len(expected) || j < len(actual) {
+		switch {
+		case j == len(actual) || (i < len(expected) && expected[i] < actual[j]):
+			fmt.Fprintf(&b, "    - %s\n", expected[i])
+			i++
+		case i == len(expected) || actual[j] < expected[i]:
+			fmt.Fprintf(&b, "    + %s\n", actual[j])
+			j++
+		default:
+			fmt.Fprintf(&b, "      %s\n", expected[i])
+			i++
+			j++
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// gateway is everything serve builds from the config before it binds any
+// listeners.
+type gateway struct {
+	schemas   *schemaStore
+	handler   http.HandlerFunc
+	keys      *adminKeys
+	settings  *runtimeSettings
+	logLevel  *logLevel
+	tlsConfig *tls.Config
+	acme      *autocert.Manager
+	coverage  *coverage
+	unknown   *unknownFields
+	stats     *fieldStats
+	learner   *learner
+	drift     *driftDetector
+	quotas    *quotas
+}
+
+// newGateway loads the schemas and every other file the config refers to
+// and builds the handler chain, failing on anything invalid.
+func newGateway(cfg *config) (*gateway, error) {
+	g := &gateway{}
+
+	if cfg.HTTP3 && (!cfg.TLS.enabled() || cfg.UnixSocket != "") {
+		return nil, errors.New("-http3 requires TLS on a TCP listener")
+	}
+	if cfg.UnixSocket != "" {
+		if _, err := strconv.ParseUint(cfg.UnixSocketMode, 8, 32); err != nil {
+			return nil, fmt.Errorf("invalid socket mode %q: %v", cfg.UnixSocketMode, err)
+		}
+	}
+
+	var err error
+	if g.logLevel, err = newLogLevel(cfg.LogLevel); err != nil {
+		return nil, err
+	}
+
+	// gojsonschema fetches remote $refs with the default client.
+	var transport, schemaTransport http.RoundTripper = nil, http.DefaultTransport
+	if cfg.Breaker.Failures > 0 {
+		schemaTransport = newBreakerTransport(&cfg.Breaker, schemaTransport)
+		transport = newBreakerTransport(&cfg.Breaker, http.DefaultTransport)
+	}
+	http.DefaultClient.Transport = &fetchStatsTransport{next: &retryTransport{backoff: &cfg.SchemaRetry, next: schemaTransport}}
+
+	if err := setStringLength(cfg.StringLength); err != nil {
+		return nil, err
+	}
+	if err := validBOMMode(cfg.ByteOrderMark); err != nil {
+		return nil, err
+	}
+	if cfg.SchemaAgeIdentity != "" {
+		if cfg.Schemas.Decrypter, err = loadSchemaDecrypter(cfg.SchemaAgeIdentity); err != nil {
+			return nil, fmt.Errorf("invalid -schema-age-identity: %v", err)
+		}
+	}
+	if cfg.SchemaPublicKey != "" {
+		if cfg.Schemas.Verifier, err = loadSchemaVerifier(cfg.SchemaPublicKey); err != nil {
+			return nil, fmt.Errorf("invalid -schema-public-key: %v", err)
+		}
+	}
+	switch {
+	case cfg.SchemaKV != "" && cfg.Kubernetes.Resource != "":
+		return nil, errors.New("-schema-kv and -schema-kubernetes can't both be used")
+	case cfg.SchemaKV != "":
+		if cfg.Schemas.KV, err = newKVSource(cfg.SchemaKV); err != nil {
+			return nil, fmt.Errorf("failed to read -schema-kv: %v", err)
+		}
+	case cfg.Kubernetes.Resource != "":
+		if cfg.Schemas.KV, err = newKubeSource(&cfg.Kubernetes); err != nil {
+			return nil, fmt.Errorf("failed to read -schema-kubernetes: %v", err)
+		}
+	}
+	if cfg.Schemas.Uploads, err = newSchemaUploads(cfg.SchemaStore, cfg.Schemas.Decrypter); err != nil {
+		return nil, fmt.Errorf("failed to open -schema-store: %v", err)
+	}
+	var schemas *schemaStore
+	err = cfg.SchemaRetry.retry(func(attempt int) error {
+		var err error
+		if schemas, err = newSchemaStore(&cfg.Schemas); err != nil && attempt < cfg.SchemaRetry.Retries {
+			log.Printf("failed to load schemas, retrying: %v", err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schemas: %v", err)
+	}
+	cfg.Schemas.Uploads.schedule(schemas)
+	if cfg.Schemas.Uploads.db != nil {
+		go cfg.Schemas.Uploads.watch(context.Background(), schemas)
+	}
+	if cfg.Schemas.KV != nil {
+		go cfg.Schemas.KV.watch(context.Background(), func() {
+			compiled, err := schemas.reload()
+			if err != nil {
+				log.Printf("failed to reload schemas changed in %s, keeping the current ones: %v", cfg.Schemas.KV.kind, err)
+				return
+			}
+			log.Printf("reloaded schemas changed in %s at revision %d, compiled %d new or changed", cfg.Schemas.KV.kind, schemas.current().revision, compiled)
+		})
+	}
+
+	if cfg.Quotas.Daily > 0 || cfg.Quotas.Monthly > 0 || cfg.Quotas.File != "" {
+		if g.quotas, err = newQuotas(&cfg.Quotas, cfg.RateLimit.APIKeyHeader); err != nil {
+			return nil, fmt.Errorf("invalid -quota-file: %v", err)
+		}
+	}
+	var limiter *rateLimiter
+	if cfg.RateLimit.Rate > 0 {
+		if limiter, err = newRateLimiter(&cfg.RateLimit, g.quotas); err != nil {
+			return nil, fmt.Errorf("invalid rate limit config: %v", err)
+		}
+	}
+	initial := cfg.Settings
+	initial.RateLimit, initial.RateBurst = cfg.RateLimit.Rate, cfg.RateLimit.Burst
+	var flags *featureFlags
+	if cfg.FeatureFlags.URL != "" {
+		if flags, err = newFeatureFlags(&cfg.FeatureFlags, cfg.RateLimit.APIKeyHeader); err != nil {
+			return nil, err
+		}
+	}
+	if g.settings, err = newRuntimeSettings(initial, limiter, flags, cfg.RoutePolicies); err != nil {
+		return nil, fmt.Errorf("invalid settings: %v", err)
+	}
+
+	types := parseMediaTypes(cfg.ContentTypes)
+	if cfg.Schemas.Vendor != "" {
+		types = append(types, schemas.current().vendorMediaTypes())
+	}
+
+	cfg.RoutePolicies.bodyLimits(cfg.BodyLimits.Routes)
+	bodyBuffers.max = int(cfg.BodyLimits.Max)
+
+	var handler http.HandlerFunc = process
+	if cfg.Upstream != "" {
+		var modify func(*http.Response) error
+		if len(cfg.ResponseSchemas) > 0 {
+			v, err := newResponseValidator(schemas, cfg.ResponseSchemas, cfg.ResponseViolation)
+			if err != nil {
+				return nil, fmt.Errorf("invalid response validation config: %v", err)
+			}
+			modify = v.modifyResponse
+		}
+
+		handler, err = newProxy(cfg.Upstream, transport, modify)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream: %v", err)
+		}
+
+		if cfg.Mirror.Upstream != "" {
+			m, err := newMirror(&cfg.Mirror)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mirror upstream: %v", err)
+			}
+			handler = mirrorTraffic(m, handler)
+		}
+	}
+	if cfg.Mirror.Upstream != "" && cfg.Upstream == "" {
+		return nil, errors.New("-mirror-upstream requires -upstream")
+	}
+	forward := handler
+
+	if cfg.InjectDefaults {
+		handler = injectDefaults(handler)
+	}
+	if cfg.StripUnknown {
+		handler = stripUnknown(handler)
+	}
+	switch cfg.AdditionalProperties {
+	case "":
+	case "reject", "log":
+		handler = enforceAdditionalProperties(cfg.AdditionalProperties, g.settings, handler)
+	default:
+		return nil, fmt.Errorf("invalid -additional-properties %q, expected reject or log", cfg.AdditionalProperties)
+	}
+	if cfg.TrackUnknownFields {
+		g.unknown = newUnknownFields()
+		handler = trackUnknownFields(g.unknown, handler)
+	}
+	if cfg.FieldStatsPercent > 0 {
+		g.stats = newFieldStats(cfg.FieldStatsPercent)
+		handler = collectFieldStats(g.stats, handler)
+	}
+	if cfg.Email.Verify {
+		v, err := newEmailVerifier(&cfg.Email)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load -email-disposable-domains: %v", err)
+		}
+		handler = verifyEmails(v, g.settings, handler)
+	}
+	handler = checkCrossFieldRules(g.settings, handler)
+	var cache *resultCache
+	if cfg.ResultCacheSize > 0 {
+		cache = newResultCache(cfg.ResultCacheSize)
+	}
+	// Streaming checks the limits as the body arrives, there's no need to
+	// check them again.
+	limits := &cfg.JSONLimits
+	if cfg.StreamCheck {
+		limits = nil
+	}
+	var pool *validationPool
+	if cfg.ValidationWorkers > 0 {
+		pool = newValidationPool(cfg.ValidationWorkers, cfg.ValidationQueue)
+	}
+	if cfg.CoveragePercent > 0 {
+		g.coverage = newCoverage(cfg.CoveragePercent)
+		handler = trackCoverage(g.coverage, handler)
+	}
+	switch {
+	case cfg.Idempotency.TTL > 0 && cfg.Idempotency.MaxBytes < cfg.Idempotency.MaxResponseBytes:
+		return nil, errors.New("-idempotency-max-bytes must be at least -idempotency-max-response-bytes")
+	case cfg.Idempotency.TTL > 0:
+		idem := newIdempotencyCache(&cfg.Idempotency, cfg.RateLimit.APIKeyHeader)
+		handler = idempotent(idem, func(next http.HandlerFunc) http.HandlerFunc {
+			return validate(limits, cache, pool, g.settings, next)
+		}, handler)
+	case cfg.Idempotency.ReplayResponses:
+		return nil, errors.New("-idempotency-replay-responses requires -idempotency-ttl")
+	default:
+		handler = validate(limits, cache, pool, g.settings, handler)
+	}
+	if cfg.CoerceTypes {
+		handler = coerceTypes(handler)
+	}
+	handler = normalizeStrings(handler)
+	if cfg.NormalizeUnicode {
+		handler = normalizeUnicode(handler)
+	}
+	if cfg.StreamCheck {
+		handler = streamCheck(&cfg.JSONLimits, g.settings, handler)
+	}
+	if cfg.JSONPatch {
+		handler = routePatch(jsonPatchMediaType, validateJSONPatch(&cfg.JSONLimits, g.settings, forward), handler)
+		types = append(types, jsonPatchMediaType)
+	}
+	if cfg.MergePatch.enabled() {
+		handler = routePatch(mergePatchMediaType, validateMergePatch(newMergePatcher(&cfg.MergePatch), &cfg.JSONLimits, g.settings, forward), handler)
+		types = append(types, mergePatchMediaType)
+	}
+	if cfg.Record.File != "" {
+		rec, err := newRecorder(&cfg.Record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open -record-file: %v", err)
+		}
+		handler = recordTraffic(rec, handler)
+	}
+	var alerts *notifier
+	if cfg.Alerts.enabled() {
+		if alerts, err = newNotifier(&cfg.Alerts); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Alerts.FailureThreshold > 0 {
+		if alerts == nil {
+			return nil, errors.New("-alert-failure-threshold requires -alert-webhook-url, -alert-slack-webhook-url or -alert-pagerduty-routing-key")
+		}
+		if cfg.Alerts.Window <= 0 {
+			return nil, errors.New("-alert-window must be positive")
+		}
+		handler = monitorFailures(newFailureMonitor(&cfg.Alerts, alerts.send), handler)
+	}
+	if cfg.Drift.Threshold > 0 {
+		if cfg.Drift.Window <= 0 {
+			return nil, errors.New("-drift-window must be positive")
+		}
+		notify := logDrift
+		if alerts != nil {
+			notify = func(a driftAlert, resolved bool) {
+				logDrift(a, resolved)
+				alerts.send(driftNotification(&cfg.Alerts, a, resolved))
+			}
+		}
+		g.drift = newDriftDetector(&cfg.Drift, notify)
+		handler = detectDrift(g.drift, handler)
+	}
+	handler = selectDiscriminated(schemas, handler)
+	handler = selectSchema(schemas, handler)
+	if cfg.GraphQL.Path != "" {
+		if cfg.GraphQL.Unknown != "allow" && cfg.GraphQL.Unknown != "reject" {
+			return nil, fmt.Errorf("invalid -graphql-unknown-operations %q, expected allow or reject", cfg.GraphQL.Unknown)
+		}
+		handler = routePath(cfg.GraphQL.Path, validateGraphQL(schemas, &cfg.GraphQL, &cfg.JSONLimits, g.settings, forward), handler)
+	}
+	if cfg.Admission.Path != "" {
+		if len(cfg.Admission.Rules) == 0 {
+			return nil, errors.New("-admission-path needs at least one -admission-rule")
+		}
+		handler = routePath(cfg.Admission.Path, handleAdmission(schemas, &cfg.Admission, g.settings), handler)
+	}
+	if cfg.Report.Path != "" {
+		var cache *reportCache
+		if cfg.Report.CacheTTL > 0 {
+			cache = newReportCache(cfg.Report.CacheTTL, cfg.Report.CacheSize)
+		}
+		handler = routePath(cfg.Report.Path, reportSchema(schemas, selectDiscriminated(schemas, reportValidation(&cfg.JSONLimits, cache))), handler)
+	}
+	if cfg.Egress.Destinations != "" {
+		dests, err := loadEgressDestinations(cfg.Egress.Destinations, cfg.Egress.Path, transport)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -egress-destinations: %v", err)
+		}
+		handler = routePath(cfg.Egress.Path, egressProxy(cfg.Egress.Path, dests, &cfg.JSONLimits, g.settings), handler)
+	}
+	if cfg.Learn.Duration > 0 {
+		g.learner = newLearner(&cfg.Learn, time.Now())
+		handler = learnSchemas(g.learner, handler)
+	}
+	handler = limitBody(&cfg.BodyLimits, decompressBody(cfg.MaxDecodedBytes, checkEncoding(cfg.ByteOrderMark, handler)))
+	handler = requireContentType(types, handler)
+	if cfg.Concurrency.MaxConcurrent > 0 {
+		handler = limitConcurrency(newConcurrencyLimiter(&cfg.Concurrency), handler)
+	}
+	switch {
+	case cfg.JWT.JWKSURL != "" && cfg.Introspection.URL != "":
+		return nil, errors.New("-jwt-jwks-url and -introspection-url are mutually exclusive")
+	case cfg.JWT.JWKSURL != "":
+		handler = verifyJWT(newJWTVerifier(&cfg.JWT), handler)
+	case cfg.Introspection.URL != "":
+		handler = introspectToken(newIntrospector(&cfg.Introspection), handler)
+	}
+	if g.quotas != nil {
+		handler = enforceQuotas(g.quotas, handler)
+	}
+	if limiter != nil {
+		handler = rateLimit(limiter, handler)
+	}
+	if len(cfg.RoutePolicies) > 0 {
+		handler = routeTimeouts(cfg.RoutePolicies, handler)
+	}
+	if cfg.Chaos.enabled() {
+		handler = injectFaults(&cfg.Chaos, handler)
+	}
+	if cfg.CORS.Origins != "" {
+		handler = handleCORS(newCORS(&cfg.CORS), handler)
+	}
+	if cfg.IPFilter.enabled() {
+		filter, err := newIPFilter(&cfg.IPFilter)
+		if err != nil {
+			return nil, err
+		}
+		handler = filterClients(filter, handler)
+	}
+	if cfg.TrustedProxies != "" {
+		proxies, err := parsePrefixes(cfg.TrustedProxies)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -trusted-proxies: %v", err)
+		}
+		handler = resolveClient(proxies, handler)
+	}
+	requestIDHeader = cfg.RequestIDHeader
+	handler = serveErrorSchema(withRequestID(handler))
+
+	if cfg.AdminKeysFile != "" || cfg.AdminJWTRole != "" {
+		g.keys, err = newAdminKeys(cfg.AdminKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load admin API keys: %v", err)
+		}
+	}
+	if cfg.AdminJWTRole != "" {
+		if cfg.JWT.JWKSURL == "" {
+			return nil, errors.New("-admin-jwt-role-claim needs -jwt-jwks-url")
+		}
+		g.keys.jwt, g.keys.roleClaim = newJWTVerifier(&cfg.JWT), cfg.AdminJWTRole
+	}
+
+	g.acme = cfg.TLS.acmeManager()
+	if cfg.TLS.enabled() {
+		g.tlsConfig, err = cfg.TLS.build(g.acme)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS config: %v", err)
+		}
+	}
+
+	g.schemas, g.handler = schemas, handler
+	return g, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runGenerate implements the generate command, printing a sample payload
+// for the schema.
+func runGenerate(args []string) error {
+	var schemaFlags schemaArgs
+	fs := flag.NewFlagSet("schema-validations generate", flag.ExitOnError)
+	schemaFlags.register(fs)
+	invalid := fs.Bool("invalid", false, "also print payloads the schema rejects, one per line")
+	fs.Parse(args)
+
+	_, schema, err := schemaFlags.load()
+	if err != nil {
+		return err
+	}
+
+	valid, rejected, err := benchPayloads(schema)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "%s\n", valid)
+	if *invalid {
+		for _, p := range rejected {
+			fmt.Fprintf(os.Stdout, "%s\n", p)
+		}
+	}
+	return nil
+}
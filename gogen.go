@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// runGenerateGo implements `generate go`, writing a client package for each
+// schema: structs for the payload, a Validate method checking them against
+// the same schema the gateway enforces, and a client that validates before
+// sending.
+func runGenerateGo(args []string) error {
+	var cfg schemaConfig
+	fs := flag.NewFlagSet("schema-validations generate go", flag.ExitOnError)
+	fs.StringVar(&cfg.Dir, "schema-dir", "", "directory of <name>.<version>.json schemas, the built-in post schema if empty")
+	fs.StringVar(&cfg.BaseSchema, "base-schema", "", "JSON schema of fields every request shares, added to the allOf of every schema")
+	fs.BoolVar(&cfg.Embedded, "embedded-schemas", embeddedSchemas != nil, "load the schemas bundled into the binary with -tags embedschemas")
+	length := fs.String("string-length", "runes", "how the generated clients count characters for minLength and maxLength, as the gateway's -string-length does: runes, bytes or graphemes")
+	out := fs.String("out", "clients", "directory to write a package per schema into")
+	fs.Parse(args)
+	if err := setStringLength(*length); err != nil {
+		return err
+	}
+
+	docs, err := schemaDocuments(&cfg)
+	if err != nil {
+		return err
+	}
+	latest := latestDocuments(docs)
+
+	for _, doc := range latest {
+		src, err := goClient(doc, *length)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", doc.Name, doc.Version, err)
+		}
+
+		dir := filepath.Join(*out, goPackageName(doc.Name))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		file := filepath.Join(dir, goPackageName(doc.Name)+".go")
+		if err := ioutil.WriteFile(file, src, 0644); err != nil {
+			return err
+		}
+		fmt.Println(file)
+	}
+	return nil
+}
+
+// latestDocuments returns the latest version of every schema outside the
+// tenant overrides, sorted by name.
+func latestDocuments(docs []schemaDocument) []schemaDocument {
+	set := make(schemaSet)
+	byVersion := make(map[*schemaVersion]schemaDocument)
+	for _, doc := range docs {
+		if doc.Tenant != "" {
+			continue
+		}
+		v := &schemaVersion{Name: doc.Name, Version: doc.Version}
+		set[doc.Name] = append(set[doc.Name], v)
+		byVersion[v] = doc
+	}
+	set.sort()
+
+	var names []string
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var latest []schemaDocument
+	for _, name := range names {
+		v, _ := set.lookup(name, "")
+		latest = append(latest, byVersion[v])
+	}
+	return latest
+}
+
+// goGen turns a schema into Go type declarations.
+type goGen struct {
+	root  interface{}
+	decls []string
+	names map[string]bool
+	refs  map[string]string
+}
+
+func goClient(doc schemaDocument, stringLength string) ([]byte, error) {
+	root, err := decodeJSON(doc.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &goGen{root: root, names: make(map[string]bool), refs: make(map[string]string)}
+	typeName := goIdentifier(doc.Name)
+	g.names[typeName] = true
+	if t := g.goType(typeName, root, 0); t != typeName {
+		g.decls = append([]string{fmt.Sprintf("// %s is a %s payload.\ntype %s %s\n", typeName, doc.Name, typeName, t)}, g.decls...)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by schema-validations generate go. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "// Package %s is a client for %s %s payloads. Payloads are checked\n", goPackageName(doc.Name), doc.Name, doc.Version)
+	fmt.Fprintf(&b, "// against the same schema the gateway enforces before they are sent.\n")
+	fmt.Fprintf(&b, "package %s\n\n", goPackageName(doc.Name))
+	b.WriteString(`import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+`)
+	fmt.Fprintf(&b, "// SchemaVersion is the version of the schema this package was generated from.\nconst SchemaVersion = %q\n\n", doc.Version)
+	fmt.Fprintf(&b, "const schemaJSON = %s\n\n", strconv.Quote(string(doc.Data)))
+	for _, decl := range g.decls {
+		b.WriteString(decl)
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, goClientTemplate, typeName, stringLength)
+
+	return format.Source(b.Bytes())
+}
+
+// goClientTemplate is the validation and client half of a generated
+// package, formatted with the payload type name and the -string-length it
+// was generated with.
+const goClientTemplate = `var (
+	compileOnce sync.Once
+	compiled    *schemavalidation.Validator
+	compileErr  error
+)
+
+func validator() (*schemavalidation.Validator, error) {
+	compileOnce.Do(func() {
+		compiled, compileErr = schemavalidation.New([]byte(schemaJSON), schemavalidation.Options{StringLength: %[2]q})
+	})
+	return compiled, compileErr
+}
+
+// ValidationError lists why a payload doesn't satisfy the schema, in the
+// same words the gateway would use.
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "payload does not match the schema: " + strings.Join(e.Errors, "; ")
+}
+
+// Validate checks v against the schema.
+func (v *%[1]s) Validate() error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ValidateJSON(b)
+}
+
+// ValidateJSON checks a raw payload against the schema.
+func ValidateJSON(b []byte) error {
+	v, err := validator()
+	if err != nil {
+		return err
+	}
+	errs, err := v.Validate(b)
+	if err != nil || len(errs) == 0 {
+		return err
+	}
+
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Message
+	}
+	return &ValidationError{Errors: msgs}
+}
+
+// Client sends payloads to a gateway, or to anything behind one.
+type Client struct {
+	// BaseURL is joined with the path given to Send.
+	BaseURL string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// VersionHeader, when set, names the schema version the payload
+	// follows, as -schema-version-header does on the gateway.
+	VersionHeader string
+}
+
+// Send validates v and POSTs it to path, returning a *ValidationError
+// without sending anything when it is invalid.
+func (c *Client) Send(ctx context.Context, path string, v *%[1]s) (*http.Response, error) {
+	return c.Do(ctx, http.MethodPost, path, v)
+}
+
+// Do validates v and sends it to path with method.
+func (c *Client) Do(ctx context.Context, method, path string, v *%[1]s) (*http.Response, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateJSON(b); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.BaseURL, "/")+path, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.VersionHeader != "" {
+		req.Header.Set(c.VersionHeader, SchemaVersion)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+`
+
+// goType returns the Go type for node, declaring the structs it needs under
+// names derived from name.
+func (g *goGen) goType(name string, node interface{}, depth int) string {
+	if depth > schemavalidation.MaxSchemaDepth {
+		return "interface{}"
+	}
+	if m, ok := node.(schemavalidation.SchemaObject); ok {
+		if ref, ok := m["$ref"].(string); ok {
+			return g.refType(ref, depth)
+		}
+	}
+
+	parts := schemavalidation.SchemaParts(g.root, node)
+	types := schemaTypes(parts)
+	for _, part := range parts {
+		for _, keyword := range []string{"anyOf", "oneOf"} {
+			if _, ok := part[keyword]; ok && len(types) != 1 {
+				return "interface{}"
+			}
+		}
+	}
+	if len(types) > 1 {
+		return "interface{}"
+	}
+
+	switch sampleType(parts) {
+	case "object":
+		return g.objectType(name, parts, depth)
+	case "array":
+		items := schemavalidation.ItemSchemas(parts, 0)
+		if len(items) == 0 {
+			return "[]interface{}"
+		}
+		return "[]" + g.goType(name+"Item", items[0], depth+1)
+	case "integer":
+		return "int64"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "null":
+		return "interface{}"
+	}
+	if len(types) == 0 && !hasStringHints(parts) {
+		return "interface{}"
+	}
+	return "string"
+}
+
+// hasStringHints reports whether an untyped schema still only describes
+// strings, through string keywords or an enum of strings.
+func hasStringHints(parts []schemavalidation.SchemaObject) bool {
+	for _, part := range parts {
+		for _, keyword := range []string{"minLength", "maxLength", "pattern", "format"} {
+			if _, ok := part[keyword]; ok {
+				return true
+			}
+		}
+		if enum, ok := part["enum"].([]interface{}); ok && len(enum) > 0 {
+			for _, e := range enum {
+				if _, ok := e.(string); !ok {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (g *goGen) refType(ref string, depth int) string {
+	if t, ok := g.refs[ref]; ok {
+		return t
+	}
+	target, ok := schemavalidation.ResolveRef(g.root, ref)
+	if !ok {
+		return "interface{}"
+	}
+
+	name := g.uniqueName(goIdentifier(ref[strings.LastIndex(ref, "/")+1:]))
+	g.refs[ref] = name
+	t := g.goType(name, target, depth+1)
+	if t != name {
+		g.refs[ref] = t
+	}
+	return g.refs[ref]
+}
+
+func (g *goGen) objectType(name string, parts []schemavalidation.SchemaObject, depth int) string {
+	var props []string
+	schemas := make(map[string][]interface{})
+	for _, part := range parts {
+		p, _ := part["properties"].(schemavalidation.SchemaObject)
+		for prop, s := range p {
+			if _, ok := schemas[prop]; !ok {
+				props = append(props, prop)
+			}
+			schemas[prop] = append(schemas[prop], s)
+		}
+	}
+	required := schemavalidation.RequiredProperties(parts)
+	// Required names the properties don't declare still need a field, or no
+	// value of the type could be valid.
+	for prop := range required {
+		if _, ok := schemas[prop]; !ok {
+			props = append(props, prop)
+			schemas[prop] = nil
+		}
+	}
+	if len(props) == 0 {
+		for _, part := range parts {
+			if additional, ok := part["additionalProperties"].(schemavalidation.SchemaObject); ok {
+				return "map[string]" + g.goType(name+"Value", additional, depth+1)
+			}
+		}
+		return "map[string]interface{}"
+	}
+	sort.Strings(props)
+
+	if !g.names[name] {
+		name = g.uniqueName(name)
+	}
+
+	var b strings.Builder
+	if desc := schemaDescription(parts); desc != "" {
+		r := []rune(strings.TrimSuffix(desc, "."))
+		if len(r) > 1 && unicode.IsLower(r[1]) {
+			r[0] = unicode.ToLower(r[0])
+		}
+		fmt.Fprintf(&b, "// %s is %s.\n", name, string(r))
+	}
+	fmt.Fprintf(&b, "type %s struct {\n", name)
+	fields := make(map[string]bool)
+	for _, prop := range props {
+		field := goIdentifier(prop)
+		for fields[field] {
+			field += "_"
+		}
+		fields[field] = true
+
+		// Only the first schema, usually the one with the detail, shapes
+		// the field; the rest still apply through Validate.
+		t := "interface{}"
+		if len(schemas[prop]) > 0 {
+			t = g.goType(name+field, schemas[prop][0], depth+1)
+		}
+		tag := prop
+		if !required[prop] {
+			tag += ",omitempty"
+			if !strings.HasPrefix(t, "[]") && !strings.HasPrefix(t, "map[") && t != "interface{}" {
+				t = "*" + t
+			}
+		}
+		fmt.Fprintf(&b, "\t%s %s `json:%q`\n", field, t, tag)
+	}
+	b.WriteString("}\n")
+
+	g.decls = append(g.decls, b.String())
+	return name
+}
+
+func (g *goGen) uniqueName(name string) string {
+	unique := name
+	for i := 2; g.names[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	g.names[unique] = true
+	return unique
+}
+
+func schemaDescription(parts []schemavalidation.SchemaObject) string {
+	for _, part := range parts {
+		if s, ok := part["description"].(string); ok && !strings.ContainsAny(s, "\n") {
+			return s
+		}
+	}
+	return ""
+}
+
+// goInitialisms are the words golint would have upper case.
+var goInitialisms = map[string]bool{
+	"api": true, "id": true, "ip": true, "json": true, "url": true, "uri": true, "uuid": true, "http": true,
+}
+
+// goIdentifier turns a schema or property name such as author_email into an
+// exported Go identifier, AuthorEmail.
+func goIdentifier(s string) string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var b strings.Builder
+	for _, word := range words {
+		if goInitialisms[strings.ToLower(word)] {
+			b.WriteString(strings.ToUpper(word))
+			continue
+		}
+		r := []rune(word)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+
+	id := b.String()
+	if id == "" || !unicode.IsLetter([]rune(id)[0]) {
+		id = "X" + id
+	}
+	return id
+}
+
+func goPackageName(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 || !unicode.IsLetter([]rune(b.String())[0]) {
+		return "schema" + b.String()
+	}
+	return b.String()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+type graphqlConfig struct {
+	Path         string
+	SchemaPrefix string
+	Unknown      string
+}
+
+// graphqlRequest is a GraphQL request over HTTP, as a POST body or the
+// query string of a GET.
+type graphqlRequest struct {
+	Query         string          `json:"query"`
+	OperationName string          `json:"operationName"`
+	Variables     json.RawMessage `json:"variables"`
+}
+
+// graphqlOperation finds the name of the only operation in a document
+// without an operationName.
+var graphqlOperation = regexp.MustCompile(`^\s*(?:query|mutation|subscription)\s+([_A-Za-z][_0-9A-Za-z]*)`)
+
+// routePath sends requests under path to h and everything else to next, for
+// endpoints like GraphQL's that pick their schema themselves rather than
+// through the usual schema selection.
+func routePath(path string, h, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path || strings.HasPrefix(r.URL.Path, strings.TrimSuffix(path, "/")+"/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateGraphQL checks the variables of each operation in a request
+// against the schema named after it, the operation name with the configured
+// prefix, at the version the version header asks for. Batched requests are
+// checked operation by operation.
+func validateGraphQL(schemas *schemaStore, cfg *graphqlConfig, limits *jsonLimits, rt *runtimeSettings, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			if err := limits.check(body); err != nil {
+				writeErrors(w, http.StatusBadRequest, codeOf(err, codeInvalidJSON), err.Error())
+				return
+			}
+		}
+
+		var ops []graphqlRequest
+		switch {
+		case r.Method == http.MethodGet:
+			q := r.URL.Query()
+			ops = []graphqlRequest{{Query: q.Get("query"), OperationName: q.Get("operationName"), Variables: json.RawMessage(q.Get("variables"))}}
+		case len(strings.TrimSpace(string(body))) > 0 && strings.TrimSpace(string(body))[0] == '[':
+			if err := json.Unmarshal(body, &ops); err != nil {
+				writeErrors(w, http.StatusBadRequest, codeInvalidGraphQL, fmt.Sprintf("request body is not a GraphQL batch: %v", err))
+				return
+			}
+		default:
+			var op graphqlRequest
+			if err := json.Unmarshal(body, &op); err != nil {
+				writeErrors(w, http.StatusBadRequest, codeInvalidGraphQL, fmt.Sprintf("request body is not a GraphQL request: %v", err))
+				return
+			}
+			ops = []graphqlRequest{op}
+		}
+
+		registry := schemas.current()
+		var msgs []string
+		for _, op := range ops {
+			name := op.OperationName
+			if name == "" {
+				if m := graphqlOperation.FindStringSubmatch(op.Query); m != nil {
+					name = m[1]
+				}
+			}
+
+			schema, ok := registry.find(r, cfg.SchemaPrefix+name, r.Header.Get(registry.versionHeader))
+			if !ok || name == "" {
+				if cfg.Unknown == "reject" {
+					msgs = append(msgs, fmt.Sprintf("operation %q: no schema for its variables", name))
+				}
+				continue
+			}
+
+			variables := op.Variables
+			if len(variables) == 0 || string(variables) == "null" {
+				variables = json.RawMessage("{}")
+			}
+			result, err := schema.Schema.Validate(gojsonschema.NewBytesLoader(variables))
+			if err != nil {
+				msgs = append(msgs, fmt.Sprintf("operation %q: variables are not valid JSON", name))
+				continue
+			}
+			for _, msg := range errorMessages(schemaErrors(schema, variables, result)) {
+				msgs = append(msgs, fmt.Sprintf("operation %q: variables: %s", name, msg))
+			}
+		}
+
+		if len(msgs) > 0 && rt.rejectInvalid(w, r, rt.forRequest(r), "does not match the schema", errorEntries(codeSchemaViolation, msgs)) {
+			return
+		}
+
+		replaceBody(r, body)
+		next.ServeHTTP(w, r)
+	})
+}
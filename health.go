@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// health tracks whether the process should receive traffic. Liveness only
+// says the process is up; readiness is flipped on once the schema has been
+// compiled and flipped off again when the server starts shutting down. With
+// an upstream and a timeout, readiness also needs a TCP connection to the
+// upstream to open within the timeout on every probe.
+type health struct {
+	ready int32
+
+	upstream string
+	timeout  time.Duration
+}
+
+func newHealth(upstream string, timeout time.Duration) *health {
+	h := &health{timeout: timeout}
+	if u, err := url.Parse(upstream); err == nil && u.Host != "" {
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+		h.upstream = net.JoinHostPort(u.Hostname(), port)
+	}
+	return h
+}
+
+func (h *health) setReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&h.ready, v)
+}
+
+func (h *health) isReady() bool {
+	return atomic.LoadInt32(&h.ready) == 1
+}
+
+func (h *health) healthz(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+func (h *health) readyz(w http.ResponseWriter, _ *http.Request) {
+	if !h.isReady() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("not ready"))
+		return
+	}
+	if h.upstream != "" && h.timeout > 0 {
+		conn, err := net.DialTimeout("tcp", h.upstream, h.timeout)
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("upstream unreachable: " + err.Error()))
+			return
+		}
+		conn.Close()
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ready"))
+}
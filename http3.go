@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server returns a QUIC listener on the same address and handler
+// chain as data, which must already be configured for TLS. The TCP handler is
+// wrapped so its responses advertise HTTP/3 through Alt-Svc.
+func newHTTP3Server(data *http.Server) *http3.Server {
+	h3 := &http3.Server{
+		Addr:        data.Addr,
+		Handler:     data.Handler,
+		TLSConfig:   http3.ConfigureTLSConfig(data.TLSConfig),
+		IdleTimeout: data.IdleTimeout,
+	}
+
+	next := data.Handler
+	data.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h3.SetQUICHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+
+	return h3
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type idempotencyConfig struct {
+	TTL              time.Duration
+	ReplayResponses  bool
+	MaxResponseBytes int
+	MaxBytes         int
+}
+
+type idempotencyKey struct {
+	key    string
+	method string
+	path   string
+	apiKey string
+}
+
+// idempotentOutcome is what happened to the first request with a key: it
+// was either valid, rejected with errors, or, replaying responses, answered
+// by the upstream with the response kept here.
+type idempotentOutcome struct {
+	key     idempotencyKey
+	size    int
+	schema  *schemaVersion
+	sum     [sha256.Size]byte
+	expires time.Time
+	pending bool
+
+	status int
+	errors []errorEntry
+	header http.Header
+	body   []byte
+}
+
+// idempotencyCache remembers, for a TTL, the outcome of requests sent with an
+// Idempotency-Key header, so a client retrying one, the same body with the
+// same key, doesn't have it validated again: a valid body goes straight on
+// to the upstream and an invalid one gets the same rejection. With
+// -idempotency-replay-responses the upstream's response is kept too and
+// retries are answered with it without reaching the upstream, which is
+// what makes retrying a webhook that did get through harmless. Keys are
+// scoped to the method, path and API key of the request, and a key reused
+// for a different body is refused with a 422. Keys are chosen by clients,
+// so once the outcomes take up MaxBytes the least recently used are
+// forgotten before their TTL is up.
+type idempotencyCache struct {
+	cfg    *idempotencyConfig
+	header string
+
+	mu       sync.Mutex
+	order    *list.List
+	outcomes map[idempotencyKey]*list.Element
+	bytes    int
+	swept    time.Time
+}
+
+func newIdempotencyCache(cfg *idempotencyConfig, apiKeyHeader string) *idempotencyCache {
+	return &idempotencyCache{
+		cfg:      cfg,
+		header:   apiKeyHeader,
+		order:    list.New(),
+		outcomes: make(map[idempotencyKey]*list.Element),
+	}
+}
+
+// sweep drops expired outcomes.
+func (c *idempotencyCache) sweep(now time.Time) {
+	if now.Sub(c.swept) < time.Minute {
+		return
+	}
+	c.swept = now
+
+	for _, e := range c.outcomes {
+		if now.After(e.Value.(*idempotentOutcome).expires) {
+			c.remove(e)
+		}
+	}
+}
+
+// store keeps o as the outcome of its key, replacing any there was, and
+// evicts the least recently used outcomes over the byte budget.
+func (c *idempotencyCache) store(o *idempotentOutcome) {
+	if e, ok := c.outcomes[o.key]; ok {
+		c.remove(e)
+	}
+	// Roughly what is held onto, the key included since clients choose it.
+	o.size = len(o.key.key) + len(o.key.method) + len(o.key.path) + len(o.key.apiKey) + len(o.body)
+	for _, e := range o.errors {
+		o.size += len(e.Code) + len(e.Message)
+	}
+	for name, values := range o.header {
+		o.size += len(name)
+		for _, v := range values {
+			o.size += len(v)
+		}
+	}
+	c.outcomes[o.key] = c.order.PushFront(o)
+	c.bytes += o.size
+
+	for c.bytes > c.cfg.MaxBytes && c.order.Len() > 0 {
+		c.remove(c.order.Back())
+		metricIdempotentEvictions.Add(1)
+	}
+}
+
+func (c *idempotencyCache) remove(e *list.Element) {
+	o := e.Value.(*idempotentOutcome)
+	c.order.Remove(e)
+	delete(c.outcomes, o.key)
+	c.bytes -= o.size
+}
+
+// begin looks up the outcome of key, claiming it for this request when
+// there is none, or none left for the schema the request is validated
+// against.
+func (c *idempotencyCache) begin(key idempotencyKey, schema *schemaVersion, sum [sha256.Size]byte, now time.Time) (idempotentOutcome, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweep(now)
+	if e, ok := c.outcomes[key]; ok {
+		if o := e.Value.(*idempotentOutcome); now.Before(o.expires) && o.schema == schema {
+			c.order.MoveToFront(e)
+			return *o, true
+		}
+	}
+	c.store(&idempotentOutcome{key: key, schema: schema, sum: sum, expires: now.Add(c.cfg.TTL), pending: true})
+	return idempotentOutcome{}, false
+}
+
+// finish records the outcome of the request that claimed key, or forgets
+// the claim when o is nil so the next retry is handled afresh.
+func (c *idempotencyCache) finish(key idempotencyKey, o *idempotentOutcome) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if o == nil {
+		if e, ok := c.outcomes[key]; ok {
+			c.remove(e)
+		}
+		return
+	}
+	o.key = key
+	c.store(o)
+}
+
+// idempotent wraps validated, the validation of the request body, and next,
+// what it hands valid bodies to, with the idempotency cache.
+func idempotent(c *idempotencyCache, validated func(next http.HandlerFunc) http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	handler := validated(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("Idempotency-Key")
+		if id == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		replaceBody(r, body)
+
+		key := idempotencyKey{key: id, method: r.Method, path: r.URL.Path, apiKey: r.Header.Get(c.header)}
+		schema := requestSchema(r)
+		sum := sha256.Sum256(body)
+		now := time.Now()
+		o, ok := c.begin(key, schema, sum, now)
+		if ok {
+			switch {
+			case o.sum != sum:
+				writeErrors(w, http.StatusUnprocessableEntity, codeIdempotencyKeyReused, "the Idempotency-Key was already used for a different request body")
+			case o.pending:
+				writeErrors(w, http.StatusConflict, codeIdempotencyKeyInUse, "a request with this Idempotency-Key is still being processed")
+			case o.status == 0:
+				metricIdempotentReplays.Add(1)
+				next.ServeHTTP(w, r)
+			case o.header == nil:
+				metricIdempotentReplays.Add(1)
+				w.Header().Set("Idempotent-Replayed", "true")
+				writeErrorEntries(w, o.status, o.errors)
+			default:
+				metricIdempotentReplays.Add(1)
+				for name, values := range o.header {
+					// The request ID is this request's, not the first one's.
+					if name != http.CanonicalHeaderKey(requestIDHeader) {
+						w.Header()[name] = values
+					}
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(o.status)
+				w.Write(o.body)
+			}
+			return
+		}
+
+		// Only responses not coming from next, rejections, are kept, unless
+		// the upstream's are replayed too.
+		valid := false
+		rw := &replayWriter{ResponseWriter: w, max: c.cfg.MaxResponseBytes}
+		validated(func(w http.ResponseWriter, r *http.Request) {
+			valid = true
+			if !c.cfg.ReplayResponses {
+				rw.passThrough = true
+			}
+			next.ServeHTTP(w, r)
+		}).ServeHTTP(rw, r)
+
+		outcome := &idempotentOutcome{schema: schema, sum: sum, expires: now.Add(c.cfg.TTL)}
+		switch {
+		case valid && !c.cfg.ReplayResponses:
+		case rw.overflow || rw.status == 0 || rw.status >= 500 || rw.status == http.StatusTooManyRequests:
+			// Failures worth retrying aren't kept, nor responses too large
+			// to.
+			outcome = nil
+		case !valid:
+			outcome.status, outcome.errors = rw.status, rw.errors()
+		default:
+			outcome.status, outcome.header, outcome.body = rw.status, rw.header, rw.body.Bytes()
+		}
+		c.finish(key, outcome)
+	})
+}
+
+// replayWriter keeps a copy of the response it writes, up to max bytes of
+// body.
+type replayWriter struct {
+	http.ResponseWriter
+	max         int
+	passThrough bool
+
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *replayWriter) WriteHeader(status int) {
+	if w.status == 0 && !w.passThrough {
+		w.status = status
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *replayWriter) Write(b []byte) (int, error) {
+	if w.status == 0 && !w.passThrough {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.passThrough && !w.overflow {
+		if w.body.Len()+len(b) > w.max {
+			w.overflow = true
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// errors returns the entries of an error response.
+func (w *replayWriter) errors() []errorEntry {
+	var errBody struct {
+		Errors []errorEntry `json:"errors"`
+	}
+	json.Unmarshal(w.body.Bytes(), &errBody)
+	return errBody.Errors
+}
+
+func (w *replayWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
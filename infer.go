@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+const (
+	// maxLearnedRoutes and maxLearnedProperties bound what a learner keeps,
+	// so traffic with random paths or keys can't grow it without limit.
+	maxLearnedRoutes     = 100
+	maxLearnedProperties = 200
+)
+
+type learnConfig struct {
+	Duration          time.Duration
+	RequiredThreshold float64
+	EnumMax           int
+}
+
+// shape accumulates the values seen at one location of the bodies of a
+// route, to draft a schema describing them.
+type shape struct {
+	count   int64
+	types   map[string]int64
+	objects int64
+	props   map[string]*shape
+	items   *shape
+
+	// strings counts distinct string values while there are few enough of
+	// them to be an enum, and is nil once there are too many.
+	strings map[string]int64
+	formats map[string]int64
+}
+
+func newShape() *shape {
+	return &shape{types: make(map[string]int64), strings: make(map[string]int64), formats: make(map[string]int64)}
+}
+
+// stringFormats are the formats drafted for strings that all match them.
+var stringFormats = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"date-time", regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[Tt ]\d{2}:\d{2}:\d{2}(\.\d+)?([Zz]|[+-]\d{2}:\d{2})$`)},
+	{"date", regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)},
+	{"email", regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)},
+	{"uuid", regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)},
+	{"uri", regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://\S+$`)},
+}
+
+func (s *shape) add(v interface{}, cfg *learnConfig, depth int) {
+	if depth > schemavalidation.MaxSchemaDepth {
+		return
+	}
+	s.count++
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		s.types["object"]++
+		s.objects++
+		if s.props == nil {
+			s.props = make(map[string]*shape)
+		}
+		for key, child := range v {
+			p, ok := s.props[key]
+			if !ok {
+				if len(s.props) >= maxLearnedProperties {
+					continue
+				}
+				p = newShape()
+				s.props[key] = p
+			}
+			p.add(child, cfg, depth+1)
+		}
+	case []interface{}:
+		s.types["array"]++
+		if s.items == nil {
+			s.items = newShape()
+		}
+		for _, child := range v {
+			s.items.add(child, cfg, depth+1)
+		}
+	case string:
+		s.types["string"]++
+		if s.strings != nil {
+			s.strings[v]++
+			if len(s.strings) > cfg.EnumMax {
+				s.strings = nil
+			}
+		}
+		for _, f := range stringFormats {
+			if f.re.MatchString(v) {
+				s.formats[f.name]++
+			}
+		}
+	case json.Number:
+		if strings.ContainsAny(string(v), ".eE") {
+			s.types["number"]++
+		} else {
+			s.types["integer"]++
+		}
+	case bool:
+		s.types["boolean"]++
+	case nil:
+		s.types["null"]++
+	}
+}
+
+// draft returns the schema describing the values seen. Properties present
+// in at least cfg.RequiredThreshold of the objects are required. Strings
+// become an enum when they took at most cfg.EnumMax values, each seen five
+// times on average, and get a format when every one of them matched it.
+func (s *shape) draft(cfg *learnConfig) schemavalidation.SchemaObject {
+	schema := make(schemavalidation.SchemaObject)
+
+	var types []string
+	for t := range s.types {
+		if t == "integer" && s.types["number"] > 0 {
+			continue
+		}
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	switch len(types) {
+	case 0:
+	case 1:
+		schema["type"] = types[0]
+	default:
+		list := make([]interface{}, len(types))
+		for i, t := range types {
+			list[i] = t
+		}
+		schema["type"] = list
+	}
+
+	if s.props != nil {
+		props := make(schemavalidation.SchemaObject, len(s.props))
+		var required []string
+		for key, p := range s.props {
+			props[key] = p.draft(cfg)
+			if float64(p.count) >= cfg.RequiredThreshold*float64(s.objects) {
+				required = append(required, key)
+			}
+		}
+		schema["properties"] = props
+		if len(required) > 0 {
+			sort.Strings(required)
+			list := make([]interface{}, len(required))
+			for i, key := range required {
+				list[i] = key
+			}
+			schema["required"] = list
+		}
+	}
+	if s.items != nil && s.items.count > 0 {
+		schema["items"] = s.items.draft(cfg)
+	}
+
+	if n := s.types["string"]; n > 0 {
+		if s.strings != nil && len(s.types) == 1 && n >= int64(5*len(s.strings)) {
+			values := make([]string, 0, len(s.strings))
+			for v := range s.strings {
+				values = append(values, v)
+			}
+			sort.Strings(values)
+			enum := make([]interface{}, len(values))
+			for i, v := range values {
+				enum[i] = v
+			}
+			schema["enum"] = enum
+		} else {
+			for _, f := range stringFormats {
+				if s.formats[f.name] == n {
+					schema["format"] = f.name
+					break
+				}
+			}
+		}
+	}
+
+	return schema
+}
+
+// learner drafts a schema per route from the bodies it observes, for the
+// configured period after it starts, or for good without one.
+type learner struct {
+	cfg   *learnConfig
+	until time.Time
+
+	mu     sync.Mutex
+	routes map[string]*learnedRoute
+}
+
+type learnedRoute struct {
+	method, path string
+	requests     int64
+	shape        *shape
+}
+
+func newLearner(cfg *learnConfig, now time.Time) *learner {
+	l := &learner{cfg: cfg, routes: make(map[string]*learnedRoute)}
+	if cfg.Duration > 0 {
+		l.until = now.Add(cfg.Duration)
+	}
+	return l
+}
+
+// idSegment matches path segments that are most likely identifiers, which
+// are folded together so /posts/1 and /posts/2 are one route.
+var idSegment = regexp.MustCompile(`^(\d+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{24,})$`)
+
+// learnedPath is the route path identifiers are folded into.
+func learnedPath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		if idSegment.MatchString(s) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// observe adds body, sent to method and path, to the route's draft.
+func (l *learner) observe(method, path string, body []byte) {
+	value, err := decodeJSON(body)
+	if err != nil {
+		return
+	}
+
+	path = learnedPath(path)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := method + " " + path
+	route, ok := l.routes[key]
+	if !ok {
+		if len(l.routes) >= maxLearnedRoutes {
+			return
+		}
+		route = &learnedRoute{method: method, path: path, shape: newShape()}
+		l.routes[key] = route
+	}
+	route.requests++
+	route.shape.add(value, l.cfg, 0)
+}
+
+type learnedSchema struct {
+	Method   string                        `json:"method"`
+	Path     string                        `json:"path"`
+	Name     string                        `json:"name"`
+	Requests int64                         `json:"requests"`
+	Schema   schemavalidation.SchemaObject `json:"schema"`
+}
+
+// drafts returns the draft schema of every route observed so far.
+func (l *learner) drafts() []learnedSchema {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := make([]learnedSchema, 0, len(l.routes))
+	for _, route := range l.routes {
+		schema := route.shape.draft(l.cfg)
+		schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+		schema["title"] = route.method + " " + route.path
+		schema["description"] = fmt.Sprintf("Drafted from %d requests, review before use.", route.requests)
+		out = append(out, learnedSchema{
+			Method:   route.method,
+			Path:     route.path,
+			Name:     learnedSchemaName(route.method, route.path),
+			Requests: route.requests,
+			Schema:   schema,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Path != out[j].Path {
+			return out[i].Path < out[j].Path
+		}
+		return out[i].Method < out[j].Method
+	})
+	return out
+}
+
+var nonName = regexp.MustCompile(`[^a-z0-9]+`)
+
+// learnedSchemaName names the draft of a route, e.g. post-posts-id for
+// POST /posts/{id}.
+func learnedSchemaName(method, path string) string {
+	return strings.Trim(nonName.ReplaceAllString(strings.ToLower(method+" "+path), "-"), "-")
+}
+
+// learnSchemas observes every request body that reaches next until the
+// learning period is over.
+func learnSchemas(l *learner, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.until.IsZero() && time.Now().After(l.until) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		l.observe(r.Method, r.URL.Path, body)
+
+		replaceBody(r, body)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handle serves the drafts learned so far.
+func (l *learner) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Learning bool            `json:"learning"`
+		Routes   []learnedSchema `json:"routes"`
+	}{l.until.IsZero() || time.Now().Before(l.until), l.drafts()}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// runInfer implements the infer command, drafting a schema per route from
+// the requests in a -record-file recording. Fields the recorder redacted
+// are drafted from their redacted values.
+func runInfer(args []string) error {
+	var cfg learnConfig
+	fs := flag.NewFlagSet("schema-validations infer", flag.ExitOnError)
+	fs.Float64Var(&cfg.RequiredThreshold, "required-threshold", 1, "fraction of the objects a property has to be present in to be drafted as required")
+	fs.IntVar(&cfg.EnumMax, "enum-max", 10, "most distinct values a string field can take to be drafted as an enum")
+	out := fs.String("out", "", "directory to write a <name>.v1.json draft per route to, instead of printing them")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: schema-validations infer [flags] <recording file>")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	l := newLearner(&cfg, time.Now())
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var entry recording
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("%s:%d: %v", fs.Arg(0), line, err)
+		}
+		l.observe(entry.Method, entry.Path, entry.Body)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	drafts := l.drafts()
+	if *out == "" {
+		b, err := json.MarshalIndent(drafts, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return err
+	}
+	for _, d := range drafts {
+		b, err := json.MarshalIndent(d.Schema, "", "  ")
+		if err != nil {
+			return err
+		}
+		file := filepath.Join(*out, d.Name+".v1.json")
+		if err := ioutil.WriteFile(file, append(b, '\n'), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("%s %s: %d requests, wrote %s\n", d.Method, d.Path, d.Requests, file)
+	}
+	return nil
+}
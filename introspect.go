@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type introspectionConfig struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+	CacheTTL     time.Duration
+}
+
+type introspection struct {
+	claims  jwt.MapClaims
+	active  bool
+	expires time.Time
+}
+
+// introspector checks opaque bearer tokens against an RFC 7662 introspection
+// endpoint. Results, including inactive ones, are cached for the configured
+// TTL, but never past the token's own expiry.
+type introspector struct {
+	cfg    *introspectionConfig
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]*introspection
+	swept time.Time
+}
+
+func newIntrospector(cfg *introspectionConfig) *introspector {
+	return &introspector{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		cache:  make(map[[sha256.Size]byte]*introspection),
+	}
+}
+
+func (i *introspector) introspect(ctx context.Context, token string) (*introspection, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	i.mu.Lock()
+	i.sweep(now)
+	cached, ok := i.cache[key]
+	i.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached, nil
+	}
+
+	result, err := i.fetch(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	result.expires = now.Add(i.cfg.CacheTTL)
+	if exp, err := result.claims.GetExpirationTime(); err == nil && exp != nil && exp.Before(result.expires) {
+		result.expires = exp.Time
+	}
+
+	i.mu.Lock()
+	i.cache[key] = result
+	i.mu.Unlock()
+
+	return result, nil
+}
+
+func (i *introspector) fetch(ctx context.Context, token string) (*introspection, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.cfg.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.cfg.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(i.cfg.ClientID), url.QueryEscape(i.cfg.ClientSecret))
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token introspection failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token introspection failed: %s", resp.Status)
+	}
+
+	claims := jwt.MapClaims{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("failed to decode introspection response: %v", err)
+	}
+
+	active, _ := claims["active"].(bool)
+	return &introspection{claims: claims, active: active}, nil
+}
+
+func (i *introspector) sweep(now time.Time) {
+	if now.Sub(i.swept) < time.Minute {
+		return
+	}
+	i.swept = now
+
+	for key, cached := range i.cache {
+		if !now.Before(cached.expires) {
+			delete(i.cache, key)
+		}
+	}
+}
+
+func introspectToken(i *introspector, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			writeUnauthorized(w, "a bearer token is required")
+			return
+		}
+
+		result, err := i.introspect(r.Context(), token)
+		if err != nil {
+			writeErrors(w, http.StatusBadGateway, codeUpstreamError, err.Error())
+			return
+		}
+		if !result.active {
+			writeUnauthorized(w, "bearer token is not active")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsKey{}, result.claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
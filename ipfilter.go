@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type ipFilterConfig struct {
+	Allow string
+	Deny  string
+}
+
+func (c *ipFilterConfig) enabled() bool {
+	return c.Allow != "" || c.Deny != ""
+}
+
+// ipFilter lets requests through by the address of the client sending them,
+// as resolveClient found it. A client in a denied network is refused; with
+// allowed networks, so is one outside all of them.
+type ipFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func newIPFilter(cfg *ipFilterConfig) (*ipFilter, error) {
+	f := &ipFilter{}
+	var err error
+	if f.allow, err = parsePrefixes(cfg.Allow); err != nil {
+		return nil, fmt.Errorf("invalid -allow-cidrs: %v", err)
+	}
+	if f.deny, err = parsePrefixes(cfg.Deny); err != nil {
+		return nil, fmt.Errorf("invalid -deny-cidrs: %v", err)
+	}
+	return f, nil
+}
+
+// parsePrefixes parses a comma separated list of CIDRs, or single addresses.
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *ipFilter) allowed(addr netip.Addr) bool {
+	if containsAddr(f.deny, addr) {
+		return false
+	}
+	return len(f.allow) == 0 || containsAddr(f.allow, addr)
+}
+
+// filterClients refuses requests from clients the filter doesn't allow with
+// a 403, before anything of the request is read.
+func filterClients(f *ipFilter, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := clientAddr(r)
+		if !ok || !f.allowed(addr) {
+			msg := "the client address is not allowed"
+			if ok {
+				msg = fmt.Sprintf("client address %s is not allowed", addr)
+			}
+			writeErrors(w, http.StatusForbidden, codeForbidden, msg)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// jsonLimits bounds the shape of a request body. It is checked with a cheap
+// token scan before the body is handed to the schema validator, so deeply
+// nested or enormous documents are rejected without being fully decoded.
+// A zero value disables the corresponding limit.
+type jsonLimits struct {
+	MaxDepth      int
+	MaxTokens     int
+	MaxArrayLen   int
+	MaxObjectKeys int
+	// ExactFloats rejects numbers a float64 can't hold exactly.
+	ExactFloats bool
+	// DuplicateKeys rejects objects with the same key twice, which parsers
+	// disagree about: some keep the first, most the last.
+	DuplicateKeys bool
+	// Strict rejects what lenient parsers accept and strict ones don't:
+	// anything after the top-level value, and control characters other
+	// than tab, newline and carriage return escaped into strings. NaN,
+	// Infinity and unescaped control characters are never valid.
+	Strict bool
+}
+
+type jsonFrame struct {
+	object    bool
+	expectKey bool
+	n         int
+
+	// key and keys are the current key and the keys seen so far, only
+	// tracked to find duplicate keys.
+	key  string
+	keys map[string]bool
+}
+
+// isStrictControl reports whether r is a control character strict parsers
+// reject in strings even when escaped.
+func isStrictControl(r rune) bool {
+	return (r < 0x20 && r != '\t' && r != '\n' && r != '\r') || r == 0x7f
+}
+
+// framesPointer is the JSON pointer of the container at the top of stack.
+func framesPointer(stack []*jsonFrame) string {
+	var b strings.Builder
+	for _, f := range stack[:len(stack)-1] {
+		b.WriteByte('/')
+		if f.object {
+			b.WriteString(schemavalidation.EscapePointer(f.key))
+		} else {
+			b.WriteString(strconv.Itoa(f.n - 1))
+		}
+	}
+	return b.String()
+}
+
+func (l *jsonLimits) check(body []byte) error {
+	if l == nil {
+		return nil
+	}
+	return l.scan(bytes.NewReader(body), nil)
+}
+
+// bodyReadError is a failure to read the body rather than a problem with the
+// JSON in it.
+type bodyReadError struct {
+	err error
+}
+
+func (e *bodyReadError) Error() string { return e.err.Error() }
+func (e *bodyReadError) Unwrap() error { return e.err }
+
+// codedError is a problem with a request body whose entry in the error body
+// has a code of its own, see codeOf.
+type codedError struct {
+	code string
+	msg  string
+}
+
+func (e *codedError) Error() string { return e.msg }
+
+func codedErrorf(code, format string, args ...interface{}) error {
+	return &codedError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// codeOf returns the code err is reported with, fallback when it has
+// none of its own.
+func codeOf(err error, fallback string) string {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return fallback
+}
+
+// errReader remembers the first error other than EOF its reader returned.
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
+
+// scan checks the limits against the JSON read from r, calling visit, when
+// set, for every token with the depth of the container it is in and whether
+// it is an object key.
+func (l *jsonLimits) scan(r io.Reader, visit func(tok json.Token, depth int, key bool) error) error {
+	src := &errReader{r: r}
+	dec := json.NewDecoder(src)
+	dec.UseNumber()
+
+	var stack []*jsonFrame
+	tokens, values := 0, 0
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF && len(stack) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == io.EOF && tokens == 0 && src.err == nil {
+			return codedErrorf(codeEmptyBody, "request body is empty")
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && src.err != nil {
+			return &bodyReadError{err: src.err}
+		}
+		if err != nil {
+			return codedErrorf(codeInvalidJSON, "request body is not valid JSON: %v", err)
+		}
+
+		tokens++
+		if len(stack) == 0 {
+			values++
+			if l.Strict && values > 1 {
+				return codedErrorf(codeTrailingData, "request body has data after the top-level JSON value")
+			}
+		}
+		if s, ok := tok.(string); ok && l.Strict {
+			if i := strings.IndexFunc(s, isStrictControl); i >= 0 {
+				return codedErrorf(codeControlCharacter, "request body has the control character %U in a string", []rune(s[i:])[0])
+			}
+		}
+		if n, ok := tok.(json.Number); ok && l.ExactFloats && !float64Exact(string(n)) {
+			return codedErrorf(codeInexactNumber, "request body has the number %s, which can't be represented exactly as a 64-bit float", n)
+		}
+		if l.MaxTokens > 0 && tokens > l.MaxTokens {
+			return codedErrorf(codeJSONLimit, "request body exceeds %d JSON tokens", l.MaxTokens)
+		}
+
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			stack = stack[:len(stack)-1]
+			if visit != nil {
+				if err := visit(tok, len(stack), false); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		key := false
+		if len(stack) > 0 {
+			f := stack[len(stack)-1]
+			key = f.object && f.expectKey
+			if err := l.count(f); err != nil {
+				return err
+			}
+			if key && l.DuplicateKeys {
+				name := tok.(string)
+				if f.keys[name] {
+					at := framesPointer(stack)
+					if at == "" {
+						at = "(root)"
+					}
+					return codedErrorf(codeDuplicateKey, "request body has the key %q more than once in the object at %s", name, at)
+				}
+				if f.keys == nil {
+					f.keys = make(map[string]bool)
+				}
+				f.keys[name] = true
+				f.key = name
+			}
+		}
+		if visit != nil {
+			if err := visit(tok, len(stack), key); err != nil {
+				return err
+			}
+		}
+
+		if d, ok := tok.(json.Delim); ok {
+			stack = append(stack, &jsonFrame{object: d == '{', expectKey: d == '{'})
+			if l.MaxDepth > 0 && len(stack) > l.MaxDepth {
+				return codedErrorf(codeJSONLimit, "request body exceeds the maximum nesting depth of %d", l.MaxDepth)
+			}
+		}
+	}
+}
+
+// count records one token inside the container f. Inside objects tokens
+// alternate between keys and values, and only keys count towards the limit.
+func (l *jsonLimits) count(f *jsonFrame) error {
+	if !f.object {
+		f.n++
+		if l.MaxArrayLen > 0 && f.n > l.MaxArrayLen {
+			return codedErrorf(codeJSONLimit, "request body contains an array longer than %d elements", l.MaxArrayLen)
+		}
+		return nil
+	}
+
+	if f.expectKey {
+		f.n++
+		if l.MaxObjectKeys > 0 && f.n > l.MaxObjectKeys {
+			return codedErrorf(codeJSONLimit, "request body contains an object with more than %d keys", l.MaxObjectKeys)
+		}
+	}
+	f.expectKey = !f.expectKey
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+const jsonPatchMediaType = "application/json-patch+json"
+
+// isPatch reports whether r is a PATCH with a body of the given media type.
+func isPatch(r *http.Request, mediaType string) bool {
+	got, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return r.Method == http.MethodPatch && got == mediaType
+}
+
+// routePatch sends PATCH requests of the given media type to patch and
+// everything else to next, since a patch isn't an instance of the resource
+// schema.
+func routePatch(mediaType string, patch, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPatch(r, mediaType) {
+			patch.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateJSONPatch checks that the body is a well formed JSON Patch whose
+// operations only touch locations the selected schema describes, that it
+// doesn't remove required properties, and that every value it adds or
+// replaces is valid against the schema at its location.
+func validateJSONPatch(limits *jsonLimits, rt *runtimeSettings, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+
+		if err := limits.check(body); err != nil {
+			writeErrors(w, http.StatusBadRequest, codeOf(err, codeInvalidJSON), err.Error())
+			return
+		}
+
+		ops, err := parseJSONPatch(body)
+		if err != nil {
+			writeErrors(w, http.StatusBadRequest, codeOf(err, codeInvalidPatch), err.Error())
+			return
+		}
+
+		schema := requestSchema(r)
+		var msgs []string
+		for i, op := range ops {
+			for _, msg := range checkPatchOperation(schema.Document, op) {
+				msgs = append(msgs, fmt.Sprintf("operation %d (%s %s): %s", i, op.Op, op.Path, msg))
+			}
+		}
+
+		if len(msgs) > 0 && rt.rejectInvalid(w, r, rt.forRequest(r), "is a patch the schema doesn't allow", errorEntries(codeSchemaViolation, msgs)) {
+			return
+		}
+
+		replaceBody(r, body)
+		next.ServeHTTP(w, r)
+	})
+}
+
+type patchOperation struct {
+	Op       string
+	Path     string
+	From     string
+	Value    interface{}
+	HasValue bool
+}
+
+func parseJSONPatch(body []byte) ([]patchOperation, error) {
+	doc, err := decodeJSON(body)
+	if err != nil {
+		return nil, fmt.Errorf("request body is not valid JSON: %v", err)
+	}
+
+	list, ok := doc.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("a JSON Patch must be an array of operations")
+	}
+
+	ops := make([]patchOperation, 0, len(list))
+	for i, e := range list {
+		m, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("operation %d: must be an object", i)
+		}
+
+		var op patchOperation
+		op.Op, _ = m["op"].(string)
+		op.Path, ok = m["path"].(string)
+		if !ok {
+			return nil, fmt.Errorf("operation %d: path must be a string", i)
+		}
+		if _, err := schemavalidation.ParsePointer(op.Path); err != nil {
+			return nil, fmt.Errorf("operation %d: %v", i, err)
+		}
+		op.Value, op.HasValue = m["value"]
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if !op.HasValue {
+				return nil, fmt.Errorf("operation %d: %s requires a value", i, op.Op)
+			}
+		case "move", "copy":
+			if op.From, ok = m["from"].(string); !ok {
+				return nil, fmt.Errorf("operation %d: %s requires from", i, op.Op)
+			}
+			if _, err := schemavalidation.ParsePointer(op.From); err != nil {
+				return nil, fmt.Errorf("operation %d: %v", i, err)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("operation %d: unknown op %q", i, op.Op)
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+func checkPatchOperation(root interface{}, op patchOperation) []string {
+	var msgs []string
+
+	target, ok := schemavalidation.LocateInSchema(root, op.Path)
+	if !ok {
+		return []string{fmt.Sprintf("%s is not described by the schema", op.Path)}
+	}
+
+	switch op.Op {
+	case "remove":
+		if target.Required {
+			msgs = append(msgs, fmt.Sprintf("cannot remove required property %s", op.Path))
+		}
+
+	case "add", "replace":
+		for _, s := range target.Schemas {
+			msgs = append(msgs, validateSubschema(root, s, op.Value)...)
+		}
+
+	case "move", "copy":
+		from, ok := schemavalidation.LocateInSchema(root, op.From)
+		if !ok {
+			msgs = append(msgs, fmt.Sprintf("%s is not described by the schema", op.From))
+		} else if op.Op == "move" && from.Required {
+			msgs = append(msgs, fmt.Sprintf("cannot move required property %s", op.From))
+		}
+	}
+
+	return msgs
+}
+
+// validateSubschema validates value against a schema nested in root. The
+// root's definitions are carried along so local $refs still resolve.
+func validateSubschema(root, schema, value interface{}) []string {
+	result, _, err := schemavalidation.SubschemaResult(root, schema, value)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var msgs []string
+	for _, e := range schemavalidation.OrderErrors(result.Errors()) {
+		if e.Field() == displayField("") {
+			msgs = append(msgs, e.Description())
+			continue
+		}
+		msgs = append(msgs, e.Field()+": "+e.Description())
+	}
+	return msgs
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestWriteJSONStringMatchesMarshal(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"plain text",
+		`quotes " and \ backslashes`,
+		"\x00\x01\x08\x0c\x1f\x7f",
+		"\n\r\t",
+		"<script>&amp;</script>",
+		"line and paragraph separators",
+		"h\xe9llo",
+		"\xff\xfe",
+		"truncated \xe2\x82",
+		"\xed\xa0\x80 surrogate half",
+		"valid ü, € and 🙂",
+		"� as written",
+	} {
+		var buf bytes.Buffer
+		writeJSONString(&buf, s)
+		want, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if utf8.ValidString(s) {
+			if got := buf.String(); got != string(want) {
+				t.Errorf("writeJSONString(%q) = %s, json.Marshal = %s", s, got, want)
+			}
+			continue
+		}
+
+		// How invalid UTF-8 is written depends on the encoding/json
+		// implementation, but both mean the same string.
+		var got, wantString string
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("writeJSONString(%q) = %s, not JSON: %v", s, buf.Bytes(), err)
+		}
+		json.Unmarshal(want, &wantString)
+		if got != wantString {
+			t.Errorf("writeJSONString(%q) decodes to %q, json.Marshal to %q", s, got, wantString)
+		}
+	}
+}
+
+func BenchmarkWriteErrors(b *testing.B) {
+	msgs := make([]string, 1000)
+	for i := range msgs {
+		msgs[i] = fmt.Sprintf(`items.%d.title: String length must be less than or equal to 100 "quoted"`, i)
+	}
+
+	rec := httptest.NewRecorder()
+	b.ReportAllocs()
+	for b.Loop() {
+		rec.Body.Reset()
+		writeErrors(rec, http.StatusBadRequest, codeSchemaViolation, msgs...)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type jwtConfig struct {
+	JWKSURL  string
+	Issuer   string
+	Audience string
+	// AllowNoExpiry accepts tokens without an exp claim, which never expire.
+	AllowNoExpiry bool
+}
+
+type claimsKey struct{}
+
+// requestClaims returns the claims of the verified bearer token on r, or nil
+// when JWT verification is disabled.
+func requestClaims(r *http.Request) jwt.MapClaims {
+	claims, _ := r.Context().Value(claimsKey{}).(jwt.MapClaims)
+	return claims
+}
+
+type jwtVerifier struct {
+	parser *jwt.Parser
+	keys   *jwks
+}
+
+func newJWTVerifier(cfg *jwtConfig) *jwtVerifier {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	if !cfg.AllowNoExpiry {
+		opts = append(opts, jwt.WithExpirationRequired())
+	}
+
+	return &jwtVerifier{
+		parser: jwt.NewParser(opts...),
+		keys:   &jwks{url: cfg.JWKSURL, client: &http.Client{Timeout: 10 * time.Second}},
+	}
+}
+
+func (v *jwtVerifier) verify(token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.keys.keyFunc); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func verifyJWT(v *jwtVerifier, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			writeUnauthorized(w, "a bearer token is required")
+			return
+		}
+
+		claims, err := v.verify(token)
+		if err != nil {
+			writeUnauthorized(w, fmt.Sprintf("invalid bearer token: %v", err))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsKey{}, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+
+	token := strings.TrimSpace(auth[7:])
+	return token, token != ""
+}
+
+func writeUnauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeErrors(w, http.StatusUnauthorized, codeUnauthorized, msg)
+}
+
+// jwksRefetchInterval bounds how often an unknown key id triggers a refetch,
+// so tokens with made up key ids can't be used to hammer the JWKS endpoint.
+const jwksRefetchInterval = time.Minute
+
+// jwks caches the public keys published at a JWKS URL. Keys are fetched
+// lazily and refetched when a token names a key id that isn't cached yet,
+// which is how issuers roll keys. The fetch happens outside the lock, so
+// tokens signed with known keys are verified while it is in progress; those
+// waiting for the new keys wait for it instead of fetching again.
+type jwks struct {
+	url    string
+	client *http.Client
+
+	mu       sync.Mutex
+	keys     map[string]interface{}
+	fetched  time.Time
+	fetching chan struct{} // closed when the fetch in progress is done
+}
+
+func (j *jwks) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	j.mu.Lock()
+	if key, ok := j.find(kid); ok {
+		j.mu.Unlock()
+		return key, nil
+	}
+
+	done := j.fetching
+	if done == nil {
+		if time.Since(j.fetched) < jwksRefetchInterval {
+			j.mu.Unlock()
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		j.fetched = time.Now()
+		done = make(chan struct{})
+		j.fetching = done
+		j.mu.Unlock()
+
+		keys, err := j.fetch()
+
+		j.mu.Lock()
+		if err == nil {
+			j.keys = keys
+		}
+		j.fetching = nil
+		close(done)
+		j.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		j.mu.Unlock()
+		<-done
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if key, ok := j.find(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (j *jwks) find(kid string) (interface{}, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetch gets the keys published at the JWKS URL.
+func (j *jwks) fetch() (map[string]interface{}, error) {
+	resp, err := j.client.Get(j.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %v", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			// Skip key types we don't understand rather than failing the set.
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
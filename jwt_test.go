@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TestJWTExpiryRequired checks tokens without an exp claim are only
+// accepted with AllowNoExpiry.
+func TestJWTExpiryRequired(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"keys":[{"kid":"k1","kty":"OKP","crv":"Ed25519","x":%q}]}`, base64.RawURLEncoding.EncodeToString(pub))
+	}))
+	defer jwksServer.Close()
+
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+		token.Header["kid"] = "k1"
+		s, err := token.SignedString(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	expiring := sign(jwt.MapClaims{"sub": "someone", "exp": time.Now().Add(time.Hour).Unix()})
+	forever := sign(jwt.MapClaims{"sub": "someone"})
+
+	v := newJWTVerifier(&jwtConfig{JWKSURL: jwksServer.URL})
+	if _, err := v.verify(expiring); err != nil {
+		t.Errorf("token with exp: %v", err)
+	}
+	if _, err := v.verify(forever); err == nil {
+		t.Error("token without exp was accepted")
+	}
+
+	v = newJWTVerifier(&jwtConfig{JWKSURL: jwksServer.URL, AllowNoExpiry: true})
+	if _, err := v.verify(forever); err != nil {
+		t.Errorf("token without exp with AllowNoExpiry: %v", err)
+	}
+}
+
+// TestJWKSRefreshOutsideLock checks tokens signed with a known key are
+// verified while the keys are being refetched for an unknown one.
+func TestJWKSRefreshOutsideLock(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetches := 0
+	release := make(chan struct{})
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if fetches > 1 {
+			<-release
+		}
+		fmt.Fprintf(w, `{"keys":[{"kid":"k1","kty":"OKP","crv":"Ed25519","x":%q}]}`, base64.RawURLEncoding.EncodeToString(pub))
+	}))
+	defer jwksServer.Close()
+	defer close(release)
+
+	sign := func(kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()})
+		token.Header["kid"] = kid
+		s, err := token.SignedString(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	v := newJWTVerifier(&jwtConfig{JWKSURL: jwksServer.URL})
+	if _, err := v.verify(sign("k1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// An unknown key id refetches the keys once the interval is up, and
+	// the fetch hangs until released.
+	v.keys.mu.Lock()
+	v.keys.fetched = time.Now().Add(-jwksRefetchInterval)
+	v.keys.mu.Unlock()
+	go v.verify(sign("k2"))
+	for {
+		v.keys.mu.Lock()
+		fetching := v.keys.fetching != nil
+		v.keys.mu.Unlock()
+		if fetching {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	verified := make(chan error, 1)
+	go func() {
+		_, err := v.verify(sign("k1"))
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("token with a known key during the refetch: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("token with a known key waited for the refetch")
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kubeTarget is the Kubernetes resource schemas are read from, with
+// -schema-kubernetes: either ConfigMaps, every data key named
+// <name>.<version>.json being a schema, or ValidationSchema objects of the
+// schema-validations.io/v1 custom resource:
+//
+//	apiVersion: schema-validations.io/v1
+//	kind: ValidationSchema
+//	metadata:
+//	  name: order-v2
+//	  labels:
+//	    schema-validations.io/schema: "true"
+//	spec:
+//	  name: order
+//	  version: v2
+//	  schema: {"type": "object", ...}
+//
+// Only objects matching the label selector are read, from one namespace.
+// Objects labeled schema-validations.io/tenant hold that tenant's overrides,
+// as does a ValidationSchema with spec.tenant. With -schema-public-key a
+// ConfigMap holds each schema's signature in <name>.<version>.json.sig;
+// ValidationSchemas can't be signed, the API server rewriting their schema. Like the etcd and Consul
+// sources, the list is read again whenever a watch reports a change, the
+// list's resourceVersion being the revision reported.
+type kubeTarget struct {
+	resource  string
+	namespace string
+	selector  string
+}
+
+const (
+	kubeTokenFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	kubeCAFile        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	kubeNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	kubeTenantLabel   = "schema-validations.io/tenant"
+)
+
+type kubeConfig struct {
+	Resource  string
+	Namespace string
+	Selector  string
+	API       string
+}
+
+// newKubeSource reads the schemas cfg points at, talking to cfg.API or,
+// without it, to the cluster's API server with the pod's service account.
+func newKubeSource(cfg *kubeConfig) (*kvSource, error) {
+	if cfg.Resource != "configmaps" && cfg.Resource != "validationschemas" {
+		return nil, fmt.Errorf("invalid -schema-kubernetes %q, expected configmaps or validationschemas", cfg.Resource)
+	}
+
+	k := &kvSource{kind: "kubernetes", base: cfg.API, client: &http.Client{}}
+	k.kube = &kubeTarget{resource: cfg.Resource, namespace: cfg.Namespace, selector: cfg.Selector}
+
+	if k.base == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" {
+			return nil, errors.New("not running in a Kubernetes cluster, set -kubernetes-api")
+		}
+		k.base = "https://" + net.JoinHostPort(host, port)
+
+		ca, err := os.ReadFile(kubeCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		k.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+	if k.kube.namespace == "" {
+		ns, err := os.ReadFile(kubeNamespaceFile)
+		if err != nil {
+			return nil, errors.New("no namespace to read schemas from, set -kubernetes-namespace")
+		}
+		k.kube.namespace = strings.TrimSpace(string(ns))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	snap, err := k.readKube(ctx)
+	if err != nil {
+		return nil, err
+	}
+	k.current.Store(snap)
+	return k, nil
+}
+
+func (t *kubeTarget) path() string {
+	if t.resource == "configmaps" {
+		return "/api/v1/namespaces/" + url.PathEscape(t.namespace) + "/configmaps"
+	}
+	return "/apis/schema-validations.io/v1/namespaces/" + url.PathEscape(t.namespace) + "/validationschemas"
+}
+
+func (k *kvSource) kubeRequest(ctx context.Context, q url.Values) (*http.Response, error) {
+	if k.kube.selector != "" {
+		q.Set("labelSelector", k.kube.selector)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.base+k.kube.path()+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// Projected tokens are rotated, so the file is read every time.
+	if token, err := os.ReadFile(kubeTokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	return k.do(req)
+}
+
+type kubeObject struct {
+	Metadata struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+	Spec struct {
+		Name    string          `json:"name"`
+		Version string          `json:"version"`
+		Tenant  string          `json:"tenant"`
+		Schema  json.RawMessage `json:"schema"`
+	} `json:"spec"`
+}
+
+// documents returns the schemas o holds.
+func (t *kubeTarget) documents(o kubeObject) []schemaDocument {
+	tenant := o.Metadata.Labels[kubeTenantLabel]
+	if t.resource == "validationschemas" {
+		if o.Spec.Name == "" || len(o.Spec.Schema) == 0 {
+			return nil
+		}
+		version := o.Spec.Version
+		if version == "" {
+			version = "v1"
+		}
+		if o.Spec.Tenant != "" {
+			tenant = o.Spec.Tenant
+		}
+		return []schemaDocument{{Tenant: tenant, Name: o.Spec.Name, Version: version, Data: o.Spec.Schema}}
+	}
+
+	var docs []schemaDocument
+	for key, value := range o.Data {
+		if strings.HasSuffix(key, ".json") {
+			name, version := parseSchemaFilename(key)
+			var sig []byte
+			if s, ok := o.Data[key+".sig"]; ok {
+				sig = []byte(s)
+			}
+			docs = append(docs, schemaDocument{Tenant: tenant, Name: name, Version: version, Data: []byte(value), Signature: sig})
+		}
+	}
+	return docs
+}
+
+func (k *kvSource) readKube(ctx context.Context) (*kvSnapshot, error) {
+	resp, err := k.kubeRequest(ctx, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []kubeObject `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	// Resource versions are opaque, but in practice etcd revisions.
+	rv, _ := strconv.ParseInt(list.Metadata.ResourceVersion, 10, 64)
+	snap := &kvSnapshot{revision: rv}
+	for _, o := range list.Items {
+		snap.docs = append(snap.docs, k.kube.documents(o)...)
+	}
+	sortDocuments(snap.docs)
+	return snap, nil
+}
+
+// watchKube watches the objects from the current resource version until
+// the API server ends the watch, which it does every few minutes.
+func (k *kvSource) watchKube(ctx context.Context, changed func()) error {
+	resp, err := k.kubeRequest(ctx, url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {strconv.FormatInt(k.current.Load().revision, 10)},
+		"allowWatchBookmarks": {"true"},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type   string `json:"type"`
+			Object struct {
+				Message string `json:"message"`
+			} `json:"object"`
+		}
+		err := dec.Decode(&event)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch event.Type {
+		case "BOOKMARK":
+			continue
+		case "ERROR":
+			// Usually 410 Gone, the resource version being too old.
+			return fmt.Errorf("kubernetes watch: %s", event.Object.Message)
+		}
+		snap, err := k.readKube(ctx)
+		if err != nil {
+			return err
+		}
+		k.update(snap, changed)
+	}
+}
+
+// validationSchemaCRD defines the ValidationSchema resource. The schema is
+// kept as is, whatever its content.
+const validationSchemaCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: validationschemas.schema-validations.io
+spec:
+  group: schema-validations.io
+  scope: Namespaced
+  names:
+    kind: ValidationSchema
+    plural: validationschemas
+    singular: validationschema
+  versions:
+    - name: v1
+      served: true
+      storage: true
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              required: [name, schema]
+              properties:
+                name:
+                  type: string
+                version:
+                  type: string
+                  pattern: "^v[0-9]+$"
+                tenant:
+                  type: string
+                schema:
+                  type: object
+                  x-kubernetes-preserve-unknown-fields: true
+`
+
+func runKubeCRD(args []string) error {
+	fs := flag.NewFlagSet("schema-validations kubernetes crd", flag.ExitOnError)
+	fs.Parse(args)
+	fmt.Print(validationSchemaCRD)
+	return nil
+}
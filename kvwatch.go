@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// kvSource loads schemas from the keys under a prefix of etcd or Consul,
+// <prefix>/<name>.<version>.json with tenants' overrides under
+// <prefix>/<tenant>/, and watches the prefix so every instance pointed at it
+// reloads within moments of a change. Both are spoken to over their HTTP
+// APIs: etcd's v3 JSON gateway and Consul's blocking KV queries. The
+// revision the schemas were read at, etcd's store revision or Consul's
+// index, is reported by /admin/version. Kubernetes objects are read the
+// same way, see kubeTarget.
+type kvSource struct {
+	kind   string
+	base   string
+	prefix string
+	token  string
+	kube   *kubeTarget
+	client *http.Client
+
+	current atomic.Pointer[kvSnapshot]
+}
+
+type kvSnapshot struct {
+	docs     []schemaDocument
+	revision int64
+}
+
+// newKVSource parses a -schema-kv URL, etcd://host:port/prefix or
+// consul://host:port/prefix, with +https after the scheme for TLS, and
+// reads the schemas under the prefix.
+func newKVSource(raw string) (*kvSource, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	kind, scheme, _ := strings.Cut(u.Scheme, "+")
+	if scheme == "" {
+		scheme = "http"
+	}
+	if (kind != "etcd" && kind != "consul") || (scheme != "http" && scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("expected etcd://host:port/prefix or consul://host:port/prefix, got %q", raw)
+	}
+
+	k := &kvSource{
+		kind:   kind,
+		base:   scheme + "://" + u.Host,
+		prefix: strings.TrimPrefix(u.Path, "/"),
+		client: &http.Client{},
+	}
+	if kind == "consul" {
+		k.token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	snap, err := k.read(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	k.current.Store(snap)
+	return k, nil
+}
+
+func (k *kvSource) snapshot() *kvSnapshot {
+	if k == nil {
+		return nil
+	}
+	return k.current.Load()
+}
+
+// watch calls changed with every new snapshot of the prefix, until ctx is
+// done. Failures are retried after a pause.
+func (k *kvSource) watch(ctx context.Context, changed func()) {
+	for ctx.Err() == nil {
+		var err error
+		switch k.kind {
+		case "consul":
+			err = k.watchConsul(ctx, changed)
+		case "kubernetes":
+			err = k.watchKube(ctx, changed)
+		default:
+			err = k.watchEtcd(ctx, changed)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("failed to watch %s for schema changes, retrying: %v", k.kind, err)
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+				return
+			}
+			// Changes may have been missed, or compacted away, meanwhile.
+			if snap, err := k.read(ctx, 0); err == nil {
+				k.update(snap, changed)
+			}
+		}
+	}
+}
+
+// update stores snap and calls changed when it is newer than the current
+// snapshot.
+func (k *kvSource) update(snap *kvSnapshot, changed func()) {
+	if snap.revision == k.current.Load().revision {
+		return
+	}
+	k.current.Store(snap)
+	changed()
+}
+
+func (k *kvSource) read(ctx context.Context, index int64) (*kvSnapshot, error) {
+	switch k.kind {
+	case "consul":
+		return k.readConsul(ctx, index)
+	case "kubernetes":
+		return k.readKube(ctx)
+	}
+	return k.readEtcd(ctx)
+}
+
+// documents turns keys and their values into schema documents, skipping
+// keys that aren't JSON files, each with its signature from the key named
+// after it with .sig appended.
+func (k *kvSource) documents(kvs map[string][]byte) []schemaDocument {
+	var docs []schemaDocument
+	for key, value := range kvs {
+		if doc, ok := k.document(key, value); ok {
+			doc.Signature = kvs[key+".sig"]
+			docs = append(docs, doc)
+		}
+	}
+	sortDocuments(docs)
+	return docs
+}
+
+func (k *kvSource) document(key string, value []byte) (schemaDocument, bool) {
+	rel := strings.TrimPrefix(strings.TrimPrefix(key, k.prefix), "/")
+	if !strings.HasSuffix(rel, ".json") {
+		return schemaDocument{}, false
+	}
+	tenant, file := path.Split(rel)
+	if strings.Contains(strings.TrimSuffix(tenant, "/"), "/") {
+		return schemaDocument{}, false
+	}
+	name, version := parseSchemaFilename(file)
+	return schemaDocument{Tenant: strings.TrimSuffix(tenant, "/"), Name: name, Version: version, Data: value}, true
+}
+
+func (k *kvSource) do(req *http.Request) (*http.Response, error) {
+	if k.token != "" {
+		req.Header.Set("X-Consul-Token", k.token)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 && !(k.kind == "consul" && resp.StatusCode == http.StatusNotFound) {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, bytes.TrimSpace(b))
+	}
+	return resp, nil
+}
+
+// readConsul lists the prefix, blocking until the index moves past index
+// when it is non-zero.
+func (k *kvSource) readConsul(ctx context.Context, index int64) (*kvSnapshot, error) {
+	q := url.Values{"recurse": {"true"}}
+	if index > 0 {
+		q.Set("index", strconv.FormatInt(index, 10))
+		q.Set("wait", "5m")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.base+"/v1/kv/"+k.prefix+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	snap := &kvSnapshot{}
+	if snap.revision, err = strconv.ParseInt(resp.Header.Get("X-Consul-Index"), 10, 64); err != nil {
+		return nil, fmt.Errorf("consul returned no X-Consul-Index")
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return snap, nil
+	}
+
+	var pairs []struct {
+		Key   string
+		Value []byte
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+		return nil, err
+	}
+	kvs := make(map[string][]byte, len(pairs))
+	for _, p := range pairs {
+		kvs[p.Key] = p.Value
+	}
+	snap.docs = k.documents(kvs)
+	return snap, nil
+}
+
+func (k *kvSource) watchConsul(ctx context.Context, changed func()) error {
+	for ctx.Err() == nil {
+		snap, err := k.readConsul(ctx, k.current.Load().revision)
+		if err != nil {
+			return err
+		}
+		k.update(snap, changed)
+	}
+	return nil
+}
+
+func sortDocuments(docs []schemaDocument) {
+	sort.Slice(docs, func(i, j int) bool {
+		a, b := docs[i], docs[j]
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+}
+
+// etcdRange is the key range of every key starting with prefix.
+func etcdRange(prefix string) map[string]string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			end = end[:i+1]
+			break
+		}
+	}
+	if prefix == "" {
+		end = []byte{0}
+	}
+	return map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(end),
+	}
+}
+
+func (k *kvSource) post(ctx context.Context, endpoint string, body interface{}) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.base+endpoint, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return k.do(req)
+}
+
+func (k *kvSource) readEtcd(ctx context.Context) (*kvSnapshot, error) {
+	resp, err := k.post(ctx, "/v3/kv/range", etcdRange(k.prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// etcd's gateway encodes 64-bit integers as strings and bytes as base64.
+	var out struct {
+		Header struct {
+			Revision int64 `json:"revision,string"`
+		} `json:"header"`
+		Kvs []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+
+	kvs := make(map[string][]byte, len(out.Kvs))
+	for _, kv := range out.Kvs {
+		kvs[string(kv.Key)] = kv.Value
+	}
+	return &kvSnapshot{docs: k.documents(kvs), revision: out.Header.Revision}, nil
+}
+
+// watchEtcd streams the prefix's events from after the current revision,
+// reading the whole prefix again after each batch so every reload sees a
+// consistent revision.
+func (k *kvSource) watchEtcd(ctx context.Context, changed func()) error {
+	create := etcdRange(k.prefix)
+	watch := map[string]interface{}{"create_request": map[string]interface{}{
+		"key":            create["key"],
+		"range_end":      create["range_end"],
+		"start_revision": strconv.FormatInt(k.current.Load().revision+1, 10),
+	}}
+	resp, err := k.post(ctx, "/v3/watch", watch)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Events []json.RawMessage `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("etcd watch: %s", msg.Error.Message)
+		}
+		if len(msg.Result.Events) == 0 {
+			continue
+		}
+		snap, err := k.readEtcd(ctx)
+		if err != nil {
+			return err
+		}
+		k.update(snap, changed)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the validation
+// latency histogram buckets.
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// maxLatencyKeys bounds the schema and route pairs latencies are kept for;
+// the rest are counted together under "other".
+const maxLatencyKeys = 1000
+
+// validationLatency is published as validation_duration_seconds: for each
+// schema version and route a histogram of how long validating bodies took,
+// with cumulative bucket counts as Prometheus has them. Every bucket is a
+// counter of validations at or under its bound, so the share of good
+// validations over any window, and how fast an SLO such as "99% under 5ms"
+// burns, is the increase of the bucket over the increase of the count.
+var validationLatency = newLatencyHistograms()
+
+func init() {
+	expvar.Publish("validation_duration_seconds", expvar.Func(validationLatency.snapshot))
+}
+
+type latencyHistograms struct {
+	mu   sync.Mutex
+	keys map[string]*latencyHistogram
+}
+
+type latencyHistogram struct {
+	Schema  string           `json:"schema"`
+	Route   string           `json:"route"`
+	Buckets map[string]int64 `json:"buckets"`
+	Count   int64            `json:"count"`
+	Sum     float64          `json:"sum"`
+
+	counts []int64
+}
+
+func newLatencyHistograms() *latencyHistograms {
+	return &latencyHistograms{keys: make(map[string]*latencyHistogram)}
+}
+
+// observe records that validating a body against schema, sent to method and
+// path, took d.
+func (h *latencyHistograms) observe(schema *schemaVersion, method, path string, d time.Duration) {
+	id := schema.Name + "." + schema.Version
+	if schema.Tenant != "" {
+		id = schema.Tenant + "/" + id
+	}
+	route := method + " " + learnedPath(path)
+	key := id + " " + route
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, ok := h.keys[key]
+	if !ok {
+		if len(h.keys) >= maxLatencyKeys {
+			key, id, route = "other", "other", "other"
+			hist = h.keys[key]
+		}
+		if hist == nil {
+			hist = &latencyHistogram{Schema: id, Route: route, counts: make([]int64, len(latencyBuckets))}
+			h.keys[key] = hist
+		}
+	}
+
+	seconds := d.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.Count++
+	hist.Sum += seconds
+}
+
+func (h *latencyHistograms) snapshot() interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	out := make(map[string]latencyHistogram, len(h.keys))
+	for key, hist := range h.keys {
+		snap := *hist
+		snap.Buckets = make(map[string]int64, len(latencyBuckets)+1)
+		for i, bound := range latencyBuckets {
+			snap.Buckets[strconv.FormatFloat(bound, 'g', -1, 64)] = hist.counts[i]
+		}
+		snap.Buckets["+Inf"] = hist.Count
+		out[key] = snap
+	}
+	return out
+}
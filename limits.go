@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// bodyLimits caps how many bytes of a request body are read. Routes are
+// matched by longest path prefix and fall back to Max.
+type bodyLimits struct {
+	Max    int64
+	Routes routeLimits
+}
+
+func (l *bodyLimits) forPath(path string) int64 {
+	limit, matched := l.Max, ""
+	for prefix, n := range l.Routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(matched) {
+			limit, matched = n, prefix
+		}
+	}
+
+	return limit
+}
+
+// routeLimits is a flag.Value accepting repeated "prefix=bytes" pairs.
+type routeLimits map[string]int64
+
+func (r routeLimits) String() string {
+	var pairs []string
+	for prefix, n := range r {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", prefix, n))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (r routeLimits) Set(v string) error {
+	i := strings.LastIndex(v, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected prefix=bytes, got %q", v)
+	}
+
+	n, err := strconv.ParseInt(v[i+1:], 10, 64)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid byte limit in %q", v)
+	}
+
+	r[v[:i]] = n
+	return nil
+}
+
+func limitBody(limits *bodyLimits, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := limits.forPath(r.URL.Path)
+		if r.ContentLength > limit {
+			writeBodyTooLarge(w, limit)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, limit int64) {
+	msg := fmt.Sprintf("request body exceeds the %d byte limit", limit)
+	writeErrors(w, http.StatusRequestEntityTooLarge, codeBodyTooLarge, msg)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// runLint implements the lint command: every schema must compile, and
+// likely mistakes are reported as warnings, which fail the run with -strict.
+func runLint(args []string) error {
+	var cfg schemaConfig
+	fs := flag.NewFlagSet("schema-validations lint", flag.ExitOnError)
+	fs.StringVar(&cfg.Dir, "schema-dir", "", "directory of <name>.<version>.json schemas, the built-in post schema if empty")
+	fs.StringVar(&cfg.BaseSchema, "base-schema", "", "JSON schema of fields every request shares, added to the allOf of every schema")
+	fs.BoolVar(&cfg.Embedded, "embedded-schemas", embeddedSchemas != nil, "load the schemas bundled into the binary with -tags embedschemas")
+	strict := fs.Bool("strict", false, "fail on warnings too")
+	fs.Parse(args)
+
+	docs, err := schemaDocuments(&cfg)
+	if err != nil {
+		return err
+	}
+
+	errs, warnings := 0, 0
+	for _, doc := range docs {
+		id := doc.Name + "." + doc.Version
+		if doc.Tenant != "" {
+			id = doc.Tenant + "/" + id
+		}
+
+		compiled, err := compileSchema(doc.Data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: error: %v\n", id, err)
+			errs++
+			continue
+		}
+
+		for _, msg := range lintSchema(compiled.validation.Document()) {
+			fmt.Fprintf(os.Stderr, "%s: warning: %s\n", id, msg)
+			warnings++
+		}
+	}
+
+	// Composition, such as tenant overrides and the default schema, only
+	// fails once the schemas are loaded together.
+	if errs == 0 {
+		for _, doc := range docs {
+			if doc.Tenant == "" {
+				cfg.Default = doc.Name
+			}
+		}
+		if _, err := newSchemaRegistry(&cfg, docs, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			errs++
+		}
+	}
+
+	if errs > 0 || (*strict && warnings > 0) {
+		return errFailed
+	}
+	return nil
+}
+
+// lintSchema reports schema constructs that are valid but probably not what
+// the author meant.
+func lintSchema(root interface{}) []string {
+	var msgs []string
+
+	m, ok := root.(schemavalidation.SchemaObject)
+	if !ok {
+		return []string{"(root): schema is not an object"}
+	}
+	if _, ok := m["$schema"]; !ok {
+		msgs = append(msgs, "(root): no $schema declares which draft the schema follows")
+	}
+
+	var walk func(node interface{}, pointer string, depth int)
+	walk = func(node interface{}, pointer string, depth int) {
+		s, ok := node.(schemavalidation.SchemaObject)
+		if !ok || depth > schemavalidation.MaxSchemaDepth {
+			return
+		}
+		at := pointer
+		if at == "" {
+			at = "(root)"
+		}
+
+		if ref, ok := s["$ref"].(string); ok && strings.HasPrefix(ref, "#") {
+			if _, ok := schemavalidation.ResolveRef(root, ref); !ok {
+				msgs = append(msgs, fmt.Sprintf("%s: $ref %q does not resolve", at, ref))
+			}
+		}
+
+		props, _ := s["properties"].(schemavalidation.SchemaObject)
+		if required, ok := s["required"].([]interface{}); ok && s["additionalProperties"] == false {
+			for _, name := range required {
+				if n, ok := name.(string); ok && props[n] == nil && !patternCovers(s, n) {
+					msgs = append(msgs, fmt.Sprintf("%s: %q is required but additionalProperties is false and it isn't a property, nothing can validate", at, n))
+				}
+			}
+		}
+
+		var names []string
+		for name := range props {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if p, ok := props[name].(schemavalidation.SchemaObject); ok && !constrainsType(p) {
+				msgs = append(msgs, fmt.Sprintf("%s/properties/%s: property accepts any type", pointer, schemavalidation.EscapePointer(name)))
+			}
+			walk(props[name], pointer+"/properties/"+schemavalidation.EscapePointer(name), depth+1)
+		}
+
+		for _, keyword := range []string{"items", "additionalProperties", "not", "if", "then", "else"} {
+			walk(s[keyword], pointer+"/"+keyword, depth+1)
+		}
+		for _, keyword := range []string{"allOf", "anyOf", "oneOf", "items"} {
+			if list, ok := s[keyword].([]interface{}); ok {
+				for i, e := range list {
+					walk(e, fmt.Sprintf("%s/%s/%d", pointer, keyword, i), depth+1)
+				}
+			}
+		}
+		for _, keyword := range []string{"definitions", "$defs", "patternProperties"} {
+			defs, _ := s[keyword].(schemavalidation.SchemaObject)
+			for name, def := range defs {
+				walk(def, pointer+"/"+keyword+"/"+schemavalidation.EscapePointer(name), depth+1)
+			}
+		}
+	}
+	walk(root, "", 0)
+
+	return msgs
+}
+
+func constrainsType(s schemavalidation.SchemaObject) bool {
+	for _, keyword := range []string{"type", "$ref", "enum", "const", "allOf", "anyOf", "oneOf", "not"} {
+		if _, ok := s[keyword]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func patternCovers(s schemavalidation.SchemaObject, name string) bool {
+	patterns, _ := s["patternProperties"].(schemavalidation.SchemaObject)
+	for pattern := range patterns {
+		if re, err := regexp.Compile(pattern); err == nil && re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
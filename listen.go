@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// dataListener opens the listener for validated traffic: the socket passed in
+// by systemd if there is one, a unix socket when -unix-socket is set, and TCP
+// on -addr otherwise.
+func dataListener(cfg *config, activated map[string]net.Listener) (net.Listener, error) {
+	if ln := activated["data"]; ln != nil {
+		return ln, nil
+	}
+
+	if cfg.UnixSocket == "" {
+		return net.Listen("tcp", cfg.Addr)
+	}
+
+	mode, err := strconv.ParseUint(cfg.UnixSocketMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket mode %q: %v", cfg.UnixSocketMode, err)
+	}
+
+	// A socket left behind by an unclean exit would fail the bind.
+	if err := os.Remove(cfg.UnixSocket); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", cfg.UnixSocket)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(cfg.UnixSocket, os.FileMode(mode)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+func adminListener(cfg *config, activated map[string]net.Listener) (net.Listener, error) {
+	if ln := activated["admin"]; ln != nil {
+		return ln, nil
+	}
+
+	return net.Listen("tcp", cfg.AdminAddr)
+}
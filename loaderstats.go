@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// loaderStats records what loading schemas costs: how long reloads take,
+// and how often and how slowly schemas and their remote $refs are fetched,
+// per host. Remote references are fetched while schemas compile, so slow
+// hosts delay reloads and, on startup, readiness. The compile cache and
+// result cache hit rates are published as metrics alongside.
+type loaderStats struct {
+	mu      sync.Mutex
+	reloads reloadStats
+	hosts   map[string]*fetchStats
+}
+
+type reloadStats struct {
+	Total     int64     `json:"total"`
+	Failed    int64     `json:"failed"`
+	Compiled  int       `json:"last_compiled"`
+	Last      float64   `json:"last_duration_seconds"`
+	Max       float64   `json:"max_duration_seconds"`
+	At        time.Time `json:"last_at"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+type fetchStats struct {
+	Host     string  `json:"host"`
+	Count    int64   `json:"count"`
+	Failures int64   `json:"failures"`
+	Total    float64 `json:"total_seconds"`
+	Max      float64 `json:"max_seconds"`
+	Last     float64 `json:"last_seconds"`
+}
+
+var schemaLoader = &loaderStats{hosts: make(map[string]*fetchStats)}
+
+// reloaded records a load of the schemas that took d, compiling compiled of
+// them, or failing with err.
+func (s *loaderStats) reloaded(d time.Duration, compiled int, err error) {
+	metricSchemaReloads.Add(1)
+	metricSchemaReloadSeconds.Set(d.Seconds())
+	if err != nil {
+		metricSchemaReloadFailures.Add(1)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := &s.reloads
+	r.Total++
+	r.Last = d.Seconds()
+	if r.Last > r.Max {
+		r.Max = r.Last
+	}
+	r.At = time.Now()
+	r.LastError = ""
+	if err != nil {
+		r.Failed++
+		r.LastError = err.Error()
+		return
+	}
+	r.Compiled = compiled
+}
+
+func (s *loaderStats) fetched(host string, d time.Duration, failed bool) {
+	metricSchemaFetches.Add(host, 1)
+	metricSchemaFetchSeconds.AddFloat(host, d.Seconds())
+	if failed {
+		metricSchemaFetchFailures.Add(host, 1)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.hosts[host]
+	if !ok {
+		f = &fetchStats{Host: host}
+		s.hosts[host] = f
+	}
+	f.Count++
+	if failed {
+		f.Failures++
+	}
+	f.Last = d.Seconds()
+	f.Total += f.Last
+	if f.Last > f.Max {
+		f.Max = f.Last
+	}
+}
+
+// fetchStatsTransport times the requests made for schemas and remote $refs.
+type fetchStatsTransport struct {
+	next http.RoundTripper
+}
+
+func (t *fetchStatsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	schemaLoader.fetched(req.URL.Host, time.Since(start), err != nil || resp.StatusCode >= 400)
+	return resp, err
+}
+
+// handleLoaderStats serves GET /admin/schema-loader, the reload and fetch
+// statistics with the slowest hosts first.
+func handleLoaderStats(schemas *schemaStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		schemaLoader.mu.Lock()
+		reloads := schemaLoader.reloads
+		hosts := make([]fetchStats, 0, len(schemaLoader.hosts))
+		for _, f := range schemaLoader.hosts {
+			hosts = append(hosts, *f)
+		}
+		schemaLoader.mu.Unlock()
+
+		sort.Slice(hosts, func(i, j int) bool {
+			if hosts[i].Total != hosts[j].Total {
+				return hosts[i].Total > hosts[j].Total
+			}
+			return hosts[i].Host < hosts[j].Host
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct {
+			CompileCache compileCacheStats `json:"compile_cache"`
+			Reloads      reloadStats       `json:"reloads"`
+			Fetches      []fetchStats      `json:"fetches"`
+		}{schemas.cache.stats(), reloads, hosts}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
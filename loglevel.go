@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the level the server logs at. It can be raised or lowered at
+// runtime through /admin/loglevel, optionally going back to the configured
+// level after a while so debug logging isn't left on by accident.
+type logLevel struct {
+	level slog.LevelVar
+
+	mu     sync.Mutex
+	base   slog.Level
+	revert *time.Timer
+}
+
+// newLogLevel routes the log package, and slog, through a handler logging at
+// the given level or above. Plain log.Printf calls log at info.
+func newLogLevel(level string) (*logLevel, error) {
+	l := &logLevel{}
+	if err := l.base.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", level)
+	}
+	l.level.Set(l.base)
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: &l.level})))
+	return l, nil
+}
+
+// set changes the level. With a non-zero revertAfter the change is
+// temporary, otherwise it becomes the level later reverts go back to.
+func (l *logLevel) set(level slog.Level, revertAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.revert != nil {
+		l.revert.Stop()
+		l.revert = nil
+	}
+	l.level.Set(level)
+	if revertAfter == 0 {
+		l.base = level
+		return
+	}
+
+	base := l.base
+	l.revert = time.AfterFunc(revertAfter, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.level.Set(base)
+		l.revert = nil
+		slog.Info("log level reverted", "level", base)
+	})
+}
+
+type logLevelRequest struct {
+	Level       string `json:"level"`
+	RevertAfter string `json:"revert_after,omitempty"`
+}
+
+// handle serves GET and PUT /admin/loglevel, e.g. PUT
+// {"level":"debug","revert_after":"15m"}. Like settings, the level can only
+// be changed with -admin-keys-file.
+func (l *logLevel) handle(keys *adminKeys) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			if keys == nil {
+				writeErrors(w, http.StatusForbidden, codeForbidden, "changing the log level requires -admin-keys-file")
+				return
+			}
+			var req logLevelRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeErrors(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("invalid log level request: %v", err))
+				return
+			}
+
+			var level slog.Level
+			if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+				writeErrors(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("invalid log level %q, expected debug, info, warn or error", req.Level))
+				return
+			}
+			var revertAfter time.Duration
+			if req.RevertAfter != "" {
+				d, err := time.ParseDuration(req.RevertAfter)
+				if err != nil || d <= 0 {
+					writeErrors(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("invalid revert_after %q, expected a positive duration such as 15m", req.RevertAfter))
+					return
+				}
+				revertAfter = d
+			}
+
+			l.set(level, revertAfter)
+			slog.Warn("log level changed", "level", level, "revert_after", revertAfter, "by", adminCaller(keys, r))
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(logLevelRequest{Level: strings.ToLower(l.level.Level().String())}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
@@ -1,26 +1,150 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
 	"github.com/xeipuuv/gojsonschema"
 )
 
-type errResponse struct {
-	Errors []string `json:"errors"`
-}
+// entrypoint is the CLI, except in builds for a browser, see wasm_js.go.
+var entrypoint = runCLI
 
 func main() {
-	schema, err := loadSchema()
+	entrypoint()
+}
+
+func serve(args []string) {
+	cfg := parseConfig(args)
+
+	g, err := newGateway(cfg)
 	if err != nil {
-		panic(fmt.Sprintf("failed to load schema.json: %v", err))
+		panic(err.Error())
+	}
+
+	h := newHealth(cfg.Upstream, cfg.ReadyUpstreamTimeout)
+	data := newServer(cfg, cfg.Addr, g.handler)
+	admin := newServer(cfg, cfg.AdminAddr, newAdminMux(h, g.keys, cfg.AdminReadOnly, g.schemas, g.settings, g.logLevel, g.coverage, g.unknown, g.stats, g.learner, g.drift, g.quotas))
+
+	if cfg.H2C {
+		// HTTP/2 over TLS is negotiated by default, h2c has to be opted into.
+		var protocols http.Protocols
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		data.Protocols = &protocols
+	}
+
+	data.TLSConfig = g.tlsConfig
+	acme := g.acme
+
+	activated, err := systemdListeners()
+	if err != nil {
+		panic(fmt.Sprintf("failed to use systemd sockets: %v", err))
+	}
+
+	ln, err := dataListener(cfg, activated)
+	if err != nil {
+		panic(fmt.Sprintf("failed to listen: %v", err))
+	}
+	adminLn, err := adminListener(cfg, activated)
+	if err != nil {
+		panic(fmt.Sprintf("failed to listen: %v", err))
+	}
+
+	servers := []shutdowner{data}
+	errc := make(chan error, 4)
+
+	if cfg.HTTP3 {
+		h3 := newHTTP3Server(data)
+		servers = append(servers, h3)
+		go func() { errc <- h3.ListenAndServe() }()
 	}
 
-	handler := validate(schema, process)
-	http.ListenAndServe(":8000", handler)
+	go func() { errc <- admin.Serve(adminLn) }()
+	if acme != nil && cfg.ACMEHTTPAddr != "" {
+		challenge := newServer(cfg, cfg.ACMEHTTPAddr, acme.HTTPHandler(nil))
+		go func() { errc <- challenge.ListenAndServe() }()
+	}
+	go func() {
+		if data.TLSConfig != nil {
+			errc <- data.ServeTLS(ln, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			return
+		}
+		errc <- data.Serve(ln)
+	}()
+
+	h.setReady(true)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, append([]os.Signal{syscall.SIGTERM, os.Interrupt}, reloadSignals...)...)
+
+wait:
+	for {
+		select {
+		case err := <-errc:
+			log.Fatal(err)
+		case sig := <-sigs:
+			if sig != syscall.SIGTERM && sig != os.Interrupt {
+				compiled, err := g.schemas.reload()
+				if err != nil {
+					log.Printf("failed to reload schemas, keeping the current ones: %v", err)
+					continue
+				}
+				log.Printf("reloaded schemas, compiled %d new or changed", compiled)
+				continue
+			}
+			log.Printf("received %v, draining connections", sig)
+			break wait
+		}
+	}
+
+	h.setReady(false)
+	if err := shutdown(cfg.ShutdownTimeout, append(servers, admin)...); err != nil {
+		log.Fatalf("failed to drain connections: %v", err)
+	}
+}
+
+func newServer(cfg *config, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// shutdown stops the servers in order, waiting up to timeout in total for
+// in-flight requests to finish. The admin server goes last so readiness keeps
+// reporting false while the data listener drains.
+func shutdown(timeout time.Duration, servers ...shutdowner) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func process(w http.ResponseWriter, _ *http.Request) {
@@ -28,58 +152,145 @@ func process(w http.ResponseWriter, _ *http.Request) {
 	w.Write([]byte("valid request"))
 }
 
-func validate(schema *gojsonschema.Schema, next http.HandlerFunc) http.HandlerFunc {
+// validate checks the request body against the selected schema. The body is
+// put back afterwards so the rest of the chain, and the upstream, can read it.
+// With a cache, bodies already seen for the same schema version reuse the
+// earlier result, and with a pool the validation itself runs on the pool.
+// Validation taking longer than the validation timeout is abandoned with a
+// 503.
+// Whether invalid bodies are rejected, and with how much detail, follows the
+// runtime settings. Requests without a body aren't validated.
+func validate(limits *jsonLimits, cache *resultCache, pool *validationPool, rt *runtimeSettings, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		body, err := ioutil.ReadAll(r.Body)
-		defer r.Body.Close()
-
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		if !hasBody(r) {
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		requestJSON := gojsonschema.NewBytesLoader(body)
-		result, err := schema.Validate(requestJSON)
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
 
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		if err := limits.check(body); err != nil {
+			writeErrors(w, http.StatusBadRequest, codeOf(err, codeInvalidJSON), err.Error())
 			return
 		}
 
-		if !result.Valid() {
-			if err := writeError(result.Errors(), w); err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+		schema := requestSchema(r)
+		key := resultKey{schema: schema}
+		if cache != nil {
+			key.sum = sha256.Sum256(body)
+		}
+
+		msgs, ok := cache.get(key)
+		if !ok {
+			ctx := r.Context()
+			if timeout := rt.validationTimeout(r); timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			start := time.Now()
+			result, err := pool.validate(ctx, schema.Schema, body)
+			if err == errPoolFull {
+				writeErrors(w, http.StatusServiceUnavailable, codeOverloaded, "server is overloaded, try again later")
 				return
 			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				validationLatency.observe(schema, r.Method, r.URL.Path, time.Since(start))
+				metricValidationTimeouts.Add(1)
+				log.Printf("abandoned validating %s %s (request %s), it took too long", r.Method, r.URL.Path, requestID(r))
+				writeErrors(w, http.StatusServiceUnavailable, codeValidationTimeout, "validating the request body took too long")
+				return
+			}
+			if err != nil {
+				log.Printf("failed to validate %s %s (request %s): %v", r.Method, r.URL.Path, requestID(r), err)
+				writeErrors(w, http.StatusInternalServerError, codeInternal, "failed to validate the request body")
+				return
+			}
+
+			msgs = errorMessages(schemaErrors(schema, body, result))
+			validationLatency.observe(schema, r.Method, r.URL.Path, time.Since(start))
+			cache.put(key, msgs)
+		}
+
+		if len(msgs) > 0 && rt.rejectInvalid(w, r, rt.forRequest(r), "does not match the schema", errorEntries(codeSchemaViolation, msgs)) {
+			return
 		}
 
+		replaceBody(r, body)
 		next.ServeHTTP(w, r)
 	})
 }
 
-func writeError(errors []gojsonschema.ResultError, w http.ResponseWriter) error {
-	var r errResponse
+func errorMessages(errors []gojsonschema.ResultError) []string {
+	msgs := make([]string, 0, len(errors))
 
-	for _, e := range errors {
-		r.Errors = append(r.Errors, e.String())
-	}
-	b, err := json.Marshal(r)
-	if err != nil {
-		return err
+	for _, e := range schemavalidation.OrderErrors(errors) {
+		msgs = append(msgs, e.String())
 	}
 
-	w.Write(b)
-	w.WriteHeader(http.StatusBadRequest)
+	return msgs
+}
 
-	return nil
+// writeErrors writes an error body with an entry for each of msgs, all of
+// them with code, one of the error codes.
+func writeErrors(w http.ResponseWriter, status int, code string, msgs ...string) {
+	buf := responseBuffers.get()
+	defer responseBuffers.put(buf)
+
+	if msgs == nil {
+		buf.WriteString(`{"errors":null`)
+	} else {
+		buf.WriteString(`{"errors":[`)
+		for i, msg := range msgs {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeErrorEntry(buf, code, msg)
+		}
+		buf.WriteByte(']')
+	}
+	closeErrorBody(buf, w)
+
+	writeErrorBody(w, status, buf)
 }
 
-func loadSchema() (*gojsonschema.Schema, error) {
-	loader := gojsonschema.NewStringLoader(schemaJSON)
-	schema, err := gojsonschema.NewSchema(loader)
-	if err != nil {
-		return nil, err
+// writeErrorEntries writes an error body with entries, whose codes may
+// differ.
+func writeErrorEntries(w http.ResponseWriter, status int, entries []errorEntry) {
+	buf := responseBuffers.get()
+	defer responseBuffers.put(buf)
+
+	if entries == nil {
+		buf.WriteString(`{"errors":null`)
+	} else {
+		buf.WriteString(`{"errors":[`)
+		for i, e := range entries {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeErrorEntry(buf, e.Code, e.Message)
+		}
+		buf.WriteByte(']')
 	}
+	closeErrorBody(buf, w)
+
+	writeErrorBody(w, status, buf)
+}
+
+func writeErrorEntry(buf *bytes.Buffer, code, msg string) {
+	buf.WriteString(`{"code":`)
+	writeJSONString(buf, code)
+	buf.WriteString(`,"message":`)
+	writeJSONString(buf, msg)
+	buf.WriteByte('}')
+}
 
-	return schema, nil
+func writeErrorBody(w http.ResponseWriter, status int, buf *bytes.Buffer) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
 }
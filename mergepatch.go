@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+const mergePatchMediaType = "application/merge-patch+json"
+
+type mergePatchConfig struct {
+	ResourceURL    string
+	ResourceHeader string
+	Timeout        time.Duration
+}
+
+func (c *mergePatchConfig) enabled() bool {
+	return c.ResourceURL != "" || c.ResourceHeader != ""
+}
+
+// mergePatcher finds the current state of the resource a merge patch applies
+// to, either from a header on the request or by fetching it from the
+// resource URL.
+type mergePatcher struct {
+	cfg    *mergePatchConfig
+	client *http.Client
+}
+
+func newMergePatcher(cfg *mergePatchConfig) *mergePatcher {
+	return &mergePatcher{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+// current returns the resource r patches, or the status to fail it with.
+func (p *mergePatcher) current(r *http.Request) (interface{}, int, error) {
+	if p.cfg.ResourceHeader != "" {
+		if encoded := r.Header.Get(p.cfg.ResourceHeader); encoded != "" {
+			b, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, http.StatusBadRequest, fmt.Errorf("%s is not valid base64: %v", p.cfg.ResourceHeader, err)
+			}
+			v, err := decodeJSON(b)
+			if err != nil {
+				return nil, http.StatusBadRequest, fmt.Errorf("%s is not valid JSON: %v", p.cfg.ResourceHeader, err)
+			}
+			return v, 0, nil
+		}
+	}
+
+	if p.cfg.ResourceURL == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("missing %s header", p.cfg.ResourceHeader)
+	}
+
+	return p.fetch(r.Context(), r)
+}
+
+func (p *mergePatcher) fetch(ctx context.Context, r *http.Request) (interface{}, int, error) {
+	u := strings.TrimRight(p.cfg.ResourceURL, "/") + r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		u += "?" + r.URL.RawQuery
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, http.StatusNotFound, fmt.Errorf("resource not found")
+	case resp.StatusCode != http.StatusOK:
+		return nil, http.StatusBadGateway, fmt.Errorf("resource fetch returned %s", resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+
+	v, err := decodeJSON(b)
+	if err != nil {
+		return nil, http.StatusBadGateway, fmt.Errorf("resource is not valid JSON: %v", err)
+	}
+	return v, 0, nil
+}
+
+// validateMergePatch applies an RFC 7396 merge patch to the current resource
+// and validates the merged result against the selected schema. The patch
+// itself is what gets forwarded.
+func validateMergePatch(p *mergePatcher, limits *jsonLimits, rt *runtimeSettings, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+
+		if err := limits.check(body); err != nil {
+			writeErrors(w, http.StatusBadRequest, codeOf(err, codeInvalidJSON), err.Error())
+			return
+		}
+
+		patch, err := decodeJSON(body)
+		if err != nil {
+			writeErrors(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("request body is not valid JSON: %v", err))
+			return
+		}
+
+		current, status, err := p.current(r)
+		if err != nil {
+			writeErrors(w, status, codeUpstreamError, fmt.Sprintf("failed to get current resource: %v", err))
+			return
+		}
+
+		schema := requestSchema(r)
+		merged, err := encodeJSON(mergePatch(current, patch))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		result, err := schema.Schema.Validate(gojsonschema.NewBytesLoader(merged))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if !result.Valid() {
+			msgs := errorMessages(schemaErrors(schema, merged, result))
+			if rt.rejectInvalid(w, r, rt.forRequest(r), "would leave the resource not matching the schema", errorEntries(codeSchemaViolation, msgs)) {
+				return
+			}
+		}
+
+		replaceBody(r, body)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// mergePatch applies patch to target as described by RFC 7396. target is
+// modified in place where possible.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+
+	return t
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"expvar"
+)
+
+// Metrics are published through expvar and served by the admin listener on
+// /debug/vars.
+var (
+	metricInFlight   = expvar.NewInt("concurrency_in_flight")
+	metricQueueDepth = expvar.NewInt("concurrency_queue_depth")
+	metricShed       = expvar.NewInt("concurrency_shed_total")
+
+	metricResultCacheHits    = expvar.NewInt("result_cache_hits_total")
+	metricResultCacheMisses  = expvar.NewInt("result_cache_misses_total")
+	metricResultCacheEntries = expvar.NewInt("result_cache_entries")
+
+	metricIdempotentReplays   = expvar.NewInt("idempotent_replays_total")
+	metricIdempotentEvictions = expvar.NewInt("idempotent_evictions_total")
+
+	metricCompileCacheHits     = expvar.NewInt("schema_compile_cache_hits_total")
+	metricCompileCacheMisses   = expvar.NewInt("schema_compile_cache_misses_total")
+	metricSchemaReloads        = expvar.NewInt("schema_reloads_total")
+	metricSchemaReloadFailures = expvar.NewInt("schema_reload_failures_total")
+	metricSchemaReloadSeconds  = expvar.NewFloat("schema_reload_last_seconds")
+	metricSchemaFetches        = expvar.NewMap("schema_fetches_total")
+	metricSchemaFetchFailures  = expvar.NewMap("schema_fetch_failures_total")
+	metricSchemaFetchSeconds   = expvar.NewMap("schema_fetch_seconds_total")
+
+	metricReported   = expvar.NewInt("validation_not_enforced_total")
+	metricFlagErrors = expvar.NewInt("feature_flag_errors_total")
+
+	metricPoolQueueDepth       = expvar.NewInt("validation_pool_queue_depth")
+	metricPoolRejected         = expvar.NewInt("validation_pool_rejected_total")
+	metricValidationTimeouts   = expvar.NewInt("validation_timeouts_total")
+	metricAbandonedValidations = expvar.NewInt("validation_abandoned_running")
+
+	metricMirrorSent    = expvar.NewInt("mirror_sent_total")
+	metricMirrorFailed  = expvar.NewInt("mirror_failed_total")
+	metricMirrorDropped = expvar.NewInt("mirror_dropped_total")
+
+	metricRecorded      = expvar.NewInt("recorded_total")
+	metricRecordDropped = expvar.NewInt("record_dropped_total")
+
+	metricChaos = expvar.NewMap("chaos_injected_total")
+
+	metricDriftAlerts = expvar.NewInt("schema_drift_alerts_total")
+
+	metricEmailLookups  = expvar.NewMap("email_domain_lookups_total")
+	metricEmailRejected = expvar.NewInt("email_rejected_total")
+
+	metricAlertsSent   = expvar.NewMap("alert_notifications_sent_total")
+	metricAlertsFailed = expvar.NewMap("alert_notifications_failed_total")
+
+	metricEgressSent    = expvar.NewMap("egress_sent_total")
+	metricEgressRefused = expvar.NewMap("egress_refused_total")
+
+	metricBreakerOpened = expvar.NewInt("circuit_breaker_opened_total")
+	metricBreakerState  = expvar.NewMap("circuit_breaker_state")
+)
+
+func expvarString(v string) *expvar.String {
+	s := new(expvar.String)
+	s.Set(v)
+	return s
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type mirrorConfig struct {
+	Upstream    string
+	Percent     float64
+	MaxInFlight int
+	Timeout     time.Duration
+}
+
+// mirror sends copies of forwarded requests to a shadow upstream. It never
+// holds up the real request: copies go out in the background, and are
+// dropped when too many are already in flight. Shadow responses are
+// discarded.
+type mirror struct {
+	cfg      *mirrorConfig
+	upstream *url.URL
+	client   *http.Client
+	slots    chan struct{}
+}
+
+func newMirror(cfg *mirrorConfig) (*mirror, error) {
+	u, err := url.Parse(cfg.Upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mirror{
+		cfg:      cfg,
+		upstream: u,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		slots:    make(chan struct{}, cfg.MaxInFlight),
+	}, nil
+}
+
+func (m *mirror) send(r *http.Request, body []byte) {
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		metricMirrorDropped.Add(1)
+		return
+	}
+
+	target := *m.upstream
+	target.Path = strings.TrimRight(target.Path, "/") + r.URL.Path
+	target.RawQuery = r.URL.RawQuery
+
+	req, err := http.NewRequestWithContext(context.Background(), r.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		<-m.slots
+		metricMirrorFailed.Add(1)
+		return
+	}
+	req.Header = r.Header.Clone()
+	req.Header.Set("X-Mirrored", "true")
+
+	go func() {
+		defer func() { <-m.slots }()
+
+		resp, err := m.client.Do(req)
+		if err != nil {
+			metricMirrorFailed.Add(1)
+			return
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		metricMirrorSent.Add(1)
+	}()
+}
+
+func mirrorTraffic(m *mirror, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.cfg.Percent < 100 && rand.Float64()*100 >= m.cfg.Percent {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+
+		m.send(r, body)
+
+		replaceBody(r, body)
+		next.ServeHTTP(w, r)
+	})
+}
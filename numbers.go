@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Numbers are never converted to float64 on the way through: bodies are
+// decoded into json.Number, re-encoded from its text, and the validator
+// compares them with minimum, maximum and multipleOf as exact rationals, so
+// 64-bit IDs and currency amounts are checked and forwarded as sent. Where
+// precision can still be lost is in the clients and services on either
+// side parsing JSON numbers as doubles, which -reject-imprecise-numbers
+// guards against.
+
+// float64Exact reports whether the number n, in JSON syntax, survives a
+// round trip through float64, parsing back to the same value from the
+// shortest representation of its float64.
+func float64Exact(n string) bool {
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return false
+	}
+	if f == 0 {
+		// Underflows parse as zero too.
+		mantissa := strings.TrimLeft(n, "-")
+		if i := strings.IndexAny(mantissa, "eE"); i >= 0 {
+			mantissa = mantissa[:i]
+		}
+		return strings.Trim(mantissa, "0.") == ""
+	}
+
+	want, ok := new(big.Rat).SetString(n)
+	if !ok {
+		return false
+	}
+	got, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return want.Cmp(got) == 0
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/open-feature/go-sdk/openfeature"
+)
+
+// ofrepProvider is an OpenFeature provider evaluating flags remotely over
+// the OpenFeature Remote Evaluation Protocol, which flagd, go-feature-flag
+// and most hosted flag services speak.
+type ofrepProvider struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func newOFREPProvider(base, token string, timeout time.Duration) (*ofrepProvider, error) {
+	u, err := url.Parse(base)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid feature flag URL %q", base)
+	}
+	return &ofrepProvider{base: strings.TrimSuffix(base, "/"), token: token, client: &http.Client{Timeout: timeout}}, nil
+}
+
+func (p *ofrepProvider) Metadata() openfeature.Metadata {
+	return openfeature.Metadata{Name: "ofrep"}
+}
+
+func (p *ofrepProvider) Hooks() []openfeature.Hook {
+	return nil
+}
+
+type ofrepResult struct {
+	Value        interface{}            `json:"value"`
+	Reason       string                 `json:"reason"`
+	Variant      string                 `json:"variant"`
+	Metadata     map[string]interface{} `json:"metadata"`
+	ErrorCode    string                 `json:"errorCode"`
+	ErrorDetails string                 `json:"errorDetails"`
+}
+
+// evaluate asks the flag service for flag in flatCtx.
+func (p *ofrepProvider) evaluate(ctx context.Context, flag string, flatCtx openfeature.FlattenedContext) (interface{}, openfeature.ProviderResolutionDetail) {
+	body, err := json.Marshal(map[string]interface{}{"context": flatCtx})
+	if err != nil {
+		return nil, resolutionError(openfeature.NewGeneralResolutionError(err.Error()))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.base+"/ofrep/v1/evaluate/flags/"+url.PathEscape(flag), bytes.NewReader(body))
+	if err != nil {
+		return nil, resolutionError(openfeature.NewGeneralResolutionError(err.Error()))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, resolutionError(openfeature.NewGeneralResolutionError(err.Error()))
+	}
+	defer resp.Body.Close()
+
+	var result ofrepResult
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest && resp.StatusCode != http.StatusNotFound {
+		return nil, resolutionError(openfeature.NewGeneralResolutionError(fmt.Sprintf("flag service responded %s", resp.Status)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, resolutionError(openfeature.NewParseErrorResolutionError(err.Error()))
+	}
+
+	if result.ErrorCode != "" || resp.StatusCode != http.StatusOK {
+		msg := result.ErrorDetails
+		switch result.ErrorCode {
+		case "FLAG_NOT_FOUND":
+			return nil, resolutionError(openfeature.NewFlagNotFoundResolutionError(msg))
+		case "TARGETING_KEY_MISSING":
+			return nil, resolutionError(openfeature.NewTargetingKeyMissingResolutionError(msg))
+		case "PARSE_ERROR":
+			return nil, resolutionError(openfeature.NewParseErrorResolutionError(msg))
+		case "TYPE_MISMATCH":
+			return nil, resolutionError(openfeature.NewTypeMismatchResolutionError(msg))
+		}
+		return nil, resolutionError(openfeature.NewGeneralResolutionError(msg))
+	}
+
+	return result.Value, openfeature.ProviderResolutionDetail{
+		Reason:       openfeature.Reason(result.Reason),
+		Variant:      result.Variant,
+		FlagMetadata: result.Metadata,
+	}
+}
+
+func resolutionError(err openfeature.ResolutionError) openfeature.ProviderResolutionDetail {
+	return openfeature.ProviderResolutionDetail{ResolutionError: err, Reason: openfeature.ErrorReason}
+}
+
+// resolve converts what the flag service returned to T, falling back to
+// defaultValue on any error or a value of the wrong type.
+func resolve[T any](p *ofrepProvider, ctx context.Context, flag string, defaultValue T, flatCtx openfeature.FlattenedContext, convert func(interface{}) (T, bool)) openfeature.GenericResolutionDetail[T] {
+	value, detail := p.evaluate(ctx, flag, flatCtx)
+	if detail.Reason == openfeature.ErrorReason {
+		return openfeature.GenericResolutionDetail[T]{Value: defaultValue, ProviderResolutionDetail: detail}
+	}
+
+	v, ok := convert(value)
+	if !ok {
+		detail = resolutionError(openfeature.NewTypeMismatchResolutionError(fmt.Sprintf("flag %s is a %T", flag, value)))
+		return openfeature.GenericResolutionDetail[T]{Value: defaultValue, ProviderResolutionDetail: detail}
+	}
+	return openfeature.GenericResolutionDetail[T]{Value: v, ProviderResolutionDetail: detail}
+}
+
+func (p *ofrepProvider) BooleanEvaluation(ctx context.Context, flag string, defaultValue bool, flatCtx openfeature.FlattenedContext) openfeature.BoolResolutionDetail {
+	return resolve(p, ctx, flag, defaultValue, flatCtx, func(v interface{}) (bool, bool) {
+		b, ok := v.(bool)
+		return b, ok
+	})
+}
+
+func (p *ofrepProvider) StringEvaluation(ctx context.Context, flag string, defaultValue string, flatCtx openfeature.FlattenedContext) openfeature.StringResolutionDetail {
+	return resolve(p, ctx, flag, defaultValue, flatCtx, func(v interface{}) (string, bool) {
+		s, ok := v.(string)
+		return s, ok
+	})
+}
+
+func (p *ofrepProvider) FloatEvaluation(ctx context.Context, flag string, defaultValue float64, flatCtx openfeature.FlattenedContext) openfeature.FloatResolutionDetail {
+	return resolve(p, ctx, flag, defaultValue, flatCtx, func(v interface{}) (float64, bool) {
+		f, ok := v.(float64)
+		return f, ok
+	})
+}
+
+func (p *ofrepProvider) IntEvaluation(ctx context.Context, flag string, defaultValue int64, flatCtx openfeature.FlattenedContext) openfeature.IntResolutionDetail {
+	return resolve(p, ctx, flag, defaultValue, flatCtx, func(v interface{}) (int64, bool) {
+		f, ok := v.(float64)
+		return int64(f), ok && f == float64(int64(f))
+	})
+}
+
+func (p *ofrepProvider) ObjectEvaluation(ctx context.Context, flag string, defaultValue any, flatCtx openfeature.FlattenedContext) openfeature.InterfaceResolutionDetail {
+	return resolve(p, ctx, flag, defaultValue, flatCtx, func(v interface{}) (interface{}, bool) {
+		return v, true
+	})
+}
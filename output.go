@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// outputFormats are the standard output formats of JSON Schema 2019-09 and
+// later the validation report endpoint can answer in, asked for with the
+// output query parameter or the Schema-Output-Format header.
+var outputFormats = map[string]bool{"flag": true, "basic": true, "detailed": true, "verbose": true}
+
+// requestedOutput returns the output format r asks for, "" for the
+// endpoint's own report.
+func requestedOutput(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("output")
+	if format == "" {
+		format = r.Header.Get("Schema-Output-Format")
+	}
+	if format != "" && !outputFormats[format] {
+		return "", fmt.Errorf("unknown output format %q, expected flag, basic, detailed or verbose", format)
+	}
+	return format, nil
+}
+
+// outputUnit is an output unit of the standard formats.
+type outputUnit struct {
+	Valid            bool          `json:"valid"`
+	KeywordLocation  string        `json:"keywordLocation"`
+	InstanceLocation string        `json:"instanceLocation"`
+	Error            string        `json:"error,omitempty"`
+	Errors           []*outputUnit `json:"errors,omitempty"`
+}
+
+// errorKeywords are the keywords behind the error types of gojsonschema.
+// Types missing here stand for their keyword themselves.
+var errorKeywords = map[string]string{
+	"required":                        "required",
+	"invalid_type":                    "type",
+	"number_any_of":                   "anyOf",
+	"number_one_of":                   "oneOf",
+	"number_all_of":                   "allOf",
+	"number_not":                      "not",
+	"missing_dependency":              "dependencies",
+	"const":                           "const",
+	"enum":                            "enum",
+	"array_no_additional_items":       "additionalItems",
+	"array_min_items":                 "minItems",
+	"array_max_items":                 "maxItems",
+	"unique":                          "uniqueItems",
+	"contains":                        "contains",
+	"array_min_properties":            "minProperties",
+	"array_max_properties":            "maxProperties",
+	"additional_property_not_allowed": "additionalProperties",
+	"invalid_property_pattern":        "patternProperties",
+	"invalid_property_name":           "propertyNames",
+	"string_gte":                      "minLength",
+	"string_lte":                      "maxLength",
+	"pattern":                         "pattern",
+	"format":                          "format",
+	"multiple_of":                     "multipleOf",
+	"number_gte":                      "minimum",
+	"number_gt":                       "exclusiveMinimum",
+	"number_lte":                      "maximum",
+	"number_lt":                       "exclusiveMaximum",
+	"condition_then":                  "then",
+	"condition_else":                  "else",
+}
+
+// outputStep is a level of the path to an error: the schema keywords taken
+// into the subschema and the instance token they apply to.
+type outputStep struct {
+	keywords string
+	token    string
+}
+
+// errorPath follows properties, additionalProperties and items from the
+// root for the instance tokens of an error, for as long as the schema
+// declares them. The validator doesn't say which subschema failed, so
+// keyword locations are as far as this gets, without going through $ref or
+// combinators.
+func errorPath(doc interface{}, tokens []string) []outputStep {
+	var path []outputStep
+	node := doc
+	for _, token := range tokens {
+		obj, _ := node.(map[string]interface{})
+		props, _ := obj["properties"].(map[string]interface{})
+		var next interface{}
+		var keywords string
+		switch items := obj["items"].(type) {
+		case map[string]interface{}:
+			next, keywords = items, "/items"
+		case []interface{}:
+			if i, err := strconv.Atoi(token); err == nil && i >= 0 && i < len(items) {
+				next, keywords = items[i], "/items/"+token
+			}
+		}
+		if keywords == "" {
+			if s, ok := props[token]; ok {
+				next, keywords = s, "/properties/"+schemavalidation.EscapePointer(token)
+			} else if s, ok := obj["additionalProperties"].(map[string]interface{}); ok {
+				next, keywords = s, "/additionalProperties"
+			}
+		}
+		if keywords == "" {
+			break
+		}
+		path = append(path, outputStep{keywords: keywords, token: schemavalidation.EscapePointer(token)})
+		node = next
+	}
+	return path
+}
+
+// formatOutput renders errs in one of the standard output formats.
+func formatOutput(format string, doc interface{}, errs []gojsonschema.ResultError) interface{} {
+	if format == "flag" {
+		return struct {
+			Valid bool `json:"valid"`
+		}{len(errs) == 0}
+	}
+	root := &outputUnit{Valid: len(errs) == 0}
+	if len(errs) == 0 {
+		return root
+	}
+
+	type leaf struct {
+		path []outputStep
+		unit *outputUnit
+	}
+	var leaves []leaf
+	for _, e := range errs {
+		tokens := contextHeads(e.Context())
+		path := errorPath(doc, tokens)
+		keyword, ok := errorKeywords[e.Type()]
+		if !ok {
+			keyword = e.Type()
+		}
+		var at strings.Builder
+		for _, step := range path {
+			at.WriteString(step.keywords)
+		}
+		leaves = append(leaves, leaf{path, &outputUnit{
+			KeywordLocation:  at.String() + "/" + keyword,
+			InstanceLocation: schemavalidation.Pointer(e.Context()),
+			Error:            e.Description(),
+		}})
+	}
+
+	if format == "basic" {
+		for _, l := range leaves {
+			root.Errors = append(root.Errors, l.unit)
+		}
+		return root
+	}
+
+	// detailed and verbose nest the errors by the subschemas they're in,
+	// detailed leaving out the levels with a single child.
+	units := make(map[string]*outputUnit)
+	for _, l := range leaves {
+		parent := root
+		var keywordAt, instanceAt string
+		for _, step := range l.path {
+			keywordAt += step.keywords
+			instanceAt += "/" + step.token
+			unit, ok := units[keywordAt+" "+instanceAt]
+			if !ok {
+				unit = &outputUnit{KeywordLocation: keywordAt, InstanceLocation: instanceAt}
+				units[keywordAt+" "+instanceAt] = unit
+				parent.Errors = append(parent.Errors, unit)
+			}
+			parent = unit
+		}
+		parent.Errors = append(parent.Errors, l.unit)
+	}
+	sortUnits(root)
+	if format == "detailed" {
+		for i, child := range root.Errors {
+			root.Errors[i] = condenseUnit(child)
+		}
+	}
+	return root
+}
+
+// contextHeads returns the path of c below the root.
+func contextHeads(c *gojsonschema.JsonContext) []string {
+	if c == nil {
+		return nil
+	}
+	heads := strings.Split(c.String("\x00"), "\x00")
+	return heads[1:]
+}
+
+func sortUnits(u *outputUnit) {
+	sort.SliceStable(u.Errors, func(i, j int) bool {
+		if u.Errors[i].KeywordLocation != u.Errors[j].KeywordLocation {
+			return u.Errors[i].KeywordLocation < u.Errors[j].KeywordLocation
+		}
+		return u.Errors[i].InstanceLocation < u.Errors[j].InstanceLocation
+	})
+	for _, child := range u.Errors {
+		sortUnits(child)
+	}
+}
+
+func condenseUnit(u *outputUnit) *outputUnit {
+	for len(u.Errors) == 1 {
+		u = u.Errors[0]
+	}
+	for i, child := range u.Errors {
+		u.Errors[i] = condenseUnit(child)
+	}
+	return u
+}
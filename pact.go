@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// pactFile is the part of a Pact contract, v2 to v4, the pact command
+// reads: the requests each consumer expects the provider to accept.
+type pactFile struct {
+	Consumer struct {
+		Name string `json:"name"`
+	} `json:"consumer"`
+	Interactions []struct {
+		Description string `json:"description"`
+		Request     struct {
+			Method  string                     `json:"method"`
+			Path    string                     `json:"path"`
+			Headers map[string]json.RawMessage `json:"headers"`
+			Body    json.RawMessage            `json:"body"`
+		} `json:"request"`
+	} `json:"interactions"`
+}
+
+// runPact implements the pact command, checking that every request body in
+// the Pact files given after the server's flags is accepted by the schema
+// the server would select for it. Pointing -schema-dir at a proposed change
+// lists the consumer expectations it would break.
+func runPact(args []string) error {
+	cfg := parseConfig(args)
+	if len(cfg.Args) == 0 {
+		return fmt.Errorf("usage: schema-validations pact [flags] <pact file or directory>...")
+	}
+
+	store, err := newSchemaStore(&cfg.Schemas)
+	if err != nil {
+		return fmt.Errorf("failed to load schemas: %v", err)
+	}
+	registry := store.current()
+
+	var files []string
+	for _, arg := range cfg.Args {
+		if info, err := os.Stat(arg); err == nil && info.IsDir() {
+			matches, err := filepath.Glob(filepath.Join(arg, "*.json"))
+			if err != nil {
+				return err
+			}
+			files = append(files, matches...)
+			continue
+		}
+		files = append(files, arg)
+	}
+
+	passed, broken := 0, 0
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var pact pactFile
+		if err := json.Unmarshal(b, &pact); err != nil {
+			return fmt.Errorf("%s: not a Pact file: %v", file, err)
+		}
+
+		for _, interaction := range pact.Interactions {
+			body, ok := pactBody(interaction.Request.Body)
+			if !ok {
+				continue
+			}
+
+			req := httptest.NewRequest(interaction.Request.Method, "http://pact"+interaction.Request.Path, bytes.NewReader(body))
+			for name, value := range interaction.Request.Headers {
+				req.Header.Set(name, pactHeader(value))
+			}
+
+			msgs, err := pactErrors(registry, req, body)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %v", file, interaction.Description, err)
+			}
+			if len(msgs) == 0 {
+				passed++
+				continue
+			}
+
+			broken++
+			fmt.Printf("BROKEN %s: %q (%s %s)\n", pact.Consumer.Name, interaction.Description, req.Method, req.URL.Path)
+			for _, msg := range msgs {
+				fmt.Printf("    %s\n", msg)
+			}
+		}
+	}
+
+	fmt.Printf("%d interactions accepted, %d broken\n", passed, broken)
+	if broken > 0 {
+		return errFailed
+	}
+	return nil
+}
+
+// pactErrors returns why the gateway would reject req, schema selection
+// failures included.
+func pactErrors(registry *schemaRegistry, req *http.Request, body []byte) ([]string, error) {
+	schema, err := registry.resolve(req)
+	var sel *selectionError
+	if errors.As(err, &sel) {
+		return []string{sel.msg}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := schema.Schema.Validate(gojsonschema.NewBytesLoader(body))
+	if err != nil {
+		return []string{"request body is not valid JSON"}, nil
+	}
+	return errorMessages(schemaErrors(schema, body, result)), nil
+}
+
+// pactBody returns the JSON request body of an interaction. v4 wraps it as
+// {"content": ..., "contentType": ..., "encoded": ...}.
+func pactBody(raw json.RawMessage) ([]byte, bool) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, false
+	}
+
+	var v4 struct {
+		Content     json.RawMessage `json:"content"`
+		ContentType *string         `json:"contentType"`
+		Encoded     interface{}     `json:"encoded"`
+	}
+	if json.Unmarshal(raw, &v4) != nil || v4.ContentType == nil {
+		return raw, true
+	}
+	if len(v4.Content) == 0 {
+		return nil, false
+	}
+
+	// Encoded bodies hold the JSON as a string, base64 encoded unless
+	// encoded is "json".
+	var s string
+	if v4.Encoded == nil || v4.Encoded == false || json.Unmarshal(v4.Content, &s) != nil {
+		return v4.Content, true
+	}
+	if enc, _ := v4.Encoded.(string); strings.EqualFold(enc, "json") {
+		return []byte(s), true
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return []byte(s), true
+	}
+	return b, true
+}
+
+// pactHeader flattens a header value, a string in v2 and a list of strings
+// in v3 and later.
+func pactHeader(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var list []string
+	json.Unmarshal(raw, &list)
+	return strings.Join(list, ", ")
+}
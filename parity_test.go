@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidatetest"
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// paritySchema is a draft-04 schema whose constraints gojsonschema doesn't
+// implement on its own: dependentRequired, rewritten into dependencies, and
+// the cross-field x-less-than.
+const paritySchema = `{
+	"$schema": "http://json-schema.org/draft-04/schema#",
+	"type": "object",
+	"properties": {
+		"start": {"type": "integer", "x-less-than": "end"},
+		"end": {"type": "integer"},
+		"kind": {"type": "string"},
+		"url": {"type": "string"}
+	},
+	"dependentRequired": {"url": ["kind"]}
+}`
+
+// parityBodies are rejected by the gateway for paritySchema, one through
+// each of its keywords.
+var parityBodies = map[string]string{
+	"dependentRequired": `{"url":"https://example.com"}`,
+	"x-less-than":       `{"start":5,"end":1}`,
+}
+
+// gatewayErrors returns the messages the gateway rejects body with when it
+// validates against schema, failing the test if it accepts it.
+func gatewayErrors(t *testing.T, schema, body string) []string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "parity.1.json"), []byte(schema), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	g, err := newGateway(parseConfig([]string{"-upstream", upstream.URL, "-schema-dir", dir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	g.handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("gateway answered %s with %d %s, want it rejected", body, rec.Code, rec.Body)
+	}
+
+	var errBody struct {
+		Errors []errorEntry `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &errBody); err != nil {
+		t.Fatalf("gateway error body %s: %v", rec.Body, err)
+	}
+	if len(errBody.Errors) == 0 {
+		t.Fatalf("gateway rejected %s without errors", body)
+	}
+	return entryMessages(errBody.Errors)
+}
+
+// TestValidatorAgreesWithGateway checks schemavalidatetest rejects bodies
+// with the errors the gateway does.
+func TestValidatorAgreesWithGateway(t *testing.T) {
+	v := schemavalidatetest.NewValidator(t, paritySchema)
+	for keyword, body := range parityBodies {
+		t.Run(keyword, func(t *testing.T) {
+			want := gatewayErrors(t, paritySchema, body)
+
+			var got []string
+			for _, e := range v.Validate(body) {
+				got = append(got, e.Message)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Validate(%s) = %q, the gateway says %q", body, got, want)
+			}
+		})
+	}
+}
+
+// TestTransportAgreesWithGateway checks schemavalidation.Transport refuses to
+// send bodies with the errors the gateway would reject them with.
+func TestTransportAgreesWithGateway(t *testing.T) {
+	v, err := schemavalidation.New([]byte(paritySchema), schemavalidation.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = true
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &schemavalidation.Transport{Request: v}}
+
+	for keyword, body := range parityBodies {
+		t.Run(keyword, func(t *testing.T) {
+			want := gatewayErrors(t, paritySchema, body)
+
+			sent = false
+			res, err := client.Post(server.URL, "application/json", strings.NewReader(body))
+			if err == nil {
+				res.Body.Close()
+			}
+			var verr *schemavalidation.ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Post(%s) = %v, want a *ValidationError", body, err)
+			}
+			if sent {
+				t.Errorf("Post(%s) reached the server", body)
+			}
+
+			var got []string
+			for _, e := range verr.Errors {
+				got = append(got, e.Message)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Post(%s) failed with %q, the gateway says %q", body, got, want)
+			}
+		})
+	}
+}
+
+// TestGeneratedClientAgreesWithGateway builds a client with `generate go`
+// and checks its ValidateJSON rejects bodies with the errors the gateway
+// does.
+func TestGeneratedClientAgreesWithGateway(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the generated client")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go command to build the generated client with")
+	}
+
+	src, err := goClient(schemaDocument{Name: "parity", Version: "1", Data: []byte(paritySchema)}, "runes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Inside the module, so the client builds against this schemavalidation,
+	// and named so ./... leaves it out.
+	dir, err := os.MkdirTemp(".", "_generated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "parity", "cmd"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "parity", "parity.go"), src, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The command validates each argument and prints the errors as JSON.
+	cmd := `package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/mitchfriedman/schema-validations/` + filepath.ToSlash(filepath.Base(dir)) + `/parity"
+)
+
+func main() {
+	var out [][]string
+	for _, body := range os.Args[1:] {
+		var verr *parity.ValidationError
+		if err := parity.ValidateJSON([]byte(body)); !errors.As(err, &verr) {
+			panic(err)
+		}
+		out = append(out, verr.Errors)
+	}
+	json.NewEncoder(os.Stdout).Encode(out)
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "parity", "cmd", "main.go"), []byte(cmd), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var keywords, bodies []string
+	for keyword, body := range parityBodies {
+		keywords = append(keywords, keyword)
+		bodies = append(bodies, body)
+	}
+	run := exec.Command("go", append([]string{"run", "./" + filepath.ToSlash(dir) + "/parity/cmd"}, bodies...)...)
+	out, err := run.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			t.Fatalf("generated client: %v\n%s", err, exitErr.Stderr)
+		}
+		t.Fatal(err)
+	}
+	var got [][]string
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("generated client printed %s: %v", out, err)
+	}
+
+	for i, keyword := range keywords {
+		t.Run(keyword, func(t *testing.T) {
+			if want := gatewayErrors(t, paritySchema, bodies[i]); !reflect.DeepEqual(got[i], want) {
+				t.Errorf("ValidateJSON(%s) = %q, the gateway says %q", bodies[i], got[i], want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// routePolicy overrides how requests under a path prefix are handled. Zero
+// values keep the global behaviour.
+type routePolicy struct {
+	MaxBodyBytes      int64
+	Status            int
+	ErrorDetail       string
+	Enforcement       string
+	Timeout           time.Duration
+	ValidationTimeout time.Duration
+}
+
+// routePolicies is a flag.Value accepting repeated
+// "prefix=key:value;key:value" policies, with the keys max-body-bytes,
+// status, error-detail, enforcement, timeout and validation-timeout. The
+// longest matching prefix wins. Keys are separated by semicolons so
+// policies can still be listed comma separated in SV_ROUTE_POLICY.
+type routePolicies map[string]*routePolicy
+
+func (p routePolicies) String() string {
+	prefixes := make([]string, 0, len(p))
+	for prefix := range p {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	var out []string
+	for _, prefix := range prefixes {
+		var fields []string
+		pol := p[prefix]
+		if pol.MaxBodyBytes > 0 {
+			fields = append(fields, fmt.Sprintf("max-body-bytes:%d", pol.MaxBodyBytes))
+		}
+		if pol.Status > 0 {
+			fields = append(fields, fmt.Sprintf("status:%d", pol.Status))
+		}
+		if pol.ErrorDetail != "" {
+			fields = append(fields, "error-detail:"+pol.ErrorDetail)
+		}
+		if pol.Enforcement != "" {
+			fields = append(fields, "enforcement:"+pol.Enforcement)
+		}
+		if pol.Timeout > 0 {
+			fields = append(fields, "timeout:"+pol.Timeout.String())
+		}
+		if pol.ValidationTimeout > 0 {
+			fields = append(fields, "validation-timeout:"+pol.ValidationTimeout.String())
+		}
+		out = append(out, prefix+"="+strings.Join(fields, ";"))
+	}
+	return strings.Join(out, ",")
+}
+
+func (p routePolicies) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected prefix=key:value;..., got %q", v)
+	}
+
+	pol := &routePolicy{}
+	for _, field := range strings.Split(v[i+1:], ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), ":")
+		if !ok {
+			return fmt.Errorf("expected key:value, got %q", field)
+		}
+
+		switch key {
+		case "max-body-bytes":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid byte limit %q", value)
+			}
+			pol.MaxBodyBytes = n
+		case "status":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 400 || n > 599 {
+				return fmt.Errorf("invalid failure status %q, expected 400 to 599", value)
+			}
+			pol.Status = n
+		case "error-detail":
+			if value != "full" && value != "summary" {
+				return fmt.Errorf("invalid error detail %q, expected full or summary", value)
+			}
+			pol.ErrorDetail = value
+		case "enforcement":
+			if value != "enforce" && value != "report" {
+				return fmt.Errorf("invalid enforcement %q, expected enforce or report", value)
+			}
+			pol.Enforcement = value
+		case "timeout":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid timeout %q", value)
+			}
+			pol.Timeout = d
+		case "validation-timeout":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid validation timeout %q", value)
+			}
+			pol.ValidationTimeout = d
+		default:
+			return fmt.Errorf("unknown route policy key %q, expected max-body-bytes, status, error-detail, enforcement, timeout or validation-timeout", key)
+		}
+	}
+
+	p[v[:i]] = pol
+	return nil
+}
+
+func (p routePolicies) forPath(path string) *routePolicy {
+	var pol *routePolicy
+	matched := ""
+	for prefix, candidate := range p {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(matched) {
+			pol, matched = candidate, prefix
+		}
+	}
+	return pol
+}
+
+// bodyLimits adds the policies' body size limits to routes, replacing a
+// -route-max-body-bytes limit for the same prefix.
+func (p routePolicies) bodyLimits(routes routeLimits) {
+	for prefix, pol := range p {
+		if pol.MaxBodyBytes > 0 {
+			routes[prefix] = pol.MaxBodyBytes
+		}
+	}
+}
+
+// apply returns s with the policy's overrides.
+func (pol *routePolicy) apply(s *settings) *settings {
+	if pol == nil {
+		return s
+	}
+
+	out := *s
+	if pol.Enforcement != "" {
+		out.Enforcement = pol.Enforcement
+	}
+	if pol.ErrorDetail != "" {
+		out.ErrorDetail = pol.ErrorDetail
+	}
+	if pol.Status != 0 {
+		out.FailureStatus = pol.Status
+	}
+	if pol.ValidationTimeout != 0 {
+		out.ValidationTimeout = pol.ValidationTimeout
+	}
+	return &out
+}
+
+// routeTimeouts bounds how long requests on routes with a timeout policy
+// may take, through their context, so validation and the upstream call give
+// up once the time is spent.
+func routeTimeouts(policies routePolicies, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pol := policies.forPath(r.URL.Path)
+		if pol == nil || pol.Timeout == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), pol.Timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// The gateway can sit in front of grpc-gateway with schemas derived from the
+// protos instead of written by hand. -proto-descriptors names a
+// FileDescriptorSet, from protoc --include_imports --descriptor_set_out,
+// and every RPC with a google.api.http binding that takes a body gets a
+// schema for the proto3 JSON mapping of that body. Requests are matched to
+// those schemas by method and path template.
+
+// Extension numbers of the annotations read from the descriptors, so the
+// googleapis protos aren't needed to decode them.
+const (
+	httpRuleExtension      = 72295728 // google.api.http on MethodOptions
+	fieldBehaviorExtension = 1052     // google.api.field_behavior on FieldOptions
+	fieldBehaviorRequired  = 2
+)
+
+// protoRoute is one HTTP binding of an RPC.
+type protoRoute struct {
+	method  string
+	pattern *regexp.Regexp
+	schema  string
+}
+
+// httpRule is the part of google.api.HttpRule the gateway uses.
+type httpRule struct {
+	method, path, body string
+	additional         []httpRule
+}
+
+// loadProtoSchemas derives a schema document and a route for every HTTP
+// binding with a body in the descriptor set in file. Additional bindings of
+// an RPC are named after it with -2, -3 and so on.
+func loadProtoSchemas(file string) ([]schemaDocument, []protoRoute, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(b, &set); err != nil {
+		return nil, nil, fmt.Errorf("%s: not a FileDescriptorSet: %v", file, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	var docs []schemaDocument
+	var routes []protoRoute
+	var failed error
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		services := fd.Services()
+		for i := 0; i < services.Len(); i++ {
+			methods := services.Get(i).Methods()
+			for j := 0; j < methods.Len(); j++ {
+				md := methods.Get(j)
+				opts, _ := md.Options().(*descriptorpb.MethodOptions)
+				rule, ok := methodHTTPRule(opts)
+				if !ok {
+					continue
+				}
+
+				bindings := append([]httpRule{rule}, rule.additional...)
+				for n, binding := range bindings {
+					if binding.body == "" {
+						continue
+					}
+					name := string(md.FullName())
+					if n > 0 {
+						name = fmt.Sprintf("%s-%d", name, n+1)
+					}
+
+					pattern, vars, err := protoPathPattern(binding.path)
+					if err != nil {
+						failed = fmt.Errorf("%s: %s: %v", file, md.FullName(), err)
+						return false
+					}
+					data, err := protoBodySchema(md.Input(), binding.body, vars)
+					if err != nil {
+						failed = fmt.Errorf("%s: %s: %v", file, md.FullName(), err)
+						return false
+					}
+
+					docs = append(docs, schemaDocument{Name: name, Version: "v1", Data: data})
+					routes = append(routes, protoRoute{method: binding.method, pattern: pattern, schema: name})
+				}
+			}
+		}
+		return true
+	})
+	if failed != nil {
+		return nil, nil, failed
+	}
+	return docs, routes, nil
+}
+
+// protoRouteSchema returns the schema of the binding r matches.
+func (s *schemaRegistry) protoRouteSchema(r *http.Request) (string, bool) {
+	for _, route := range s.protoRoutes {
+		if strings.EqualFold(route.method, r.Method) && route.pattern.MatchString(r.URL.Path) {
+			return route.schema, true
+		}
+	}
+	return "", false
+}
+
+func methodHTTPRule(opts *descriptorpb.MethodOptions) (httpRule, bool) {
+	if opts == nil {
+		return httpRule{}, false
+	}
+	for _, field := range wireFields(opts.ProtoReflect().GetUnknown()) {
+		if field.num == httpRuleExtension && field.typ == protowire.BytesType {
+			return parseHTTPRule(field.value), true
+		}
+	}
+	return httpRule{}, false
+}
+
+func parseHTTPRule(b []byte) httpRule {
+	var rule httpRule
+	methods := map[protowire.Number]string{2: http.MethodGet, 3: http.MethodPut, 4: http.MethodPost, 5: http.MethodDelete, 6: http.MethodPatch}
+	for _, field := range wireFields(b) {
+		if field.typ != protowire.BytesType {
+			continue
+		}
+		switch field.num {
+		case 2, 3, 4, 5, 6:
+			rule.method, rule.path = methods[field.num], string(field.value)
+		case 7:
+			rule.body = string(field.value)
+		case 8:
+			for _, custom := range wireFields(field.value) {
+				switch custom.num {
+				case 1:
+					rule.method = string(custom.value)
+				case 2:
+					rule.path = string(custom.value)
+				}
+			}
+		case 11:
+			rule.additional = append(rule.additional, parseHTTPRule(field.value))
+		}
+	}
+	return rule
+}
+
+// fieldRequired reports whether a field is annotated
+// (google.api.field_behavior) = REQUIRED.
+func fieldRequired(fd protoreflect.FieldDescriptor) bool {
+	opts, _ := fd.Options().(*descriptorpb.FieldOptions)
+	if opts == nil {
+		return false
+	}
+	for _, field := range wireFields(opts.ProtoReflect().GetUnknown()) {
+		if field.num != fieldBehaviorExtension {
+			continue
+		}
+		switch field.typ {
+		case protowire.VarintType:
+			if field.varint == fieldBehaviorRequired {
+				return true
+			}
+		case protowire.BytesType:
+			for b := field.value; len(b) > 0; {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					break
+				}
+				if v == fieldBehaviorRequired {
+					return true
+				}
+				b = b[n:]
+			}
+		}
+	}
+	return false
+}
+
+type wireField struct {
+	num    protowire.Number
+	typ    protowire.Type
+	value  []byte
+	varint uint64
+}
+
+// wireFields splits encoded protobuf fields, stopping at anything malformed.
+func wireFields(b []byte) []wireField {
+	var fields []wireField
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fields
+		}
+		b = b[n:]
+
+		field := wireField{num: num, typ: typ}
+		switch typ {
+		case protowire.BytesType:
+			field.value, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			field.varint, n = protowire.ConsumeVarint(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fields
+		}
+		b = b[n:]
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// protoPathPattern compiles a google.api.http path template such as
+// /v1/{name=shelves/*}/books:batchGet, returning the top level fields its
+// variables bind.
+func protoPathPattern(template string) (*regexp.Regexp, []string, error) {
+	var b strings.Builder
+	var vars []string
+	b.WriteString("^")
+	for i := 0; i < len(template); {
+		if template[i] == '{' {
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return nil, nil, fmt.Errorf("unclosed variable in path template %q", template)
+			}
+			name, segments, ok := strings.Cut(template[i+1:i+end], "=")
+			if !ok {
+				segments = "*"
+			}
+			vars = append(vars, strings.SplitN(name, ".", 2)[0])
+			b.WriteString(templateSegments(segments))
+			i += end + 1
+			continue
+		}
+
+		j := strings.IndexByte(template[i:], '{')
+		if j < 0 {
+			j = len(template) - i
+		}
+		b.WriteString(templateSegments(template[i : i+j]))
+		i += j
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	return re, vars, err
+}
+
+func templateSegments(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "**"):
+			b.WriteString(".+")
+			i++
+		case s[i] == '*':
+			b.WriteString("[^/]+")
+		default:
+			b.WriteString(regexp.QuoteMeta(s[i : i+1]))
+		}
+	}
+	return b.String()
+}
+
+// protoBodySchema writes the schema of a binding's body: the whole input
+// message for "*", less the fields the path binds, or the named field.
+func protoBodySchema(input protoreflect.MessageDescriptor, body string, pathVars []string) ([]byte, error) {
+	g := &protoSchemaGen{defs: make(schemavalidation.SchemaObject)}
+
+	var root schemavalidation.SchemaObject
+	if body == "*" {
+		bound := make(map[string]bool)
+		for _, v := range pathVars {
+			bound[v] = true
+		}
+		root = g.messageSchema(input, bound)
+	} else {
+		fd := input.Fields().ByName(protoreflect.Name(body))
+		if fd == nil {
+			return nil, fmt.Errorf("body field %q is not a field of %s", body, input.FullName())
+		}
+		root = schemavalidation.SchemaObject{"allOf": []interface{}{g.fieldSchema(fd)}}
+	}
+
+	root["$schema"] = "http://json-schema.org/draft-07/schema#"
+	root["title"] = string(input.FullName())
+	if len(g.defs) > 0 {
+		root["definitions"] = g.defs
+	}
+	return encodeJSON(root)
+}
+
+// protoSchemaGen writes schemas for the proto3 JSON mapping, as protojson
+// parses it, with each message as a definition.
+type protoSchemaGen struct {
+	defs schemavalidation.SchemaObject
+}
+
+func (g *protoSchemaGen) messageRef(md protoreflect.MessageDescriptor) interface{} {
+	if s, ok := wellKnownSchema(md); ok {
+		return s
+	}
+
+	name := string(md.FullName())
+	if _, ok := g.defs[name]; !ok {
+		// Claimed before the fields are walked, for recursive messages.
+		g.defs[name] = schemavalidation.SchemaObject{}
+		g.defs[name] = g.messageSchema(md, nil)
+	}
+	return schemavalidation.SchemaObject{"$ref": "#/definitions/" + name}
+}
+
+func (g *protoSchemaGen) messageSchema(md protoreflect.MessageDescriptor, skip map[string]bool) schemavalidation.SchemaObject {
+	props := make(schemavalidation.SchemaObject)
+	var all []interface{}
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if skip[string(fd.Name())] {
+			continue
+		}
+
+		// protojson accepts the proto name as well as the JSON name.
+		s := g.fieldSchema(fd)
+		props[fd.JSONName()] = s
+		props[string(fd.Name())] = s
+
+		if fieldRequired(fd) {
+			if fd.JSONName() == string(fd.Name()) {
+				all = append(all, schemavalidation.SchemaObject{"required": []interface{}{fd.JSONName()}})
+			} else {
+				all = append(all, schemavalidation.SchemaObject{"anyOf": []interface{}{
+					schemavalidation.SchemaObject{"required": []interface{}{fd.JSONName()}},
+					schemavalidation.SchemaObject{"required": []interface{}{string(fd.Name())}},
+				}})
+			}
+		}
+	}
+
+	oneofs := md.Oneofs()
+	for i := 0; i < oneofs.Len(); i++ {
+		od := oneofs.Get(i)
+		if od.IsSynthetic() || od.Fields().Len() < 2 {
+			continue
+		}
+		var pairs []interface{}
+		for a := 0; a < od.Fields().Len(); a++ {
+			for b := a + 1; b < od.Fields().Len(); b++ {
+				pairs = append(pairs, schemavalidation.SchemaObject{"required": []interface{}{od.Fields().Get(a).JSONName(), od.Fields().Get(b).JSONName()}})
+			}
+		}
+		all = append(all, schemavalidation.SchemaObject{"not": schemavalidation.SchemaObject{"anyOf": pairs}})
+	}
+
+	s := schemavalidation.SchemaObject{"type": "object", "properties": props, "additionalProperties": false}
+	if len(all) > 0 {
+		s["allOf"] = all
+	}
+	return s
+}
+
+func (g *protoSchemaGen) fieldSchema(fd protoreflect.FieldDescriptor) interface{} {
+	if fd.IsMap() {
+		return schemavalidation.SchemaObject{"type": "object", "additionalProperties": g.valueSchema(fd.MapValue())}
+	}
+	s := g.valueSchema(fd)
+	if fd.IsList() {
+		return schemavalidation.SchemaObject{"type": "array", "items": s}
+	}
+	return s
+}
+
+func (g *protoSchemaGen) valueSchema(fd protoreflect.FieldDescriptor) interface{} {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return g.messageRef(fd.Message())
+	case protoreflect.EnumKind:
+		if fd.Enum().FullName() == "google.protobuf.NullValue" {
+			return schemavalidation.SchemaObject{"type": "null"}
+		}
+		var names []interface{}
+		values := fd.Enum().Values()
+		for i := 0; i < values.Len(); i++ {
+			names = append(names, string(values.Get(i).Name()))
+		}
+		return schemavalidation.SchemaObject{"anyOf": []interface{}{
+			schemavalidation.SchemaObject{"type": "string", "enum": names},
+			schemavalidation.SchemaObject{"type": "integer"},
+		}}
+	}
+	return scalarSchema(fd.Kind())
+}
+
+// scalarSchema follows protojson: 64 bit integers may be strings, floats may
+// be "NaN" or "Infinity", bytes are base64.
+func scalarSchema(kind protoreflect.Kind) schemavalidation.SchemaObject {
+	switch kind {
+	case protoreflect.BoolKind:
+		return schemavalidation.SchemaObject{"type": "boolean"}
+	case protoreflect.StringKind:
+		return schemavalidation.SchemaObject{"type": "string"}
+	case protoreflect.BytesKind:
+		return schemavalidation.SchemaObject{"type": "string", "contentEncoding": "base64"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return schemavalidation.SchemaObject{"type": "integer", "minimum": int64(-1 << 31), "maximum": int64(1<<31 - 1)}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return schemavalidation.SchemaObject{"type": "integer", "minimum": 0, "maximum": int64(1<<32 - 1)}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return schemavalidation.SchemaObject{"type": []interface{}{"integer", "string"}, "pattern": "^-?[0-9]+$"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return schemavalidation.SchemaObject{"type": []interface{}{"integer", "string"}, "minimum": 0, "pattern": "^[0-9]+$"}
+	}
+	return schemavalidation.SchemaObject{"type": []interface{}{"number", "string"}}
+}
+
+// wellKnownSchema returns the special JSON forms of the well-known types.
+func wellKnownSchema(md protoreflect.MessageDescriptor) (schemavalidation.SchemaObject, bool) {
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		return schemavalidation.SchemaObject{"type": "string", "format": "date-time"}, true
+	case "google.protobuf.Duration":
+		return schemavalidation.SchemaObject{"type": "string", "pattern": `^-?[0-9]+(\.[0-9]{1,9})?s$`}, true
+	case "google.protobuf.FieldMask":
+		return schemavalidation.SchemaObject{"type": "string"}, true
+	case "google.protobuf.Struct":
+		return schemavalidation.SchemaObject{"type": "object"}, true
+	case "google.protobuf.ListValue":
+		return schemavalidation.SchemaObject{"type": "array"}, true
+	case "google.protobuf.Value":
+		return schemavalidation.SchemaObject{}, true
+	case "google.protobuf.Empty":
+		return schemavalidation.SchemaObject{"type": "object", "additionalProperties": false}, true
+	case "google.protobuf.Any":
+		return schemavalidation.SchemaObject{"type": "object", "required": []interface{}{"@type"}, "properties": schemavalidation.SchemaObject{"@type": schemavalidation.SchemaObject{"type": "string"}}}, true
+	}
+
+	if md.FullName().Parent() == "google.protobuf" && strings.HasSuffix(string(md.Name()), "Value") {
+		if value := md.Fields().ByName("value"); value != nil {
+			return scalarSchema(value.Kind()), true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// newProxy forwards validated requests to upstream through transport, the
+// default transport if nil. modifyResponse, when set, sees every upstream
+// response before it is relayed.
+func newProxy(upstream string, transport http.RoundTripper, modifyResponse func(*http.Response) error) (http.HandlerFunc, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("upstream %q must be an absolute URL", upstream)
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(u)
+			pr.SetXForwarded()
+		},
+		Transport:      transport,
+		ModifyResponse: modifyResponse,
+		ErrorHandler:   proxyError,
+	}
+
+	return proxy.ServeHTTP, nil
+}
+
+func proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	if v, ok := err.(*responseViolation); ok {
+		msgs := make([]string, len(v.msgs))
+		for i, msg := range v.msgs {
+			msgs[i] = "upstream response: " + msg
+		}
+		writeErrors(w, http.StatusBadGateway, codeInvalidResponse, msgs...)
+		return
+	}
+
+	if errors.Is(err, errCircuitOpen) {
+		w.Header().Set("Retry-After", "1")
+		writeErrors(w, http.StatusServiceUnavailable, codeUpstreamUnavailable, "upstream is unavailable")
+		return
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeErrors(w, http.StatusGatewayTimeout, codeUpstreamTimeout, "upstream did not respond in time")
+		return
+	}
+
+	log.Printf("failed to proxy %s %s (request %s): %v", r.Method, r.URL.Path, requestID(r), err)
+	writeErrors(w, http.StatusBadGateway, codeUpstreamUnavailable, "upstream is unavailable")
+}
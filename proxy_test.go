@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestProxyRequestsWithoutBody checks requests without a body are forwarded
+// to the upstream as they are, with nothing to validate.
+func TestProxyRequestsWithoutBody(t *testing.T) {
+	var got []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	g, err := newGateway(parseConfig([]string{"-upstream", upstream.URL}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		for _, contentType := range []string{"", "application/json"} {
+			got = nil
+			r := httptest.NewRequest(method, "/posts/1", nil)
+			if contentType != "" {
+				r.Header.Set("Content-Type", contentType)
+			}
+			rec := httptest.NewRecorder()
+			g.handler.ServeHTTP(rec, r)
+			if rec.Code != http.StatusNoContent {
+				t.Errorf("%s with Content-Type %q = %d %s, want it forwarded", method, contentType, rec.Code, rec.Body)
+			}
+			if want := method + " /posts/1"; len(got) != 1 || got[0] != want {
+				t.Errorf("%s with Content-Type %q reached the upstream as %q, want %q", method, contentType, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type quotaConfig struct {
+	Daily   int64
+	Monthly int64
+	File    string
+}
+
+// quotaLimits are the daily and monthly number of requests an API key may
+// have validated, 0 for no limit.
+type quotaLimits struct {
+	label   string
+	daily   int64
+	monthly int64
+}
+
+type quotaUsage struct {
+	day        string
+	dayCount   int64
+	month      string
+	monthCount int64
+}
+
+// quotas counts the requests validated for each API key, read from the
+// -api-key-header header, against daily and monthly quotas. Days and months
+// are UTC. Only the keys in -quota-file, SHA-256 hashes like those of
+// -admin-keys-file, are told apart, with their own quotas or -quota-daily and
+// -quota-monthly: anyone can make up a new key, so with a file any other key,
+// or none, is refused, and without one every request shares a single quota.
+// Counts are kept in memory, so they start over on restarts and are per
+// instance.
+type quotas struct {
+	header   string
+	defaults quotaLimits
+	keys     map[[sha256.Size]byte]quotaLimits
+	listed   bool
+
+	mu    sync.Mutex
+	usage map[[sha256.Size]byte]*quotaUsage
+	swept string
+}
+
+// sharedQuota is the hash the requests without a listed key are counted
+// under, which no key hashes to.
+var sharedQuota [sha256.Size]byte
+
+func newQuotas(cfg *quotaConfig, header string) (*quotas, error) {
+	q := &quotas{
+		header:   header,
+		defaults: quotaLimits{daily: cfg.Daily, monthly: cfg.Monthly},
+		keys:     make(map[[sha256.Size]byte]quotaLimits),
+		usage:    make(map[[sha256.Size]byte]*quotaUsage),
+	}
+	if cfg.File == "" {
+		return q, nil
+	}
+	q.listed = true
+
+	f, err := os.Open(cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		b, err := hex.DecodeString(fields[0])
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: expected a hex SHA-256 hash", cfg.File, n)
+		}
+		var hash [sha256.Size]byte
+		copy(hash[:], b)
+
+		limits := q.defaults
+		var label []string
+		for _, field := range fields[1:] {
+			name, value, _ := strings.Cut(field, "=")
+			var limit *int64
+			switch name {
+			case "daily":
+				limit = &limits.daily
+			case "monthly":
+				limit = &limits.monthly
+			default:
+				label = append(label, field)
+				continue
+			}
+			if *limit, err = strconv.ParseInt(value, 10, 64); err != nil || *limit < 0 {
+				return nil, fmt.Errorf("%s:%d: invalid %s quota %q", cfg.File, n, name, value)
+			}
+		}
+		limits.label = strings.Join(label, " ")
+		q.keys[hash] = limits
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// listedKey reports whether key is one of those in the quota file.
+func (q *quotas) listedKey(key string) bool {
+	if q == nil || key == "" {
+		return false
+	}
+	_, ok := q.keys[sha256.Sum256([]byte(key))]
+	return ok
+}
+
+// limits returns the quotas of the key with hash.
+func (q *quotas) limits(hash [sha256.Size]byte) quotaLimits {
+	limits, ok := q.keys[hash]
+	if !ok {
+		limits = q.defaults
+	}
+	if hash == sharedQuota {
+		limits.label = "unlisted keys"
+	}
+	if limits.label == "" {
+		limits.label = "key " + hex.EncodeToString(hash[:4])
+	}
+	return limits
+}
+
+// quotaExceeded is the quota a request went over.
+type quotaExceeded struct {
+	period string
+	limit  int64
+	resets time.Time
+}
+
+// take counts a request for the key with hash unless it would go over one
+// of its quotas, returning the remaining number of requests of the quota
+// closest to running out, -1 when there are no limits.
+func (q *quotas) take(hash [sha256.Size]byte, now time.Time) (int64, *quotaExceeded) {
+	limits := q.limits(hash)
+	now = now.UTC()
+	day, month := now.Format(time.DateOnly), now.Format("2006-01")
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.sweep(day, month)
+	u, ok := q.usage[hash]
+	if !ok {
+		u = &quotaUsage{}
+		q.usage[hash] = u
+	}
+	if u.day != day {
+		u.day, u.dayCount = day, 0
+	}
+	if u.month != month {
+		u.month, u.monthCount = month, 0
+	}
+
+	if limits.daily > 0 && u.dayCount >= limits.daily {
+		return 0, &quotaExceeded{period: "daily", limit: limits.daily, resets: nextDay(now)}
+	}
+	if limits.monthly > 0 && u.monthCount >= limits.monthly {
+		return 0, &quotaExceeded{period: "monthly", limit: limits.monthly, resets: nextMonth(now)}
+	}
+	u.dayCount++
+	u.monthCount++
+
+	remaining := int64(-1)
+	if limits.daily > 0 {
+		remaining = limits.daily - u.dayCount
+	}
+	if limits.monthly > 0 && (remaining < 0 || limits.monthly-u.monthCount < remaining) {
+		remaining = limits.monthly - u.monthCount
+	}
+	return remaining, nil
+}
+
+// sweep drops the usage left from past months, once a day.
+func (q *quotas) sweep(day, month string) {
+	if q.swept == day {
+		return
+	}
+	q.swept = day
+
+	for hash, u := range q.usage {
+		if u.month != month {
+			delete(q.usage, hash)
+		}
+	}
+}
+
+func nextDay(now time.Time) time.Time {
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+func nextMonth(now time.Time) time.Time {
+	y, m, _ := now.Date()
+	return time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// enforceQuotas rejects requests whose API key has used up its quota with a
+// 429 saying which quota and when it resets, and tells the rest how many
+// requests they have left in X-Quota-Remaining. With -quota-file, requests
+// without one of its keys are rejected with a 401.
+func enforceQuotas(q *quotas, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(q.header)
+		hash := sha256.Sum256([]byte(key))
+		if _, ok := q.keys[hash]; !ok || key == "" {
+			if q.listed {
+				msg := fmt.Sprintf("an API key in the %s header is required", q.header)
+				if key != "" {
+					msg = fmt.Sprintf("unknown API key in the %s header", q.header)
+				}
+				writeErrors(w, http.StatusUnauthorized, codeUnauthorized, msg)
+				return
+			}
+			hash = sharedQuota
+		}
+
+		now := time.Now()
+		remaining, exceeded := q.take(hash, now)
+		if exceeded != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(exceeded.resets.Sub(now).Round(time.Second).Seconds())))
+			w.Header().Set("X-Quota-Remaining", "0")
+			msg := fmt.Sprintf("%s quota of %d requests exceeded, it resets at %s", exceeded.period, exceeded.limit, exceeded.resets.Format(time.RFC3339))
+			writeErrors(w, http.StatusTooManyRequests, codeQuotaExceeded, msg)
+			return
+		}
+		if remaining >= 0 {
+			w.Header().Set("X-Quota-Remaining", strconv.FormatInt(remaining, 10))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type quotaPeriod struct {
+	Used     int64     `json:"used"`
+	Limit    int64     `json:"limit,omitempty"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+type quotaReport struct {
+	Key     string      `json:"key"`
+	Daily   quotaPeriod `json:"daily"`
+	Monthly quotaPeriod `json:"monthly"`
+}
+
+// handle reports the usage of every API key seen, and of the keys in
+// -quota-file that haven't been, against their quotas.
+func (q *quotas) handle(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	day, month := now.Format(time.DateOnly), now.Format("2006-01")
+
+	q.mu.Lock()
+	reports := make([]quotaReport, 0, len(q.usage))
+	seen := make(map[[sha256.Size]byte]bool)
+	report := func(hash [sha256.Size]byte, u *quotaUsage) {
+		limits := q.limits(hash)
+		rep := quotaReport{
+			Key:     limits.label,
+			Daily:   quotaPeriod{Limit: limits.daily, ResetsAt: nextDay(now)},
+			Monthly: quotaPeriod{Limit: limits.monthly, ResetsAt: nextMonth(now)},
+		}
+		if u != nil && u.day == day {
+			rep.Daily.Used = u.dayCount
+		}
+		if u != nil && u.month == month {
+			rep.Monthly.Used = u.monthCount
+		}
+		reports = append(reports, rep)
+		seen[hash] = true
+	}
+	for hash, u := range q.usage {
+		report(hash, u)
+	}
+	for hash := range q.keys {
+		if !seen[hash] {
+			report(hash, nil)
+		}
+	}
+	q.mu.Unlock()
+
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Key < reports[j].Key })
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Keys []quotaReport `json:"keys"`
+	}{reports}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
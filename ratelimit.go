@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type rateLimitConfig struct {
+	Rate         float64
+	Burst        int
+	By           string
+	APIKeyHeader string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per client key. Every key function derived
+// from the config must have a token available for a request to go through.
+// API keys only get a bucket of their own when they are listed in the quota
+// file, made up keys would otherwise each get a fresh one.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	keyFns []func(*http.Request) string
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newRateLimiter(cfg *rateLimitConfig, known *quotas) (*rateLimiter, error) {
+	l := &rateLimiter{
+		rate:    cfg.Rate,
+		burst:   float64(cfg.Burst),
+		buckets: make(map[string]*bucket),
+	}
+	if l.burst < 1 {
+		l.burst = 1
+	}
+
+	for _, by := range strings.Split(cfg.By, ",") {
+		switch strings.TrimSpace(by) {
+		case "ip":
+			l.keyFns = append(l.keyFns, func(r *http.Request) string {
+				return "ip:" + clientIP(r)
+			})
+		case "api-key":
+			header := cfg.APIKeyHeader
+			l.keyFns = append(l.keyFns, func(r *http.Request) string {
+				key := r.Header.Get(header)
+				if key != "" && known.listedKey(key) {
+					return "key:" + key
+				}
+				if key != "" {
+					return "unlisted-keys"
+				}
+				return "ip:" + clientIP(r)
+			})
+		case "client-cert":
+			l.keyFns = append(l.keyFns, func(r *http.Request) string {
+				if id := clientIdentity(r); id != "" {
+					return "cert:" + id
+				}
+				return "ip:" + clientIP(r)
+			})
+		default:
+			return nil, fmt.Errorf("unknown rate limit key %q, expected ip, api-key or client-cert", by)
+		}
+	}
+
+	return l, nil
+}
+
+// setLimits changes the rate and burst, applying to existing buckets from
+// their next request.
+func (l *rateLimiter) setLimits(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate, l.burst = rate, math.Max(float64(burst), 1)
+}
+
+// take consumes a token from the buckets for every one of keys, returning
+// how long the caller has to wait before they all have one available if any
+// of them is empty. Nothing is taken unless every bucket has a token.
+func (l *rateLimiter) take(keys []string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	var wait time.Duration
+	buckets := make(map[string]*bucket, len(keys))
+	for _, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{tokens: l.burst, last: now}
+			l.buckets[key] = b
+		}
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+		buckets[key] = b
+
+		if b.tokens < 1 {
+			wait = max(wait, time.Duration((1-b.tokens)/l.rate*float64(time.Second)))
+		}
+	}
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// sweep drops buckets that have been idle long enough to refill completely,
+// since they are indistinguishable from a fresh bucket.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func rateLimit(l *rateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := make([]string, len(l.keyFns))
+		for i, keyFn := range l.keyFns {
+			keys[i] = keyFn(r)
+		}
+		if ok, wait := l.take(keys, time.Now()); !ok {
+			retry := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			writeErrors(w, http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type recordConfig struct {
+	File    string
+	Percent float64
+	Redact  string
+}
+
+// recording is one request captured by -record-file, a line of JSON.
+type recording struct {
+	Time    time.Time         `json:"time"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Schema  string            `json:"schema,omitempty"`
+	Version string            `json:"version,omitempty"`
+	Body    json.RawMessage   `json:"body"`
+	Status  int               `json:"status"`
+	Errors  []string          `json:"errors,omitempty"`
+}
+
+// redactedValue replaces the values of redacted fields and headers.
+const redactedValue = "[REDACTED]"
+
+// recorder appends recordings to a file in the background, dropping them
+// rather than holding up requests when the writer falls behind.
+type recorder struct {
+	cfg    *recordConfig
+	redact map[string]bool
+	queue  chan *recording
+}
+
+func newRecorder(cfg *recordConfig) (*recorder, error) {
+	f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &recorder{cfg: cfg, redact: make(map[string]bool), queue: make(chan *recording, 1024)}
+	for _, name := range strings.Split(cfg.Redact, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			rec.redact[strings.ToLower(name)] = true
+		}
+	}
+
+	go func() {
+		w := bufio.NewWriter(f)
+		enc := json.NewEncoder(w)
+		for r := range rec.queue {
+			enc.Encode(r)
+			// Flush once the queue is drained, so a quiet gateway doesn't
+			// sit on recordings.
+			if len(rec.queue) == 0 {
+				w.Flush()
+			}
+		}
+	}()
+	return rec, nil
+}
+
+func (rec *recorder) add(r *recording) {
+	select {
+	case rec.queue <- r:
+		metricRecorded.Add(1)
+	default:
+		metricRecordDropped.Add(1)
+	}
+}
+
+// recordedHeaders keeps the headers that can select a schema, dropping
+// credentials.
+func (rec *recorder) recordedHeaders(h http.Header) map[string]string {
+	headers := make(map[string]string)
+	for name, values := range h {
+		switch lower := strings.ToLower(name); {
+		case lower == "authorization" || lower == "proxy-authorization" || lower == "cookie":
+			continue
+		case rec.redact[lower]:
+			headers[name] = redactedValue
+		default:
+			headers[name] = strings.Join(values, ", ")
+		}
+	}
+	return headers
+}
+
+// redactBody replaces the values of redacted fields, at any depth, keeping
+// strings strings and numbers numbers so the body stays close to what the
+// schema saw.
+func (rec *recorder) redactBody(body []byte) json.RawMessage {
+	v, err := decodeJSON(body)
+	if err != nil {
+		return json.RawMessage(fmt.Sprintf("%q", redactedValue))
+	}
+	if len(rec.redact) == 0 {
+		return json.RawMessage(body)
+	}
+
+	var walk func(v interface{}, redacted bool) interface{}
+	walk = func(v interface{}, redacted bool) interface{} {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				v[key] = walk(value, redacted || rec.redact[strings.ToLower(key)])
+			}
+		case []interface{}:
+			for i, value := range v {
+				v[i] = walk(value, redacted)
+			}
+		case string:
+			if redacted {
+				return redactedValue
+			}
+		case json.Number:
+			if redacted {
+				return json.Number("0")
+			}
+		}
+		return v
+	}
+
+	b, err := encodeJSON(walk(v, false))
+	if err != nil {
+		return json.RawMessage(fmt.Sprintf("%q", redactedValue))
+	}
+	return json.RawMessage(bytes.TrimSpace(b))
+}
+
+// recordTraffic records the requests it passes on together with their
+// outcome, the status and, when the gateway rejected them, the errors.
+func recordTraffic(rec *recorder, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rec.cfg.Percent < 100 && rand.Float64()*100 >= rec.cfg.Percent {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		entry := &recording{
+			Time:    time.Now().UTC(),
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Headers: rec.recordedHeaders(r.Header),
+			Body:    rec.redactBody(body),
+		}
+		if schema := requestSchema(r); schema != nil {
+			entry.Schema, entry.Version = schema.Name, schema.Version
+		}
+
+		replaceBody(r, body)
+		rw := &outcomeWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		entry.Status = rw.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.Errors = rw.errors()
+		rec.add(entry)
+	})
+}
+
+// outcomeWriter remembers the status and the start of error bodies.
+type outcomeWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *outcomeWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *outcomeWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 && w.body.Len() < 64<<10 {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// errors returns the messages of an error response, none for successes.
+func (w *outcomeWriter) errors() []string {
+	if w.status < 400 {
+		return nil
+	}
+
+	var errBody struct {
+		Errors []errorEntry `json:"errors"`
+	}
+	if json.Unmarshal(w.body.Bytes(), &errBody) != nil || errBody.Errors == nil {
+		return nil
+	}
+	return entryMessages(errBody.Errors)
+}
+
+func (w *outcomeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// runReplay implements the replay command: the requests in a -record-file
+// are validated again against the schemas the server's flags load, and
+// every request whose outcome would change is listed.
+func runReplay(args []string) error {
+	cfg := parseConfig(args)
+	if len(cfg.Args) != 1 {
+		return errors.New("usage: schema-validations replay [flags] <recording file>")
+	}
+
+	store, err := newSchemaStore(&cfg.Schemas)
+	if err != nil {
+		return fmt.Errorf("failed to load schemas: %v", err)
+	}
+	registry := store.current()
+
+	f, err := os.Open(cfg.Args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	replayed, changed := 0, 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var entry recording
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("%s:%d: %v", cfg.Args[0], line, err)
+		}
+
+		req := httptest.NewRequest(entry.Method, "http://replay"+entry.Path, bytes.NewReader(entry.Body))
+		for name, value := range entry.Headers {
+			req.Header.Set(name, value)
+		}
+		msgs, err := pactErrors(registry, req, entry.Body)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", cfg.Args[0], line, err)
+		}
+		replayed++
+
+		before := append([]string(nil), entry.Errors...)
+		sort.Strings(before)
+		sort.Strings(msgs)
+		if strings.Join(before, "\n") == strings.Join(msgs, "\n") {
+			continue
+		}
+
+		changed++
+		was, now := "accepted", "accepted"
+		if len(before) > 0 {
+			was = "rejected"
+		}
+		if len(msgs) > 0 {
+			now = "rejected"
+		}
+		fmt.Printf("CHANGED %s %s at %s, was %s, now %s, - before, + after:\n", entry.Method, entry.Path, entry.Time.Format(time.RFC3339), was, now)
+		fmt.Print(fixtureDiff(before, msgs))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%d requests replayed, %d changed\n", replayed, changed)
+	if changed > 0 {
+		return errFailed
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !wasm
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals make serve reload the schemas.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
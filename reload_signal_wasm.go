@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// reloadSignals is empty, there is no SIGHUP under WebAssembly.
+var reloadSignals []os.Signal
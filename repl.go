@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// runREPL implements the repl command: JSON documents pasted or typed in,
+// over as many lines as they take, are validated against the schema file as
+// soon as they are complete. The file is watched, and when it changes it is
+// compiled again and the last document validated against it, so a schema
+// can be edited with its results in view.
+func runREPL(args []string) error {
+	fs := flag.NewFlagSet("schema-validations repl", flag.ExitOnError)
+	file := fs.String("schema", "", "schema file to validate documents against")
+	length := fs.String("string-length", "runes", "how minLength and maxLength count characters: runes, bytes or graphemes")
+	interval := fs.Duration("watch-interval", 500*time.Millisecond, "how often to check the schema file for changes")
+	fs.Parse(args)
+
+	if *file == "" || fs.NArg() > 0 {
+		return errors.New("usage: schema-validations repl -schema <file>")
+	}
+	if err := setStringLength(*length); err != nil {
+		return err
+	}
+
+	repl := &repl{file: *file, out: os.Stdout}
+	if err := repl.load(); err != nil {
+		return err
+	}
+	info, _ := os.Stdin.Stat()
+	repl.prompt = info != nil && info.Mode()&os.ModeCharDevice != 0
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(*interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				repl.reloadIfChanged()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	fmt.Fprintf(repl.out, "validating against %s, paste JSON documents, :help for commands\n", *file)
+	return repl.run(os.Stdin)
+}
+
+type repl struct {
+	file   string
+	out    io.Writer
+	prompt bool
+
+	mu      sync.Mutex
+	schema  *schemaVersion
+	modTime time.Time
+	last    []byte
+}
+
+// load compiles the schema file.
+func (r *repl) load() error {
+	info, err := os.Stat(r.file)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(r.file)
+	if err != nil {
+		return err
+	}
+	compiled, err := compileSchema(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", r.file, err)
+	}
+
+	name, version := parseSchemaFilename(filepath.Base(r.file))
+	r.schema = compiled.version(name, version, data)
+	r.modTime = info.ModTime()
+	return nil
+}
+
+// reloadIfChanged compiles the schema file again once it has changed,
+// keeping the previous schema when it doesn't compile, and shows the last
+// document's results against it.
+func (r *repl) reloadIfChanged() {
+	info, err := os.Stat(r.file)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if info.ModTime().Equal(r.modTime) {
+		return
+	}
+	if err := r.load(); err != nil {
+		// Don't report the same broken file again until it changes.
+		r.modTime = info.ModTime()
+		fmt.Fprintf(r.out, "\nnot reloaded, keeping the previous schema: %v\n", err)
+		r.showPrompt(false)
+		return
+	}
+	fmt.Fprintf(r.out, "\nreloaded %s\n", r.file)
+	if r.last != nil {
+		r.validate(r.last)
+	}
+	r.showPrompt(false)
+}
+
+func (r *repl) run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64<<10), 64<<20)
+	var doc bytes.Buffer
+
+	r.mu.Lock()
+	r.showPrompt(false)
+	r.mu.Unlock()
+	for scanner.Scan() {
+		r.mu.Lock()
+		quit := r.line(scanner.Text(), &doc)
+		r.mu.Unlock()
+		if quit {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// line adds a line of input to doc, validating doc once it is a complete
+// document, and reports whether it was the command to quit.
+func (r *repl) line(line string, doc *bytes.Buffer) bool {
+	if doc.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+		if r.command(strings.TrimSpace(line)) {
+			return true
+		}
+		r.showPrompt(false)
+		return false
+	}
+
+	doc.WriteString(line)
+	doc.WriteByte('\n')
+	if len(bytes.TrimSpace(doc.Bytes())) == 0 {
+		doc.Reset()
+		r.showPrompt(false)
+		return false
+	}
+
+	var raw json.RawMessage
+	err := json.Unmarshal(doc.Bytes(), &raw)
+	if err != nil && isTruncatedJSON(doc.Bytes()) {
+		// Not finished yet.
+		r.showPrompt(true)
+		return false
+	}
+	if err != nil {
+		fmt.Fprintf(r.out, "not valid JSON: %v\n", err)
+	} else {
+		r.last = append([]byte(nil), doc.Bytes()...)
+		r.validate(r.last)
+	}
+	doc.Reset()
+	r.showPrompt(false)
+	return false
+}
+
+// command runs a : command, reporting whether it is the one to quit.
+func (r *repl) command(cmd string) bool {
+	switch cmd {
+	case ":quit", ":q", ":exit":
+		return true
+	case ":reload":
+		if err := r.load(); err != nil {
+			fmt.Fprintf(r.out, "not reloaded, keeping the previous schema: %v\n", err)
+			break
+		}
+		fmt.Fprintf(r.out, "reloaded %s\n", r.file)
+	case ":last":
+		if r.last == nil {
+			fmt.Fprintln(r.out, "no document yet")
+			break
+		}
+		r.validate(r.last)
+	case ":schema":
+		fmt.Fprintf(r.out, "%s\n", bytes.TrimSpace(r.schema.Data))
+	default:
+		fmt.Fprintln(r.out, ":last     validate the last document again")
+		fmt.Fprintln(r.out, ":reload   compile the schema file again")
+		fmt.Fprintln(r.out, ":schema   print the schema")
+		fmt.Fprintln(r.out, ":quit     leave, as does the end of input")
+	}
+	return false
+}
+
+// validate prints the results of validating doc, one error per line with
+// the JSON pointer of the value and the keyword it fails.
+func (r *repl) validate(doc []byte) {
+	errs, err := bodyErrors(r.schema, doc)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+	msgs, _ := validateBody(r.schema, doc)
+	if len(msgs) == 0 {
+		fmt.Fprintln(r.out, "valid")
+		return
+	}
+	if len(errs) == 0 {
+		// Only cross-field constraints failed, they have messages alone.
+		fmt.Fprintln(r.out, "invalid:")
+		for _, msg := range msgs {
+			fmt.Fprintf(r.out, "  %s\n", msg)
+		}
+		return
+	}
+
+	fmt.Fprintln(r.out, "invalid:")
+	for _, e := range errs {
+		pointer := schemavalidation.Pointer(e.Context())
+		if pointer == "" {
+			pointer = "/"
+		}
+		keyword, ok := errorKeywords[e.Type()]
+		if !ok {
+			keyword = e.Type()
+		}
+		fmt.Fprintf(r.out, "  %-20s %-16s %s\n", pointer, keyword, e.Description())
+	}
+}
+
+func (r *repl) showPrompt(continued bool) {
+	if !r.prompt {
+		return
+	}
+	if continued {
+		fmt.Fprint(r.out, "... ")
+		return
+	}
+	fmt.Fprint(r.out, "> ")
+}
+
+// isTruncatedJSON reports whether b, which doesn't parse, ends before the
+// document it starts is complete rather than having an error.
+func isTruncatedJSON(b []byte) bool {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for {
+		if _, err := dec.Token(); err != nil {
+			return err == io.EOF || err == io.ErrUnexpectedEOF
+		}
+	}
+}
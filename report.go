@@ -0,0 +1,203 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+type reportConfig struct {
+	Path      string
+	CacheTTL  time.Duration
+	CacheSize int
+}
+
+type validationReport struct {
+	Valid   bool     `json:"valid"`
+	Schema  string   `json:"schema"`
+	Version string   `json:"version"`
+	Errors  []string `json:"errors"`
+}
+
+// reportSchema selects the schema a document posted to the validation
+// report endpoint is checked against: the one named by the schema query
+// parameter, <name>.<version> or <name> for the latest version, falling back
+// to the selection every request goes through.
+func reportSchema(schemas *schemaStore, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("schema")
+		if id == "" {
+			selectSchema(schemas, next).ServeHTTP(w, r)
+			return
+		}
+
+		registry := schemas.current()
+		name, version := splitSchemaID(id)
+		schema, ok := registry.find(r, name, version)
+		if !ok {
+			writeErrors(w, http.StatusNotFound, codeUnknownSchema, fmt.Sprintf("no schema %q at version %q", name, version))
+			return
+		}
+		schema, err := registry.applyRules(r, schema)
+		var selErr *selectionError
+		if errors.As(err, &selErr) {
+			writeErrors(w, selErr.status, selErr.code, selErr.msg)
+			return
+		}
+		schema.setDeprecationHeaders(w.Header())
+
+		ctx := context.WithValue(r.Context(), schemaKey{}, schema)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// reportValidation serves the validation report endpoint: the posted
+// document is validated and the outcome reported as a 200 either way,
+// without anything being forwarded, for documentation portals and editors
+// to check examples with. Reports don't depend on the runtime settings.
+// Reports are in the endpoint's own format unless one of the standard output
+// formats is asked for. With a cache TTL, reports are kept by schema version,
+// body hash and format for that long and served with a Cache-Control max-age
+// to match.
+func reportValidation(limits *jsonLimits, cache *reportCache) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		format, err := requestedOutput(r)
+		if err != nil {
+			writeErrors(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		schema := requestSchema(r)
+		key := reportKey{resultKey{schema: schema, sum: sha256.Sum256(body)}, format}
+
+		b, ok := cache.get(key, time.Now())
+		if ok {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			if err := limits.check(body); err != nil {
+				writeErrors(w, http.StatusBadRequest, codeOf(err, codeInvalidJSON), err.Error())
+				return
+			}
+			result, err := schema.Schema.Validate(gojsonschema.NewBytesLoader(body))
+			if err != nil {
+				writeErrors(w, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("request body is not valid JSON: %v", err))
+				return
+			}
+
+			errs := schemaErrors(schema, body, result)
+			var report interface{} = formatOutput(format, schema.Document, schemavalidation.OrderErrors(errs))
+			if format == "" {
+				msgs := errorMessages(errs)
+				if msgs == nil {
+					msgs = []string{}
+				}
+				report = validationReport{Valid: len(msgs) == 0, Schema: schema.Name, Version: schema.Version, Errors: msgs}
+			}
+			if b, err = encodeJSON(report); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if cache != nil {
+				w.Header().Set("X-Cache", "MISS")
+				cache.put(key, b, time.Now())
+			}
+		}
+
+		if cache != nil {
+			etag := sha256.Sum256(append([]byte(schema.Tenant+"/"+schema.Name+"."+schema.Version+"/"+format+"/"), key.sum[:]...))
+			w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(cache.ttl.Seconds())))
+			w.Header().Set("ETag", `"`+hex.EncodeToString(etag[:16])+`"`)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
+
+// reportKey identifies a report by what was validated and the output format
+// it's in.
+type reportKey struct {
+	resultKey
+	format string
+}
+
+type cachedReport struct {
+	key     reportKey
+	body    []byte
+	expires time.Time
+}
+
+// reportCache keeps encoded validation reports for a TTL, evicting the least
+// recently used once it holds size of them.
+type reportCache struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[reportKey]*list.Element
+}
+
+func newReportCache(ttl time.Duration, size int) *reportCache {
+	return &reportCache{
+		ttl:     ttl,
+		size:    size,
+		order:   list.New(),
+		entries: make(map[reportKey]*list.Element),
+	}
+}
+
+func (c *reportCache) get(key reportKey, now time.Time) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	report := e.Value.(*cachedReport)
+	if now.After(report.expires) {
+		c.order.Remove(e)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return report.body, true
+}
+
+func (c *reportCache) put(key reportKey, body []byte, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.order.Remove(e)
+	}
+	c.entries[key] = c.order.PushFront(&cachedReport{key: key, body: body, expires: now.Add(c.ttl)})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedReport).key)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// requestIDHeader carries the request ID in both directions. It is set from
+// -request-id-header.
+var requestIDHeader = "X-Request-Id"
+
+// requestIDField is the field of error bodies holding the request ID, so a
+// rejected caller can quote it and support can find the request in the logs.
+const requestIDField = "request_id"
+
+type requestIDKey struct{}
+
+// withRequestID keeps the request ID a client or load balancer sent, if it
+// is reasonable, or makes one up, passes it upstream and echoes it back in
+// the response.
+func withRequestID(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			r.Header.Set(requestIDHeader, id)
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID of r, empty outside withRequestID.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// closeErrorBody ends an error body with the request ID taken from the
+// response headers, where withRequestID put it.
+func closeErrorBody(buf *bytes.Buffer, w http.ResponseWriter) {
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		buf.WriteString(`,"` + requestIDField + `":`)
+		writeJSONString(buf, id)
+	}
+	buf.WriteByte('}')
+}
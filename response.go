@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// responseSchemas is a flag.Value accepting repeated "prefix=name.version"
+// pairs naming the schema upstream responses on a route must satisfy.
+// Routes are matched by longest path prefix.
+type responseSchemas map[string]string
+
+func (s responseSchemas) String() string {
+	var pairs []string
+	for prefix, id := range s {
+		pairs = append(pairs, prefix+"="+id)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (s responseSchemas) Set(v string) error {
+	i := strings.LastIndex(v, "=")
+	if i <= 0 || i == len(v)-1 {
+		return fmt.Errorf("expected prefix=name.version, got %q", v)
+	}
+
+	s[v[:i]] = v[i+1:]
+	return nil
+}
+
+func (s responseSchemas) forPath(path string) (string, bool) {
+	id, matched := "", ""
+	for prefix, v := range s {
+		if strings.HasPrefix(path, prefix) && len(prefix) >= len(matched) {
+			id, matched = v, prefix
+		}
+	}
+
+	return id, id != ""
+}
+
+// responseViolation is returned from the proxy's response hook to have the
+// response replaced with a 502.
+type responseViolation struct {
+	msgs []string
+}
+
+func (v *responseViolation) Error() string {
+	return "upstream response failed validation: " + strings.Join(v.msgs, "; ")
+}
+
+// responseValidator checks successful JSON responses from the upstream
+// against the schema configured for their route. Violations are logged,
+// flagged with a header, or turned into a 502, depending on mode.
+type responseValidator struct {
+	schemas *schemaStore
+	routes  responseSchemas
+	mode    string
+}
+
+func newResponseValidator(schemas *schemaStore, routes responseSchemas, mode string) (*responseValidator, error) {
+	switch mode {
+	case "log", "header", "reject":
+	default:
+		return nil, fmt.Errorf("invalid response violation mode %q, expected log, header or reject", mode)
+	}
+
+	for prefix, id := range routes {
+		if _, ok := schemas.current().base.lookup(parseSchemaFilename(id + ".json")); !ok {
+			return nil, fmt.Errorf("response schema %q for %s is not loaded", id, prefix)
+		}
+	}
+
+	return &responseValidator{schemas: schemas, routes: routes, mode: mode}, nil
+}
+
+func (v *responseValidator) modifyResponse(resp *http.Response) error {
+	id, ok := v.routes.forPath(resp.Request.URL.Path)
+	if !ok || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return nil
+	}
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		log.Printf("not validating %s response for %s, it is %s encoded", id, resp.Request.URL.Path, enc)
+		return nil
+	}
+
+	schema, ok := v.schemas.current().base.lookup(parseSchemaFilename(id + ".json"))
+	if !ok {
+		return fmt.Errorf("response schema %q is no longer loaded", id)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	result, err := schema.Schema.Validate(gojsonschema.NewBytesLoader(body))
+	if err != nil {
+		return &responseViolation{msgs: []string{"upstream response is not valid JSON"}}
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	msgs := errorMessages(schemaErrors(schema, body, result))
+	switch v.mode {
+	case "reject":
+		return &responseViolation{msgs: msgs}
+	case "header":
+		resp.Header.Set("X-Response-Schema-Violation", strings.Join(msgs, "; "))
+	}
+	log.Printf("upstream response for %s %s violates %s: %s", resp.Request.Method, resp.Request.URL.Path, id, strings.Join(msgs, "; "))
+
+	return nil
+}
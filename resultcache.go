@@ -0,0 +1,79 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+)
+
+type resultKey struct {
+	schema *schemaVersion
+	sum    [sha256.Size]byte
+}
+
+type cachedResult struct {
+	key  resultKey
+	msgs []string
+}
+
+// resultCache remembers the validation errors, none for a valid body, of the
+// most recently validated bodies per schema version so retried payloads
+// don't pay for validation twice. It evicts least recently used entries once
+// it holds size of them.
+type resultCache struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[resultKey]*list.Element
+}
+
+func newResultCache(size int) *resultCache {
+	return &resultCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[resultKey]*list.Element),
+	}
+}
+
+func (c *resultCache) get(key resultKey) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		metricResultCacheMisses.Add(1)
+		return nil, false
+	}
+
+	metricResultCacheHits.Add(1)
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedResult).msgs, true
+}
+
+func (c *resultCache) put(key resultKey, msgs []string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*cachedResult).msgs = msgs
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cachedResult{key: key, msgs: msgs})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResult).key)
+	}
+	metricResultCacheEntries.Set(int64(c.order.Len()))
+}
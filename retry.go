@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// backoff spaces out retries exponentially from Base up to Max, with full
+// jitter so instances retrying together don't stay in lockstep.
+type backoff struct {
+	Retries int
+	Base    time.Duration
+	Max     time.Duration
+}
+
+func (b *backoff) delay(attempt int) time.Duration {
+	d := b.Base << uint(attempt)
+	if d > b.Max || d <= 0 {
+		d = b.Max
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// retry calls fn until it succeeds or the retries are used up, returning the
+// last error.
+func (b *backoff) retry(fn func(attempt int) error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = fn(attempt); err == nil || attempt >= b.Retries {
+			return err
+		}
+		time.Sleep(b.delay(attempt))
+	}
+}
+
+// retryTransport retries GETs that fail with a transport error, a 5xx or a
+// 429. Calls refused by an open circuit breaker aren't retried.
+type retryTransport struct {
+	backoff *backoff
+	next    http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.next.RoundTrip(req)
+	}
+
+	var resp *http.Response
+	var rtErr error
+	t.backoff.retry(func(attempt int) error {
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		resp, rtErr = t.next.RoundTrip(req)
+		switch {
+		case errors.Is(rtErr, errCircuitOpen), req.Context().Err() != nil:
+			return nil
+		case rtErr != nil:
+			return rtErr
+		case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests:
+			return errors.New(resp.Status)
+		}
+		return nil
+	})
+
+	return resp, rtErr
+}
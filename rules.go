@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// rule adjusts the schema a request is validated against when the request
+// matches its conditions. Use switches to another schema version, given as
+// <name>.<version>, Optional and Require edit the top level required list and
+// Constraints is an extra schema the body must also satisfy.
+type rule struct {
+	When        ruleConditions         `json:"when"`
+	Schema      string                 `json:"schema"`
+	Use         string                 `json:"use"`
+	Optional    []string               `json:"optional"`
+	Require     []string               `json:"require"`
+	Constraints map[string]interface{} `json:"constraints"`
+
+	mu       sync.Mutex
+	variants map[*schemaVersion]*schemaVersion
+}
+
+// ruleConditions must all hold for a rule to apply. Claims compare against
+// the string form of the claim, or any element of an array claim.
+type ruleConditions struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Claims  map[string]string `json:"claims"`
+}
+
+// loadRules reads a JSON array of rules from file.
+func (s *schemaRegistry) loadRules(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var rules []*rule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	for i, rl := range rules {
+		if rl.Use != "" {
+			if _, ok := s.base.lookup(parseSchemaFilename(rl.Use + ".json")); !ok {
+				return fmt.Errorf("%s: rule %d: no schema version %q is loaded", file, i, rl.Use)
+			}
+		}
+		if rl.Constraints != nil {
+			if _, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(rl.Constraints)); err != nil {
+				return fmt.Errorf("%s: rule %d: invalid constraints: %v", file, i, err)
+			}
+		}
+		rl.variants = make(map[*schemaVersion]*schemaVersion)
+	}
+
+	s.rules = rules
+	return nil
+}
+
+func (c *ruleConditions) match(r *http.Request) bool {
+	if c.Method != "" && !strings.EqualFold(c.Method, r.Method) {
+		return false
+	}
+	if !strings.HasPrefix(r.URL.Path, c.Path) {
+		return false
+	}
+	for name, want := range c.Headers {
+		if r.Header.Get(name) != want {
+			return false
+		}
+	}
+
+	claims := requestClaims(r)
+	for name, want := range c.Claims {
+		if !claimMatches(claims, name, want) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func claimMatches(claims jwt.MapClaims, name, want string) bool {
+	switch v := claims[name].(type) {
+	case nil:
+		return false
+	case []interface{}:
+		for _, e := range v {
+			if fmt.Sprint(e) == want {
+				return true
+			}
+		}
+		return false
+	default:
+		return fmt.Sprint(v) == want
+	}
+}
+
+// applyRules lets the first rule matching r adjust the schema selected for
+// it.
+func (s *schemaRegistry) applyRules(r *http.Request, schema *schemaVersion) (*schemaVersion, error) {
+	for _, rl := range s.rules {
+		if rl.Schema != "" && rl.Schema != schema.Name && rl.Schema != schema.Name+"."+schema.Version {
+			continue
+		}
+		if !rl.When.match(r) {
+			continue
+		}
+
+		if rl.Use != "" {
+			name, version := parseSchemaFilename(rl.Use + ".json")
+			schema, _ = s.find(r, name, version)
+		}
+
+		variant, err := rl.variant(schema)
+		if err != nil {
+			return nil, &selectionError{
+				status: http.StatusInternalServerError,
+				code:   codeInternal,
+				msg:    fmt.Sprintf("failed to apply rule to schema %q at version %q: %v", schema.Name, schema.Version, err),
+			}
+		}
+		return variant, nil
+	}
+
+	return schema, nil
+}
+
+// variant returns schema adjusted by the rule, compiling it the first time
+// each schema version is seen.
+func (rl *rule) variant(schema *schemaVersion) (*schemaVersion, error) {
+	if len(rl.Optional) == 0 && len(rl.Require) == 0 && rl.Constraints == nil {
+		return schema, nil
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if v, ok := rl.variants[schema]; ok {
+		return v, nil
+	}
+
+	doc, ok := copyJSON(schema.Document).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema %s %s is not an object", schema.Name, schema.Version)
+	}
+
+	optional := make(map[string]bool)
+	for _, name := range rl.Optional {
+		optional[name] = true
+	}
+	var required []interface{}
+	if list, ok := doc["required"].([]interface{}); ok {
+		for _, name := range list {
+			if s, _ := name.(string); !optional[s] {
+				required = append(required, name)
+			}
+		}
+	}
+	for _, name := range rl.Require {
+		required = append(required, name)
+	}
+	if len(required) > 0 {
+		doc["required"] = required
+	} else {
+		delete(doc, "required")
+	}
+
+	if rl.Constraints != nil {
+		allOf, _ := doc["allOf"].([]interface{})
+		doc["allOf"] = append(allOf, copyJSON(rl.Constraints))
+	}
+
+	data, err := encodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	v := *schema
+	v.Data, v.Document, v.Schema = data, doc, compiled
+	rl.variants[schema] = &v
+	return &v, nil
+}
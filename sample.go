@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// sampleValue makes up a value satisfying the schema at node, preferring
+// what the schema itself offers: const, examples, default and enum. It's
+// good enough for generating payloads, not a general solver; patterns in
+// particular are only satisfied by luck.
+func sampleValue(root, node interface{}, depth int) interface{} {
+	parts := schemavalidation.SchemaParts(root, node)
+	if depth > schemavalidation.MaxSchemaDepth {
+		return nil
+	}
+
+	for _, part := range parts {
+		if v, ok := part["const"]; ok {
+			return copyJSON(v)
+		}
+		if examples, ok := part["examples"].([]interface{}); ok && len(examples) > 0 {
+			return copyJSON(examples[0])
+		}
+		if v, ok := part["default"]; ok {
+			return copyJSON(v)
+		}
+		if enum, ok := part["enum"].([]interface{}); ok && len(enum) > 0 {
+			return copyJSON(enum[0])
+		}
+	}
+
+	if schemaTypes(parts) == nil {
+		for _, part := range parts {
+			_, props := part["properties"]
+			_, items := part["items"]
+			if props || items {
+				break
+			}
+			for _, keyword := range []string{"anyOf", "oneOf"} {
+				if branches, ok := part[keyword].([]interface{}); ok && len(branches) > 0 {
+					return sampleValue(root, branches[0], depth+1)
+				}
+			}
+		}
+	}
+
+	switch sampleType(parts) {
+	case "object":
+		obj := make(map[string]interface{})
+		for _, part := range parts {
+			props, _ := part["properties"].(schemavalidation.SchemaObject)
+			for name := range props {
+				if _, ok := obj[name]; !ok {
+					obj[name] = sampleValue(root, props[name], depth+1)
+				}
+			}
+		}
+		for name := range schemavalidation.RequiredProperties(parts) {
+			if _, ok := obj[name]; !ok {
+				obj[name] = "A"
+			}
+		}
+		return obj
+
+	case "array":
+		n := int(schemaNumber(parts, "minItems", 1))
+		arr := make([]interface{}, n)
+		for i := range arr {
+			var item interface{} = "A"
+			if schemas := schemavalidation.ItemSchemas(parts, i); len(schemas) > 0 {
+				item = sampleValue(root, schemas[0], depth+1)
+			}
+			arr[i] = item
+		}
+		return arr
+
+	case "integer":
+		return json.Number(formatSampleNumber(sampleMinimum(parts), true))
+	case "number":
+		return json.Number(formatSampleNumber(sampleMinimum(parts), false))
+	case "boolean":
+		return true
+	case "null":
+		return nil
+	}
+
+	for _, part := range parts {
+		switch part["format"] {
+		case "email":
+			return "user@example.com"
+		case "date-time":
+			return "2020-01-01T00:00:00Z"
+		case "date":
+			return "2020-01-01"
+		case "uri":
+			return "https://example.com/"
+		case "uuid":
+			return "00000000-0000-4000-8000-000000000000"
+		case "ipv4":
+			return "192.0.2.1"
+		}
+	}
+
+	n := int(schemaNumber(parts, "minLength", 1))
+	if max := int(schemaNumber(parts, "maxLength", -1)); max >= 0 && n > max {
+		n = max
+	}
+	return strings.Repeat("A", n)
+}
+
+// sampleType picks the type to generate for parts, guessing from the
+// keywords used when they don't declare one.
+func sampleType(parts []schemavalidation.SchemaObject) string {
+	if types := schemaTypes(parts); len(types) > 0 {
+		for _, t := range []string{"object", "array", "string", "integer", "number", "boolean", "null"} {
+			if types[t] {
+				return t
+			}
+		}
+	}
+
+	for _, part := range parts {
+		if _, ok := part["properties"]; ok {
+			return "object"
+		}
+		if _, ok := part["items"]; ok {
+			return "array"
+		}
+	}
+	return "string"
+}
+
+func schemaNumber(parts []schemavalidation.SchemaObject, keyword string, fallback float64) float64 {
+	for _, part := range parts {
+		if n, ok := part[keyword].(json.Number); ok {
+			if f, err := n.Float64(); err == nil {
+				return f
+			}
+		}
+	}
+	return fallback
+}
+
+func sampleMinimum(parts []schemavalidation.SchemaObject) float64 {
+	min := schemaNumber(parts, "minimum", 1)
+	if max := schemaNumber(parts, "maximum", min); max < min {
+		min = max
+	}
+	return min
+}
+
+func formatSampleNumber(f float64, integer bool) string {
+	if integer {
+		return strconv.FormatInt(int64(math.Ceil(f)), 10)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+type compiledSchema struct {
+	validation *schemavalidation.Schema
+	transforms bool
+
+	discriminator *discriminator
+}
+
+// version makes a version of the named schema, data being its source.
+func (cs *compiledSchema) version(name, version string, data []byte) *schemaVersion {
+	return &schemaVersion{
+		Name:          name,
+		Version:       version,
+		Data:          data,
+		Validation:    cs.validation,
+		Document:      cs.validation.Document(),
+		Schema:        cs.validation.Compiled(),
+		Transforms:    cs.transforms,
+		Discriminator: cs.discriminator,
+	}
+}
+
+// compileCache keeps compiled schemas keyed by a hash of their source, so a
+// reload only compiles the documents that actually changed. Entries not used
+// by the most recent successful load are dropped.
+type compileCache struct {
+	mu       sync.Mutex
+	entries  map[[sha256.Size]byte]*compiledSchema
+	seen     map[[sha256.Size]byte]*compiledSchema
+	compiled int
+	hits     int64
+	misses   int64
+}
+
+type compileCacheStats struct {
+	Entries int   `json:"entries"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+func newCompileCache() *compileCache {
+	return &compileCache{
+		entries: make(map[[sha256.Size]byte]*compiledSchema),
+		seen:    make(map[[sha256.Size]byte]*compiledSchema),
+	}
+}
+
+func (c *compileCache) compile(data []byte) (*compiledSchema, error) {
+	if c == nil {
+		return compileSchema(data)
+	}
+
+	sum := sha256.Sum256(data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cs, ok := c.seen[sum]; ok {
+		c.hit()
+		return cs, nil
+	}
+	cs, ok := c.entries[sum]
+	if !ok {
+		c.misses++
+		metricCompileCacheMisses.Add(1)
+		var err error
+		if cs, err = compileSchema(data); err != nil {
+			return nil, err
+		}
+		c.compiled++
+	} else {
+		c.hit()
+	}
+
+	c.seen[sum] = cs
+	return cs, nil
+}
+
+func (c *compileCache) hit() {
+	c.hits++
+	metricCompileCacheHits.Add(1)
+}
+
+func (c *compileCache) stats() compileCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return compileCacheStats{Entries: len(c.entries), Hits: c.hits, Misses: c.misses}
+}
+
+// begin starts a load, commit ends a successful one and reports how many
+// schemas it had to compile.
+func (c *compileCache) begin() {
+	c.mu.Lock()
+	c.seen = make(map[[sha256.Size]byte]*compiledSchema)
+	c.compiled = 0
+	c.mu.Unlock()
+}
+
+func (c *compileCache) commit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = c.seen
+	c.seen = make(map[[sha256.Size]byte]*compiledSchema)
+	return c.compiled
+}
+
+// compileSchema compiles data as schemavalidation does, with the
+// annotations only the gateway acts on checked too.
+func compileSchema(data []byte) (*compiledSchema, error) {
+	validation, err := schemavalidation.Compile(data, stringLength)
+	if err != nil {
+		return nil, err
+	}
+
+	transforms, err := checkTransforms(validation.Document())
+	if err != nil {
+		return nil, err
+	}
+	discriminator, err := checkDiscriminator(validation.Document())
+	if err != nil {
+		return nil, err
+	}
+
+	return &compiledSchema{validation: validation, transforms: transforms, discriminator: discriminator}, nil
+}
+
+// schemaStore holds the registry requests are currently resolved against and
+// swaps in a new one when the schemas are reloaded.
+type schemaStore struct {
+	cfg      *schemaConfig
+	cache    *compileCache
+	registry atomic.Pointer[schemaRegistry]
+
+	// reloading serializes reloads, which share the compile cache.
+	reloading sync.Mutex
+}
+
+func newSchemaStore(cfg *schemaConfig) (*schemaStore, error) {
+	s := &schemaStore{cfg: cfg, cache: newCompileCache()}
+	if _, err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload loads the schemas again, keeping the current registry if that
+// fails, and returns how many schemas had to be compiled.
+func (s *schemaStore) reload() (int, error) {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	start := time.Now()
+	s.cache.begin()
+	registry, err := loadSchemas(s.cfg, s.cache)
+	if err != nil {
+		schemaLoader.reloaded(time.Since(start), 0, err)
+		return 0, err
+	}
+
+	s.registry.Store(registry)
+	compiled := s.cache.commit()
+	schemaLoader.reloaded(time.Since(start), compiled, nil)
+	return compiled, nil
+}
+
+func (s *schemaStore) current() *schemaRegistry {
+	return s.registry.Load()
+}
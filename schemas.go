@@ -0,0 +1,598 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+type schemaConfig struct {
+	Dir            string
+	Embedded       bool
+	BaseSchema     string
+	Default        string
+	DefaultVersion string
+	Vendor         string
+	VersionHeader  string
+	Deprecated     string
+	TenantFrom     string
+	RulesFile      string
+
+	ProtoDescriptors string
+
+	ExampleFixtures string
+	ExampleFailures string
+
+	// KV is the etcd or Consul prefix schemas are read from.
+	KV *kvSource
+	// Uploads are the schemas uploaded through the admin API.
+	Uploads *schemaUploads
+	// Verifier checks the signatures of the schemas read from files, keys
+	// and uploads when set.
+	Verifier *schemaVerifier
+	// Decrypter decrypts the schema files encrypted at rest.
+	Decrypter *schemaDecrypter
+}
+
+// schemaDocument is the raw source of one schema version. Documents with a
+// tenant override the base document of the same name and version for that
+// tenant only.
+type schemaDocument struct {
+	Tenant  string
+	Name    string
+	Version string
+	Data    []byte
+	// Signature is the detached signature of Data, when there is one.
+	Signature []byte
+	// Encrypted is set while Data is still age encrypted.
+	Encrypted bool
+}
+
+// schemaVersion is one compiled version of a named schema.
+type schemaVersion struct {
+	Name    string
+	Version string
+	Tenant  string
+	Data    []byte
+	// Validation is the schema compiled as schemavalidation compiles it,
+	// Document and Schema are its document and gojsonschema's schema.
+	Validation *schemavalidation.Schema
+	Document   interface{}
+	Schema     *gojsonschema.Schema
+
+	// Transforms is set when the schema has x-transform annotations.
+	Transforms bool
+	// Discriminator is set when the schema picks the one the body is
+	// validated against from one of its properties.
+	Discriminator *discriminator
+
+	Deprecated bool
+	Sunset     time.Time
+}
+
+// schemaSet indexes schema versions by name, oldest version first.
+type schemaSet map[string][]*schemaVersion
+
+// lookup returns version of the named schema, or its latest version when
+// version is empty.
+func (s schemaSet) lookup(name, version string) (*schemaVersion, bool) {
+	versions := s[name]
+	if len(versions) == 0 {
+		return nil, false
+	}
+	if version == "" {
+		return versions[len(versions)-1], true
+	}
+
+	for _, v := range versions {
+		if v.Version == version {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+func (s schemaSet) sort() {
+	for _, versions := range s {
+		sort.Slice(versions, func(i, j int) bool {
+			return versionLess(versions[i].Version, versions[j].Version)
+		})
+	}
+}
+
+// schemaRegistry holds every loaded schema version and picks the one a
+// request should be validated against.
+type schemaRegistry struct {
+	base    schemaSet
+	tenants map[string]schemaSet
+
+	defaultName    string
+	defaultVersion string
+	vendor         string
+	versionHeader  string
+	tenant         func(*http.Request) string
+
+	rules       []*rule
+	protoRoutes []protoRoute
+	cache       *compileCache
+
+	// revision is that of the -schema-kv snapshot the schemas were read
+	// from.
+	revision int64
+}
+
+// selectionError is returned when no schema matches what a request asked for.
+type selectionError struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (e *selectionError) Error() string {
+	return e.msg
+}
+
+// loadSchemas reads the schema documents and compiles them into a registry,
+// reusing what cache already compiled.
+func loadSchemas(cfg *schemaConfig, cache *compileCache) (*schemaRegistry, error) {
+	kv := cfg.KV.snapshot()
+	docs, err := schemaSources(cfg, kv)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newSchemaRegistry(cfg, docs, cache)
+	if err != nil {
+		return nil, err
+	}
+	if kv != nil {
+		s.revision = kv.revision
+	}
+
+	if cfg.RulesFile != "" {
+		if err := s.loadRules(cfg.RulesFile); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ProtoDescriptors != "" {
+		if _, s.protoRoutes, err = loadProtoSchemas(cfg.ProtoDescriptors); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.checkDiscriminators(); err != nil {
+		return nil, err
+	}
+	if err := smokeTest(cfg, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// schemaDocuments returns the schemas bundled into the binary, when it was
+// built with them and cfg.Embedded is set, overridden file by file by those
+// in cfg.Dir and then by those under cfg.KV, and followed by those derived
+// from cfg.ProtoDescriptors. Without any it returns the built-in post
+// schema. Uploaded schemas override them all. Each one is composed with
+// cfg.BaseSchema when set.
+func schemaDocuments(cfg *schemaConfig) ([]schemaDocument, error) {
+	return schemaSources(cfg, cfg.KV.snapshot())
+}
+
+func schemaSources(cfg *schemaConfig, kv *kvSnapshot) ([]schemaDocument, error) {
+	var docs []schemaDocument
+	if cfg.Embedded && embeddedSchemas != nil {
+		var err error
+		if docs, err = readSchemaFS(embeddedSchemas); err != nil {
+			return nil, fmt.Errorf("embedded schemas: %v", err)
+		}
+	}
+
+	if cfg.Dir != "" {
+		files, err := readSchemaDir(cfg.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if err := cfg.Decrypter.decryptDocuments(cfg.Dir, files); err != nil {
+			return nil, err
+		}
+		if err := cfg.Verifier.verifyDocuments(cfg.Dir, files); err != nil {
+			return nil, err
+		}
+		docs = overrideSchemas(docs, files)
+	}
+	if kv != nil {
+		if err := cfg.Verifier.verifyDocuments(cfg.KV.kind, kv.docs); err != nil {
+			return nil, err
+		}
+		docs = overrideSchemas(docs, kv.docs)
+	}
+
+	if cfg.ProtoDescriptors != "" {
+		derived, _, err := loadProtoSchemas(cfg.ProtoDescriptors)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, derived...)
+	}
+
+	if len(docs) == 0 && cfg.Dir == "" && kv == nil && cfg.ProtoDescriptors == "" {
+		docs = []schemaDocument{{Name: "post", Version: "v1", Data: []byte(schemaJSON)}}
+	}
+	if uploaded := cfg.Uploads.documents(); len(uploaded) > 0 {
+		if err := cfg.Verifier.verifyDocuments("uploaded", uploaded); err != nil {
+			return nil, err
+		}
+		docs = overrideSchemas(docs, uploaded)
+	}
+	if cfg.BaseSchema != "" {
+		return composeBase(cfg.BaseSchema, docs)
+	}
+	return docs, nil
+}
+
+// overrideSchemas replaces the documents in docs with those in overrides of
+// the same tenant, name and version, and adds the rest.
+func overrideSchemas(docs, overrides []schemaDocument) []schemaDocument {
+	type id struct{ tenant, name, version string }
+
+	replaced := make(map[id]bool, len(overrides))
+	for _, doc := range overrides {
+		replaced[id{doc.Tenant, doc.Name, doc.Version}] = true
+	}
+
+	out := make([]schemaDocument, 0, len(docs)+len(overrides))
+	for _, doc := range docs {
+		if !replaced[id{doc.Tenant, doc.Name, doc.Version}] {
+			out = append(out, doc)
+		}
+	}
+	return append(out, overrides...)
+}
+
+// readSchemaDir reads every <name>.<version>.json file in dir, where a file
+// without a version, <name>.json, is taken to be v1, along with the
+// encrypted <name>.<version>.json.age ones, left for the caller to decrypt.
+// Each subdirectory holds the overrides of the tenant it is named after, in
+// the same layout.
+func readSchemaDir(dir string) ([]schemaDocument, error) {
+	docs, err := readSchemaFS(os.DirFS(dir))
+	if pe, ok := err.(*fs.PathError); ok {
+		pe.Path = filepath.Join(dir, pe.Path)
+	}
+	return docs, err
+}
+
+// readSchemaFS reads schemas laid out as readSchemaDir describes from fsys.
+func readSchemaFS(fsys fs.FS) ([]schemaDocument, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	docs, err := readSchemaFiles(fsys, ".", "")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		tenantDocs, err := readSchemaFiles(fsys, entry.Name(), entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, tenantDocs...)
+	}
+
+	return docs, nil
+}
+
+func readSchemaFiles(fsys fs.FS, dir, tenant string) ([]schemaDocument, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := fs.Glob(fsys, path.Join(dir, "*.json.age"))
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []schemaDocument
+	for _, file := range append(files, encrypted...) {
+		b, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		// The signature is of the schema, encrypted or not.
+		plain, isEncrypted := strings.CutSuffix(file, ".age")
+		sig, err := fs.ReadFile(fsys, plain+".sig")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		name, version := parseSchemaFilename(path.Base(plain))
+		docs = append(docs, schemaDocument{Tenant: tenant, Name: name, Version: version, Data: b, Signature: sig, Encrypted: isEncrypted})
+	}
+
+	return docs, nil
+}
+
+// newSchemaRegistry compiles docs. A tenant document for a name and version
+// that also exists in the base set is added to its allOf, so a tenant can
+// only tighten the shared contract, never loosen it.
+func newSchemaRegistry(cfg *schemaConfig, docs []schemaDocument, cache *compileCache) (*schemaRegistry, error) {
+	tenant, err := tenantFunc(cfg.TenantFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &schemaRegistry{
+		base:           make(schemaSet),
+		tenants:        make(map[string]schemaSet),
+		defaultName:    cfg.Default,
+		defaultVersion: cfg.DefaultVersion,
+		vendor:         cfg.Vendor,
+		versionHeader:  cfg.VersionHeader,
+		tenant:         tenant,
+		cache:          cache,
+	}
+
+	var overrides []schemaDocument
+	for _, doc := range docs {
+		if doc.Tenant != "" {
+			overrides = append(overrides, doc)
+			continue
+		}
+
+		if err := s.add(s.base, doc, doc.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, doc := range overrides {
+		set, ok := s.tenants[doc.Tenant]
+		if !ok {
+			set = make(schemaSet)
+			s.tenants[doc.Tenant] = set
+		}
+
+		data := doc.Data
+		if base, ok := s.base.lookup(doc.Name, doc.Version); ok {
+			override, ok := decodedObject(doc.Data)
+			if !ok {
+				return nil, fmt.Errorf("schema %s %s %s: a tenant override must be an object", doc.Tenant, doc.Name, doc.Version)
+			}
+			if data, err = layerSchema(base.Data, override); err != nil {
+				return nil, fmt.Errorf("schema %s %s %s: %v", doc.Tenant, doc.Name, doc.Version, err)
+			}
+		}
+		if err := s.add(set, doc, data); err != nil {
+			return nil, err
+		}
+	}
+
+	s.base.sort()
+	for _, set := range s.tenants {
+		set.sort()
+	}
+
+	if s.defaultName == "" && len(s.base) == 1 {
+		for name := range s.base {
+			s.defaultName = name
+		}
+	}
+	if _, ok := s.base.lookup(s.defaultName, s.defaultVersion); !ok {
+		return nil, fmt.Errorf("default schema %q at version %q is not loaded", s.defaultName, s.defaultVersion)
+	}
+
+	if err := s.deprecate(cfg.Deprecated); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *schemaRegistry) add(set schemaSet, doc schemaDocument, data []byte) error {
+	compiled, err := s.cache.compile(data)
+	if err != nil {
+		return fmt.Errorf("schema %s %s %s: %v", doc.Tenant, doc.Name, doc.Version, err)
+	}
+
+	version := compiled.version(doc.Name, doc.Version, data)
+	version.Tenant = doc.Tenant
+	set[doc.Name] = append(set[doc.Name], version)
+	return nil
+}
+
+// deprecate marks the versions listed in spec, a comma separated list of
+// <name>.<version>[=<sunset date>] entries, as deprecated, along with every
+// tenant override of them.
+func (s *schemaRegistry) deprecate(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		id, date := entry, ""
+		if i := strings.Index(entry, "="); i >= 0 {
+			id, date = entry[:i], entry[i+1:]
+		}
+
+		var sunset time.Time
+		if date != "" {
+			var err error
+			if sunset, err = time.Parse("2006-01-02", date); err != nil {
+				return fmt.Errorf("invalid sunset date for %q: %v", id, err)
+			}
+		}
+
+		name, version := parseSchemaFilename(id + ".json")
+		v, ok := s.base.lookup(name, version)
+		if !ok {
+			return fmt.Errorf("cannot deprecate %q, no such schema version is loaded", id)
+		}
+		v.Deprecated, v.Sunset = true, sunset
+
+		for _, set := range s.tenants {
+			if v, ok := set.lookup(name, version); ok {
+				v.Deprecated, v.Sunset = true, sunset
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolve picks the schema for r. Clients opt into a specific schema and
+// version with a vendor media type, application/vnd.<vendor>.<name>.<version>+json,
+// in Content-Type or, failing that, Accept. Otherwise the default schema is
+// used, at the version named by the version header if there is one. Tenants
+// get their own override of the selected version where they have one, and
+// rules matching the request get the last word.
+func (s *schemaRegistry) resolve(r *http.Request) (*schemaVersion, error) {
+	name, version := s.defaultName, s.defaultVersion
+	status := http.StatusBadRequest
+	if v := r.Header.Get(s.versionHeader); s.versionHeader != "" && v != "" {
+		version = v
+	}
+
+	if n, v, ok := s.vendorMediaType(r.Header.Get("Content-Type")); ok {
+		name, version, status = n, v, http.StatusUnsupportedMediaType
+	} else {
+		for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+			if n, v, ok := s.vendorMediaType(accept); ok {
+				name, version, status = n, v, http.StatusNotAcceptable
+				break
+			}
+		}
+	}
+	if status == http.StatusBadRequest {
+		if n, ok := s.protoRouteSchema(r); ok {
+			name = n
+		}
+	}
+
+	schema, ok := s.find(r, name, version)
+	if !ok {
+		return nil, &selectionError{
+			status: status,
+			code:   codeUnknownSchema,
+			msg:    fmt.Sprintf("no schema %q at version %q", name, version),
+		}
+	}
+
+	return s.applyRules(r, schema)
+}
+
+// find looks up a version of name, preferring the override of r's tenant.
+func (s *schemaRegistry) find(r *http.Request, name, version string) (*schemaVersion, bool) {
+	if set, ok := s.tenants[s.tenant(r)]; ok {
+		if schema, ok := s.lookupTenant(set, name, version); ok {
+			return schema, true
+		}
+	}
+
+	return s.base.lookup(name, version)
+}
+
+// lookupTenant finds the tenant override for a version of name. When the
+// latest version is asked for, the override only applies if it overrides the
+// latest base version, so a stale override never pins a tenant to an old
+// contract.
+func (s *schemaRegistry) lookupTenant(set schemaSet, name, version string) (*schemaVersion, bool) {
+	if version == "" {
+		if latest, ok := s.base.lookup(name, ""); ok {
+			version = latest.Version
+		} else {
+			return set.lookup(name, "")
+		}
+	}
+
+	return set.lookup(name, version)
+}
+
+// setDeprecationHeaders tells clients the version they used is going away,
+// following the Deprecation and Sunset (RFC 8594) header conventions.
+func (v *schemaVersion) setDeprecationHeaders(h http.Header) {
+	if !v.Deprecated {
+		return
+	}
+
+	h.Set("Deprecation", "true")
+	msg := fmt.Sprintf("schema %s version %s is deprecated", v.Name, v.Version)
+	if !v.Sunset.IsZero() {
+		h.Set("Sunset", v.Sunset.UTC().Format(http.TimeFormat))
+		msg += " and will be removed on " + v.Sunset.Format("2006-01-02")
+	}
+	h.Set("Warning", fmt.Sprintf("299 - %q", msg))
+}
+
+func (s *schemaRegistry) vendorMediaType(header string) (name, version string, ok bool) {
+	if s.vendor == "" {
+		return "", "", false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return "", "", false
+	}
+
+	prefix := "application/vnd." + strings.ToLower(s.vendor) + "."
+	if !strings.HasPrefix(mediaType, prefix) || !strings.HasSuffix(mediaType, "+json") {
+		return "", "", false
+	}
+
+	subtype := strings.TrimSuffix(strings.TrimPrefix(mediaType, prefix), "+json")
+	if i := strings.LastIndex(subtype, "."); i > 0 {
+		return subtype[:i], subtype[i+1:], true
+	}
+
+	return subtype, "", true
+}
+
+func (s *schemaRegistry) vendorMediaTypes() string {
+	return "application/vnd." + strings.ToLower(s.vendor) + ".*+json"
+}
+
+func parseSchemaFilename(file string) (name, version string) {
+	base := strings.TrimSuffix(file, ".json")
+	if i := strings.LastIndex(base, "."); i > 0 && isVersion(base[i+1:]) {
+		return base[:i], base[i+1:]
+	}
+
+	return base, "v1"
+}
+
+func isVersion(v string) bool {
+	_, err := strconv.Atoi(strings.TrimPrefix(v, "v"))
+	return strings.HasPrefix(v, "v") && err == nil
+}
+
+// versionLess orders v2 before v10, falling back to string order for
+// versions that aren't of the form v<n>.
+func versionLess(a, b string) bool {
+	if isVersion(a) && isVersion(b) {
+		na, _ := strconv.Atoi(a[1:])
+		nb, _ := strconv.Atoi(b[1:])
+		return na < nb
+	}
+
+	return a < b
+}
@@ -0,0 +1,178 @@
+// Package schemavalidatetest helps services behind schema-validations test
+// their contracts without running the gateway. It validates bodies with
+// package schemavalidation, the gateway's validation step, and rejects
+// invalid ones with the same status and error body, so handlers and clients
+// can be exercised against a schema literal in ordinary unit tests.
+//
+// Only validation is reproduced; limits, authentication and the rest of the
+// gateway's chain are not.
+package schemavalidatetest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// Error is one reason a payload doesn't match the schema.
+type Error = schemavalidation.Error
+
+// Validator checks payloads against one schema.
+type Validator struct {
+	t testing.TB
+	v *schemavalidation.Validator
+}
+
+// NewValidator compiles schema, a JSON schema literal, failing the test if
+// it doesn't compile.
+func NewValidator(t testing.TB, schema string) *Validator {
+	t.Helper()
+
+	v, err := schemavalidation.New([]byte(schema), schemavalidation.Options{})
+	if err != nil {
+		t.Fatalf("schema does not compile: %v", err)
+	}
+	return &Validator{t: t, v: v}
+}
+
+// Validate returns the errors for payload, sorted as the gateway sorts
+// them, and none if it is valid. Payloads that aren't JSON fail the test.
+func (v *Validator) Validate(payload string) []Error {
+	v.t.Helper()
+
+	errs, err := v.v.Validate([]byte(payload))
+	if err != nil {
+		v.t.Fatalf("payload is not JSON: %v", err)
+	}
+	return errs
+}
+
+// AssertValid fails the test if payload doesn't match the schema.
+func (v *Validator) AssertValid(payload string) {
+	v.t.Helper()
+
+	if errs := v.Validate(payload); len(errs) > 0 {
+		v.t.Errorf("expected payload to be valid, got errors:\n%s", format(errs))
+	}
+}
+
+// AssertInvalid fails the test if payload matches the schema, or, when
+// pointers are given, if the errors aren't about exactly those locations.
+func (v *Validator) AssertInvalid(payload string, pointers ...string) {
+	v.t.Helper()
+
+	errs := v.Validate(payload)
+	if len(errs) == 0 {
+		v.t.Errorf("expected payload to be invalid, it matches the schema")
+		return
+	}
+	if len(pointers) == 0 {
+		return
+	}
+
+	got := make(map[string]bool)
+	for _, e := range errs {
+		got[e.Pointer] = true
+	}
+	want := make(map[string]bool)
+	for _, p := range pointers {
+		want[p] = true
+	}
+	for p := range want {
+		if !got[p] {
+			v.t.Errorf("expected an error at %q, got errors:\n%s", p, format(errs))
+		}
+	}
+	for p := range got {
+		if !want[p] {
+			v.t.Errorf("unexpected error at %q, got errors:\n%s", p, format(errs))
+		}
+	}
+}
+
+// Middleware validates request bodies before passing them to next,
+// answering invalid ones with 400 and the gateway's error body.
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return v.v.Wrap(next)
+}
+
+// Server is an httptest server validating requests against a schema before
+// handing them to a handler, standing in for the gateway in front of it.
+type Server struct {
+	*httptest.Server
+	*Validator
+}
+
+// NewServer starts a Server for schema in front of next, closed when the
+// test finishes. next defaults to a handler answering 200.
+func NewServer(t testing.TB, schema string, next http.Handler) *Server {
+	t.Helper()
+
+	if next == nil {
+		next = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+
+	v := NewValidator(t, schema)
+	s := &Server{Server: httptest.NewServer(v.Middleware(next)), Validator: v}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Post sends payload to path as JSON, returning the status and body.
+func (s *Server) Post(path, payload string) (int, []byte) {
+	s.t.Helper()
+
+	res, err := s.Client().Post(s.URL+path, "application/json", strings.NewReader(payload))
+	if err != nil {
+		s.t.Fatalf("failed to send request: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		s.t.Fatalf("failed to read response: %v", err)
+	}
+	return res.StatusCode, body
+}
+
+// AssertAccepted fails the test unless posting payload to path gets past
+// validation.
+func (s *Server) AssertAccepted(path, payload string) {
+	s.t.Helper()
+
+	if status, body := s.Post(path, payload); status == http.StatusBadRequest {
+		s.t.Errorf("expected payload to be accepted, got %d: %s", status, body)
+	}
+}
+
+// AssertRejected fails the test unless posting payload to path is rejected
+// with 400, and, when pointers are given, for errors at exactly those
+// locations.
+func (s *Server) AssertRejected(path, payload string, pointers ...string) {
+	s.t.Helper()
+
+	status, body := s.Post(path, payload)
+	if status != http.StatusBadRequest {
+		s.t.Errorf("expected payload to be rejected with 400, got %d: %s", status, body)
+		return
+	}
+	s.AssertInvalid(payload, pointers...)
+}
+
+func format(errs []Error) string {
+	var b strings.Builder
+	for _, e := range errs {
+		p := e.Pointer
+		if p == "" {
+			p = "(root)"
+		}
+		b.WriteString("  " + p + ": " + e.Message + "\n")
+	}
+	return b.String()
+}
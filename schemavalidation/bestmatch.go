@@ -0,0 +1,221 @@
+package schemavalidation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// bestMatchBody is bestMatch for a raw body, which is only decoded when
+// there is an anyOf or oneOf failure to look into.
+func bestMatchBody(root interface{}, body []byte, errs []gojsonschema.ResultError) []gojsonschema.ResultError {
+	if !hasCombinatorError(errs) {
+		return errs
+	}
+	instance, err := decodeJSON(body)
+	if err != nil {
+		return errs
+	}
+	return bestMatch(root, instance, errs)
+}
+
+func hasCombinatorError(errs []gojsonschema.ResultError) bool {
+	for _, e := range errs {
+		if e.Type() == "number_any_of" || e.Type() == "number_one_of" {
+			return true
+		}
+	}
+	return false
+}
+
+// bestMatch replaces the errors reported for a failed anyOf or oneOf with
+// those of the branch the instance most likely meant. gojsonschema reports
+// the branch with the most passing checks, which is often not the one the
+// caller aimed for. Here a branch whose const or single-value enum
+// properties match the instance wins, then the one with fewest errors.
+func bestMatch(root, instance interface{}, errs []gojsonschema.ResultError) []gojsonschema.ResultError {
+	for _, e := range errs {
+		keyword := ""
+		switch e.Type() {
+		case "number_any_of":
+			keyword = "anyOf"
+		case "number_one_of":
+			keyword = "oneOf"
+		default:
+			continue
+		}
+
+		heads := contextHeads(e.Context())
+		pointer := headsPointer(heads)
+		value, ok := ResolvePointer(instance, pointer)
+		if !ok {
+			continue
+		}
+		branches := combinatorBranches(root, pointer, keyword)
+		if len(branches) == 0 {
+			continue
+		}
+
+		best, seen, ok := pickBranch(root, branches, value)
+		if !ok {
+			continue
+		}
+
+		// Drop what gojsonschema merged in from its own pick, recognised by
+		// being reported by one of the branches, and add the best branch's.
+		kept := make([]gojsonschema.ResultError, 0, len(errs))
+		for _, other := range errs {
+			if other != e {
+				if rel, ok := relativeTo(contextHeads(other.Context()), heads); ok && seen[errorKey(rel, other)] {
+					continue
+				}
+			}
+			kept = append(kept, other)
+		}
+		for _, b := range best {
+			b.SetContext(rebase(e.Context(), contextHeads(b.Context())))
+			kept = append(kept, b)
+		}
+		return kept
+	}
+	return errs
+}
+
+// combinatorBranches returns the branches of the first keyword applying to
+// the value at pointer.
+func combinatorBranches(root interface{}, pointer, keyword string) []interface{} {
+	loc, ok := LocateInSchema(root, pointer)
+	if !ok {
+		return nil
+	}
+	for _, s := range loc.Schemas {
+		for _, part := range SchemaParts(root, s) {
+			if branches, ok := part[keyword].([]interface{}); ok {
+				return branches
+			}
+		}
+	}
+	return nil
+}
+
+// pickBranch validates value against every branch, returning the errors of
+// the best one, with nested anyOfs and oneOfs resolved the same way, and the
+// keys of every error any branch reported. It reports false when a branch
+// passes, as for a oneOf matching several.
+func pickBranch(root interface{}, branches []interface{}, value interface{}) ([]gojsonschema.ResultError, map[string]bool, bool) {
+	var best []gojsonschema.ResultError
+	bestScore := -1
+	seen := make(map[string]bool)
+
+	for _, branch := range branches {
+		result, doc, err := SubschemaResult(root, branch, value)
+		if err != nil {
+			return nil, nil, false
+		}
+		if result.Valid() {
+			return nil, nil, false
+		}
+
+		for _, e := range result.Errors() {
+			seen[errorKey(contextHeads(e.Context()), e)] = true
+		}
+
+		errs := bestMatch(doc, value, result.Errors())
+		// A matching discriminator outweighs any number of errors.
+		score := len(errs)
+		if discriminates(doc, branch, value) {
+			score -= 1 << 20
+		}
+		if best == nil || score < bestScore {
+			best, bestScore = errs, score
+		}
+	}
+	return best, seen, true
+}
+
+// discriminates reports whether branch pins a property to a single value
+// with const or enum and value has that property set to it.
+func discriminates(root, branch, value interface{}) bool {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, part := range SchemaParts(root, branch) {
+		props, _ := part["properties"].(SchemaObject)
+		for name, prop := range props {
+			p, ok := prop.(SchemaObject)
+			if !ok {
+				continue
+			}
+			want, pinned := p["const"]
+			if enum, ok := p["enum"].([]interface{}); ok && len(enum) == 1 {
+				want, pinned = enum[0], true
+			}
+			if got, ok := obj[name]; pinned && ok && jsonEqual(got, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ab, err := encodeJSON(a)
+	if err != nil {
+		return false
+	}
+	bb, err := encodeJSON(b)
+	return err == nil && string(ab) == string(bb)
+}
+
+// contextHeads returns the path of c below the root.
+func contextHeads(c *gojsonschema.JsonContext) []string {
+	if c == nil {
+		return nil
+	}
+	heads := strings.Split(c.String("\x00"), "\x00")
+	return heads[1:]
+}
+
+func headsPointer(heads []string) string {
+	var b strings.Builder
+	for _, h := range heads {
+		b.WriteByte('/')
+		b.WriteString(EscapePointer(h))
+	}
+	return b.String()
+}
+
+func relativeTo(heads, prefix []string) ([]string, bool) {
+	if len(heads) < len(prefix) {
+		return nil, false
+	}
+	for i, h := range prefix {
+		if heads[i] != h {
+			return nil, false
+		}
+	}
+	return heads[len(prefix):], true
+}
+
+func rebase(c *gojsonschema.JsonContext, heads []string) *gojsonschema.JsonContext {
+	for _, h := range heads {
+		c = gojsonschema.NewJsonContext(h, c)
+	}
+	return c
+}
+
+// errorKey identifies an error by where it is relative to the branch and
+// what it says, leaving out the field name descriptions embed.
+func errorKey(heads []string, e gojsonschema.ResultError) string {
+	var details []string
+	for k, v := range e.Details() {
+		if k != "field" && k != "context" {
+			details = append(details, fmt.Sprintf("%s=%v", k, v))
+		}
+	}
+	sort.Strings(details)
+	return strings.Join(heads, "\x00") + "\x01" + e.Type() + "\x01" + strings.Join(details, "\x00")
+}
@@ -0,0 +1,207 @@
+package schemavalidation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// crossFieldKeywords relate a property to its siblings in the same object,
+// e.g. "start": {"x-less-than": "end"} or "source_url": {"x-requires":
+// ["post_type"]}. The ordering keywords compare numbers, or strings that are
+// dates or date-times, and hold vacuously when either property is missing;
+// x-requires makes the listed siblings required when the property is
+// present.
+var crossFieldKeywords = map[string]string{
+	"x-less-than":          "less than",
+	"x-less-than-or-equal": "less than or equal to",
+	"x-before":             "before",
+	"x-requires":           "",
+}
+
+// checkCrossFields reports cross-field keywords in a schema document that
+// are malformed, and whether the document uses any at all. Like
+// checkPatterns it only looks at schemas, not at enum or default values or
+// at the names of properties.
+func checkCrossFields(doc interface{}) (bool, error) {
+	found := false
+
+	var walk func(v interface{}) error
+	walk = func(v interface{}) error {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for keyword := range crossFieldKeywords {
+				arg, ok := v[keyword]
+				if !ok {
+					continue
+				}
+				found = true
+				if keyword == "x-requires" {
+					list, ok := arg.([]interface{})
+					if !ok {
+						return fmt.Errorf("x-requires must be an array of property names")
+					}
+					for _, name := range list {
+						if _, ok := name.(string); !ok {
+							return fmt.Errorf("x-requires must be an array of property names")
+						}
+					}
+				} else if _, ok := arg.(string); !ok {
+					return fmt.Errorf("%s must be a property name", keyword)
+				}
+			}
+			for keyword, child := range v {
+				switch {
+				case schemaValueKeywords[keyword]:
+				case schemaMapKeywords[keyword]:
+					if m, ok := child.(map[string]interface{}); ok {
+						for _, s := range m {
+							if err := walk(s); err != nil {
+								return err
+							}
+						}
+					}
+				default:
+					if err := walk(child); err != nil {
+						return err
+					}
+				}
+			}
+		case []interface{}:
+			for _, child := range v {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	err := walk(doc)
+	return found, err
+}
+
+// crossFieldErrors returns the cross-field constraints value breaks. Each
+// message names both fields and gives their JSON pointers.
+func crossFieldErrors(root, schema, value interface{}, field, pointer string) []Error {
+	parts := SchemaParts(root, schema)
+
+	var errs []Error
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			childField, childPointer := joinField(field, key), pointer+"/"+EscapePointer(key)
+			for _, s := range PropertySchemas(parts, key) {
+				for _, part := range SchemaParts(root, s) {
+					errs = append(errs, siblingErrors(part, v, key, field, pointer)...)
+				}
+				errs = append(errs, crossFieldErrors(root, s, child, childField, childPointer)...)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			index := fmt.Sprint(i)
+			for _, s := range ItemSchemas(parts, i) {
+				errs = append(errs, crossFieldErrors(root, s, child, joinField(field, index), pointer+"/"+index)...)
+			}
+		}
+	}
+	return errs
+}
+
+// siblingErrors checks the cross-field keywords of schema, the schema of
+// property key of obj, against the other properties of obj.
+func siblingErrors(schema SchemaObject, obj map[string]interface{}, key, parent, pointer string) []Error {
+	var errs []Error
+	keyField, keyPointer := joinField(parent, key), pointer+"/"+EscapePointer(key)
+
+	for keyword, relation := range crossFieldKeywords {
+		arg, ok := schema[keyword]
+		if !ok {
+			continue
+		}
+
+		if keyword == "x-requires" {
+			list, _ := arg.([]interface{})
+			for _, name := range list {
+				other, _ := name.(string)
+				if _, ok := obj[other]; !ok {
+					otherPointer := pointer + "/" + EscapePointer(other)
+					errs = append(errs, Error{Pointer: otherPointer, Type: keyword, Message: fmt.Sprintf("%s: %s is required when %s is present (%s, %s)", displayField(parent), other, key, otherPointer, keyPointer)})
+				}
+			}
+			continue
+		}
+
+		other, _ := arg.(string)
+		otherValue, ok := obj[other]
+		if !ok {
+			continue
+		}
+		cmp, ok := compareFields(obj[key], otherValue, keyword == "x-before")
+		if !ok {
+			errs = append(errs, Error{Pointer: keyPointer, Type: keyword, Message: fmt.Sprintf("%s: Can't be compared with %s (%s, %s)", keyField, other, keyPointer, pointer+"/"+EscapePointer(other))})
+			continue
+		}
+		if cmp < 0 || (cmp == 0 && keyword == "x-less-than-or-equal") {
+			continue
+		}
+		errs = append(errs, Error{Pointer: keyPointer, Type: keyword, Message: fmt.Sprintf("%s: Must be %s %s (%s, %s)", keyField, relation, other, keyPointer, pointer+"/"+EscapePointer(other))})
+	}
+	return errs
+}
+
+// compareFields compares two numbers exactly, or two dates or date-times,
+// which are all that dates must be.
+func compareFields(a, b interface{}, dates bool) (int, bool) {
+	if !dates {
+		x, okA := a.(json.Number)
+		y, okB := b.(json.Number)
+		if okA && okB {
+			ra, okA := new(big.Rat).SetString(string(x))
+			rb, okB := new(big.Rat).SetString(string(y))
+			if okA && okB {
+				return ra.Cmp(rb), true
+			}
+			return 0, false
+		}
+	}
+
+	x, okA := a.(string)
+	y, okB := b.(string)
+	if !okA || !okB {
+		return 0, false
+	}
+	ta, okA := parseFieldTime(x)
+	tb, okB := parseFieldTime(y)
+	if !okA || !okB {
+		return 0, false
+	}
+	return ta.Compare(tb), true
+}
+
+func parseFieldTime(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func joinField(field, key string) string {
+	if field == "" {
+		return key
+	}
+
+	return field + "." + key
+}
+
+func displayField(field string) string {
+	if field == "" {
+		return "(root)"
+	}
+
+	return field
+}
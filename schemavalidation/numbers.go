@@ -0,0 +1,62 @@
+package schemavalidation
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// numberLimitKeywords are the error types whose details hold the limit as a
+// *big.Rat, which the validator prints as a fraction, such as 1/1 for a
+// minimum of 1, or a *big.Float, which it rounds to ten digits.
+var numberLimitKeywords = map[string]string{
+	"number_gte":  "min",
+	"number_gt":   "min",
+	"number_lte":  "max",
+	"number_lt":   "max",
+	"multiple_of": "multiple",
+}
+
+// describeNumberLimits rewrites the descriptions of errors about numeric
+// limits to give the limit as the exact decimal the schema declares.
+func describeNumberLimits(errs []gojsonschema.ResultError) {
+	for _, e := range errs {
+		detail, ok := numberLimitKeywords[e.Type()]
+		if !ok {
+			continue
+		}
+
+		var limit string
+		switch v := e.Details()[detail].(type) {
+		case *big.Rat:
+			limit = decimalString(v)
+		case *big.Float:
+			limit = v.Text('f', -1)
+		default:
+			continue
+		}
+
+		format := e.DescriptionFormat()
+		e.SetDescription(strings.Replace(format, "{{."+detail+"}}", limit, 1))
+	}
+}
+
+// decimalString formats r as the shortest decimal equal to it, or with 20
+// digits after the point for fractions like 1/3 without one.
+func decimalString(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	if f, exact := r.Float64(); exact {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	for digits := 1; digits <= 20; digits++ {
+		s := r.FloatString(digits)
+		if back, ok := new(big.Rat).SetString(s); ok && back.Cmp(r) == 0 {
+			return s
+		}
+	}
+	return r.FloatString(20)
+}
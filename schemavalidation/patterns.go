@@ -0,0 +1,63 @@
+package schemavalidation
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// checkPatterns reports the pattern and patternProperties regular
+// expressions in a schema document that don't compile.
+//
+// The validator matches them with Go's regexp package, an RE2 engine whose
+// matching time is linear in the input, so no payload can make a pattern
+// backtrack exponentially and no per-match timeout is needed. The price is
+// that lookarounds and backreferences, which are what make backtracking
+// necessary, aren't supported; schemas written for ECMA or PCRE engines that
+// use them are rejected here with the pointer of the pattern, rather than
+// with the validator's bare "invalid regex pattern".
+func checkPatterns(doc interface{}) error {
+	var walk func(v interface{}, pointer string) error
+	walk = func(v interface{}, pointer string) error {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if pattern, ok := v["pattern"].(string); ok {
+				if _, err := regexp.Compile(pattern); err != nil {
+					return fmt.Errorf("%s/pattern: %v, patterns are RE2 syntax without lookarounds or backreferences", pointer, err)
+				}
+			}
+			if patterns, ok := v["patternProperties"].(map[string]interface{}); ok {
+				for pattern := range patterns {
+					if _, err := regexp.Compile(pattern); err != nil {
+						return fmt.Errorf("%s/patternProperties: %v, patterns are RE2 syntax without lookarounds or backreferences", pointer, err)
+					}
+				}
+			}
+
+			for keyword, child := range v {
+				switch {
+				case schemaValueKeywords[keyword]:
+				case schemaMapKeywords[keyword]:
+					if m, ok := child.(map[string]interface{}); ok {
+						for name, s := range m {
+							if err := walk(s, pointer+"/"+keyword+"/"+EscapePointer(name)); err != nil {
+								return err
+							}
+						}
+					}
+				default:
+					if err := walk(child, pointer+"/"+EscapePointer(keyword)); err != nil {
+						return err
+					}
+				}
+			}
+		case []interface{}:
+			for i, child := range v {
+				if err := walk(child, fmt.Sprintf("%s/%d", pointer, i)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(doc, "")
+}
@@ -0,0 +1,123 @@
+package schemavalidation
+
+import "strings"
+
+// schemaMapKeywords hold maps of names to schemas, whose keys are not
+// keywords; schemaValueKeywords hold values that are not schemas at all.
+var (
+	schemaMapKeywords   = map[string]bool{"properties": true, "patternProperties": true, "definitions": true, "$defs": true, "dependentSchemas": true, "dependencies": true}
+	schemaValueKeywords = map[string]bool{"enum": true, "const": true, "default": true, "examples": true, "required": true, "dependentRequired": true}
+)
+
+// polyfillKeywords rewrites keywords the validator doesn't implement into
+// equivalents it does, in place, reporting whether it changed anything:
+//
+//   - dependentRequired and dependentSchemas (draft 2019-09) become
+//     dependencies, which they split up
+//   - if, then and else (draft-07), in draft-04 and draft-06 schemas which
+//     otherwise ignore them, become an allOf of two anyOfs
+//
+// so schemas stuck on draft-04 can say a property requires others, or that
+// a value of one makes another required.
+func polyfillKeywords(doc interface{}) bool {
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	uri, _ := root["$schema"].(string)
+	conditionals := strings.Contains(uri, "draft-04") || strings.Contains(uri, "draft-06")
+
+	changed := false
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if polyfillSchema(v, conditionals) {
+				changed = true
+			}
+			for keyword, child := range v {
+				switch {
+				case schemaValueKeywords[keyword]:
+				case schemaMapKeywords[keyword]:
+					if m, ok := child.(map[string]interface{}); ok {
+						for _, s := range m {
+							walk(s)
+						}
+					}
+				default:
+					walk(child)
+				}
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+	return changed
+}
+
+func polyfillSchema(s map[string]interface{}, conditionals bool) bool {
+	changed := false
+
+	for _, keyword := range []string{"dependentRequired", "dependentSchemas"} {
+		deps, ok := s[keyword].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		existing, _ := s["dependencies"].(map[string]interface{})
+		if existing == nil {
+			existing = make(map[string]interface{})
+		}
+		for name, dep := range deps {
+			existing[name] = mergeDependency(existing[name], dep)
+		}
+		s["dependencies"] = existing
+		delete(s, keyword)
+		changed = true
+	}
+
+	if cond, ok := s["if"]; ok && conditionals {
+		// then and else come first, for their errors to be the ones
+		// reported when neither branch matches.
+		var parts []interface{}
+		if then, ok := s["then"]; ok {
+			parts = append(parts, map[string]interface{}{"anyOf": []interface{}{then, map[string]interface{}{"not": cond}}})
+		}
+		if otherwise, ok := s["else"]; ok {
+			parts = append(parts, map[string]interface{}{"anyOf": []interface{}{otherwise, cond}})
+		}
+		if len(parts) > 0 {
+			allOf, _ := s["allOf"].([]interface{})
+			s["allOf"] = append(allOf, parts...)
+		}
+		delete(s, "if")
+		delete(s, "then")
+		delete(s, "else")
+		changed = true
+	}
+
+	return changed
+}
+
+// mergeDependency combines two dependencies of the same property, either
+// of which can be a list of required properties or a schema.
+func mergeDependency(a, b interface{}) interface{} {
+	if a == nil {
+		return b
+	}
+	listA, okA := a.([]interface{})
+	listB, okB := b.([]interface{})
+	if okA && okB {
+		return append(listA, listB...)
+	}
+
+	asSchema := func(dep interface{}) interface{} {
+		if list, ok := dep.([]interface{}); ok {
+			return map[string]interface{}{"required": list}
+		}
+		return dep
+	}
+	return map[string]interface{}{"allOf": []interface{}{asSchema(a), asSchema(b)}}
+}
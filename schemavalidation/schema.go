@@ -0,0 +1,134 @@
+package schemavalidation
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Schema is a JSON Schema document compiled the way the gateway compiles it.
+// On top of what gojsonschema implements, dependentRequired and
+// dependentSchemas, and if, then and else in draft-04 and draft-06 schemas,
+// are rewritten into keywords it does implement, patterns have to be RE2,
+// the cross-field keywords such as x-less-than are checked, a failed anyOf
+// or oneOf reports the errors of the branch the body most likely meant, and
+// minLength and maxLength can count bytes or graphemes.
+type Schema struct {
+	document     interface{}
+	schema       *gojsonschema.Schema
+	crossFields  bool
+	lengths      bool
+	stringLength string
+}
+
+// Compile compiles data, a JSON Schema document. stringLength is how
+// minLength and maxLength count characters: runes, the validator's own way
+// and what JSON Schema specifies, bytes of UTF-8, or graphemes, what a
+// reader would call characters. It is runes if empty.
+func Compile(data []byte, stringLength string) (*Schema, error) {
+	switch stringLength {
+	case "":
+		stringLength = "runes"
+	case "runes", "bytes", "graphemes":
+	default:
+		return nil, fmt.Errorf("invalid string length %q, expected runes, bytes or graphemes", stringLength)
+	}
+
+	document, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	crossFields, err := checkCrossFields(document)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPatterns(document); err != nil {
+		return nil, err
+	}
+
+	polyfilled := polyfillKeywords(document)
+	lengths := rewriteLengths(document, stringLength)
+	if polyfilled || lengths {
+		if data, err = encodeJSON(document); err != nil {
+			return nil, err
+		}
+	}
+
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Schema{document: document, schema: schema, crossFields: crossFields, lengths: lengths, stringLength: stringLength}, nil
+}
+
+// Document returns the decoded document, with the keywords rewritten as
+// they were for gojsonschema.
+func (s *Schema) Document() interface{} {
+	return s.document
+}
+
+// Compiled returns the schema as gojsonschema compiled it. What it reports
+// for a body is only part of the story, see Errors.
+func (s *Schema) Compiled() *gojsonschema.Schema {
+	return s.schema
+}
+
+// CrossFields reports whether the document has cross-field keywords.
+func (s *Schema) CrossFields() bool {
+	return s.crossFields
+}
+
+// Errors returns the errors of body given result, what Compiled reported
+// for it: result's, with anyOf and oneOf failures narrowed to the branch
+// meant and numeric limits given as decimals, and those of the length
+// keywords counting bytes or graphemes. They aren't ordered, see
+// OrderErrors.
+func (s *Schema) Errors(body []byte, result *gojsonschema.Result) []gojsonschema.ResultError {
+	errs := bestMatchBody(s.document, body, result.Errors())
+	describeNumberLimits(errs)
+	if s.lengths {
+		if doc, err := decodeJSON(body); err == nil {
+			errs = append(errs, lengthErrors(s.document, s.document, doc, s.stringLength, gojsonschema.NewJsonContext(gojsonschema.STRING_CONTEXT_ROOT, nil))...)
+		}
+	}
+	return errs
+}
+
+// CrossFieldErrors returns the cross-field constraints body breaks, sorted
+// by message, none if it isn't JSON.
+func (s *Schema) CrossFieldErrors(body []byte) []Error {
+	if !s.crossFields {
+		return nil
+	}
+	doc, err := decodeJSON(body)
+	if err != nil {
+		return nil
+	}
+
+	errs := crossFieldErrors(s.document, s.document, doc, "", "")
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Message < errs[j].Message })
+	return errs
+}
+
+// Validate returns the errors the gateway rejects body with: those of
+// Errors, in the order of OrderErrors, or when there are none, those of
+// CrossFieldErrors. It fails if body isn't JSON.
+func (s *Schema) Validate(body []byte) ([]Error, error) {
+	result, err := s.schema.Validate(gojsonschema.NewBytesLoader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := OrderErrors(s.Errors(body, result))
+	if len(ordered) == 0 {
+		return s.CrossFieldErrors(body), nil
+	}
+	errs := make([]Error, len(ordered))
+	for i, e := range ordered {
+		errs[i] = Error{Pointer: Pointer(e.Context()), Type: e.Type(), Message: e.String()}
+	}
+	return errs, nil
+}
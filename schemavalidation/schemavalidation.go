@@ -0,0 +1,199 @@
+// Package schemavalidation is the validation step of schema-validations as
+// an importable package, for running in other processes: the Caddy module,
+// the Traefik plugin, the test helpers and Go clients checking what they
+// send. Schemas are compiled as the gateway compiles them, see Schema, and
+// bodies get the errors the gateway would give them, in the same order, with
+// the gateway's status and error body.
+//
+// It validates against a single schema; selecting between schemas and
+// versions, which x-discriminator annotations take part in, limits other
+// than the body size, and the rest of the gateway's chain, such as the
+// x-transform normalizations applied before validating, stay in the
+// gateway.
+package schemavalidation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Error is one reason a body doesn't match the schema.
+type Error struct {
+	// Pointer is the RFC 6901 JSON pointer of the value the error is about,
+	// "" for the whole body.
+	Pointer string
+	// Type is what failed: gojsonschema's error type, such as required or
+	// invalid_type, or a cross-field keyword, such as x-less-than.
+	Type string
+	// Message is the message the gateway reports for the error.
+	Message string
+}
+
+// Options adjust how a Validator treats requests.
+type Options struct {
+	// MaxBodyBytes rejects larger bodies with 413, unlimited if 0.
+	MaxBodyBytes int64
+	// ReportOnly logs invalid bodies instead of rejecting them.
+	ReportOnly bool
+	// Logf logs report-only violations, log.Printf if nil.
+	Logf func(format string, args ...interface{})
+	// StringLength is how minLength and maxLength count characters, see
+	// Compile.
+	StringLength string
+}
+
+// Validator checks bodies against one compiled schema.
+type Validator struct {
+	schema *Schema
+	opts   Options
+}
+
+// New compiles schema, a JSON Schema document.
+func New(schema []byte, opts Options) (*Validator, error) {
+	s, err := Compile(schema, opts.StringLength)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Logf == nil {
+		opts.Logf = log.Printf
+	}
+	return &Validator{schema: s, opts: opts}, nil
+}
+
+// Validate returns the errors for body, see Schema.Validate, and none if it
+// is valid. It fails if body isn't JSON.
+func (v *Validator) Validate(body []byte) ([]Error, error) {
+	return v.schema.Validate(body)
+}
+
+// OrderErrors sorts errors by the JSON pointer of what they are about, then
+// keyword, then message, and drops duplicates, so the same body always gets
+// the same response.
+func OrderErrors(errors []gojsonschema.ResultError) []gojsonschema.ResultError {
+	type keyed struct {
+		pointer string
+		msg     string
+		e       gojsonschema.ResultError
+	}
+	all := make([]keyed, len(errors))
+	for i, e := range errors {
+		all[i] = keyed{Pointer(e.Context()), e.String(), e}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if a.pointer != b.pointer {
+			return a.pointer < b.pointer
+		}
+		if a.e.Type() != b.e.Type() {
+			return a.e.Type() < b.e.Type()
+		}
+		return a.msg < b.msg
+	})
+
+	out := make([]gojsonschema.ResultError, 0, len(all))
+	for i, k := range all {
+		if i > 0 && k.msg == all[i-1].msg {
+			continue
+		}
+		out = append(out, k.e)
+	}
+	return out
+}
+
+// Check validates the body of r, answering w itself and returning false
+// when the request is rejected. Otherwise the body is put back for the
+// handlers after it.
+func (v *Validator) Check(w http.ResponseWriter, r *http.Request) bool {
+	body := r.Body
+	if v.opts.MaxBodyBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, v.opts.MaxBodyBytes)
+	}
+	b, err := io.ReadAll(body)
+	r.Body.Close()
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		WriteErrors(w, http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("request body exceeds the %d byte limit", tooLarge.Limit))
+		return false
+	}
+	if err != nil {
+		WriteErrors(w, http.StatusBadRequest, "unreadable_body", fmt.Sprintf("failed to read request body: %v", err))
+		return false
+	}
+
+	errs, err := v.Validate(b)
+	if err != nil {
+		WriteErrors(w, http.StatusBadRequest, "invalid_json", fmt.Sprintf("request body is not valid JSON: %v", err))
+		return false
+	}
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, e := range errs {
+			msgs[i] = e.Message
+		}
+		if !v.opts.ReportOnly {
+			WriteErrors(w, http.StatusBadRequest, "schema_violation", msgs...)
+			return false
+		}
+		v.opts.Logf("not enforced, %s %s does not match the schema: %s", r.Method, r.URL.Path, strings.Join(msgs, "; "))
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	r.ContentLength = int64(len(b))
+	return true
+}
+
+// Wrap returns next behind the validator.
+func (v *Validator) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v.Check(w, r) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// WriteErrors writes the gateway's error body, {"errors": [...]}, with an
+// entry for each of msgs, all of them with code. The gateway's codes are
+// listed in its error schema; it uses schema_violation for bodies that don't
+// match the schema.
+func WriteErrors(w http.ResponseWriter, status int, code string, msgs ...string) {
+	type entry struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	var entries []entry
+	if msgs != nil {
+		entries = make([]entry, len(msgs))
+		for i, msg := range msgs {
+			entries[i] = entry{Code: code, Message: msg}
+		}
+	}
+	b, _ := json.Marshal(struct {
+		Errors []entry `json:"errors"`
+	}{entries})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// Pointer returns the RFC 6901 JSON pointer of the value at c, "" for the
+// whole document.
+func Pointer(c *gojsonschema.JsonContext) string {
+	return headsPointer(contextHeads(c))
+}
+
+// EscapePointer escapes token, an object key or array index, for a JSON
+// pointer.
+func EscapePointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,312 @@
+package schemavalidation
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// The helpers here walk decoded schema documents to find the subschemas that
+// apply to a location in an instance, for features that need to look at the
+// schema text rather than just the validation result. Only local $refs are
+// followed; remote references are left to the validator.
+
+// SchemaObject is a decoded schema, or subschema, that is an object.
+type SchemaObject = map[string]interface{}
+
+// MaxSchemaDepth stops walks of cyclic $refs.
+const MaxSchemaDepth = 64
+
+// SchemaParts returns node and the schemas it pulls in through $ref and
+// allOf, all of which apply to the same instance.
+func SchemaParts(root, node interface{}) []SchemaObject {
+	var parts []SchemaObject
+
+	var walk func(n interface{}, depth int)
+	walk = func(n interface{}, depth int) {
+		m, ok := n.(SchemaObject)
+		if !ok || depth > MaxSchemaDepth {
+			return
+		}
+
+		// Siblings of $ref are ignored, as in draft-04 to draft-07.
+		if ref, ok := m["$ref"].(string); ok {
+			if target, ok := ResolveRef(root, ref); ok {
+				walk(target, depth+1)
+			}
+			return
+		}
+
+		parts = append(parts, m)
+		if all, ok := m["allOf"].([]interface{}); ok {
+			for _, s := range all {
+				walk(s, depth+1)
+			}
+		}
+	}
+	walk(node, 0)
+
+	return parts
+}
+
+// SchemaAlternatives returns SchemaParts of node together with the parts of
+// every anyOf, oneOf, then and else branch, i.e. every schema that might
+// apply to the instance depending on which branch it matches.
+func SchemaAlternatives(root, node interface{}) []SchemaObject {
+	var all []SchemaObject
+
+	var walk func(n interface{}, depth int)
+	walk = func(n interface{}, depth int) {
+		if depth > MaxSchemaDepth {
+			return
+		}
+
+		for _, part := range SchemaParts(root, n) {
+			all = append(all, part)
+			for _, keyword := range []string{"anyOf", "oneOf"} {
+				branches, _ := part[keyword].([]interface{})
+				for _, b := range branches {
+					walk(b, depth+1)
+				}
+			}
+			for _, keyword := range []string{"then", "else"} {
+				if b, ok := part[keyword]; ok {
+					walk(b, depth+1)
+				}
+			}
+		}
+	}
+	walk(node, 0)
+
+	return all
+}
+
+// ResolveRef resolves a local reference such as #/definitions/author.
+func ResolveRef(root interface{}, ref string) (interface{}, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+
+	pointer, err := url.PathUnescape(ref[1:])
+	if err != nil {
+		return nil, false
+	}
+
+	return ResolvePointer(root, pointer)
+}
+
+// ResolvePointer resolves an RFC 6901 JSON pointer against doc.
+func ResolvePointer(doc interface{}, pointer string) (interface{}, bool) {
+	if pointer == "" {
+		return doc, true
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, false
+	}
+
+	current := doc
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+
+		switch v := current.(type) {
+		case map[string]interface{}:
+			next, ok := v[token]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			current = v[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// PropertySchemas returns the schemas that apply to the value of key in an
+// object validated by parts, following properties, patternProperties and
+// additionalProperties.
+func PropertySchemas(parts []SchemaObject, key string) []interface{} {
+	var schemas []interface{}
+
+	for _, part := range parts {
+		matched := false
+
+		if props, ok := part["properties"].(SchemaObject); ok {
+			if s, ok := props[key]; ok {
+				schemas = append(schemas, s)
+				matched = true
+			}
+		}
+
+		if patterns, ok := part["patternProperties"].(SchemaObject); ok {
+			for pattern, s := range patterns {
+				if re, err := regexp.Compile(pattern); err == nil && re.MatchString(key) {
+					schemas = append(schemas, s)
+					matched = true
+				}
+			}
+		}
+
+		if additional, ok := part["additionalProperties"].(SchemaObject); ok && !matched {
+			schemas = append(schemas, additional)
+		}
+	}
+
+	return schemas
+}
+
+// ItemSchemas returns the schemas that apply to element i of an array
+// validated by parts.
+func ItemSchemas(parts []SchemaObject, i int) []interface{} {
+	var schemas []interface{}
+
+	for _, part := range parts {
+		switch items := part["items"].(type) {
+		case SchemaObject:
+			schemas = append(schemas, items)
+		case []interface{}:
+			if i < len(items) {
+				schemas = append(schemas, items[i])
+			} else if additional, ok := part["additionalItems"].(SchemaObject); ok {
+				schemas = append(schemas, additional)
+			}
+		}
+	}
+
+	return schemas
+}
+
+// RequiredProperties returns the union of the required lists in parts.
+func RequiredProperties(parts []SchemaObject) map[string]bool {
+	required := make(map[string]bool)
+	for _, part := range parts {
+		list, _ := part["required"].([]interface{})
+		for _, name := range list {
+			if s, ok := name.(string); ok {
+				required[s] = true
+			}
+		}
+	}
+
+	return required
+}
+
+// SchemaLocation is what LocateInSchema found for a value.
+type SchemaLocation struct {
+	// Schemas apply to the value at the location, none for free-form values.
+	Schemas []interface{}
+	// Required is set when the value is a property its object requires.
+	Required bool
+}
+
+// LocateInSchema finds the schemas for the value a JSON pointer into an
+// instance points at. Since the instance itself isn't known, a level is
+// treated as an array when its schema has items and an object otherwise.
+// Below free-form objects anything goes.
+func LocateInSchema(root interface{}, pointer string) (SchemaLocation, bool) {
+	tokens, _ := ParsePointer(pointer)
+	loc := SchemaLocation{Schemas: []interface{}{root}}
+
+	for _, token := range tokens {
+		if len(loc.Schemas) == 0 {
+			return SchemaLocation{}, true
+		}
+		var parts []SchemaObject
+		for _, s := range loc.Schemas {
+			parts = append(parts, SchemaAlternatives(root, s)...)
+		}
+
+		isArray, described := false, false
+		for _, part := range parts {
+			_, items := part["items"]
+			_, props := part["properties"]
+			_, patterns := part["patternProperties"]
+			isArray = isArray || items
+			described = described || props || patterns
+		}
+
+		if isArray {
+			i, err := strconv.Atoi(token)
+			if token != "-" && (err != nil || i < 0) {
+				return SchemaLocation{}, false
+			}
+			loc = SchemaLocation{Schemas: ItemSchemas(parts, i)}
+			continue
+		}
+
+		next := PropertySchemas(parts, token)
+		if described && len(next) == 0 {
+			return SchemaLocation{}, false
+		}
+		loc = SchemaLocation{Schemas: next, Required: RequiredProperties(parts)[token]}
+	}
+
+	return loc, true
+}
+
+// SubschemaResult validates value against schema, nested in root, and also
+// returns the document it compiled for it.
+func SubschemaResult(root, schema, value interface{}) (*gojsonschema.Result, SchemaObject, error) {
+	compiled, doc, err := CompileSubschema(root, schema)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, err := compiled.Validate(gojsonschema.NewGoLoader(value))
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, doc, nil
+}
+
+// CompileSubschema compiles a schema nested in root on its own, with the
+// root's definitions carried along so local $refs still resolve.
+func CompileSubschema(root, schema interface{}) (*gojsonschema.Schema, SchemaObject, error) {
+	doc := make(SchemaObject)
+	if m, ok := root.(SchemaObject); ok {
+		for _, key := range []string{"definitions", "$defs"} {
+			if defs, ok := m[key]; ok {
+				doc[key] = defs
+			}
+		}
+	}
+	if m, ok := schema.(SchemaObject); ok {
+		for k, v := range m {
+			doc[k] = v
+		}
+	}
+
+	compiled, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(doc))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to compile schema: %v", err)
+	}
+	return compiled, doc, nil
+}
+
+// ParsePointer splits an RFC 6901 JSON pointer into its unescaped tokens.
+func ParsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
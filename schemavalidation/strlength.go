@@ -0,0 +1,161 @@
+package schemavalidation
+
+import (
+	"encoding/json"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+func stringLen(s, mode string) int {
+	switch mode {
+	case "bytes":
+		return len(s)
+	case "graphemes":
+		return uniseg.GraphemeClusterCount(s)
+	}
+	return utf8.RuneCountInString(s)
+}
+
+// When counting bytes or graphemes, minLength and maxLength are
+// taken out of the compiled schema, renamed to these, and checked after it
+// validated the body, by lengthErrors.
+const (
+	minLengthKeyword = "x-minLength"
+	maxLengthKeyword = "x-maxLength"
+)
+
+// rewriteLengths renames the minLength and maxLength keywords of doc for
+// lengthErrors to check, reporting whether there were any. Those under
+// anyOf, oneOf, not and if/then/else, or in definitions referenced from
+// there, decide which branch matches and stay with the validator, counting
+// runes.
+func rewriteLengths(doc interface{}, mode string) bool {
+	if mode == "runes" {
+		return false
+	}
+
+	// Whatever a combinator references, directly or through other
+	// references, is part of the branch.
+	branchRefs := make(map[string]bool)
+	var collect func(v interface{}, inBranch bool)
+	collect = func(v interface{}, inBranch bool) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok && inBranch && !branchRefs[ref] {
+				branchRefs[ref] = true
+				if target, ok := ResolveRef(doc, ref); ok {
+					collect(target, true)
+				}
+			}
+			for keyword, child := range v {
+				if !schemaValueKeywords[keyword] {
+					collect(child, inBranch || isBranchKeyword(keyword))
+				}
+			}
+		case []interface{}:
+			for _, child := range v {
+				collect(child, inBranch)
+			}
+		}
+	}
+	collect(doc, false)
+
+	changed := false
+	var walk func(v interface{}, pointer string, inBranch bool)
+	walk = func(v interface{}, pointer string, inBranch bool) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if !inBranch && !branchRefs["#"+pointer] {
+				for from, to := range map[string]string{"minLength": minLengthKeyword, "maxLength": maxLengthKeyword} {
+					if n, ok := v[from]; ok {
+						v[to] = n
+						delete(v, from)
+						changed = true
+					}
+				}
+			}
+			for keyword, child := range v {
+				switch {
+				case schemaValueKeywords[keyword]:
+				case schemaMapKeywords[keyword]:
+					if m, ok := child.(map[string]interface{}); ok {
+						for name, s := range m {
+							walk(s, pointer+"/"+keyword+"/"+EscapePointer(name), inBranch || branchRefs["#"+pointer])
+						}
+					}
+				default:
+					walk(child, pointer+"/"+EscapePointer(keyword), inBranch || branchRefs["#"+pointer] || isBranchKeyword(keyword))
+				}
+			}
+		case []interface{}:
+			for i, child := range v {
+				walk(child, pointer+"/"+strconv.Itoa(i), inBranch)
+			}
+		}
+	}
+	walk(doc, "", false)
+	return changed
+}
+
+func isBranchKeyword(keyword string) bool {
+	switch keyword {
+	case "anyOf", "oneOf", "not", "if", "then", "else":
+		return true
+	}
+	return false
+}
+
+// lengthErrors checks the strings of value against the renamed length
+// keywords of the schemas applying to them, reporting failures the way the
+// validator reports its own.
+func lengthErrors(root, schema, value interface{}, mode string, context *gojsonschema.JsonContext) []gojsonschema.ResultError {
+	parts := SchemaParts(root, schema)
+
+	var errs []gojsonschema.ResultError
+	switch v := value.(type) {
+	case string:
+		n := stringLen(v, mode)
+		for _, part := range parts {
+			if min, ok := lengthLimit(part[minLengthKeyword]); ok && n < min {
+				errs = append(errs, lengthError(&gojsonschema.StringLengthGTEError{}, "string_gte", "String length must be greater than or equal to ", "min", min, v, context))
+			}
+			if max, ok := lengthLimit(part[maxLengthKeyword]); ok && n > max {
+				errs = append(errs, lengthError(&gojsonschema.StringLengthLTEError{}, "string_lte", "String length must be less than or equal to ", "max", max, v, context))
+			}
+		}
+	case map[string]interface{}:
+		for key, child := range v {
+			for _, s := range PropertySchemas(parts, key) {
+				errs = append(errs, lengthErrors(root, s, child, mode, gojsonschema.NewJsonContext(key, context))...)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			for _, s := range ItemSchemas(parts, i) {
+				errs = append(errs, lengthErrors(root, s, child, mode, gojsonschema.NewJsonContext(strconv.Itoa(i), context))...)
+			}
+		}
+	}
+	return errs
+}
+
+func lengthLimit(v interface{}) (int, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := n.Int64()
+	return int(i), err == nil
+}
+
+func lengthError(e gojsonschema.ResultError, kind, description, detail string, limit int, value string, context *gojsonschema.JsonContext) gojsonschema.ResultError {
+	e.SetType(kind)
+	e.SetContext(context)
+	e.SetValue(value)
+	e.SetDetails(gojsonschema.ErrorDetails{detail: limit, "field": e.Field(), "context": context.String()})
+	e.SetDescription(description + strconv.Itoa(limit))
+	return e
+}
@@ -0,0 +1,112 @@
+package schemavalidation
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ValidationError is returned by Transport for bodies that don't match the
+// schema.
+type ValidationError struct {
+	// Response is set when the response, not the request, is invalid.
+	Response bool
+	Errors   []Error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Message
+	}
+
+	what := "request"
+	if e.Response {
+		what = "response"
+	}
+	return what + " body does not match the schema: " + strings.Join(msgs, "; ")
+}
+
+// Transport validates the bodies of outgoing requests before sending them,
+// and optionally those of successful responses, so a service can check what
+// it sends before the gateway does:
+//
+//	client := &http.Client{Transport: &schemavalidation.Transport{Request: v}}
+//
+// Invalid requests aren't sent; RoundTrip returns a *ValidationError
+// instead, as it does for invalid responses after closing them. Validators
+// with ReportOnly set log instead. Requests and responses without a body are
+// passed through.
+type Transport struct {
+	// Base sends the requests, http.DefaultTransport if nil.
+	Base http.RoundTripper
+	// Request validates request bodies, skipped if nil.
+	Request *Validator
+	// Response validates the bodies of 2xx responses, skipped if nil.
+	Response *Validator
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if t.Request != nil && req.Body != nil && req.Body != http.NoBody {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err := t.Request.check(b, false, req); err != nil {
+			return nil, err
+		}
+
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(b))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}
+		req.ContentLength = int64(len(b))
+	}
+
+	res, err := base.RoundTrip(req)
+	if err != nil || t.Response == nil || res.StatusCode < 200 || res.StatusCode > 299 {
+		return res, err
+	}
+
+	b, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(b)) > 0 {
+		if err := t.Response.check(b, true, req); err != nil {
+			return nil, err
+		}
+	}
+	res.Body = io.NopCloser(bytes.NewReader(b))
+	res.ContentLength = int64(len(b))
+	return res, nil
+}
+
+// check validates an outgoing or incoming body for Transport, logging
+// rather than failing when the validator is report-only.
+func (v *Validator) check(b []byte, response bool, req *http.Request) error {
+	errs, err := v.Validate(b)
+	if err != nil {
+		return err
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	verr := &ValidationError{Response: response, Errors: errs}
+	if !v.opts.ReportOnly {
+		return verr
+	}
+	v.opts.Logf("not enforced, %s %s: %v", req.Method, req.URL, verr)
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type schemaKey struct{}
+
+// requestSchema returns the schema version selected for r.
+func requestSchema(r *http.Request) *schemaVersion {
+	schema, _ := r.Context().Value(schemaKey{}).(*schemaVersion)
+	return schema
+}
+
+// selectSchema resolves which schema version r is validated against and
+// makes it available to the rest of the chain through requestSchema.
+func selectSchema(schemas *schemaStore, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		schema, err := schemas.current().resolve(r)
+		var selErr *selectionError
+		if errors.As(err, &selErr) {
+			writeErrors(w, selErr.status, selErr.code, selErr.msg)
+			return
+		}
+		schema.setDeprecationHeaders(w.Header())
+
+		ctx := context.WithValue(r.Context(), schemaKey{}, schema)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// settings are the knobs that can be changed while serving through the
+// admin API. Everything else needs a restart.
+type settings struct {
+	// Enforcement is enforce to reject invalid requests or report to log
+	// them and let them through.
+	Enforcement string `json:"enforcement"`
+	// EnforcePercent rolls enforcement out to a share of requests, the
+	// rest are reported only.
+	EnforcePercent float64 `json:"enforce_percent"`
+	// ErrorDetail is full to return every validation error or summary to
+	// return a single generic one.
+	ErrorDetail string  `json:"error_detail"`
+	RateLimit   float64 `json:"rate_limit"`
+	RateBurst   int     `json:"rate_burst"`
+
+	// FailureStatus is set from route policies only, 0 means 400.
+	FailureStatus int `json:"-"`
+	// ValidationTimeout bounds how long validating a body may take, 0
+	// for no bound. It is set from -validation-timeout and route policies.
+	ValidationTimeout time.Duration `json:"-"`
+}
+
+func (s *settings) check() error {
+	if s.Enforcement != "enforce" && s.Enforcement != "report" {
+		return fmt.Errorf("invalid enforcement %q, expected enforce or report", s.Enforcement)
+	}
+	if s.EnforcePercent < 0 || s.EnforcePercent > 100 {
+		return fmt.Errorf("invalid enforce percent %v, expected 0 to 100", s.EnforcePercent)
+	}
+	if s.ErrorDetail != "full" && s.ErrorDetail != "summary" {
+		return fmt.Errorf("invalid error detail %q, expected full or summary", s.ErrorDetail)
+	}
+	if s.RateLimit < 0 || s.RateBurst < 0 {
+		return errors.New("rate limit and burst cannot be negative")
+	}
+	if s.ValidationTimeout < 0 {
+		return errors.New("validation timeout cannot be negative")
+	}
+	return nil
+}
+
+// maxSettingsHistory is how many earlier settings are kept to revert to.
+const maxSettingsHistory = 20
+
+// runtimeSettings holds the current settings, read on every request, and the
+// earlier ones they can be reverted to.
+type runtimeSettings struct {
+	current atomic.Pointer[settings]
+	limiter *rateLimiter
+	flags   *featureFlags
+	routes  routePolicies
+
+	mu      sync.Mutex
+	history []*settings
+}
+
+func newRuntimeSettings(initial settings, limiter *rateLimiter, flags *featureFlags, routes routePolicies) (*runtimeSettings, error) {
+	if err := initial.check(); err != nil {
+		return nil, err
+	}
+
+	rt := &runtimeSettings{limiter: limiter, flags: flags, routes: routes}
+	rt.current.Store(&initial)
+	return rt, nil
+}
+
+func (rt *runtimeSettings) get() *settings {
+	if rt == nil {
+		return &settings{Enforcement: "enforce", EnforcePercent: 100, ErrorDetail: "full"}
+	}
+	return rt.current.Load()
+}
+
+// forRequest returns the settings for r: the runtime settings, overridden
+// by the policy of its route, overridden by any feature flags.
+func (rt *runtimeSettings) forRequest(r *http.Request) *settings {
+	s := rt.get()
+	if rt == nil {
+		return s
+	}
+	s = rt.routes.forPath(r.URL.Path).apply(s)
+	if rt.flags != nil {
+		s = rt.flags.apply(r, s)
+	}
+	return s
+}
+
+// validationTimeout returns how long validating the body of r may take, 0
+// for no bound. Only route policies set it, so it is read without
+// evaluating feature flags.
+func (rt *runtimeSettings) validationTimeout(r *http.Request) time.Duration {
+	s := rt.get()
+	if rt == nil {
+		return s.ValidationTimeout
+	}
+	return rt.routes.forPath(r.URL.Path).apply(s).ValidationTimeout
+}
+
+// enforced reports whether validation errors should reject this request.
+func (s *settings) enforced() bool {
+	if s.Enforcement != "enforce" {
+		return false
+	}
+	return s.EnforcePercent >= 100 || rand.Float64()*100 < s.EnforcePercent
+}
+
+// rejection decides what becomes of a request with entries wrong with it,
+// what being how it is wrong and current the settings for it, see forRequest:
+// the status to reject it with when they enforce validation, otherwise 0,
+// with the request logged. The entries returned are those for the client, a
+// single generic one with summary error detail. Every check finding a body invalid goes through here, so they all
+// answer the same way.
+func (rt *runtimeSettings) rejection(r *http.Request, current *settings, what string, entries []errorEntry) (int, []errorEntry) {
+	enforced := current.enforced()
+	if enforced {
+		slog.Debug("rejected invalid request", "request_id", requestID(r), "method", r.Method, "path", r.URL.Path, "errors", entryMessages(entries))
+	} else {
+		metricReported.Add(1)
+		log.Printf("not enforced, %s %s (request %s) %s: %s", r.Method, r.URL.Path, requestID(r), what, strings.Join(entryMessages(entries), "; "))
+	}
+
+	if current.ErrorDetail == "summary" {
+		entries = []errorEntry{{Code: codeSchemaViolation, Message: "request body does not match the schema"}}
+	}
+	if !enforced {
+		return 0, entries
+	}
+	if current.FailureStatus != 0 {
+		return current.FailureStatus, entries
+	}
+	return http.StatusBadRequest, entries
+}
+
+// rejectInvalid rejects the request with entries when current, the settings
+// for it, enforce validation, reporting whether it was rejected.
+func (rt *runtimeSettings) rejectInvalid(w http.ResponseWriter, r *http.Request, current *settings, what string, entries []errorEntry) bool {
+	status, entries := rt.rejection(r, current, what, entries)
+	if status == 0 {
+		return false
+	}
+	writeErrorEntries(w, status, entries)
+	return true
+}
+
+func (rt *runtimeSettings) set(next *settings) error {
+	if err := next.check(); err != nil {
+		return err
+	}
+	prev := rt.current.Load()
+	if rt.limiter == nil && (next.RateLimit != prev.RateLimit || next.RateBurst != prev.RateBurst) {
+		return errors.New("rate limiting is not enabled, start with -rate-limit to change it at runtime")
+	}
+	if rt.limiter != nil && next.RateLimit == 0 {
+		return errors.New("rate limit must be above 0")
+	}
+
+	rt.history = append(rt.history, prev)
+	if len(rt.history) > maxSettingsHistory {
+		rt.history = rt.history[1:]
+	}
+	rt.apply(next)
+	return nil
+}
+
+func (rt *runtimeSettings) revert() (*settings, bool) {
+	if len(rt.history) == 0 {
+		return nil, false
+	}
+	prev := rt.history[len(rt.history)-1]
+	rt.history = rt.history[:len(rt.history)-1]
+	rt.apply(prev)
+	return prev, true
+}
+
+func (rt *runtimeSettings) apply(s *settings) {
+	if rt.limiter != nil {
+		rt.limiter.setLimits(s.RateLimit, s.RateBurst)
+	}
+	rt.current.Store(s)
+}
+
+// settingsChanges describes what changed between two settings for the log.
+func settingsChanges(prev, next *settings) string {
+	var changes []string
+	add := func(name string, from, to interface{}) {
+		if from != to {
+			changes = append(changes, fmt.Sprintf("%s %v -> %v", name, from, to))
+		}
+	}
+	add("enforcement", prev.Enforcement, next.Enforcement)
+	add("enforce_percent", prev.EnforcePercent, next.EnforcePercent)
+	add("error_detail", prev.ErrorDetail, next.ErrorDetail)
+	add("rate_limit", prev.RateLimit, next.RateLimit)
+	add("rate_burst", prev.RateBurst, next.RateBurst)
+
+	if len(changes) == 0 {
+		return "no changes"
+	}
+	return strings.Join(changes, ", ")
+}
+
+// handleSettings serves GET and PUT /admin/settings. A PUT takes any subset
+// of the settings, leaving the rest as they are.
+func (rt *runtimeSettings) handleSettings(keys *adminKeys) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			rt.mu.Lock()
+			defer rt.mu.Unlock()
+
+			prev := rt.current.Load()
+			next := *prev
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&next); err != nil {
+				writeErrors(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("invalid settings: %v", err))
+				return
+			}
+			if err := rt.set(&next); err != nil {
+				writeErrors(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+				return
+			}
+			log.Printf("runtime settings changed by %s: %s", adminCaller(keys, r), settingsChanges(prev, &next))
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeSettings(w, rt.current.Load())
+	}
+}
+
+// handleRevert serves POST /admin/settings/revert, undoing the last change.
+func (rt *runtimeSettings) handleRevert(keys *adminKeys) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+
+		current := rt.current.Load()
+		prev, ok := rt.revert()
+		if !ok {
+			writeErrors(w, http.StatusConflict, codeConflict, "there are no earlier settings to revert to")
+			return
+		}
+		log.Printf("runtime settings reverted by %s: %s", adminCaller(keys, r), settingsChanges(current, prev))
+
+		writeSettings(w, prev)
+	}
+}
+
+func writeSettings(w http.ResponseWriter, s *settings) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// adminCaller names who made an admin request for the logs, by the label of
+// their key when it has one.
+func adminCaller(keys *adminKeys, r *http.Request) string {
+	if keys == nil {
+		return r.RemoteAddr
+	}
+	if id, ok := r.Context().Value(adminIdentityKey{}).(adminIdentity); ok && id.name != "" {
+		return id.name
+	}
+	if key, ok := bearerToken(r); ok {
+		if label, ok := keys.lookup(key); ok && label != "" {
+			return label
+		}
+	}
+	return r.RemoteAddr
+}
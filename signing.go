@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// schemaVerifier checks the detached signatures of schemas before they are
+// loaded, with -schema-public-key, so whoever can write to where schemas
+// are read from can't change what is enforced without the signing key.
+// Each schema file or key <name>.<version>.json has its signature next to
+// it in <name>.<version>.json.sig, uploads carry theirs in the
+// X-Schema-Signature header. Signatures made by any of these are accepted:
+//
+//   - minisign, with a minisign public key
+//   - cosign sign-blob, with the PEM ECDSA P-256 or Ed25519 public key
+//   - plain Ed25519, base64 encoded or raw, with a PEM Ed25519 public key
+//
+// One schema failing to verify fails the whole load, keeping the schemas
+// already loaded. Embedded schemas are part of the binary and aren't checked.
+type schemaVerifier struct {
+	keys     []interface{}
+	minisign map[[8]byte]ed25519.PublicKey
+}
+
+// loadSchemaVerifier reads the public keys in file: PEM PUBLIC KEY blocks,
+// as cosign writes them, or a minisign public key.
+func loadSchemaVerifier(file string) (*schemaVerifier, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &schemaVerifier{minisign: make(map[[8]byte]ed25519.PublicKey)}
+	rest := b
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		switch key.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey:
+			v.keys = append(v.keys, key)
+		default:
+			return nil, fmt.Errorf("%s: unsupported %T public key, expected Ed25519 or ECDSA", file, key)
+		}
+	}
+
+	if len(v.keys) == 0 {
+		raw, err := minisignLine(b, 42)
+		if err != nil || string(raw[:2]) != "Ed" {
+			return nil, fmt.Errorf("%s: expected PEM public keys or a minisign public key", file)
+		}
+		var id [8]byte
+		copy(id[:], raw[2:10])
+		v.minisign[id] = ed25519.PublicKey(raw[10:])
+	}
+	return v, nil
+}
+
+// minisignLine decodes the first line of b that isn't a comment, expecting
+// n bytes.
+func minisignLine(b []byte, n int) ([]byte, error) {
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) != n {
+			return nil, fmt.Errorf("expected %d bytes, got %d", n, len(raw))
+		}
+		return raw, nil
+	}
+	return nil, errors.New("no key or signature")
+}
+
+// verify checks sig is a signature of data by one of the keys.
+func (v *schemaVerifier) verify(data, sig []byte) error {
+	if len(sig) == 0 {
+		return errors.New("the schema isn't signed")
+	}
+	if bytes.HasPrefix(sig, []byte("untrusted comment:")) {
+		return v.verifyMinisign(data, sig)
+	}
+
+	raw := sig
+	if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig))); err == nil {
+		raw = decoded
+	}
+	digest := sha256.Sum256(data)
+	for _, key := range v.keys {
+		switch key := key.(type) {
+		case ed25519.PublicKey:
+			if ed25519.Verify(key, data, raw) {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, digest[:], raw) {
+				return nil
+			}
+		}
+	}
+	return errors.New("signature doesn't verify")
+}
+
+// verifyMinisign checks a minisign signature file: the signature of data,
+// legacy or prehashed, and the global signature of its trusted comment.
+func (v *schemaVerifier) verifyMinisign(data, sig []byte) error {
+	lines := strings.Split(strings.TrimSpace(string(sig)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.New("malformed minisign signature")
+	}
+	raw, err := minisignLine([]byte(lines[1]), 74)
+	if err != nil {
+		return fmt.Errorf("malformed minisign signature: %v", err)
+	}
+	global, err := minisignLine([]byte(lines[3]), 64)
+	if err != nil {
+		return fmt.Errorf("malformed minisign signature: %v", err)
+	}
+
+	var id [8]byte
+	copy(id[:], raw[2:10])
+	key, ok := v.minisign[id]
+	if !ok {
+		return fmt.Errorf("signed with unknown minisign key %X", id)
+	}
+
+	signed := data
+	switch string(raw[:2]) {
+	case "Ed":
+	case "ED":
+		sum := blake2b.Sum512(data)
+		signed = sum[:]
+	default:
+		return fmt.Errorf("unsupported minisign algorithm %q", raw[:2])
+	}
+	if !ed25519.Verify(key, signed, raw[10:]) {
+		return errors.New("signature doesn't verify")
+	}
+
+	comment := strings.TrimPrefix(strings.TrimRight(lines[2], "\r"), "trusted comment: ")
+	if !ed25519.Verify(key, append(append([]byte{}, raw[10:]...), comment...), global) {
+		return errors.New("trusted comment signature doesn't verify")
+	}
+	return nil
+}
+
+// verifyDocuments checks the signature of every document, from where.
+func (v *schemaVerifier) verifyDocuments(where string, docs []schemaDocument) error {
+	if v == nil {
+		return nil
+	}
+	for _, doc := range docs {
+		if err := v.verify(doc.Data, doc.Signature); err != nil {
+			id := doc.Name + "." + doc.Version
+			if doc.Tenant != "" {
+				id = doc.Tenant + "/" + id
+			}
+			return fmt.Errorf("%s schema %s: %v", where, id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// streamCheck checks the body's shape as it is read: the limits, the type of
+// the top level value and, for objects, the top level required properties.
+// A body failing them is rejected at the first offending token, without
+// reading the rest, and one passing them is handed on in full. When the
+// runtime settings don't enforce validation, a body of the wrong shape is
+// read to the end and handed on as well.
+func streamCheck(limits *jsonLimits, rt *runtimeSettings, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := bodyBuffers.get()
+		defer bodyBuffers.put(buf)
+		defer r.Body.Close()
+
+		check := newShapeCheck(requestSchema(r))
+		err := limits.scan(io.TeeReader(r.Body, buf), check.visit)
+		var shapeErr *shapeError
+		if errors.As(err, &shapeErr) {
+			if rt.rejectInvalid(w, r, rt.forRequest(r), "does not match the schema", errorEntries(codeSchemaViolation, shapeErr.msgs)) {
+				return
+			}
+			// Not enforced, so the rest of the body goes on too, once it is
+			// within the limits.
+			if _, err = io.Copy(buf, r.Body); err != nil {
+				err = &bodyReadError{err: err}
+			} else {
+				err = limits.check(buf.Bytes())
+			}
+		}
+
+		var tooLarge *http.MaxBytesError
+		var encErr *encodingError
+		var readErr *bodyReadError
+		switch {
+		case errors.As(err, &tooLarge):
+			writeBodyTooLarge(w, tooLarge.Limit)
+			return
+		case errors.As(err, &encErr):
+			writeEncodingError(w, encErr)
+			return
+		case errors.As(err, &readErr):
+			writeErrors(w, http.StatusBadRequest, codeUnreadableBody, fmt.Sprintf("failed to read request body: %v", readErr.err))
+			return
+		case err != nil:
+			writeErrors(w, http.StatusBadRequest, codeOf(err, codeInvalidJSON), err.Error())
+			return
+		}
+
+		replaceBody(r, bytes.Clone(buf.Bytes()))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shapeError is a document not having the shape the schema asks for.
+type shapeError struct {
+	msgs []string
+}
+
+func (e *shapeError) Error() string { return strings.Join(e.msgs, "; ") }
+
+// shapeCheck follows the top level of a document token by token.
+type shapeCheck struct {
+	types    map[string]bool
+	required map[string]bool
+	started  bool
+}
+
+func newShapeCheck(schema *schemaVersion) *shapeCheck {
+	parts := schemavalidation.SchemaParts(schema.Document, schema.Document)
+	return &shapeCheck{types: schemaTypes(parts), required: schemavalidation.RequiredProperties(parts)}
+}
+
+func (c *shapeCheck) visit(tok json.Token, depth int, key bool) error {
+	switch {
+	case depth == 0 && !c.started:
+		c.started = true
+		if t := tokenType(tok); c.types != nil && !c.types[t] && !(t == "integer" && c.types["number"]) {
+			return &shapeError{[]string{fmt.Sprintf("(root): Invalid type. Expected: %s, given: %s", joinTypes(c.types), t)}}
+		}
+		if t := tokenType(tok); t != "object" {
+			c.required = nil
+		}
+
+	case depth == 1 && key:
+		delete(c.required, tok.(string))
+
+	case depth == 0 && tok == json.Delim('}') && len(c.required) > 0:
+		var missing []string
+		for name := range c.required {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+
+		msgs := make([]string, len(missing))
+		for i, name := range missing {
+			msgs[i] = fmt.Sprintf("(root): %s is required", name)
+		}
+		return &shapeError{msgs}
+	}
+
+	return nil
+}
+
+func tokenType(tok json.Token) string {
+	switch v := tok.(type) {
+	case json.Delim:
+		if v == '{' {
+			return "object"
+		}
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	}
+	return "null"
+}
+
+func joinTypes(types map[string]bool) string {
+	var names []string
+	for t := range types {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, "/")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// stripUnknown removes properties the selected schema doesn't describe from
+// the body before it is forwarded, so the upstream gets a sanitized payload
+// even when additionalProperties can't be false for compatibility reasons.
+// It runs after validation.
+func stripUnknown(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+
+		doc, err := decodeJSON(body)
+		if err == nil {
+			schema := requestSchema(r)
+			walkUnknown(schema.Document, schema.Document, doc, "", func(obj map[string]interface{}, key, _ string) {
+				delete(obj, key)
+			})
+
+			if b, err := encodeJSON(doc); err == nil {
+				body = b
+			}
+		}
+
+		replaceBody(r, body)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// walkUnknown calls fn for every property of value that schema doesn't
+// describe, with the property's parent object and the gojsonschema style
+// field path of the parent. Objects whose schema doesn't list any properties
+// are free-form and never have unknown properties.
+func walkUnknown(root, schema, value interface{}, field string, fn func(obj map[string]interface{}, key, field string)) {
+	// Properties described by any branch are known, since which branch the
+	// instance matched isn't known here.
+	parts := schemavalidation.SchemaAlternatives(root, schema)
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		described := false
+		for _, part := range parts {
+			_, props := part["properties"]
+			_, patterns := part["patternProperties"]
+			described = described || props || patterns
+		}
+
+		for key, child := range v {
+			schemas := schemavalidation.PropertySchemas(parts, key)
+			if described && len(schemas) == 0 {
+				fn(v, key, field)
+				continue
+			}
+
+			for _, s := range schemas {
+				walkUnknown(root, s, child, joinField(field, key), fn)
+			}
+		}
+
+	case []interface{}:
+		for i, child := range v {
+			for _, s := range schemavalidation.ItemSchemas(parts, i) {
+				walkUnknown(root, s, child, joinField(field, strconv.Itoa(i)), fn)
+			}
+		}
+	}
+}
+
+// joinField builds field paths the way gojsonschema reports them, e.g.
+// tags.0 or author.email, with (root) standing for the document itself.
+func joinField(field, key string) string {
+	if field == "" {
+		return key
+	}
+
+	return field + "." + key
+}
+
+func displayField(field string) string {
+	if field == "" {
+		return "(root)"
+	}
+
+	return field
+}
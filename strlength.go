@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// stringLength is how minLength and maxLength count characters, see
+// schemavalidation.Compile. Set it with setStringLength before schemas are
+// loaded.
+var stringLength = "runes"
+
+func setStringLength(mode string) error {
+	switch mode {
+	case "runes", "bytes", "graphemes":
+		stringLength = mode
+		return nil
+	}
+	return fmt.Errorf("invalid -string-length %q, expected runes, bytes or graphemes", mode)
+}
+
+// normalizeUnicode puts every string of the body, keys included, in Unicode
+// normalization form C before it's validated and forwarded, so the same
+// text composed differently is the same length and matches the same
+// patterns and enum values.
+func normalizeUnicode(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+
+		// Escaped characters are only seen once decoded.
+		if !norm.NFC.IsNormal(body) || bytes.Contains(body, []byte(`\u`)) {
+			if doc, err := decodeJSON(body); err == nil {
+				if b, err := encodeJSON(nfcValue(doc)); err == nil {
+					body = b
+				}
+			}
+		}
+
+		replaceBody(r, body)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func nfcValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return norm.NFC.String(v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			out[norm.NFC.String(key)] = nfcValue(child)
+		}
+		return out
+	case []interface{}:
+		for i, child := range v {
+			v[i] = nfcValue(child)
+		}
+	}
+	return v
+}
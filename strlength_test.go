@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// TestGraphemeLengths checks maxLength counts what readers see as one
+// character once with -string-length graphemes, however many code points
+// it is made of.
+func TestGraphemeLengths(t *testing.T) {
+	schema, err := schemavalidation.Compile([]byte(`{"properties":{"name":{"type":"string","maxLength":1}}}`), "graphemes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, s := range map[string]string{
+		"combining accent":          "e\u0301",
+		"CRLF":                      "\r\n",
+		"family, joined with ZWJs":  "\U0001F468\u200D\U0001F469\u200D\U0001F467\u200D\U0001F466",
+		"skin tone modifier":        "\U0001F44D\U0001F3FD",
+		"flag, a regional pair":     "\U0001F1FA\U0001F1F8",
+		"Hangul syllable from jamo": "\u1100\u1161\u11A8",
+		"keycap":                    "1\uFE0F\u20E3",
+	} {
+		errs, err := schema.Validate([]byte(fmt.Sprintf(`{"name":%q}`, s)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(errs) > 0 {
+			t.Errorf("%s %q counts as more than one character: %v", name, s, errs)
+		}
+	}
+
+	for name, s := range map[string]string{
+		"two flags":            "\U0001F1FA\U0001F1F8\U0001F1EB\U0001F1F7",
+		"letter and ZWJ pair":  "a\u200D\U0001F469",
+		"two Hangul syllables": "\uAC00\uAC00",
+	} {
+		errs, err := schema.Validate([]byte(fmt.Sprintf(`{"name":%q}`, s)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(errs) == 0 {
+			t.Errorf("%s %q counts as one character", name, s)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor systemd passes sockets on.
+const listenFDsStart = 3
+
+// systemdListeners returns the sockets handed over through systemd socket
+// activation, keyed "data" or "admin". Sockets are matched by their
+// FileDescriptorName when it is one of those, and by order otherwise. It
+// returns nil when the process was not socket activated.
+func systemdListeners() (map[string]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n == 0 {
+		return nil, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if n > 2 {
+		return nil, fmt.Errorf("systemd passed %d sockets, expected at most a data and an admin socket", n)
+	}
+
+	listeners := make(map[string]net.Listener)
+	unnamed := []string{"data", "admin"}
+
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) && (names[i] == "data" || names[i] == "admin") {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %d from systemd: %v", i, err)
+		}
+
+		if name == "" {
+			for _, u := range unnamed {
+				if listeners[u] == nil {
+					name = u
+					break
+				}
+			}
+		}
+		if listeners[name] != nil {
+			return nil, fmt.Errorf("systemd passed more than one %s socket", name)
+		}
+		listeners[name] = ln
+	}
+
+	return listeners, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// tenantFunc returns how to identify the tenant a request belongs to:
+//
+//	header:<name>  the value of a request header
+//	subdomain      the leftmost label of the Host, e.g. acme in acme.api.example.com
+//	claim:<name>   a claim of the verified bearer token
+//	client-cert    the identity in the verified client certificate
+//
+// An empty spec disables tenant selection.
+func tenantFunc(spec string) (func(*http.Request) string, error) {
+	kind, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+
+	switch kind {
+	case "":
+		return func(*http.Request) string { return "" }, nil
+	case "header":
+		return func(r *http.Request) string { return r.Header.Get(arg) }, nil
+	case "subdomain":
+		return subdomainTenant, nil
+	case "claim":
+		return func(r *http.Request) string {
+			v, _ := requestClaims(r)[arg].(string)
+			return v
+		}, nil
+	case "client-cert":
+		return clientIdentity, nil
+	}
+
+	return nil, fmt.Errorf("unknown tenant source %q, expected header:<name>, subdomain, claim:<name> or client-cert", spec)
+}
+
+func subdomainTenant(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	labels := strings.Split(host, ".")
+	if len(labels) < 3 || net.ParseIP(host) != nil {
+		return ""
+	}
+
+	return strings.ToLower(labels[0])
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+type tlsConfig struct {
+	CertFile   string
+	KeyFile    string
+	MinVersion string
+
+	ClientCAFile string
+	ClientAuth   string
+
+	ACMEHosts    string
+	ACMECacheDir string
+	ACMEEmail    string
+}
+
+func (c *tlsConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.ACMEHosts != ""
+}
+
+// acmeManager returns the autocert manager obtaining certificates for the
+// configured hostnames, or nil when ACME is not configured.
+func (c *tlsConfig) acmeManager() *autocert.Manager {
+	if c.ACMEHosts == "" {
+		return nil
+	}
+
+	var hosts []string
+	for _, h := range strings.Split(c.ACMEHosts, ",") {
+		hosts = append(hosts, strings.TrimSpace(h))
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(c.ACMECacheDir),
+		Email:      c.ACMEEmail,
+	}
+}
+
+// build returns a server TLS config restricted to AEAD cipher suites with
+// forward secrecy. The suites only apply to TLS 1.2, 1.3 picks its own.
+// Certificates come either from -tls-cert/-tls-key or, when m is non-nil,
+// from ACME, which also answers tls-alpn-01 challenges on the same listener.
+func (c *tlsConfig) build(m *autocert.Manager) (*tls.Config, error) {
+	if m != nil && (c.CertFile != "" || c.KeyFile != "") {
+		return nil, fmt.Errorf("-acme-hosts cannot be combined with -tls-cert/-tls-key")
+	}
+	if m == nil && (c.CertFile == "" || c.KeyFile == "") {
+		return nil, fmt.Errorf("both -tls-cert and -tls-key are required to serve TLS")
+	}
+
+	minVersion, err := parseTLSVersion(c.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth, err := parseClientAuth(c.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	var clientCAs *x509.CertPool
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.ClientCAFile)
+		}
+	} else if clientAuth != tls.NoClientCert {
+		return nil, fmt.Errorf("-tls-client-ca is required to verify client certificates")
+	}
+
+	cfg := &tls.Config{
+		MinVersion:       minVersion,
+		ClientAuth:       clientAuth,
+		ClientCAs:        clientCAs,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+	}
+
+	if m != nil {
+		cfg.GetCertificate = m.GetCertificate
+		cfg.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	}
+
+	return cfg, nil
+}
+
+func parseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q, expected 1.2 or 1.3", v)
+	}
+}
+
+func parseClientAuth(v string) (tls.ClientAuthType, error) {
+	switch v {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("unsupported client auth mode %q, expected none, optional or require", v)
+	}
+}
@@ -0,0 +1,14 @@
+module github.com/mitchfriedman/schema-validations/traefik
+
+go 1.26.0
+
+require github.com/mitchfriedman/schema-validations v0.0.0
+
+require (
+	github.com/rivo/uniseg v0.4.7 // indirect
+	github.com/xeipuuv/gojsonpointer v0.0.0-20180127040702-4e3ac2762d5f // indirect
+	github.com/xeipuuv/gojsonreference v0.0.0-20180127040603-bd5ef7bd5415 // indirect
+	github.com/xeipuuv/gojsonschema v1.1.0 // indirect
+)
+
+replace github.com/mitchfriedman/schema-validations => ../
@@ -0,0 +1,78 @@
+// Package traefik is a Traefik middleware plugin validating request bodies
+// with package schemavalidation, so schema validation can be turned on per
+// router in an existing Traefik. Traefik interprets plugins from source, so
+// the dependencies have to be vendored first, with go mod vendor here.
+// Then, as a local plugin:
+//
+//	experimental:
+//	  localPlugins:
+//	    schemavalidations:
+//	      moduleName: github.com/mitchfriedman/schema-validations/traefik
+//
+//	http:
+//	  middlewares:
+//	    posts-schema:
+//	      plugin:
+//	        schemavalidations:
+//	          schemaFile: /etc/traefik/schemas/post.v1.json
+//	          maxBodyBytes: 1048576
+package traefik
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// Config is the plugin configuration.
+type Config struct {
+	// SchemaFile is the JSON Schema to validate against.
+	SchemaFile string `json:"schemaFile,omitempty"`
+	// Schema is the JSON Schema inline, instead of SchemaFile.
+	Schema string `json:"schema,omitempty"`
+	// MaxBodyBytes rejects larger bodies with 413, unlimited if 0.
+	MaxBodyBytes int64 `json:"maxBodyBytes,omitempty"`
+	// ReportOnly logs invalid bodies instead of rejecting them.
+	ReportOnly bool `json:"reportOnly,omitempty"`
+	// StringLength is how minLength and maxLength count characters: runes,
+	// the default, bytes or graphemes, as -string-length does.
+	StringLength string `json:"stringLength,omitempty"`
+}
+
+// CreateConfig returns the default configuration.
+func CreateConfig() *Config {
+	return &Config{}
+}
+
+// New builds the middleware in front of next.
+func New(_ context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	if (config.SchemaFile == "") == (config.Schema == "") {
+		return nil, errors.New("exactly one of schemaFile and schema is required")
+	}
+
+	schema := []byte(config.Schema)
+	if config.SchemaFile != "" {
+		var err error
+		if schema, err = os.ReadFile(config.SchemaFile); err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := schemavalidation.New(schema, schemavalidation.Options{
+		MaxBodyBytes: config.MaxBodyBytes,
+		ReportOnly:   config.ReportOnly,
+		StringLength: config.StringLength,
+		Logf: func(format string, args ...interface{}) {
+			log.Printf("%s: %s", name, fmt.Sprintf(format, args...))
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid schema: %v", name, err)
+	}
+	return v.Wrap(next), nil
+}
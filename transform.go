@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+	"golang.org/x/text/unicode/norm"
+)
+
+// stringTransforms are the normalizations a schema can ask for on a string
+// field with the x-transform annotation, e.g. "x-transform": ["trim",
+// "lowercase"]. They are applied in order, before validation, and the
+// normalized body is what gets forwarded. Add to it with registerTransform.
+var stringTransforms = map[string]func(string) string{
+	"trim":      strings.TrimSpace,
+	"lowercase": strings.ToLower,
+	"uppercase": strings.ToUpper,
+	"collapse-whitespace": func(s string) string {
+		return strings.Join(strings.FieldsFunc(s, unicode.IsSpace), " ")
+	},
+	"nfc": norm.NFC.String,
+}
+
+// registerTransform makes fn available to schemas as an x-transform. It must
+// be called before schemas are loaded.
+func registerTransform(name string, fn func(string) string) {
+	stringTransforms[name] = fn
+}
+
+// checkTransforms reports x-transform annotations in a schema document that
+// name unknown transforms, and whether the document uses any at all.
+func checkTransforms(doc interface{}) (bool, error) {
+	found := false
+
+	var walk func(v interface{}) error
+	walk = func(v interface{}) error {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if names, ok := v["x-transform"]; ok {
+				list, ok := names.([]interface{})
+				if !ok {
+					return fmt.Errorf("x-transform must be an array of transform names")
+				}
+				for _, name := range list {
+					s, _ := name.(string)
+					if _, ok := stringTransforms[s]; !ok {
+						return fmt.Errorf("unknown x-transform %v", name)
+					}
+				}
+				found = true
+			}
+			for _, child := range v {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, child := range v {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	err := walk(doc)
+	return found, err
+}
+
+// normalizeStrings applies the x-transform annotations of the selected
+// schema. Schemas without any are passed through without decoding the body.
+func normalizeStrings(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		schema := requestSchema(r)
+		if !schema.Transforms {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+
+		if doc, err := decodeJSON(body); err == nil {
+			doc = transform(schema.Document, schema.Document, doc)
+			if b, err := encodeJSON(doc); err == nil {
+				body = b
+			}
+		}
+
+		replaceBody(r, body)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func transform(root, schema, value interface{}) interface{} {
+	parts := schemavalidation.SchemaParts(root, schema)
+
+	switch v := value.(type) {
+	case string:
+		if m, ok := schema.(schemavalidation.SchemaObject); ok {
+			if _, ok := m["$ref"]; ok {
+				v = applyTransforms(m, v)
+			}
+		}
+		for _, part := range parts {
+			v = applyTransforms(part, v)
+		}
+		return v
+
+	case map[string]interface{}:
+		for key, child := range v {
+			for _, s := range schemavalidation.PropertySchemas(parts, key) {
+				child = transform(root, s, child)
+			}
+			v[key] = child
+		}
+
+	case []interface{}:
+		for i, child := range v {
+			for _, s := range schemavalidation.ItemSchemas(parts, i) {
+				child = transform(root, s, child)
+			}
+			v[i] = child
+		}
+	}
+
+	return value
+}
+
+func applyTransforms(schema schemavalidation.SchemaObject, s string) string {
+	names, _ := schema["x-transform"].([]interface{})
+	for _, name := range names {
+		if fn, ok := stringTransforms[name.(string)]; ok {
+			s = fn(s)
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+)
+
+// runGenerateTS implements `generate ts`, writing TypeScript types for the
+// latest version of every schema, and with -zod zod schemas checking them.
+func runGenerateTS(args []string) error {
+	var cfg schemaConfig
+	fs := flag.NewFlagSet("schema-validations generate ts", flag.ExitOnError)
+	fs.StringVar(&cfg.Dir, "schema-dir", "", "directory of <name>.<version>.json schemas, the built-in post schema if empty")
+	fs.StringVar(&cfg.BaseSchema, "base-schema", "", "JSON schema of fields every request shares, added to the allOf of every schema")
+	fs.BoolVar(&cfg.Embedded, "embedded-schemas", embeddedSchemas != nil, "load the schemas bundled into the binary with -tags embedschemas")
+	out := fs.String("out", "", "file to write, standard output if empty")
+	zod := fs.Bool("zod", false, "also generate zod schemas for runtime validation")
+	fs.Parse(args)
+
+	docs, err := schemaDocuments(&cfg)
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	fmt.Fprintln(w, "// Code generated by schema-validations generate ts. DO NOT EDIT.")
+	if *zod {
+		fmt.Fprintln(w, "\nimport { z } from \"zod\";")
+	}
+
+	names := make(map[string]bool)
+	for _, doc := range latestDocuments(docs) {
+		root, err := decodeJSON(doc.Data)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", doc.Name, doc.Version, err)
+		}
+
+		g := &tsGen{root: root, zod: *zod, names: names, refs: make(map[string]string), prefix: goIdentifier(doc.Name)}
+		name := g.uniqueName(g.prefix)
+		g.refs["#"] = name
+		g.declare(name, fmt.Sprintf("%s %s", doc.Name, doc.Version), root, 0)
+		for _, decl := range g.decls {
+			fmt.Fprint(w, "\n"+decl)
+		}
+	}
+	return nil
+}
+
+// tsGen turns one schema into TypeScript declarations. Inline objects stay
+// inline; the root and every $ref target get a named type.
+type tsGen struct {
+	root   interface{}
+	zod    bool
+	prefix string
+	decls  []string
+	names  map[string]bool
+	refs   map[string]string
+}
+
+func (g *tsGen) declare(name, comment string, node interface{}, depth int) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "/** %s */\n", comment)
+
+	t, z := g.types(node, depth)
+	if strings.HasPrefix(t, "{") {
+		fmt.Fprintf(&b, "export interface %s %s\n", name, t)
+	} else {
+		fmt.Fprintf(&b, "export type %s = %s;\n", name, t)
+	}
+	if g.zod {
+		fmt.Fprintf(&b, "\nexport const %sSchema: z.ZodType<%s> = %s;\n", name, name, z)
+	}
+	g.decls = append(g.decls, b.String())
+}
+
+// types returns the TypeScript type of node and the zod expression
+// validating it.
+func (g *tsGen) types(node interface{}, depth int) (string, string) {
+	if depth > schemavalidation.MaxSchemaDepth {
+		return "unknown", "z.unknown()"
+	}
+	if b, ok := node.(bool); ok && !b {
+		return "never", "z.never()"
+	}
+	m, ok := node.(schemavalidation.SchemaObject)
+	if !ok {
+		return "unknown", "z.unknown()"
+	}
+	if ref, ok := m["$ref"].(string); ok {
+		name := g.refType(ref)
+		if name == "" {
+			return "unknown", "z.unknown()"
+		}
+		return name, fmt.Sprintf("z.lazy(() => %sSchema)", name)
+	}
+
+	parts := schemavalidation.SchemaParts(g.root, node)
+	for _, part := range parts {
+		for _, keyword := range []string{"anyOf", "oneOf"} {
+			if branches, ok := part[keyword].([]interface{}); ok && len(branches) > 0 {
+				return g.union(branches, depth)
+			}
+		}
+	}
+
+	for _, part := range parts {
+		if c, ok := part["const"]; ok {
+			return tsLiteral(c), fmt.Sprintf("z.literal(%s)", tsLiteral(c))
+		}
+		if enum, ok := part["enum"].([]interface{}); ok && len(enum) > 0 {
+			return tsEnum(enum)
+		}
+	}
+
+	types := schemaTypes(parts)
+	if len(types) == 0 && !hasStringHints(parts) && sampleType(parts) == "string" {
+		return "unknown", "z.unknown()"
+	}
+	if len(types) > 1 {
+		var ts, zs []string
+		for _, t := range []string{"object", "array", "string", "integer", "number", "boolean", "null"} {
+			if types[t] && !(t == "integer" && types["number"]) {
+				tt, zz := g.typed(t, parts, depth)
+				ts, zs = append(ts, tt), append(zs, zz)
+			}
+		}
+		return strings.Join(ts, " | "), fmt.Sprintf("z.union([%s])", strings.Join(zs, ", "))
+	}
+	return g.typed(sampleType(parts), parts, depth)
+}
+
+func (g *tsGen) typed(t string, parts []schemavalidation.SchemaObject, depth int) (string, string) {
+	switch t {
+	case "object":
+		return g.object(parts, depth)
+	case "array":
+		items := schemavalidation.ItemSchemas(parts, 0)
+		if len(items) == 0 {
+			return "unknown[]", "z.array(z.unknown())"
+		}
+		it, iz := g.types(items[0], depth)
+		if strings.Contains(it, "|") {
+			it = "(" + it + ")"
+		}
+		return it + "[]", fmt.Sprintf("z.array(%s)%s", iz, tsChecks(parts, "minItems", "maxItems"))
+	case "integer":
+		return "number", "z.number().int()" + tsNumberChecks(parts)
+	case "number":
+		return "number", "z.number()" + tsNumberChecks(parts)
+	case "boolean":
+		return "boolean", "z.boolean()"
+	case "null":
+		return "null", "z.null()"
+	}
+	return "string", "z.string()" + tsStringChecks(parts)
+}
+
+func (g *tsGen) union(branches []interface{}, depth int) (string, string) {
+	var ts, zs []string
+	for _, b := range branches {
+		t, z := g.types(b, depth)
+		ts, zs = append(ts, t), append(zs, z)
+	}
+	if len(ts) == 1 {
+		return ts[0], zs[0]
+	}
+	return strings.Join(ts, " | "), fmt.Sprintf("z.union([%s])", strings.Join(zs, ", "))
+}
+
+func (g *tsGen) object(parts []schemavalidation.SchemaObject, depth int) (string, string) {
+	var props []string
+	schemas := make(map[string]interface{})
+	closed := false
+	var additional interface{}
+	for _, part := range parts {
+		p, _ := part["properties"].(schemavalidation.SchemaObject)
+		for prop, s := range p {
+			if _, ok := schemas[prop]; !ok {
+				props = append(props, prop)
+				schemas[prop] = s
+			}
+		}
+		switch a := part["additionalProperties"].(type) {
+		case bool:
+			closed = closed || !a
+		case schemavalidation.SchemaObject:
+			additional = a
+		}
+	}
+	required := schemavalidation.RequiredProperties(parts)
+	// Required names the properties don't declare are still members, or no
+	// value of the type could be valid.
+	for prop := range required {
+		if _, ok := schemas[prop]; !ok {
+			props = append(props, prop)
+			schemas[prop] = nil
+		}
+	}
+	sort.Strings(props)
+
+	if len(props) == 0 {
+		if additional != nil {
+			t, z := g.types(additional, depth+1)
+			return fmt.Sprintf("Record<string, %s>", t), fmt.Sprintf("z.record(%s)", z)
+		}
+		return "Record<string, unknown>", "z.record(z.unknown())"
+	}
+
+	indent := strings.Repeat("  ", depth+1)
+	var t, z strings.Builder
+	t.WriteString("{\n")
+	z.WriteString("z.object({\n")
+	for _, prop := range props {
+		// z.unknown() would leave the key optional.
+		pt, pz := "unknown", `z.custom<{} | null>((v) => v !== undefined, "Required")`
+		if schemas[prop] != nil {
+			pt, pz = g.types(schemas[prop], depth+1)
+		}
+		key := tsKey(prop)
+		if required[prop] {
+			fmt.Fprintf(&t, "%s%s: %s;\n", indent, key, pt)
+		} else {
+			fmt.Fprintf(&t, "%s%s?: %s;\n", indent, key, pt)
+			pz += ".optional()"
+		}
+		fmt.Fprintf(&z, "%s%s: %s,\n", indent, key, pz)
+	}
+	outdent := strings.Repeat("  ", depth)
+	t.WriteString(outdent + "}")
+	z.WriteString(outdent + "})")
+
+	// zod drops unknown keys by default; the gateway either rejects or passes
+	// them through.
+	if closed {
+		z.WriteString(".strict()")
+	} else {
+		z.WriteString(".passthrough()")
+	}
+	return t.String(), z.String()
+}
+
+func (g *tsGen) refType(ref string) string {
+	if name, ok := g.refs[ref]; ok {
+		return name
+	}
+	target, ok := schemavalidation.ResolveRef(g.root, ref)
+	if !ok {
+		return ""
+	}
+
+	name := g.uniqueName(g.prefix + goIdentifier(ref[strings.LastIndex(ref, "/")+1:]))
+	g.refs[ref] = name
+	g.declare(name, ref, target, 0)
+	return name
+}
+
+func (g *tsGen) uniqueName(name string) string {
+	unique := name
+	for i := 2; g.names[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	g.names[unique] = true
+	return unique
+}
+
+func tsEnum(enum []interface{}) (string, string) {
+	var ts []string
+	strs := true
+	for _, e := range enum {
+		ts = append(ts, tsLiteral(e))
+		if _, ok := e.(string); !ok {
+			strs = false
+		}
+	}
+	if strs {
+		return strings.Join(ts, " | "), fmt.Sprintf("z.enum([%s])", strings.Join(ts, ", "))
+	}
+
+	zs := make([]string, len(ts))
+	for i, t := range ts {
+		zs[i] = fmt.Sprintf("z.literal(%s)", t)
+	}
+	if len(zs) == 1 {
+		return ts[0], zs[0]
+	}
+	return strings.Join(ts, " | "), fmt.Sprintf("z.union([%s])", strings.Join(zs, ", "))
+}
+
+// tsLiteral writes a JSON value as a TypeScript literal, which JSON already
+// is for the scalars enum and const are usually made of.
+func tsLiteral(v interface{}) string {
+	b, err := encodeJSON(v)
+	if err != nil {
+		return "null"
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func tsKey(prop string) string {
+	for i, r := range prop {
+		if !(r == '_' || r == '$' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return tsLiteral(prop)
+		}
+	}
+	if prop == "" {
+		return `""`
+	}
+	return prop
+}
+
+func tsStringChecks(parts []schemavalidation.SchemaObject) string {
+	s := tsChecks(parts, "minLength", "maxLength")
+	for _, part := range parts {
+		if p, ok := part["pattern"].(string); ok {
+			s += fmt.Sprintf(".regex(new RegExp(%s))", tsLiteral(p))
+		}
+		switch part["format"] {
+		case "email":
+			s += ".email()"
+		case "uri":
+			s += ".url()"
+		case "uuid":
+			s += ".uuid()"
+		case "date-time":
+			s += ".datetime({ offset: true })"
+		}
+	}
+	return s
+}
+
+func tsNumberChecks(parts []schemavalidation.SchemaObject) string {
+	var s string
+	for _, part := range parts {
+		for _, bound := range [][2]string{{"minimum", "gte"}, {"maximum", "lte"}} {
+			keyword, method := bound[0], bound[1]
+			n, ok := part[keyword].(json.Number)
+			if !ok {
+				continue
+			}
+			// draft-04 makes the bound exclusive with a sibling boolean.
+			if exclusive, _ := part["exclusive"+strings.ToUpper(keyword[:1])+keyword[1:]].(bool); exclusive {
+				method = method[:2]
+			}
+			s += fmt.Sprintf(".%s(%s)", method, n)
+		}
+		if n, ok := part["exclusiveMinimum"].(json.Number); ok {
+			s += fmt.Sprintf(".gt(%s)", n)
+		}
+		if n, ok := part["exclusiveMaximum"].(json.Number); ok {
+			s += fmt.Sprintf(".lt(%s)", n)
+		}
+		if n, ok := part["multipleOf"].(json.Number); ok {
+			s += fmt.Sprintf(".multipleOf(%s)", n)
+		}
+	}
+	return s
+}
+
+// tsChecks writes zod's .min and .max for a pair of length keywords.
+func tsChecks(parts []schemavalidation.SchemaObject, min, max string) string {
+	var s string
+	for _, part := range parts {
+		if n, ok := part[min].(json.Number); ok {
+			s += fmt.Sprintf(".min(%s)", n)
+		}
+		if n, ok := part[max].(json.Number); ok {
+			s += fmt.Sprintf(".max(%s)", n)
+		}
+	}
+	return s
+}
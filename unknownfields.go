@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxUnknownFields bounds how many distinct fields are tracked, so
+	// clients sending random keys can't grow the report without limit.
+	maxUnknownFields = 1000
+	// maxUnknownFieldRoutes bounds the routes kept per field.
+	maxUnknownFieldRoutes = 20
+)
+
+// unknownFields counts the properties clients send that their schema
+// doesn't describe, whether or not they are allowed, as candidates for the
+// next version of the schema.
+type unknownFields struct {
+	mu     sync.Mutex
+	fields map[string]*unknownField
+}
+
+type unknownField struct {
+	Tenant    string           `json:"tenant,omitempty"`
+	Schema    string           `json:"schema"`
+	Version   string           `json:"version"`
+	Field     string           `json:"field"`
+	Count     int64            `json:"count"`
+	Routes    map[string]int64 `json:"routes"`
+	FirstSeen time.Time        `json:"first_seen"`
+	LastSeen  time.Time        `json:"last_seen"`
+}
+
+func newUnknownFields() *unknownFields {
+	return &unknownFields{fields: make(map[string]*unknownField)}
+}
+
+func (u *unknownFields) add(schema *schemaVersion, field, route string, now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	key := schema.Tenant + "\x00" + schema.Name + "\x00" + schema.Version + "\x00" + field
+	f, ok := u.fields[key]
+	if !ok {
+		if len(u.fields) >= maxUnknownFields {
+			return
+		}
+		f = &unknownField{
+			Tenant:    schema.Tenant,
+			Schema:    schema.Name,
+			Version:   schema.Version,
+			Field:     field,
+			Routes:    make(map[string]int64),
+			FirstSeen: now,
+		}
+		u.fields[key] = f
+	}
+
+	f.Count++
+	f.LastSeen = now
+	if _, ok := f.Routes[route]; ok || len(f.Routes) < maxUnknownFieldRoutes {
+		f.Routes[route]++
+	}
+}
+
+// unknownFieldPath is the field path of an unknown property with array
+// indexes replaced by *, so the same field in every element counts once.
+func unknownFieldPath(parent, key string) string {
+	segments := strings.Split(joinField(parent, key), ".")
+	for i, s := range segments[:len(segments)-1] {
+		if _, err := strconv.Atoi(s); err == nil {
+			segments[i] = "*"
+		}
+	}
+	return strings.Join(segments, ".")
+}
+
+// trackUnknownFields counts the unknown properties of every body that
+// reaches next. It runs after validation, so only bodies the schema
+// accepted are counted.
+func trackUnknownFields(u *unknownFields, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		replaceBody(r, body)
+
+		if doc, err := decodeJSON(body); err == nil {
+			schema, now := requestSchema(r), time.Now().UTC()
+			walkUnknown(schema.Document, schema.Document, doc, "", func(_ map[string]interface{}, key, field string) {
+				u.add(schema, unknownFieldPath(field, key), r.URL.Path, now)
+			})
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handle serves the unknown fields seen so far, most frequent first.
+func (u *unknownFields) handle(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	fields := make([]unknownField, 0, len(u.fields))
+	for _, f := range u.fields {
+		copied := *f
+		copied.Routes = make(map[string]int64, len(f.Routes))
+		for route, n := range f.Routes {
+			copied.Routes[route] = n
+		}
+		fields = append(fields, copied)
+	}
+	u.mu.Unlock()
+
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i], fields[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Schema != b.Schema {
+			return a.Schema < b.Schema
+		}
+		return a.Field < b.Field
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Fields []unknownField `json:"fields"`
+	}{fields}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// uploadedSchema is one schema version registered through the admin API.
+// Every upload is kept, the latest active one of each tenant, name and
+// version being the one loaded. An upload with an activation time is pending
+// until then, and one rolled back no longer counts.
+type uploadedSchema struct {
+	id int64
+
+	Tenant    string          `json:"tenant,omitempty"`
+	Name      string          `json:"name"`
+	Version   string          `json:"version"`
+	Document  json.RawMessage `json:"document"`
+	Signature []byte          `json:"signature,omitempty"`
+	Author    string          `json:"author"`
+	Created   time.Time       `json:"created_at"`
+	Activates time.Time       `json:"activates_at,omitzero"`
+	Pending   bool            `json:"pending,omitempty"`
+
+	RolledBack   bool   `json:"rolled_back,omitempty"`
+	RolledBackBy string `json:"rolled_back_by,omitempty"`
+}
+
+func (s *uploadedSchema) active(now time.Time) bool {
+	return !s.RolledBack && !s.Activates.After(now)
+}
+
+func (s *uploadedSchema) pending(now time.Time) bool {
+	return !s.RolledBack && s.Activates.After(now)
+}
+
+// effective is when the upload came into force, or will.
+func (s *uploadedSchema) effective() time.Time {
+	if s.Activates.IsZero() {
+		return s.Created
+	}
+	return s.Activates
+}
+
+// schemaUploads holds the schemas uploaded at runtime, loaded after and
+// overriding those from files. Without a database they only last until
+// the process exits; with -schema-store they are kept in Postgres, and
+// read back on startup, history included, and every minute after so
+// instances sharing the store learn of each other's uploads. Uploads
+// scheduled to activate later are loaded by every instance at that moment.
+// With -schema-age-identity the documents are kept encrypted in the store.
+type schemaUploads struct {
+	db    *sql.DB
+	crypt *schemaDecrypter
+
+	// uploading serializes uploads, each reloading the schemas.
+	uploading sync.Mutex
+
+	mu      sync.Mutex
+	history []uploadedSchema
+	timer   *time.Timer
+}
+
+const uploadsTable = `CREATE TABLE IF NOT EXISTS schema_uploads (
+	id         BIGSERIAL PRIMARY KEY,
+	tenant     TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	version    TEXT NOT NULL,
+	document   TEXT NOT NULL,
+	author     TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+)`
+
+// uploadsActivation, uploadsRollback and uploadsSignature add the columns
+// of tables created before uploads could be scheduled, rolled back or
+// signed.
+const (
+	uploadsActivation = `ALTER TABLE schema_uploads ADD COLUMN IF NOT EXISTS activates_at TIMESTAMPTZ`
+	uploadsRollback   = `ALTER TABLE schema_uploads ADD COLUMN IF NOT EXISTS rolled_back_by TEXT`
+	uploadsSignature  = `ALTER TABLE schema_uploads ADD COLUMN IF NOT EXISTS signature BYTEA`
+)
+
+func newSchemaUploads(dsn string, crypt *schemaDecrypter) (*schemaUploads, error) {
+	u := &schemaUploads{crypt: crypt}
+	if dsn == "" {
+		return u, nil
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, stmt := range []string{uploadsTable, uploadsActivation, uploadsRollback, uploadsSignature} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	u.db = db
+	if u.history, err = u.read(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return u, nil
+}
+
+func (u *schemaUploads) read(ctx context.Context) ([]uploadedSchema, error) {
+	rows, err := u.db.QueryContext(ctx, `SELECT id, tenant, name, version, document, author, created_at, activates_at, rolled_back_by, signature FROM schema_uploads ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []uploadedSchema
+	for rows.Next() {
+		var s uploadedSchema
+		var doc string
+		var activates sql.NullTime
+		var rolledBackBy sql.NullString
+		if err := rows.Scan(&s.id, &s.Tenant, &s.Name, &s.Version, &doc, &s.Author, &s.Created, &activates, &rolledBackBy, &s.Signature); err != nil {
+			return nil, err
+		}
+		if isAgeArmored([]byte(doc)) {
+			if u.crypt == nil {
+				return nil, fmt.Errorf("upload %d of schema %s.%s is encrypted, decrypting it needs -schema-age-identity", s.id, s.Name, s.Version)
+			}
+			b, err := u.crypt.decrypt([]byte(doc))
+			if err != nil {
+				return nil, fmt.Errorf("upload %d of schema %s.%s: %v", s.id, s.Name, s.Version, err)
+			}
+			doc = string(b)
+		}
+		s.Document = json.RawMessage(doc)
+		s.Activates = activates.Time
+		s.RolledBack, s.RolledBackBy = rolledBackBy.Valid, rolledBackBy.String
+		history = append(history, s)
+	}
+	return history, rows.Err()
+}
+
+// watch reads the uploads from the store every minute, reloading the
+// schemas when another instance uploaded some, until ctx is done.
+func (u *schemaUploads) watch(ctx context.Context, schemas *schemaStore) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		history, err := u.read(ctx)
+		if err != nil {
+			log.Printf("failed to read schema uploads from -schema-store: %v", err)
+			continue
+		}
+
+		u.uploading.Lock()
+		u.mu.Lock()
+		changed := len(history) != len(u.history) || rolledBack(history) != rolledBack(u.history)
+		if changed {
+			u.history = history
+		}
+		u.mu.Unlock()
+		if changed {
+			if _, err := schemas.reload(); err != nil {
+				log.Printf("failed to reload schemas uploaded to -schema-store, keeping the current ones: %v", err)
+			}
+			u.schedule(schemas)
+		}
+		u.uploading.Unlock()
+	}
+}
+
+func rolledBack(history []uploadedSchema) int {
+	n := 0
+	for _, s := range history {
+		if s.RolledBack {
+			n++
+		}
+	}
+	return n
+}
+
+// schedule arranges for the schemas to be reloaded when the next pending
+// upload activates.
+func (u *schemaUploads) schedule(schemas *schemaStore) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	var next time.Time
+	for _, s := range u.history {
+		if s.pending(now) && (next.IsZero() || s.Activates.Before(next)) {
+			next = s.Activates
+		}
+	}
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+	if next.IsZero() {
+		return
+	}
+	u.timer = time.AfterFunc(time.Until(next), func() {
+		u.uploading.Lock()
+		defer u.uploading.Unlock()
+		if _, err := schemas.reload(); err != nil {
+			log.Printf("failed to load schemas scheduled to activate at %s, keeping the current ones: %v", next.Format(time.RFC3339), err)
+		} else {
+			log.Printf("activated schemas scheduled for %s", next.Format(time.RFC3339))
+		}
+		u.schedule(schemas)
+	})
+}
+
+// documents returns the latest active upload of every tenant, name and
+// version.
+func (u *schemaUploads) documents() []schemaDocument {
+	if u == nil {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	type id struct{ tenant, name, version string }
+	latest := make(map[id]int)
+	var order []id
+	now := time.Now()
+	for i, s := range u.history {
+		if !s.active(now) {
+			continue
+		}
+		key := id{s.Tenant, s.Name, s.Version}
+		if _, ok := latest[key]; !ok {
+			order = append(order, key)
+		}
+		latest[key] = i
+	}
+
+	docs := make([]schemaDocument, 0, len(order))
+	for _, key := range order {
+		s := u.history[latest[key]]
+		docs = append(docs, schemaDocument{Tenant: s.Tenant, Name: s.Name, Version: s.Version, Data: s.Document, Signature: s.Signature})
+	}
+	return docs
+}
+
+func (u *schemaUploads) add(s uploadedSchema) {
+	u.mu.Lock()
+	u.history = append(u.history, s)
+	u.mu.Unlock()
+}
+
+// drop removes the last upload, one that failed to load or to be saved.
+func (u *schemaUploads) drop() {
+	u.mu.Lock()
+	u.history = u.history[:len(u.history)-1]
+	u.mu.Unlock()
+}
+
+// save stores the last upload.
+func (u *schemaUploads) save(ctx context.Context, s uploadedSchema) error {
+	if u.db == nil {
+		return nil
+	}
+	doc := []byte(s.Document)
+	if u.crypt != nil {
+		var err error
+		if doc, err = u.crypt.encrypt(doc); err != nil {
+			return err
+		}
+	}
+	activates := sql.NullTime{Time: s.Activates, Valid: !s.Activates.IsZero()}
+	var id int64
+	err := u.db.QueryRowContext(ctx, `INSERT INTO schema_uploads (tenant, name, version, document, author, created_at, activates_at, signature) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		s.Tenant, s.Name, s.Version, string(doc), s.Author, s.Created, activates, s.Signature).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	u.history[len(u.history)-1].id = id
+	u.mu.Unlock()
+	return nil
+}
+
+// handle serves GET /admin/schemas, the history of uploads optionally
+// filtered by ?name=, pending ones included, and PUT
+// /admin/schemas/<name>/<version>, uploading a schema version for ?tenant=
+// or every tenant, from ?activate_at=, an RFC 3339 time, or right away. An
+// upload is loaded before it is saved, so one that doesn't compile or breaks
+// the schemas' examples is refused and leaves nothing behind; a scheduled
+// one is only compiled beforehand. With -schema-public-key the schema's
+// signature, base64 encoded, goes in the X-Schema-Signature header.
+// POST /admin/schemas/<name>/rollback rolls back the upload of name last to
+// come into force.
+func (u *schemaUploads) handle(schemas *schemaStore, keys *adminKeys) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			u.list(w, r)
+		case http.MethodPut:
+			if keys == nil {
+				writeErrors(w, http.StatusForbidden, codeForbidden, "uploading schemas requires -admin-keys-file")
+				return
+			}
+			u.upload(schemas, keys, w, r)
+		case http.MethodPost:
+			if keys == nil {
+				writeErrors(w, http.StatusForbidden, codeForbidden, "rolling back schemas requires -admin-keys-file")
+				return
+			}
+			u.rollback(schemas, keys, w, r)
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (u *schemaUploads) list(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+
+	u.mu.Lock()
+	history := make([]uploadedSchema, 0, len(u.history))
+	now := time.Now()
+	for _, s := range u.history {
+		if name == "" || s.Name == name {
+			s.Pending = s.pending(now)
+			history = append(history, s)
+		}
+	}
+	u.mu.Unlock()
+
+	sort.SliceStable(history, func(i, j int) bool { return history[i].Created.After(history[j].Created) })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Uploads []uploadedSchema `json:"uploads"`
+	}{history}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (u *schemaUploads) upload(schemas *schemaStore, keys *adminKeys, w http.ResponseWriter, r *http.Request) {
+	name, version, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/schemas/"), "/")
+	if !ok || name == "" || version == "" || strings.Contains(version, "/") {
+		writeErrors(w, http.StatusNotFound, codeNotFound, "expected PUT /admin/schemas/<name>/<version>")
+		return
+	}
+
+	var activates time.Time
+	if v := r.URL.Query().Get("activate_at"); v != "" {
+		var err error
+		if activates, err = time.Parse(time.RFC3339, v); err != nil {
+			writeErrors(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("invalid activate_at %q, expected an RFC 3339 time", v))
+			return
+		}
+	}
+
+	var sig []byte
+	if v := r.Header.Get("X-Schema-Signature"); v != "" {
+		var err error
+		if sig, err = base64.StdEncoding.DecodeString(v); err != nil {
+			writeErrors(w, http.StatusBadRequest, codeInvalidRequest, "X-Schema-Signature is not base64 encoded")
+			return
+		}
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	if !json.Valid(body) {
+		writeErrors(w, http.StatusBadRequest, codeInvalidSchema, "schema is not valid JSON")
+		return
+	}
+	if v := schemas.cfg.Verifier; v != nil {
+		if err := v.verify(body, sig); err != nil {
+			writeErrors(w, http.StatusUnprocessableEntity, codeInvalidSchema, fmt.Sprintf("schema %s.%s: %v", name, version, err))
+			return
+		}
+	}
+
+	u.uploading.Lock()
+	defer u.uploading.Unlock()
+
+	s := uploadedSchema{
+		Tenant:    r.URL.Query().Get("tenant"),
+		Name:      name,
+		Version:   version,
+		Document:  json.RawMessage(body),
+		Signature: sig,
+		Author:    adminCaller(keys, r),
+		Created:   time.Now().UTC(),
+	}
+	if activates.After(s.Created) {
+		s.Activates = activates.UTC()
+	}
+
+	// A scheduled upload only loads when it activates, so until then the
+	// most that can be checked is that it compiles.
+	if !s.Activates.IsZero() {
+		if _, err := compileSchema(body); err != nil {
+			writeErrors(w, http.StatusUnprocessableEntity, codeInvalidSchema, fmt.Sprintf("schema %s.%s doesn't compile: %v", name, version, err))
+			return
+		}
+	}
+
+	u.add(s)
+	if s.Activates.IsZero() {
+		if _, err := schemas.reload(); err != nil {
+			u.drop()
+			writeErrors(w, http.StatusUnprocessableEntity, codeInvalidSchema, fmt.Sprintf("schema %s.%s doesn't load: %v", name, version, err))
+			return
+		}
+	}
+	if err := u.save(r.Context(), s); err != nil {
+		u.drop()
+		if _, err := schemas.reload(); err != nil {
+			log.Printf("failed to reload schemas after an upload failed to save: %v", err)
+		}
+		writeErrors(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("failed to save schema %s.%s: %v", name, version, err))
+		return
+	}
+
+	if s.Activates.IsZero() {
+		log.Printf("schema %s.%s uploaded by %s", name, version, s.Author)
+	} else {
+		log.Printf("schema %s.%s uploaded by %s, activating at %s", name, version, s.Author, s.Activates.Format(time.RFC3339))
+		u.schedule(schemas)
+		s.Pending = true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// rollback rolls back the upload of a schema, for ?tenant= or every tenant
+// and optionally only of ?version=, that came into force last, so the upload
+// before it, or the schema from files, is loaded again. Pending uploads are
+// left alone.
+func (u *schemaUploads) rollback(schemas *schemaStore, keys *adminKeys, w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/admin/schemas/"), "/rollback")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		writeErrors(w, http.StatusNotFound, codeNotFound, "expected POST /admin/schemas/<name>/rollback")
+		return
+	}
+	tenant, version := r.URL.Query().Get("tenant"), r.URL.Query().Get("version")
+
+	u.uploading.Lock()
+	defer u.uploading.Unlock()
+
+	u.mu.Lock()
+	last := -1
+	now := time.Now()
+	for i, s := range u.history {
+		if s.Name != name || s.Tenant != tenant || (version != "" && s.Version != version) || !s.active(now) {
+			continue
+		}
+		if last < 0 || !s.effective().Before(u.history[last].effective()) {
+			last = i
+		}
+	}
+	if last < 0 {
+		u.mu.Unlock()
+		writeErrors(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("schema %s has no upload to roll back", name))
+		return
+	}
+	author := adminCaller(keys, r)
+	u.history[last].RolledBack, u.history[last].RolledBackBy = true, author
+	s := u.history[last]
+	u.mu.Unlock()
+
+	undo := func() {
+		u.mu.Lock()
+		u.history[last].RolledBack, u.history[last].RolledBackBy = false, ""
+		u.mu.Unlock()
+	}
+	if _, err := schemas.reload(); err != nil {
+		undo()
+		writeErrors(w, http.StatusUnprocessableEntity, codeInvalidSchema, fmt.Sprintf("schemas don't load without the upload of %s.%s: %v", name, s.Version, err))
+		return
+	}
+	if u.db != nil {
+		if _, err := u.db.ExecContext(r.Context(), `UPDATE schema_uploads SET rolled_back_by = $1 WHERE id = $2`, author, s.id); err != nil {
+			undo()
+			if _, err := schemas.reload(); err != nil {
+				log.Printf("failed to reload schemas after a rollback failed to save: %v", err)
+			}
+			writeErrors(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("failed to save the rollback of %s.%s: %v", name, s.Version, err))
+			return
+		}
+	}
+
+	log.Printf("schema %s.%s uploaded by %s at %s rolled back by %s", name, s.Version, s.Author, s.Created.Format(time.RFC3339), author)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
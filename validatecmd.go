@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/mitchfriedman/schema-validations/schemavalidation"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Exit statuses of the validate command, for CI jobs to branch on. With
+// files failing in different ways the highest applies.
+const (
+	exitInvalid     = 1
+	exitSchemaError = 2
+	exitIOError     = 3
+)
+
+// runValidate implements the validate command: each file, or stdin with
+// none or "-", is validated against the schema and its errors printed.
+func runValidate(args []string) error {
+	var schemaFlags schemaArgs
+	fs := flag.NewFlagSet("schema-validations validate", flag.ExitOnError)
+	schemaFlags.register(fs)
+	quiet := fs.Bool("quiet", false, "print nothing for valid files")
+	failFast := fs.Bool("fail-fast", false, "stop at the first file that isn't valid")
+	workers := fs.Int("workers", 1, "number of files validated at once, output keeps the order of the files")
+	fs.Parse(args)
+
+	_, schema, err := schemaFlags.load()
+	if err != nil {
+		return &exitStatus{code: exitSchemaError, err: err}
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	results, stop := validateFiles(schema, files, *workers)
+	defer stop()
+
+	status := 0
+	for i, file := range files {
+		res := <-results[i]
+		switch {
+		case res.err != nil:
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, res.err)
+		case len(res.msgs) == 0:
+			if !*quiet {
+				fmt.Printf("%s: valid\n", file)
+			}
+		default:
+			for _, msg := range res.msgs {
+				fmt.Printf("%s: %s\n", file, msg)
+			}
+		}
+
+		status = max(status, res.status)
+		if *failFast && status != 0 {
+			break
+		}
+	}
+
+	if status != 0 {
+		return &exitStatus{code: status}
+	}
+	return nil
+}
+
+// fileResult is the outcome of validating a file: its errors, or why it
+// couldn't be validated, and the exit status that calls for.
+type fileResult struct {
+	msgs   []string
+	err    error
+	status int
+}
+
+// validateFiles validates files on workers goroutines, the result of each
+// file delivered on its own channel so they can be read in order. Calling
+// stop leaves the files not started yet unvalidated.
+func validateFiles(schema *schemaVersion, files []string, workers int) ([]chan fileResult, func()) {
+	results := make([]chan fileResult, len(files))
+	for i := range results {
+		results[i] = make(chan fileResult, 1)
+	}
+
+	next := make(chan int)
+	done := make(chan struct{})
+	go func() {
+		defer close(next)
+		for i := range files {
+			select {
+			case next <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+	for w := 0; w < max(workers, 1); w++ {
+		go func() {
+			for i := range next {
+				results[i] <- validateFile(schema, files[i])
+			}
+		}()
+	}
+
+	return results, func() { close(done) }
+}
+
+func validateFile(schema *schemaVersion, file string) fileResult {
+	b, err := readInput(file)
+	if err != nil {
+		return fileResult{err: err, status: exitIOError}
+	}
+
+	msgs, err := validateBody(schema, b)
+	if err != nil {
+		// Not being JSON is as invalid as a document gets.
+		return fileResult{err: err, status: exitInvalid}
+	}
+	if len(msgs) > 0 {
+		return fileResult{msgs: msgs, status: exitInvalid}
+	}
+	return fileResult{}
+}
+
+// validateFileErrors is validateFile returning the schema errors themselves.
+func validateFileErrors(schema *schemaVersion, file string) ([]gojsonschema.ResultError, error) {
+	b, err := readInput(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return bodyErrors(schema, b)
+}
+
+// readInput reads file, or stdin for "-".
+func readInput(file string) ([]byte, error) {
+	if file == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// validateBody returns the errors the gateway would reject body with, in
+// the order it would list them: the schema's, or when there are none, the
+// cross-field constraints it breaks.
+func validateBody(schema *schemaVersion, body []byte) ([]string, error) {
+	errs, err := schema.Validation.Validate(body)
+	if err != nil {
+		return nil, fmt.Errorf("not valid JSON: %v", err)
+	}
+	return validationMessages(errs), nil
+}
+
+func validationMessages(errs []schemavalidation.Error) []string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Message
+	}
+	return msgs
+}
+
+// bodyErrors is validateBody returning the schema's errors themselves,
+// without the cross-field constraints.
+func bodyErrors(schema *schemaVersion, body []byte) ([]gojsonschema.ResultError, error) {
+	result, err := schema.Schema.Validate(gojsonschema.NewBytesLoader(body))
+	if err != nil {
+		return nil, fmt.Errorf("not valid JSON: %v", err)
+	}
+
+	return schemavalidation.OrderErrors(schemaErrors(schema, body, result)), nil
+}
+
+// schemaErrors are the errors of validating body against schema given
+// result, see schemavalidation.Schema.Errors.
+func schemaErrors(schema *schemaVersion, body []byte, result *gojsonschema.Result) []gojsonschema.ResultError {
+	return schema.Validation.Errors(body, result)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+)
+
+// Set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=...".
+// Without them the commit and date come from the VCS stamp go build embeds.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+func currentBuild() buildInfo {
+	b := buildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && b.Commit == "":
+				b.Commit = s.Value
+			case s.Key == "vcs.time" && b.BuildDate == "":
+				b.BuildDate = s.Value
+			}
+		}
+	}
+	return b
+}
+
+func (b buildInfo) String() string {
+	s := "schema-validations " + b.Version
+	if b.Commit != "" {
+		s += " commit " + b.Commit
+	}
+	if b.BuildDate != "" {
+		s += " built " + b.BuildDate
+	}
+	return s + " " + b.GoVersion
+}
+
+func runVersion(args []string) error {
+	fmt.Println(currentBuild())
+	return nil
+}
+
+type loadedSchema struct {
+	Tenant  string `json:"tenant,omitempty"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	SHA256  string `json:"sha256"`
+}
+
+// loadedSchemas lists every schema version in the registry with a hash of
+// the source it was compiled from.
+func loadedSchemas(registry *schemaRegistry) []loadedSchema {
+	var out []loadedSchema
+	add := func(set schemaSet) {
+		for _, versions := range set {
+			for _, v := range versions {
+				sum := sha256.Sum256(v.Data)
+				out = append(out, loadedSchema{Tenant: v.Tenant, Name: v.Name, Version: v.Version, SHA256: hex.EncodeToString(sum[:])})
+			}
+		}
+	}
+	add(registry.base)
+	for _, set := range registry.tenants {
+		add(set)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	return out
+}
+
+// handleVersion serves /admin/version, reporting the build and exactly
+// which schemas the instance is enforcing.
+func handleVersion(schemas *schemaStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		registry := schemas.current()
+		resp := struct {
+			buildInfo
+			Revision int64          `json:"schema_revision,omitempty"`
+			Schemas  []loadedSchema `json:"schemas"`
+		}{currentBuild(), registry.revision, loadedSchemas(registry)}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/sha256"
+	"sync"
+	"syscall/js"
+)
+
+// Built with GOOS=js GOARCH=wasm, and loaded with Go's wasm_exec.js, the
+// binary exposes the validator to JavaScript instead of running the CLI:
+//
+//	schemaValidations.validate(schema, body)
+//
+// takes the schema and the body as JSON strings and returns
+// {valid, errors}, errors being exactly the messages the gateway would
+// reject the body with, in the same order. Compile errors and invalid JSON
+// come back as {error}. Schemas are compiled once per distinct document.
+func init() {
+	entrypoint = runJS
+}
+
+func runJS() {
+	var mu sync.Mutex
+	compiled := make(map[[sha256.Size]byte]*schemaVersion)
+
+	validate := js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+		if len(args) != 2 || args[0].Type() != js.TypeString || args[1].Type() != js.TypeString {
+			return map[string]interface{}{"error": "validate takes a schema and a body, both JSON strings"}
+		}
+		data, body := []byte(args[0].String()), []byte(args[1].String())
+
+		mu.Lock()
+		schema, ok := compiled[sha256.Sum256(data)]
+		if !ok {
+			cs, err := compileSchema(data)
+			if err != nil {
+				mu.Unlock()
+				return map[string]interface{}{"error": err.Error()}
+			}
+			schema = cs.version("", "", data)
+			compiled[sha256.Sum256(data)] = schema
+		}
+		mu.Unlock()
+
+		msgs, err := validateBody(schema, body)
+		if err != nil {
+			return map[string]interface{}{"error": err.Error()}
+		}
+		errors := make([]interface{}, len(msgs))
+		for i, msg := range msgs {
+			errors[i] = msg
+		}
+		return map[string]interface{}{"valid": len(msgs) == 0, "errors": errors}
+	})
+
+	js.Global().Set("schemaValidations", map[string]interface{}{
+		"validate": validate,
+		"version":  currentBuild().Version,
+	})
+	select {}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+var errPoolFull = errors.New("validation queue is full")
+
+type validationJob struct {
+	ctx    context.Context
+	schema *gojsonschema.Schema
+	body   []byte
+	done   chan validationOutcome
+}
+
+type validationOutcome struct {
+	result *gojsonschema.Result
+	err    error
+}
+
+// validationPool runs schema validation on a fixed number of goroutines, so
+// however many requests are in flight only that many validations compete
+// for CPU. Jobs wait in a bounded queue; when it is full they are refused
+// rather than piling up.
+type validationPool struct {
+	jobs chan validationJob
+}
+
+func newValidationPool(workers, queue int) *validationPool {
+	p := &validationPool{jobs: make(chan validationJob, queue)}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *validationPool) work() {
+	for job := range p.jobs {
+		metricPoolQueueDepth.Add(-1)
+		if err := job.ctx.Err(); err != nil {
+			// Given up on while queued.
+			job.done <- validationOutcome{err: err}
+			continue
+		}
+		result, err := job.schema.Validate(gojsonschema.NewBytesLoader(job.body))
+		job.done <- validationOutcome{result: result, err: err}
+	}
+}
+
+// validate runs the validation on the pool, or inline without one. It gives
+// up waiting when ctx is done, but the validation itself keeps running until
+// it finishes, since gojsonschema can't be cancelled: on the pool it holds
+// its worker, and without a pool, where a ctx with a deadline has the
+// validation run on a goroutine of its own to give up on, it holds that
+// goroutine. So abandoned validations without a pool don't pile up, once
+// GOMAXPROCS of them are still running further ones with a deadline are
+// refused as if a pool were full.
+func (p *validationPool) validate(ctx context.Context, schema *gojsonschema.Schema, body []byte) (*gojsonschema.Result, error) {
+	if _, ok := ctx.Deadline(); p == nil && !ok {
+		return schema.Validate(gojsonschema.NewBytesLoader(body))
+	}
+	if p == nil {
+		if metricAbandonedValidations.Value() >= int64(runtime.GOMAXPROCS(0)) {
+			metricPoolRejected.Add(1)
+			return nil, errPoolFull
+		}
+
+		// state goes from running to either finished or abandoned,
+		// whichever happens first.
+		const running, finished, abandoned = 0, 1, 2
+		var state atomic.Int32
+		done := make(chan validationOutcome, 1)
+		go func() {
+			result, err := schema.Validate(gojsonschema.NewBytesLoader(body))
+			done <- validationOutcome{result: result, err: err}
+			if !state.CompareAndSwap(running, finished) {
+				metricAbandonedValidations.Add(-1)
+			}
+		}()
+		select {
+		case out := <-done:
+			return out.result, out.err
+		case <-ctx.Done():
+			if !state.CompareAndSwap(running, abandoned) {
+				out := <-done
+				return out.result, out.err
+			}
+			metricAbandonedValidations.Add(1)
+			return nil, ctx.Err()
+		}
+	}
+
+	job := validationJob{ctx: ctx, schema: schema, body: body, done: make(chan validationOutcome, 1)}
+	metricPoolQueueDepth.Add(1)
+	select {
+	case p.jobs <- job:
+	default:
+		metricPoolQueueDepth.Add(-1)
+		metricPoolRejected.Add(1)
+		return nil, errPoolFull
+	}
+
+	select {
+	case out := <-job.done:
+		return out.result, out.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}